@@ -16,6 +16,12 @@ import (
 	"github.com/xiaopang/fusionapi/internal/store"
 )
 
+// rateLimitSaveInterval 频率限制器状态持久化间隔
+const rateLimitSaveInterval = 30 * time.Second
+
+// rateLimitSaveDebounce 日配额计数或封禁变化后等待多久再保存，合并这段时间内的多次变化
+const rateLimitSaveDebounce = time.Second
+
 func main() {
 	// 命令行参数
 	configPath := flag.String("config", "config.yaml", "配置文件路径")
@@ -63,6 +69,7 @@ func main() {
 
 	// 初始化源管理器
 	manager := core.NewSourceManager(db)
+	manager.SetBreakers(core.NewBreakerSet(&cfg.Routing.CircuitBreaker))
 
 	// 从数据库加载源
 	if err := manager.Load(); err != nil {
@@ -79,7 +86,14 @@ func main() {
 
 	// 初始化路由器
 	router := core.NewRouter(manager, cfg.Routing.Strategy)
+	router.SetAffinity(&cfg.Routing.Affinity)
 	log.Printf("Router initialized with strategy: %s", cfg.Routing.Strategy)
+	if rules, err := db.ListRoutingRules(); err != nil {
+		log.Printf("Warning: failed to load routing rules: %v", err)
+	} else {
+		router.SetRules(rules)
+		log.Printf("Loaded %d routing rules", len(rules))
+	}
 
 	// 初始化健康检查器
 	healthChecker := core.NewHealthChecker(manager, &cfg.HealthCheck)
@@ -93,11 +107,54 @@ func main() {
 	translator := core.NewTranslator()
 
 	// 初始化频率限制器
-	rateLimiter := core.NewRateLimiter()
+	var rateLimiter core.Limiter
+	saveRateLimitState := func() {}
+	switch cfg.RateLimit.Backend {
+	case "redis":
+		rc := cfg.RateLimit.Redis
+		redisLimiter := core.NewRedisLimiter(rc.Addr, rc.Password, rc.DB, rc.KeyPrefix, time.Duration(rc.Timeout)*time.Millisecond)
+		if err := redisLimiter.Ping(); err != nil {
+			log.Printf("Warning: rate limit redis %s unreachable, requests will be allowed until it recovers: %v", rc.Addr, err)
+		}
+		rateLimiter = redisLimiter
+		log.Printf("Rate limiter backend: redis (%s)", rc.Addr)
+	default:
+		// 内存后端：恢复上次保存的配额计数与封禁状态，并定期落盘；
+		// 日配额计数与封禁变化后约 1 秒内落盘，进程崩溃最多丢失这段时间的日计数和分钟级窗口
+		memLimiter := core.NewRateLimiter()
+		if snap, err := db.LoadRateLimitSnapshot(); err == nil {
+			memLimiter.Restore(snap)
+			log.Printf("Rate limiter state restored (saved at %s)", snap.SavedAt.Format(time.RFC3339))
+		}
+		saveRateLimitState = func() {
+			if err := db.SaveRateLimitSnapshot(memLimiter.Snapshot()); err != nil {
+				logger.Warn("failed to save rate limiter state", "err", err)
+			}
+		}
+		go func() {
+			ticker := time.NewTicker(rateLimitSaveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+				case <-memLimiter.DailyChanges():
+					time.Sleep(rateLimitSaveDebounce)
+				}
+				saveRateLimitState()
+			}
+		}()
+		rateLimiter = memLimiter
+	}
+	router.SetLimiter(rateLimiter)
 
 	// 初始化 API 处理器
 	proxyHandler := api.NewProxyHandler(router, manager, translator, db, cfg, rateLimiter)
-	adminHandler := api.NewAdminHandler(manager, healthChecker, router, db, cfg, *configPath)
+	if cc := cfg.Cache; cc.Backend == "sqlite" {
+		proxyHandler.SetResponseCache(core.NewSQLiteCache(db, time.Duration(cc.TTL)*time.Second, cc.MaxEntries))
+	} else {
+		proxyHandler.SetResponseCache(core.NewMemoryCache(time.Duration(cc.TTL)*time.Second, cc.MaxEntries))
+	}
+	adminHandler := api.NewAdminHandler(manager, healthChecker, router, db, cfg, *configPath, rateLimiter)
 
 	// 设置路由
 	r := api.SetupRouter(cfg, proxyHandler, adminHandler, db, rateLimiter)
@@ -112,6 +169,7 @@ func main() {
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 		log.Println("Shutting down...")
+		saveRateLimitState()
 		os.Exit(0)
 	}()
 
@@ -15,24 +15,26 @@ import (
 
 // AdminHandler 管理 API 处理器
 type AdminHandler struct {
-	manager    *core.SourceManager
-	health     *core.HealthChecker
-	router     *core.Router
-	store      *store.Store
-	cfg        *config.Config
-	configPath string
-	cfgMu      sync.Mutex
+	manager     *core.SourceManager
+	health      *core.HealthChecker
+	router      *core.Router
+	store       *store.Store
+	cfg         *config.Config
+	configPath  string
+	rateLimiter core.Limiter
+	cfgMu       sync.Mutex
 }
 
 // NewAdminHandler 创建管理处理器
-func NewAdminHandler(manager *core.SourceManager, health *core.HealthChecker, router *core.Router, store *store.Store, cfg *config.Config, configPath string) *AdminHandler {
+func NewAdminHandler(manager *core.SourceManager, health *core.HealthChecker, router *core.Router, store *store.Store, cfg *config.Config, configPath string, rateLimiter core.Limiter) *AdminHandler {
 	return &AdminHandler{
-		manager:    manager,
-		health:     health,
-		router:     router,
-		store:      store,
-		cfg:        cfg,
-		configPath: configPath,
+		manager:     manager,
+		health:      health,
+		router:      router,
+		store:       store,
+		cfg:         cfg,
+		configPath:  configPath,
+		rateLimiter: rateLimiter,
 	}
 }
 
@@ -43,11 +45,20 @@ func (h *AdminHandler) ListSources(c *gin.Context) {
 	sources := h.manager.List()
 	resp := make([]model.SourceResponse, 0, len(sources))
 	for _, src := range sources {
-		resp = append(resp, src.ToResponse())
+		resp = append(resp, h.sourceResponse(src))
 	}
 	c.JSON(200, gin.H{"data": resp})
 }
 
+// sourceResponse 源响应，附带熔断器状态
+func (h *AdminHandler) sourceResponse(src *model.Source) model.SourceResponse {
+	resp := src.ToResponse()
+	if resp.Status != nil {
+		resp.Status.Breaker = h.manager.Breakers().State(src.ID)
+	}
+	return resp
+}
+
 // CreateSource 创建源
 func (h *AdminHandler) CreateSource(c *gin.Context) {
 	var src model.Source
@@ -61,6 +72,16 @@ func (h *AdminHandler) CreateSource(c *gin.Context) {
 		return
 	}
 
+	if err := validateSource(&src); err != nil {
+		c.JSON(400, model.ErrorResponse{
+			Error: model.ErrorDetail{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
 	// 设置默认值
 	if src.Priority == 0 {
 		src.Priority = 1
@@ -79,7 +100,21 @@ func (h *AdminHandler) CreateSource(c *gin.Context) {
 		return
 	}
 
-	c.JSON(201, gin.H{"data": src.ToResponse()})
+	c.JSON(201, gin.H{"data": h.sourceResponse(&src)})
+}
+
+// validateSource 校验源的模型映射、价格表、限制与能力声明
+func validateSource(src *model.Source) error {
+	if err := src.Capabilities.Validate(); err != nil {
+		return err
+	}
+	if err := model.ValidateModelMappings(src.ModelMap); err != nil {
+		return err
+	}
+	if err := model.ValidatePricing(src.Pricing); err != nil {
+		return err
+	}
+	return src.Limits.Validate()
 }
 
 // GetSource 获取源详情
@@ -95,7 +130,7 @@ func (h *AdminHandler) GetSource(c *gin.Context) {
 		})
 		return
 	}
-	c.JSON(200, gin.H{"data": src.ToResponse()})
+	c.JSON(200, gin.H{"data": h.sourceResponse(src)})
 }
 
 // UpdateSource 更新源
@@ -125,6 +160,16 @@ func (h *AdminHandler) UpdateSource(c *gin.Context) {
 		return
 	}
 
+	if err := validateSource(&src); err != nil {
+		c.JSON(400, model.ErrorResponse{
+			Error: model.ErrorDetail{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
 	src.ID = id
 	// 如果未提供 API Key，保留原有的
 	if src.APIKey == "" {
@@ -141,7 +186,7 @@ func (h *AdminHandler) UpdateSource(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, gin.H{"data": src.ToResponse()})
+	c.JSON(200, gin.H{"data": h.sourceResponse(&src)})
 }
 
 // DeleteSource 删除源
@@ -228,7 +273,7 @@ func (h *AdminHandler) GetBalance(c *gin.Context) {
 func (h *AdminHandler) GetStatus(c *gin.Context) {
 	sources := h.manager.List()
 
-	var healthy, unhealthy, disabled int
+	var healthy, unhealthy, disabled, coolingDown int
 	for _, src := range sources {
 		if !src.Enabled {
 			disabled++
@@ -237,6 +282,9 @@ func (h *AdminHandler) GetStatus(c *gin.Context) {
 		} else {
 			unhealthy++
 		}
+		if src.Enabled && src.InCooldown() {
+			coolingDown++
+		}
 	}
 
 	c.JSON(200, gin.H{
@@ -244,6 +292,7 @@ func (h *AdminHandler) GetStatus(c *gin.Context) {
 		"healthy_sources":   healthy,
 		"unhealthy_sources": unhealthy,
 		"disabled_sources":  disabled,
+		"cooling_down":      coolingDown,
 		"routing_strategy":  h.cfg.Routing.Strategy,
 		"failover_enabled":  h.cfg.Routing.Failover.Enabled,
 	})
@@ -265,6 +314,11 @@ func (h *AdminHandler) GetHealth(c *gin.Context) {
 			"balance": status.Balance,
 			"error":   status.LastError,
 		}
+		item["breaker"] = h.manager.Breakers().State(src.ID)
+		if until, reason, ok := src.Cooldown(); ok {
+			item["cooldown_until"] = until
+			item["cooldown_reason"] = reason
+		}
 		// Include model_providers for CPA sources
 		if src.Type == model.SourceTypeCPA && status.ModelProviders != nil {
 			item["model_providers"] = status.ModelProviders
@@ -345,9 +399,13 @@ func (h *AdminHandler) GetConfig(c *gin.Context) {
 			"host": h.cfg.Server.Host,
 			"port": h.cfg.Server.Port,
 		},
-		"health_check": h.cfg.HealthCheck,
-		"routing":      h.cfg.Routing,
-		"logging":      h.cfg.Logging,
+		"health_check":   h.cfg.HealthCheck,
+		"routing":        h.cfg.Routing,
+		"logging":        h.cfg.Logging,
+		"model_mappings": h.cfg.ModelMappings,
+		"rate_limit": gin.H{
+			"backend": h.cfg.RateLimit.Backend,
+		},
 	})
 }
 
@@ -358,9 +416,10 @@ func (h *AdminHandler) UpdateConfig(c *gin.Context) {
 			Host *string `json:"host"`
 			Port *int    `json:"port"`
 		} `json:"server"`
-		Routing     *config.RoutingConfig     `json:"routing"`
-		HealthCheck *config.HealthCheckConfig `json:"health_check"`
-		Logging     *config.LoggingConfig     `json:"logging"`
+		Routing       *config.RoutingConfig     `json:"routing"`
+		HealthCheck   *config.HealthCheckConfig `json:"health_check"`
+		Logging       *config.LoggingConfig     `json:"logging"`
+		ModelMappings *[]model.ModelMapping     `json:"model_mappings"`
 	}
 
 	if err := c.ShouldBindJSON(&update); err != nil {
@@ -373,6 +432,34 @@ func (h *AdminHandler) UpdateConfig(c *gin.Context) {
 		return
 	}
 
+	if update.ModelMappings != nil {
+		if err := model.ValidateModelMappings(*update.ModelMappings); err != nil {
+			c.JSON(400, model.ErrorResponse{
+				Error: model.ErrorDetail{
+					Message: err.Error(),
+					Type:    "invalid_request_error",
+				},
+			})
+			return
+		}
+	}
+
+	if update.Routing != nil {
+		err := update.Routing.CircuitBreaker.Validate()
+		if err == nil {
+			err = update.Routing.Affinity.Validate()
+		}
+		if err != nil {
+			c.JSON(400, model.ErrorResponse{
+				Error: model.ErrorDetail{
+					Message: err.Error(),
+					Type:    "invalid_request_error",
+				},
+			})
+			return
+		}
+	}
+
 	h.cfgMu.Lock()
 	defer h.cfgMu.Unlock()
 
@@ -429,6 +516,9 @@ func (h *AdminHandler) UpdateConfig(c *gin.Context) {
 	if update.Logging != nil {
 		h.cfg.Logging = *update.Logging
 	}
+	if update.ModelMappings != nil {
+		h.cfg.ModelMappings = *update.ModelMappings
+	}
 
 	if err := config.Save(h.configPath, h.cfg); err != nil {
 		c.JSON(500, model.ErrorResponse{
@@ -446,6 +536,111 @@ func (h *AdminHandler) UpdateConfig(c *gin.Context) {
 	})
 }
 
+// === 路由规则 ===
+
+// ListRoutingRules 列出路由规则
+func (h *AdminHandler) ListRoutingRules(c *gin.Context) {
+	rules, err := h.store.ListRoutingRules()
+	if err != nil {
+		c.JSON(500, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "internal_error"}})
+		return
+	}
+	if rules == nil {
+		rules = []*model.RoutingRule{}
+	}
+	c.JSON(200, gin.H{"data": rules})
+}
+
+// CreateRoutingRule 创建路由规则
+func (h *AdminHandler) CreateRoutingRule(c *gin.Context) {
+	rule := model.RoutingRule{Enabled: true, Priority: 100}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, model.ErrorResponse{Error: model.ErrorDetail{Message: "Invalid request: " + err.Error(), Type: "invalid_request_error"}})
+		return
+	}
+	if err := validateRoutingRule(&rule); err != nil {
+		c.JSON(400, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "invalid_request_error"}})
+		return
+	}
+
+	rule.ID = core.GenerateRuleID()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+	if err := h.store.SaveRoutingRule(&rule); err != nil {
+		c.JSON(500, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "internal_error"}})
+		return
+	}
+	h.reloadRoutingRules()
+	c.JSON(201, gin.H{"data": rule})
+}
+
+// GetRoutingRule 获取路由规则
+func (h *AdminHandler) GetRoutingRule(c *gin.Context) {
+	rule, err := h.store.GetRoutingRule(c.Param("id"))
+	if err != nil {
+		c.JSON(404, model.ErrorResponse{Error: model.ErrorDetail{Message: "Rule not found", Type: "not_found_error"}})
+		return
+	}
+	c.JSON(200, gin.H{"data": rule})
+}
+
+// UpdateRoutingRule 更新路由规则（整体替换）
+func (h *AdminHandler) UpdateRoutingRule(c *gin.Context) {
+	existing, err := h.store.GetRoutingRule(c.Param("id"))
+	if err != nil {
+		c.JSON(404, model.ErrorResponse{Error: model.ErrorDetail{Message: "Rule not found", Type: "not_found_error"}})
+		return
+	}
+
+	var rule model.RoutingRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, model.ErrorResponse{Error: model.ErrorDetail{Message: "Invalid request: " + err.Error(), Type: "invalid_request_error"}})
+		return
+	}
+	if err := validateRoutingRule(&rule); err != nil {
+		c.JSON(400, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "invalid_request_error"}})
+		return
+	}
+
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+	if err := h.store.SaveRoutingRule(&rule); err != nil {
+		c.JSON(500, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "internal_error"}})
+		return
+	}
+	h.reloadRoutingRules()
+	c.JSON(200, gin.H{"data": rule})
+}
+
+// DeleteRoutingRule 删除路由规则
+func (h *AdminHandler) DeleteRoutingRule(c *gin.Context) {
+	if err := h.store.DeleteRoutingRule(c.Param("id")); err != nil {
+		c.JSON(500, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "internal_error"}})
+		return
+	}
+	h.reloadRoutingRules()
+	c.JSON(200, gin.H{"message": "Rule deleted"})
+}
+
+// validateRoutingRule 校验路由规则
+func validateRoutingRule(rule *model.RoutingRule) error {
+	if rule.Strategy != "" && !core.IsValidStrategy(rule.Strategy) {
+		return fmt.Errorf("unknown strategy %q", rule.Strategy)
+	}
+	return rule.Validate()
+}
+
+// reloadRoutingRules 将数据库中的路由规则同步到路由器
+func (h *AdminHandler) reloadRoutingRules() {
+	if h.router == nil {
+		return
+	}
+	if rules, err := h.store.ListRoutingRules(); err == nil {
+		h.router.SetRules(rules)
+	}
+}
+
 // === API Keys 管理 ===
 
 // ListKeys 列出所有 API Key
@@ -628,5 +823,32 @@ func (h *AdminHandler) GetKeyUsage(c *gin.Context) {
 		c.JSON(500, model.ErrorResponse{Error: model.ErrorDetail{Message: err.Error(), Type: "internal_error"}})
 		return
 	}
-	c.JSON(200, gin.H{"data": usages})
+
+	// 预算剩余额度
+	budget := []model.BudgetStatus{}
+	if key, err := h.store.GetAPIKey(id); err == nil && key.Limits.HasBudget() {
+		dayStart, monthStart := core.BudgetPeriodStarts(time.Now())
+		if daily, monthly, err := h.store.GetKeyBudgetSpend(id, dayStart, monthStart); err == nil {
+			budget = core.EvaluateBudgets(key.Limits, *daily, *monthly)
+		}
+	}
+	c.JSON(200, gin.H{"data": usages, "budget": budget})
+}
+
+// GetRateLimitState 获取频率限制器实时状态
+func (h *AdminHandler) GetRateLimitState(c *gin.Context) {
+	if h.rateLimiter == nil {
+		c.JSON(200, gin.H{"data": &model.RateLimitState{Keys: []model.KeyLimitState{}, Sources: []model.SourceLimitState{}}})
+		return
+	}
+	c.JSON(200, gin.H{"data": h.rateLimiter.State()})
+}
+
+// GetBreakers 获取各源熔断器状态与最近的状态转换
+func (h *AdminHandler) GetBreakers(c *gin.Context) {
+	breakers := h.manager.Breakers()
+	c.JSON(200, gin.H{
+		"data":        breakers.States(),
+		"transitions": breakers.Transitions(),
+	})
 }
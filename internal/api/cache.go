@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// cacheKeyCtx gin 上下文中本次请求的响应缓存键（允许写入缓存时设置）
+const cacheKeyCtx = "response_cache_key"
+
+// cachePolicy 请求的响应缓存策略
+type cachePolicy struct {
+	key    string
+	lookup bool          // 是否读取缓存（no-cache 时跳过读取，只用上游响应刷新缓存）
+	maxAge time.Duration // 可接受的最长缓存时间，0 表示以 TTL 为准
+}
+
+// SetResponseCache 设置响应缓存，nil 表示不缓存
+func (h *ProxyHandler) SetResponseCache(cache core.ResponseCache) {
+	h.cache = cache
+}
+
+// responseCachePolicy 根据全局配置、Key 设置与 Cache-Control 请求头确定缓存策略，不缓存时返回 nil
+// Cache-Control 支持 no-store（不读也不写）、no-cache（跳过缓存并刷新）与 max-age=N（只接受 N 秒内的缓存）
+func (h *ProxyHandler) responseCachePolicy(c *gin.Context, req *model.ChatCompletionRequest, clientInfo *model.ClientInfo) *cachePolicy {
+	if h.cache == nil || !core.Cacheable(req) {
+		return nil
+	}
+	enabled := h.cfg.Cache.Enabled
+	if clientInfo != nil {
+		switch clientInfo.Limits.ResponseCache {
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		}
+	}
+	if !enabled {
+		return nil
+	}
+
+	// 缓存默认按 Key 隔离，避免一个 Key 读到另一个 Key 的响应
+	scope := ""
+	if clientInfo != nil && !h.cfg.Cache.ShareAcrossKeys {
+		scope = clientInfo.KeyID
+	}
+	policy := &cachePolicy{key: core.CacheKey(scope, req), lookup: true}
+	for _, directive := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return nil
+		case directive == "no-cache":
+			policy.lookup = false
+		case strings.HasPrefix(directive, "max-age="):
+			secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || secs < 0 {
+				continue
+			}
+			if secs == 0 {
+				policy.lookup = false
+			}
+			policy.maxAge = time.Duration(secs) * time.Second
+		}
+	}
+	return policy
+}
+
+// lookupCachedResponse 查找可用于本次请求的缓存条目
+func (h *ProxyHandler) lookupCachedResponse(req *model.ChatCompletionRequest, policy *cachePolicy) (*model.CachedResponse, bool) {
+	if !policy.lookup {
+		return nil, false
+	}
+	entry, ok := h.cache.Get(policy.key)
+	if !ok {
+		return nil, false
+	}
+	if policy.maxAge > 0 && time.Since(entry.CreatedAt) > policy.maxAge {
+		return nil, false
+	}
+	if req.Stream {
+		return entry, len(entry.Frames) > 0
+	}
+	return entry, entry.Response != nil
+}
+
+// replayCachedResponse 回放缓存的响应（按入口协议编码）并记录日志；未请求上游，不计 token 与费用
+func (h *ProxyHandler) replayCachedResponse(c *gin.Context, req *model.ChatCompletionRequest, entry *model.CachedResponse, startTime time.Time, clientInfo *model.ClientInfo) {
+	c.Header("X-Cache", "HIT")
+	c.Header("Age", strconv.Itoa(int(time.Since(entry.CreatedAt).Seconds())))
+
+	encoder := encoderFromContext(c)
+	if req.Stream {
+		writeSSEHeaders(c)
+		for _, frame := range entry.Frames {
+			encoder.writeStreamFrame(c, frame)
+		}
+		c.Writer.Flush()
+	} else {
+		encoder.writeResponse(c, http.StatusOK, entry.Response)
+	}
+
+	log := &model.RequestLog{
+		ID:          core.GenerateLogID(),
+		RequestID:   requestIDFromContext(c),
+		Timestamp:   startTime,
+		SourceID:    entry.SourceID,
+		SourceName:  entry.SourceName,
+		Model:       req.Model,
+		HasTools:    req.HasTools(),
+		HasThinking: req.HasThinking(),
+		Stream:      req.Stream,
+		Success:     true,
+		StatusCode:  http.StatusOK,
+		LatencyMs:   time.Since(startTime).Milliseconds(),
+		CacheHit:    true,
+		Endpoint:    c.GetString(endpointKey),
+	}
+	if clientInfo != nil {
+		log.ClientIP = clientInfo.IP
+		log.ClientTool = clientInfo.Tool
+		log.APIKeyID = clientInfo.KeyID
+		if clientInfo.KeyID != "" && h.rateLimiter != nil {
+			h.rateLimiter.RecordSuccess(clientInfo.KeyID)
+		}
+	}
+	h.store.SaveLog(log)
+}
+
+// saveCachedResponse 缓存上游成功的响应（仅当本次请求允许写入缓存时），超过大小上限的响应不缓存
+func (h *ProxyHandler) saveCachedResponse(c *gin.Context, src *model.Source, entry *model.CachedResponse) {
+	key := c.GetString(cacheKeyCtx)
+	if key == "" || h.cache == nil {
+		return
+	}
+	entry.SourceID = src.ID
+	entry.SourceName = src.Name
+	entry.CreatedAt = time.Now()
+	if max := h.cfg.Cache.MaxEntrySize; max > 0 {
+		if data, err := json.Marshal(entry); err != nil || len(data) > max {
+			return
+		}
+	}
+	h.cache.Set(key, entry)
+}
+
+// streamCapture 记录写给客户端的数据帧，流正常结束后写入响应缓存
+type streamCapture struct {
+	frames  []string
+	size    int
+	maxSize int
+	enabled bool
+}
+
+func newStreamCapture(c *gin.Context, maxSize int) *streamCapture {
+	return &streamCapture{enabled: c.GetString(cacheKeyCtx) != "", maxSize: maxSize}
+}
+
+// add 追加数据帧，超过大小上限后放弃缓存
+func (s *streamCapture) add(frames []string) {
+	if !s.enabled {
+		return
+	}
+	for _, frame := range frames {
+		s.size += len(frame)
+		s.frames = append(s.frames, frame)
+	}
+	if s.maxSize > 0 && s.size > s.maxSize {
+		s.enabled = false
+		s.frames = nil
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// Completions OpenAI 旧版 /v1/completions 入口
+// prompt 转换为聊天请求走统一的路由/failover；选中的源原生支持 completions 时原样透传
+func (h *ProxyHandler) Completions(c *gin.Context) {
+	encoder := completionsEncoder{translator: h.translator}
+	c.Set(ClientEncoderKey, encoder)
+
+	var req model.CompletionRequest
+	body, err := c.GetRawData()
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	var chatReq *model.ChatCompletionRequest
+	if err == nil {
+		chatReq, err = h.translator.FromCompletionRequest(&req)
+	}
+	if err != nil {
+		encoder.writeError(c, 400, model.ErrorDetail{
+			Message: "Invalid request: " + err.Error(),
+			Type:    "invalid_request_error",
+		})
+		return
+	}
+
+	c.Set(endpointKey, model.EndpointCompletions)
+	c.Set(nativeRequestKey, &nativeRequest{endpoint: model.EndpointCompletions, body: body})
+	h.proxyChatCompletion(c, chatReq)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestCompletions_TranslatesToChat(t *testing.T) {
+	var upstreamReq model.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+
+	w := performRequest(h.Completions, "/v1/completions", `{"model":"gpt-4o","prompt":"Say hi","max_tokens":16}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(upstreamReq.Messages) != 1 || upstreamReq.Messages[0].Content != "Say hi" {
+		t.Errorf("expected prompt forwarded as a user message, got %+v", upstreamReq.Messages)
+	}
+
+	var resp model.CompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if resp.Object != "text_completion" || len(resp.Choices) != 1 || resp.Choices[0].Text != "Hello!" {
+		t.Errorf("unexpected completions response: %s", w.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].Endpoint != model.EndpointCompletions || logs[0].TotalTokens != 7 {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+func TestCompletions_RejectsBatchedPrompt(t *testing.T) {
+	h, _ := newTestProxy(t)
+	w := performRequest(h.Completions, "/v1/completions", `{"model":"m","prompt":["a","b"]}`, nil)
+	if w.Code != 400 {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// maxEmbeddingResponseSize embeddings 响应体上限（大批量输入的向量远大于聊天响应）
+const maxEmbeddingResponseSize = 64 << 20
+
+// Embeddings OpenAI 兼容的 embeddings 接口
+// 只路由到声明了 embeddings 能力的源，失败时按 failover 配置换源；上游响应原样返回
+func (h *ProxyHandler) Embeddings(c *gin.Context) {
+	var req model.EmbeddingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, model.ErrorResponse{
+			Error: model.ErrorDetail{
+				Message: "Invalid request: " + err.Error(),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	inputs, err := req.Inputs()
+	if err == nil && req.Model == "" {
+		err = errors.New("model is required")
+	}
+	if err != nil {
+		c.JSON(400, model.ErrorResponse{
+			Error: model.ErrorDetail{
+				Message: "Invalid request: " + err.Error(),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	var clientInfo *model.ClientInfo
+	if ci, exists := c.Get("client_info"); exists {
+		clientInfo = ci.(*model.ClientInfo)
+	}
+
+	req.Model = model.MapModel(h.cfg.ModelMappings, req.Model)
+
+	// 批量输入按所有条目的 token 总数准入，完成后按上游 usage 校正
+	estimatedTokens := core.EstimateEmbeddingTokens(inputs)
+	if clientInfo != nil && clientInfo.Limits.TPM > 0 && h.rateLimiter != nil {
+		if ok, reason := h.rateLimiter.ReserveTokens(core.KeyTokenBucket(clientInfo.KeyID), reservationFrom(c).id, clientInfo.Limits.TPM, estimatedTokens); !ok {
+			c.JSON(429, model.ErrorResponse{
+				Error: model.ErrorDetail{
+					Message: reason,
+					Type:    "rate_limit_error",
+					Code:    "rate_limit_exceeded",
+				},
+			})
+			return
+		}
+	}
+
+	startTime := time.Now()
+	route := func(exclude []string) (*model.Source, error) {
+		return h.router.RouteEmbeddingRequest(&req, estimatedTokens, clientInfo, exclude)
+	}
+	forward := func(src *model.Source, release func(), _ []string, failoverFrom string) (bool, *model.Source, error) {
+		body, resp, err := h.fetchEmbeddings(c.Request.Context(), &req, src, startTime)
+		release()
+		if err != nil {
+			return false, nil, err
+		}
+		usage := resp.Usage
+		if usage == nil || usage.TotalTokens == 0 {
+			// 上游未返回 usage 时使用本地估算
+			usage = &model.Usage{PromptTokens: estimatedTokens, TotalTokens: estimatedTokens}
+		}
+		h.logEmbeddingRequest(c, &req, usage, src, startTime, http.StatusOK, nil, failoverFrom, clientInfo)
+		c.Data(http.StatusOK, "application/json", body)
+		return true, nil, nil
+	}
+
+	ok, failoverFrom, lastError := h.failover(c, estimatedTokens, startTime, route, forward)
+	if ok {
+		return
+	}
+	h.releaseTokens(c, clientInfo)
+	status, detail := failoverError(lastError)
+	h.logEmbeddingRequest(c, &req, nil, nil, startTime, status, lastError, failoverFrom, clientInfo)
+	encoderFromContext(c).writeError(c, status, detail)
+}
+
+// fetchEmbeddings 发送 embeddings 请求，返回上游响应体及解析结果；上游失败时已更新源状态
+func (h *ProxyHandler) fetchEmbeddings(ctx context.Context, req *model.EmbeddingRequest, src *model.Source, startTime time.Time) ([]byte, *model.EmbeddingResponse, error) {
+	path, body, err := h.translator.EncodeEmbeddingRequest(req, src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("[%s] encode request: %w", src.Name, err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", src.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("[%s] build request: %w", src.Name, err)
+	}
+
+	h.setHeaders(httpReq, src)
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.updateSourceLatency(src, time.Since(startTime), err)
+		}
+		return nil, nil, fmt.Errorf("[%s] %w", src.Name, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxEmbeddingResponseSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("[%s] read body: %w", src.Name, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := newUpstreamStatusError(resp, respBody)
+		h.updateSourceLatency(src, time.Since(startTime), statusErr)
+		return nil, nil, fmt.Errorf("[%s] %w", src.Name, statusErr)
+	}
+
+	var out model.EmbeddingResponse
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return nil, nil, fmt.Errorf("[%s] decode response: %w", src.Name, err)
+	}
+
+	h.updateSourceLatency(src, time.Since(startTime), nil)
+	return respBody, &out, nil
+}
+
+// logEmbeddingRequest 记录 embeddings 请求日志，usage 为空表示请求失败
+func (h *ProxyHandler) logEmbeddingRequest(c *gin.Context, req *model.EmbeddingRequest, usage *model.Usage, src *model.Source, startTime time.Time, statusCode int, err error, failoverFrom string, clientInfo *model.ClientInfo) {
+	requestID := requestIDFromContext(c)
+	log := &model.RequestLog{
+		ID:           core.GenerateLogID(),
+		RequestID:    requestID,
+		Timestamp:    startTime,
+		Model:        req.Model,
+		Endpoint:     model.EndpointEmbeddings,
+		Success:      err == nil && statusCode == http.StatusOK,
+		StatusCode:   statusCode,
+		LatencyMs:    time.Since(startTime).Milliseconds(),
+		FailoverFrom: failoverFrom,
+	}
+	if src != nil {
+		log.SourceID = src.ID
+		log.SourceName = src.Name
+	}
+	if err != nil {
+		log.Error = err.Error()
+	}
+
+	if usage != nil {
+		log.PromptTokens = usage.PromptTokens
+		log.TotalTokens = usage.TotalTokens
+		if src != nil {
+			log.Cost = src.CostFor(req.Model, usage)
+		}
+		h.reconcileTokens(c, src, clientInfo, usage)
+	}
+
+	if clientInfo != nil {
+		log.ClientIP = clientInfo.IP
+		log.ClientTool = clientInfo.Tool
+		log.APIKeyID = clientInfo.KeyID
+		if clientInfo.KeyID != "" && h.rateLimiter != nil {
+			if log.Success {
+				h.rateLimiter.RecordSuccess(clientInfo.KeyID)
+			} else {
+				h.rateLimiter.RecordError(clientInfo.KeyID)
+			}
+		}
+	}
+
+	h.store.SaveLog(log)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestEmbeddings_RoutesToEmbeddingSourcesWithFailover(t *testing.T) {
+	chatOnly := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("source without embeddings capability must not be used")
+	}))
+	defer chatOnly.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	var upstreamReq model.EmbeddingRequest
+	var upstreamPath string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]},{"object":"embedding","index":1,"embedding":[0.3,0.4]}],"model":"text-embedding-3-small","usage":{"prompt_tokens":8,"total_tokens":8}}`)
+	}))
+	defer healthy.Close()
+
+	h, st := newTestProxy(t,
+		&model.Source{ID: "chat", Name: "chat", Type: model.SourceTypeOpenAI, BaseURL: chatOnly.URL},
+		&model.Source{ID: "failing", Name: "failing", Type: model.SourceTypeOpenAI, BaseURL: failing.URL,
+			Capabilities: model.Capabilities{Embeddings: true}},
+		&model.Source{ID: "healthy", Name: "healthy", Type: model.SourceTypeOpenAI, BaseURL: healthy.URL,
+			Capabilities: model.Capabilities{Embeddings: true, EmbeddingModels: []string{"text-embedding-3-small"}},
+			ModelMap:     []model.ModelMapping{{Match: "embed", Target: "text-embedding-3-small"}},
+			Pricing:      []model.ModelPrice{{Model: "embed", Input: 0.02}}})
+
+	w := performRequest(h.Embeddings, "/v1/embeddings", `{"model":"embed","input":["hello","world"]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp model.EmbeddingResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data) != 2 {
+		t.Fatalf("expected upstream embeddings to be returned, got %s", w.Body.String())
+	}
+	if upstreamPath != "/v1/embeddings" || upstreamReq.Model != "text-embedding-3-small" {
+		t.Errorf("unexpected upstream request: %s %+v", upstreamPath, upstreamReq)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	log := logs[0]
+	if log.Endpoint != model.EndpointEmbeddings || log.SourceID != "healthy" || log.FailoverFrom != "failing" || !log.Success {
+		t.Errorf("unexpected log: %+v", log)
+	}
+	if log.PromptTokens != 8 || log.TotalTokens != 8 || log.CompletionTokens != 0 {
+		t.Errorf("expected upstream usage to be logged, got %+v", log)
+	}
+	if want := 8 * 0.02 / 1e6; log.Cost < want-1e-12 || log.Cost > want+1e-12 {
+		t.Errorf("expected cost %v, got %v", want, log.Cost)
+	}
+}
+
+func TestEmbeddings_EstimatesBatchUsage(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"object":"list","data":[],"model":"m"}`)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL,
+		Capabilities: model.Capabilities{Embeddings: true}})
+
+	w := performRequest(h.Embeddings, "/v1/embeddings", `{"model":"m","input":[[1,2,3],[4,5]]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].PromptTokens != 5 || logs[0].TotalTokens != 5 {
+		t.Errorf("expected every input in the batch to be counted, got %+v", logs)
+	}
+}
+
+func TestEmbeddings_RejectsInvalidInput(t *testing.T) {
+	h, _ := newTestProxy(t)
+	for _, body := range []string{`{"model":"m"}`, `{"model":"m","input":[]}`, `{"input":"hi"}`} {
+		if w := performRequest(h.Embeddings, "/v1/embeddings", body, nil); w.Code != 400 {
+			t.Errorf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
 	"github.com/xiaopang/fusionapi/internal/model"
 )
 
 type fcCompatPayload struct {
-	ToolCall *fcCompatToolCall `json:"tool_call"`
-	Final    string            `json:"final"`
+	ToolCalls []fcCompatToolCall `json:"tool_calls"`
+	ToolCall  *fcCompatToolCall  `json:"tool_call"` // 单个调用（旧格式）
+	Final     string             `json:"final"`
 }
 
 type fcCompatToolCall struct {
@@ -23,6 +25,54 @@ type fcCompatToolCall struct {
 	Arguments json.RawMessage `json:"arguments"`
 }
 
+// compatToolCall 解析后的工具调用（arguments 已归一化为 JSON 文本）
+type compatToolCall struct {
+	Name      string
+	Arguments string
+}
+
+// tool_choice 模式
+const (
+	compatChoiceAuto     = "auto"
+	compatChoiceNone     = "none"
+	compatChoiceRequired = "required"
+	compatChoiceFunction = "function"
+)
+
+// compatToolChoice 归一化后的 tool_choice / function_call
+type compatToolChoice struct {
+	Mode string
+	Name string // Mode 为 function 时指定的函数名
+}
+
+// parseCompatToolChoice 解析 tool_choice（string 或 {"type":"function","function":{"name":...}}）及 legacy function_call
+func parseCompatToolChoice(req *model.ChatCompletionRequest) compatToolChoice {
+	choice := req.ToolChoice
+	if choice == nil {
+		choice = req.FunctionCall
+	}
+
+	switch v := choice.(type) {
+	case string:
+		switch v {
+		case "none":
+			return compatToolChoice{Mode: compatChoiceNone}
+		case "required", "any":
+			return compatToolChoice{Mode: compatChoiceRequired}
+		}
+	case map[string]any:
+		if fn, ok := v["function"].(map[string]any); ok {
+			if name, _ := fn["name"].(string); name != "" {
+				return compatToolChoice{Mode: compatChoiceFunction, Name: name}
+			}
+		}
+		if name, _ := v["name"].(string); name != "" {
+			return compatToolChoice{Mode: compatChoiceFunction, Name: name}
+		}
+	}
+	return compatToolChoice{Mode: compatChoiceAuto}
+}
+
 func sourceSupportsFC(src *model.Source, modelName string) bool {
 	if src == nil {
 		return false
@@ -33,39 +83,91 @@ func sourceSupportsFC(src *model.Source, modelName string) bool {
 	return src.Capabilities.FunctionCalling
 }
 
-func (h *ProxyHandler) handleFCCompatRequest(c *gin.Context, originalReq, translatedReq *model.ChatCompletionRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) bool {
+// handleFCCompatRequest 兼容层：以提示词模拟 tool_call 输出，返回值同 forwardToSource
+func (h *ProxyHandler) handleFCCompatRequest(c *gin.Context, originalReq, translatedReq *model.ChatCompletionRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
 	compatReq, err := buildFCCompatRequest(originalReq, translatedReq)
 	if err != nil {
-		return false
+		return false, fmt.Errorf("[%s] fc_compat: %w", src.Name, err)
+	}
+
+	if originalReq.Stream {
+		return h.handleFCCompatStream(c, originalReq, compatReq, src, startTime, failoverFrom, clientInfo)
 	}
 
 	upstreamResp, err := h.sendChatRequest(c, compatReq, src)
 	if err != nil {
-		h.updateSourceLatency(src, time.Since(startTime), err)
-		return false
+		if c.Request.Context().Err() == nil {
+			h.updateSourceLatency(src, time.Since(startTime), err)
+		}
+		return false, fmt.Errorf("[%s] %w", src.Name, err)
+	}
+
+	choice := parseCompatToolChoice(originalReq)
+	compatResp := upstreamResp // tool_choice=none：按普通对话返回
+	repairs := 0
+	if choice.Mode != compatChoiceNone {
+		var verr error
+		upstreamResp, repairs, verr = h.repairCompatOutput(c, compatReq, upstreamResp, src, collectCompatTools(originalReq), choice)
+		if verr != nil {
+			if upstreamResp, err = h.fallbackCompatText(c, compatReq, upstreamResp, src, verr); err != nil {
+				if c.Request.Context().Err() == nil {
+					h.updateSourceLatency(src, time.Since(startTime), err)
+				}
+				return false, fmt.Errorf("[%s] %w", src.Name, err)
+			}
+		}
+		compatResp = buildCompatResponse(upstreamResp)
 	}
 
-	compatResp := buildCompatResponse(upstreamResp)
-
 	h.updateSourceLatency(src, time.Since(startTime), nil)
-	h.logRequest(requestIDFromContext(c), originalReq, compatResp, src, startTime, http.StatusOK, nil, failoverFrom, clientInfo, true)
+	h.logRequest(c, originalReq, compatResp, src, startTime, http.StatusOK, nil, failoverFrom, clientInfo, true, repairs)
 
-	if originalReq.Stream {
-		writeCompatStreamResponse(c, compatResp)
-	} else {
-		c.JSON(http.StatusOK, compatResp)
+	encoderFromContext(c).writeResponse(c, http.StatusOK, compatResp)
+
+	return true, nil
+}
+
+// repairCompatOutput 输出无法解析或不符合 tool_choice / 参数 schema 时，带上错误让同一个源修复（有限次数）
+// 返回最后一次的响应（usage 为各次累计）、修复次数以及最后一次的校验结果
+func (h *ProxyHandler) repairCompatOutput(c *gin.Context, compatReq *model.ChatCompletionRequest, resp *model.ChatCompletionResponse, src *model.Source, tools []model.Tool, choice compatToolChoice) (*model.ChatCompletionResponse, int, error) {
+	repairs := 0
+	text := extractResponseText(resp)
+	verr := validateCompatOutput(text, tools, choice)
+	for verr != nil && repairs < h.cfg.FCCompat.MaxRepairAttempts {
+		repairs++
+		repaired, err := h.sendChatRequest(c, buildFCCompatRepairRequest(compatReq, text, verr), src)
+		if err != nil {
+			break
+		}
+		repaired.Usage = addUsage(resp.Usage, repaired.Usage)
+		resp = repaired
+		text = extractResponseText(repaired)
+		verr = validateCompatOutput(text, tools, choice)
 	}
+	return resp, repairs, verr
+}
 
-	return true
+// fallbackCompatText 修复次数用尽后的兜底：去掉兼容层提示词重新请求纯文本回答，不把不合法的工具调用 JSON 当作回答返回
+// 兜底回答仍是工具调用时返回错误，由调用方按源失败处理
+func (h *ProxyHandler) fallbackCompatText(c *gin.Context, compatReq *model.ChatCompletionRequest, resp *model.ChatCompletionResponse, src *model.Source, verr error) (*model.ChatCompletionResponse, error) {
+	textResp, err := h.sendChatRequest(c, buildFCCompatTextRequest(compatReq), src)
+	if err != nil {
+		return nil, err
+	}
+	if calls, _, _ := parseCompatOutput(extractResponseText(textResp)); len(calls) > 0 {
+		return nil, fmt.Errorf("fc_compat: no valid output after repairs: %v", verr)
+	}
+	textResp.Usage = addUsage(resp.Usage, textResp.Usage)
+	return textResp, nil
 }
 
 func (h *ProxyHandler) sendChatRequest(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source) (*model.ChatCompletionResponse, error) {
-	body, err := json.Marshal(req)
+	path, body, err := h.translator.EncodeRequest(req, src)
 	if err != nil {
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "POST", src.BaseURL+"/v1/chat/completions", bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "POST", src.BaseURL+path, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -82,14 +184,10 @@ func (h *ProxyHandler) sendChatRequest(c *gin.Context, req *model.ChatCompletion
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, truncateBody(respBody, 4096))
+		return nil, newUpstreamStatusError(resp, respBody)
 	}
 
-	var chatResp model.ChatCompletionResponse
-	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		return nil, err
-	}
-	return &chatResp, nil
+	return h.translator.TranslateResponse(respBody, src)
 }
 
 func buildFCCompatRequest(originalReq, translatedReq *model.ChatCompletionRequest) (*model.ChatCompletionRequest, error) {
@@ -110,16 +208,60 @@ func buildFCCompatRequest(originalReq, translatedReq *model.ChatCompletionReques
 	compatReq.FunctionCall = nil
 
 	normalized := normalizeCompatMessages(compatReq.Messages)
+
+	// tool_choice=none：不允许调用工具，按普通对话请求
+	choice := parseCompatToolChoice(originalReq)
+	if choice.Mode == compatChoiceNone {
+		compatReq.Messages = normalized
+		return &compatReq, nil
+	}
+
+	// 指定函数时只提供该函数
+	if choice.Mode == compatChoiceFunction {
+		for _, tool := range tools {
+			if tool.Function.Name == choice.Name {
+				tools = []model.Tool{tool}
+				break
+			}
+		}
+	}
+
 	compatReq.Messages = append([]model.Message{
 		{
 			Role:    "system",
-			Content: buildFCCompatSystemPrompt(tools),
+			Content: buildFCCompatSystemPrompt(tools, choice),
 		},
 	}, normalized...)
 
 	return &compatReq, nil
 }
 
+// buildFCCompatRepairRequest 在原兼容请求后追加上一轮输出和校验错误，要求模型修正（修复请求总是非流式）
+func buildFCCompatRepairRequest(compatReq *model.ChatCompletionRequest, previous string, verr error) *model.ChatCompletionRequest {
+	repairReq := *compatReq
+	repairReq.Stream = false
+	repairReq.Messages = append(append([]model.Message{}, compatReq.Messages...),
+		model.Message{Role: "assistant", Content: previous},
+		model.Message{
+			Role: "user",
+			Content: "Your previous output was rejected: " + verr.Error() + "\n" +
+				"Reply again with ONLY the corrected JSON object, following the required format and the tool schemas.",
+		},
+	)
+	return &repairReq
+}
+
+// buildFCCompatTextRequest 兜底的纯文本请求：去掉兼容层提示词（Messages[0]），要求模型不调用工具直接回答
+func buildFCCompatTextRequest(compatReq *model.ChatCompletionRequest) *model.ChatCompletionRequest {
+	textReq := *compatReq
+	textReq.Stream = false
+	textReq.Messages = append(append([]model.Message{}, compatReq.Messages[1:]...), model.Message{
+		Role:    "user",
+		Content: "Tools are not available for this reply. Answer the request above directly in plain text, without JSON.",
+	})
+	return &textReq
+}
+
 func collectCompatTools(req *model.ChatCompletionRequest) []model.Tool {
 	if len(req.Tools) > 0 {
 		return req.Tools
@@ -135,15 +277,25 @@ func collectCompatTools(req *model.ChatCompletionRequest) []model.Tool {
 	return tools
 }
 
-func buildFCCompatSystemPrompt(tools []model.Tool) string {
+func buildFCCompatSystemPrompt(tools []model.Tool, choice compatToolChoice) string {
 	toolJSON, _ := json.Marshal(tools)
 
-	return "You are FusionAPI function-calling compatibility layer.\n" +
+	prompt := "You are FusionAPI function-calling compatibility layer.\n" +
 		"The upstream model does not support native tools/function_call.\n" +
 		"Available tools(JSON schema):\n" + string(toolJSON) + "\n" +
 		"Return ONLY one JSON object, no markdown/code fence.\n" +
-		"If a tool should be called, output:\n" +
-		"{\"tool_call\":{\"name\":\"<tool_name>\",\"arguments\":{...}}}\n" +
+		"If tools should be called, output:\n" +
+		"{\"tool_calls\":[{\"name\":\"<tool_name>\",\"arguments\":{...}}]}\n" +
+		"Put several entries in tool_calls when multiple independent calls are needed in this turn.\n" +
+		"Arguments must be a JSON object matching the tool's parameters schema.\n"
+
+	switch choice.Mode {
+	case compatChoiceRequired:
+		return prompt + "You MUST call at least one tool. Do not output a final answer."
+	case compatChoiceFunction:
+		return prompt + "You MUST call the tool \"" + choice.Name + "\". Do not output a final answer."
+	}
+	return prompt +
 		"If no tool call is needed, output:\n" +
 		"{\"final\":\"<assistant_response>\"}"
 }
@@ -210,18 +362,18 @@ func buildCompatResponse(upstreamResp *model.ChatCompletionResponse) *model.Chat
 	}
 
 	text := extractResponseText(upstreamResp)
-	toolName, toolArgs, finalText, ok := parseCompatOutput(text)
-	if ok && toolName != "" {
+	calls, finalText, ok := parseCompatOutput(text)
+	if ok && len(calls) > 0 {
 		resp.Choices[0].Message.Content = ""
-		resp.Choices[0].Message.ToolCalls = []model.ToolCall{
-			{
-				ID:   fmt.Sprintf("call_%d", time.Now().UnixNano()),
+		for _, call := range calls {
+			resp.Choices[0].Message.ToolCalls = append(resp.Choices[0].Message.ToolCalls, model.ToolCall{
+				ID:   core.GenerateToolCallID(),
 				Type: "function",
 				Function: model.FunctionCall{
-					Name:      toolName,
-					Arguments: toolArgs,
+					Name:      call.Name,
+					Arguments: call.Arguments,
 				},
-			},
+			})
 		}
 		resp.Choices[0].FinishReason = "tool_calls"
 		return resp
@@ -237,34 +389,111 @@ func buildCompatResponse(upstreamResp *model.ChatCompletionResponse) *model.Chat
 	return resp
 }
 
-func parseCompatOutput(text string) (toolName string, toolArgs string, final string, ok bool) {
+func parseCompatOutput(text string) (calls []compatToolCall, final string, ok bool) {
 	clean := stripCodeFence(text)
 	if clean == "" {
-		return "", "", "", false
+		return nil, "", false
 	}
 
 	var payload fcCompatPayload
 	if err := json.Unmarshal([]byte(clean), &payload); err != nil {
-		return "", "", "", false
+		return nil, "", false
 	}
 
-	if payload.ToolCall != nil && strings.TrimSpace(payload.ToolCall.Name) != "" {
-		args := strings.TrimSpace(string(payload.ToolCall.Arguments))
-		if args == "" || args == "null" {
-			args = "{}"
-		}
-		if !json.Valid([]byte(args)) {
-			b, _ := json.Marshal(map[string]string{"input": args})
-			args = string(b)
+	raw := payload.ToolCalls
+	if payload.ToolCall != nil {
+		raw = append(raw, *payload.ToolCall)
+	}
+	for _, tc := range raw {
+		name := strings.TrimSpace(tc.Name)
+		if name == "" {
+			continue
 		}
-		return strings.TrimSpace(payload.ToolCall.Name), args, "", true
+		calls = append(calls, compatToolCall{Name: name, Arguments: normalizeCompatArguments(tc.Arguments)})
+	}
+	if len(calls) > 0 {
+		return calls, "", true
 	}
 
 	if strings.TrimSpace(payload.Final) != "" {
-		return "", "", strings.TrimSpace(payload.Final), true
+		return nil, strings.TrimSpace(payload.Final), true
+	}
+
+	return nil, "", false
+}
+
+// normalizeCompatArguments 归一化 arguments：空值视为 {}，被编码为字符串的 JSON 取出原文
+func normalizeCompatArguments(raw json.RawMessage) string {
+	args := strings.TrimSpace(string(raw))
+	if args == "" || args == "null" {
+		return "{}"
+	}
+	var s string
+	if json.Unmarshal([]byte(args), &s) == nil {
+		if inner := strings.TrimSpace(s); json.Valid([]byte(inner)) {
+			return inner
+		}
+	}
+	return args
+}
+
+// validateCompatOutput 校验兼容层输出：格式、tool_choice 约束以及各调用参数是否符合 schema
+func validateCompatOutput(text string, tools []model.Tool, choice compatToolChoice) error {
+	calls, _, ok := parseCompatOutput(text)
+	if !ok && strings.HasPrefix(stripCodeFence(text), "{") {
+		return fmt.Errorf("output must be a valid JSON object containing \"tool_calls\" or \"final\"")
 	}
 
-	return "", "", "", false
+	switch choice.Mode {
+	case compatChoiceRequired:
+		if len(calls) == 0 {
+			return fmt.Errorf("at least one tool call is required")
+		}
+	case compatChoiceFunction:
+		if len(calls) == 0 {
+			return fmt.Errorf("tool %q must be called", choice.Name)
+		}
+	}
+
+	schemas := make(map[string]map[string]any, len(tools))
+	for _, tool := range tools {
+		schemas[tool.Function.Name] = tool.Function.Parameters
+	}
+	for _, call := range calls {
+		if choice.Mode == compatChoiceFunction && call.Name != choice.Name {
+			return fmt.Errorf("only tool %q may be called, got %q", choice.Name, call.Name)
+		}
+		schema, ok := schemas[call.Name]
+		if !ok {
+			return fmt.Errorf("unknown tool %q", call.Name)
+		}
+		if err := core.ValidateJSONArguments(call.Arguments, schema); err != nil {
+			return fmt.Errorf("invalid arguments for %s: %w", call.Name, err)
+		}
+	}
+	return nil
+}
+
+// addUsage 合并多次上游调用的 usage
+func addUsage(a, b *model.Usage) *model.Usage {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	sum := &model.Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+	if a.PromptTokensDetails != nil || b.PromptTokensDetails != nil {
+		sum.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:        a.CachedTokens() + b.CachedTokens(),
+			CacheCreationTokens: a.CacheCreationTokens() + b.CacheCreationTokens(),
+		}
+	}
+	return sum
 }
 
 func stripCodeFence(text string) string {
@@ -351,61 +580,3 @@ func fallbackChatModel(resp *model.ChatCompletionResponse) string {
 	}
 	return ""
 }
-
-func writeCompatStreamResponse(c *gin.Context, resp *model.ChatCompletionResponse) {
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Transfer-Encoding", "chunked")
-
-	if resp == nil || len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
-		fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-		c.Writer.Flush()
-		return
-	}
-
-	msg := resp.Choices[0].Message
-	firstDelta := &model.Message{
-		Role: "assistant",
-	}
-	if len(msg.ToolCalls) > 0 {
-		firstDelta.ToolCalls = msg.ToolCalls
-		firstDelta.Content = ""
-	} else {
-		firstDelta.Content = msg.Content
-	}
-
-	firstChunk := model.StreamChunk{
-		ID:      resp.ID,
-		Object:  "chat.completion.chunk",
-		Created: time.Now().Unix(),
-		Model:   resp.Model,
-		Choices: []model.Choice{
-			{
-				Index: 0,
-				Delta: firstDelta,
-			},
-		},
-	}
-
-	b, _ := json.Marshal(firstChunk)
-	fmt.Fprintf(c.Writer, "data: %s\n\n", b)
-	c.Writer.Flush()
-
-	endChunk := model.StreamChunk{
-		ID:      resp.ID,
-		Object:  "chat.completion.chunk",
-		Created: firstChunk.Created,
-		Model:   resp.Model,
-		Choices: []model.Choice{
-			{
-				Index:        0,
-				FinishReason: resp.Choices[0].FinishReason,
-			},
-		},
-	}
-	endData, _ := json.Marshal(endChunk)
-	fmt.Fprintf(c.Writer, "data: %s\n\n", endData)
-	fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-	c.Writer.Flush()
-}
@@ -0,0 +1,496 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// fcCompatStreamMode 兼容层流式输出的识别状态
+type fcCompatStreamMode int
+
+const (
+	fcCompatModeUndecided fcCompatStreamMode = iota // 尚未看到首个有效字符
+	fcCompatModeJSON                                // 按约定输出 JSON 对象
+	fcCompatModeText                                // 模型未遵守约定，按普通文本透传
+)
+
+// fcCompatStreamParser 兼容层增量解析器
+// 随上游文本增量解析 {"final":...}，尽早产出 content 增量；tool_calls 缓存到结束，校验通过后整体输出
+type fcCompatStreamParser struct {
+	text     strings.Builder
+	mode     fcCompatStreamMode
+	body     int  // JSON 主体在 text 中的起始位置（跳过空白和代码块标记）
+	sentText int  // 已输出的文本长度（final 解码后 / 普通文本）
+	hold     bool // tool_choice 要求调用工具：结束前不输出任何内容，校验通过后再输出
+}
+
+// newFCCompatStreamParser 创建兼容层增量解析器
+// tool_choice=none 时上游输出按普通文本透传；required / 指定函数时不提交文本，等结束后校验
+func newFCCompatStreamParser(choice compatToolChoice) *fcCompatStreamParser {
+	p := &fcCompatStreamParser{}
+	switch choice.Mode {
+	case compatChoiceNone:
+		p.mode = fcCompatModeText
+	case compatChoiceRequired, compatChoiceFunction:
+		p.hold = true
+	}
+	return p
+}
+
+// feed 追加上游文本增量，返回可立即输出的增量消息
+func (p *fcCompatStreamParser) feed(delta string) []*model.Message {
+	if delta == "" {
+		return nil
+	}
+	p.text.WriteString(delta)
+	if p.hold {
+		return nil
+	}
+
+	if p.mode == fcCompatModeUndecided {
+		p.detectMode()
+	}
+
+	switch p.mode {
+	case fcCompatModeText:
+		return p.emitText(p.text.String()[p.body:])
+	case fcCompatModeJSON:
+		return p.emitScan(scanCompatOutput(p.text.String()[p.body:]))
+	}
+	return nil
+}
+
+// finish 上游结束后输出剩余增量，并返回 finish_reason
+// 尚未输出文本时 text 为最终采用的输出（已通过校验，或来自修复失败后的纯文本兜底请求）
+func (p *fcCompatStreamParser) finish(text string) ([]*model.Message, string) {
+	if p.committed() || p.mode == fcCompatModeText {
+		// 文本已开始输出（或按普通文本透传）：只补齐剩余文本
+		output := p.output()
+		var deltas []*model.Message
+		if p.mode == fcCompatModeText {
+			deltas = p.emitText(output[p.body:])
+		} else {
+			deltas = p.emitScan(scanCompatOutput(output[p.body:]))
+		}
+		if !p.committed() {
+			deltas = append(deltas, p.emitText("(empty response)")...)
+		}
+		return deltas, "stop"
+	}
+
+	calls, final, ok := parseCompatOutput(text)
+	if len(calls) > 0 {
+		deltas := make([]*model.Message, 0, len(calls))
+		for i, call := range calls {
+			deltas = append(deltas, toolCallDelta(i, call))
+		}
+		return deltas, "tool_calls"
+	}
+	if ok {
+		text = final
+	}
+
+	// 与非流式一致：不符合约定时原样返回文本，空输出给出占位内容
+	text = strings.TrimSpace(text)
+	if text == "" {
+		text = "(empty response)"
+	}
+	return p.emitText(text), "stop"
+}
+
+// committed 返回是否已向客户端输出文本，之后无法再校验和修复
+func (p *fcCompatStreamParser) committed() bool {
+	return p.sentText > 0
+}
+
+// output 返回上游输出的完整原始文本
+func (p *fcCompatStreamParser) output() string {
+	return p.text.String()
+}
+
+// detectMode 根据首个有效字符判断输出是 JSON 还是普通文本
+func (p *fcCompatStreamParser) detectMode() {
+	text := p.text.String()
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	if trimmed == "" {
+		return
+	}
+	p.body = len(text) - len(trimmed)
+
+	// 代码块：等到首行结束后再判断
+	if strings.HasPrefix(trimmed, "```") || strings.HasPrefix("```", trimmed) {
+		nl := strings.IndexByte(trimmed, '\n')
+		if nl < 0 {
+			return
+		}
+		rest := strings.TrimLeft(trimmed[nl+1:], " \t\r\n")
+		if rest == "" {
+			return
+		}
+		if rest[0] == '{' {
+			p.body = len(text) - len(rest)
+			p.mode = fcCompatModeJSON
+		} else {
+			p.mode = fcCompatModeText // 普通文本连同代码块标记原样输出
+		}
+		return
+	}
+
+	if trimmed[0] == '{' {
+		p.mode = fcCompatModeJSON
+	} else {
+		p.mode = fcCompatModeText
+	}
+}
+
+// emitText 输出 text 中尚未输出的部分
+func (p *fcCompatStreamParser) emitText(text string) []*model.Message {
+	if len(text) <= p.sentText {
+		return nil
+	}
+	delta := text[p.sentText:]
+	p.sentText = len(text)
+	return []*model.Message{{Content: delta}}
+}
+
+// emitScan 根据解析结果输出新增的 final 文本
+func (p *fcCompatStreamParser) emitScan(scan compatScan) []*model.Message {
+	if !scan.hasFinal {
+		return nil
+	}
+	// 与非流式一致，忽略 final 的前导空白
+	return p.emitText(strings.TrimLeft(scan.final, " \t\r\n"))
+}
+
+// toolCallDelta 构造第 idx 个工具调用的完整 tool_calls 增量（id、name 和 arguments）
+func toolCallDelta(idx int, call compatToolCall) *model.Message {
+	return &model.Message{
+		ToolCalls: []model.ToolCall{{
+			Index:    &idx,
+			ID:       core.GenerateToolCallID(),
+			Type:     "function",
+			Function: model.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+		}},
+	}
+}
+
+// compatScan 对可能不完整的兼容层 JSON 输出的容错解析结果
+type compatScan struct {
+	hasFinal bool
+	final    string // 已解码的 final 文本（可能不完整）
+}
+
+// scanCompatOutput 容错解析（可能不完整的）兼容层 JSON 输出中的 final 文本
+func scanCompatOutput(text string) compatScan {
+	var res compatScan
+	s := &partialJSON{s: text}
+	s.object(func(key string) bool {
+		if key == "final" && s.peek() == '"' {
+			res.hasFinal = true
+			var done bool
+			res.final, done = s.str()
+			return done
+		}
+		return s.skip()
+	})
+	return res
+}
+
+// partialJSON 容错的 JSON 扫描器，遇到输入结尾时停止而不报错
+type partialJSON struct {
+	s string
+	i int
+}
+
+func (p *partialJSON) ws() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// peek 跳过空白并返回下一个字符，输入结束时返回 0
+func (p *partialJSON) peek() byte {
+	p.ws()
+	if p.i >= len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+// object 扫描对象，对每个键调用 value（此时位于值的起始处）
+// value 返回 false 表示值不完整；整个对象完整时返回 true
+func (p *partialJSON) object(value func(key string) bool) bool {
+	if p.peek() != '{' {
+		return false
+	}
+	p.i++
+	for {
+		switch p.peek() {
+		case '}':
+			p.i++
+			return true
+		case '"':
+		default:
+			return false
+		}
+		key, ok := p.str()
+		if !ok || p.peek() != ':' {
+			return false
+		}
+		p.i++
+		if p.peek() == 0 || !value(key) {
+			return false
+		}
+		if p.peek() == ',' {
+			p.i++
+		}
+	}
+}
+
+// str 扫描字符串并返回已解码内容；字符串未闭合时返回已确定的部分和 false
+func (p *partialJSON) str() (string, bool) {
+	p.i++ // 开头的引号
+	start, safe := p.i, p.i
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case '"':
+			raw := p.s[start:p.i]
+			p.i++
+			return decodeJSONString(raw), true
+		case '\\':
+			n := jsonEscapeLen(p.s[p.i:])
+			if n == 0 {
+				p.i = len(p.s)
+				return decodeJSONString(p.s[start:safe]), false
+			}
+			p.i += n
+		default:
+			p.i++
+		}
+		safe = p.i
+	}
+	return decodeJSONString(p.s[start:safe]), false
+}
+
+// skip 跳过任意值，值完整时返回 true
+func (p *partialJSON) skip() bool {
+	switch p.peek() {
+	case 0:
+		return false
+	case '"':
+		_, ok := p.str()
+		return ok
+	case '{', '[':
+		depth := 0
+		for p.i < len(p.s) {
+			switch p.s[p.i] {
+			case '"':
+				if _, ok := p.str(); !ok {
+					return false
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			p.i++
+			if depth == 0 {
+				return true
+			}
+		}
+		return false
+	default:
+		for p.i < len(p.s) && strings.IndexByte(",}] \t\r\n", p.s[p.i]) < 0 {
+			p.i++
+		}
+		return p.i < len(p.s)
+	}
+}
+
+// jsonEscapeLen 返回转义序列的长度；转义不完整（含代理对的前半部分）时返回 0
+func jsonEscapeLen(s string) int {
+	if len(s) < 2 {
+		return 0
+	}
+	if s[1] != 'u' {
+		return 2
+	}
+	if len(s) < 6 {
+		return 0
+	}
+	if hex := strings.ToUpper(s[2:4]); hex >= "D8" && hex <= "DB" {
+		if len(s) < 12 {
+			return 0
+		}
+		if s[6] == '\\' && s[7] == 'u' {
+			return 12
+		}
+	}
+	return 6
+}
+
+// decodeJSONString 解码 JSON 字符串内容（不含引号），失败时返回原文
+func decodeJSONString(raw string) string {
+	var s string
+	if err := json.Unmarshal([]byte(`"`+raw+`"`), &s); err != nil {
+		return raw
+	}
+	return s
+}
+
+// handleFCCompatStream 流式兼容层：增量解析上游文本，尽早输出 content 增量
+// 工具调用（以及 tool_choice 要求调用工具时的全部输出）缓存到结束，与非流式一致地校验和修复后再输出
+// 与 handleStreamRequest 一致，首个增量输出前的失败返回 false 以便 failover
+func (h *ProxyHandler) handleFCCompatStream(c *gin.Context, originalReq, compatReq *model.ChatCompletionRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
+	compatReq.Stream = true
+	stream, err := h.openStream(c.Request.Context(), compatReq, src, startTime)
+	if err != nil {
+		return false, err
+	}
+	defer stream.close()
+
+	encoder := encoderFromContext(c)
+	choice := parseCompatToolChoice(originalReq)
+	parser := newFCCompatStreamParser(choice)
+	clientWantsUsage := originalReq.StreamOptions != nil && originalReq.StreamOptions.IncludeUsage
+	var usage *model.Usage
+	id := fallbackChatID(nil)
+	modelName := originalReq.Model
+	created := time.Now().Unix()
+	committed := false
+	finished := false
+	var streamErr error
+
+	write := func(delta *model.Message, finishReason string) {
+		chunk := model.StreamChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   modelName,
+			Choices: []model.Choice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		}
+		if !committed {
+			writeSSEHeaders(c)
+			committed = true
+			if delta != nil {
+				delta.Role = "assistant"
+			}
+		}
+		b, _ := json.Marshal(chunk)
+		encoder.writeStreamFrame(c, string(b))
+	}
+
+	for {
+		frames, done, err := stream.next()
+		if err == io.EOF {
+			if !finished {
+				streamErr = io.ErrUnexpectedEOF
+			}
+			break
+		}
+
+		for _, frame := range frames {
+			var chunk model.StreamChunk
+			if frame == "[DONE]" || json.Unmarshal([]byte(frame), &chunk) != nil {
+				continue
+			}
+			if chunk.ID != "" {
+				id = chunk.ID
+			}
+			if chunk.Model != "" {
+				modelName = chunk.Model
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			for _, choice := range chunk.Choices {
+				if choice.Delta != nil {
+					if text, ok := choice.Delta.Content.(string); ok {
+						// 工具调用和 hold 模式会缓存到上游结束：收到首个内容增量即停止首 token 计时，而不是等到向客户端输出
+						if text != "" {
+							stream.firstToken()
+						}
+						for _, delta := range parser.feed(text) {
+							write(delta, "")
+						}
+					}
+				}
+				if choice.FinishReason != "" {
+					finished = true
+				}
+			}
+		}
+		if committed {
+			c.Writer.Flush()
+		}
+
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if done {
+			break
+		}
+	}
+
+	if usage == nil {
+		usage = core.EstimateUsage(compatReq, parser.output())
+	}
+
+	if streamErr != nil {
+		return h.handleStreamFailure(c, originalReq, src, startTime, usage, streamErr, committed, failoverFrom, clientInfo, true)
+	}
+
+	// 尚未输出任何内容时校验工具调用，不合法则修复（已输出文本的回答无法再修复）
+	text, repairs := parser.output(), 0
+	if choice.Mode != compatChoiceNone && !parser.committed() {
+		resp := &model.ChatCompletionResponse{
+			Choices: []model.Choice{{Message: &model.Message{Role: "assistant", Content: text}}},
+			Usage:   usage,
+		}
+		var verr error
+		resp, repairs, verr = h.repairCompatOutput(c, compatReq, resp, src, collectCompatTools(originalReq), choice)
+		if verr != nil {
+			// 修复次数用尽：改为请求纯文本回答；此时尚未向客户端输出，失败时可以 failover
+			if resp, err = h.fallbackCompatText(c, compatReq, resp, src, verr); err != nil {
+				if c.Request.Context().Err() == nil {
+					h.updateSourceLatency(src, time.Since(startTime), err)
+				}
+				return false, fmt.Errorf("[%s] %w", src.Name, err)
+			}
+		}
+		text, usage = extractResponseText(resp), resp.Usage
+	}
+
+	// 输出剩余增量和结束块
+	deltas, finishReason := parser.finish(text)
+	for _, delta := range deltas {
+		write(delta, "")
+	}
+	write(&model.Message{}, finishReason)
+	if clientWantsUsage {
+		b, _ := json.Marshal(model.StreamChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   modelName,
+			Choices: []model.Choice{},
+			Usage:   usage,
+		})
+		encoder.writeStreamFrame(c, string(b))
+	}
+	encoder.writeStreamFrame(c, "[DONE]")
+	c.Writer.Flush()
+
+	h.updateSourceLatency(src, time.Since(startTime), nil)
+	h.logStreamRequest(c, originalReq, src, startTime, usage, nil, failoverFrom, clientInfo, true, repairs)
+	return true, nil
+}
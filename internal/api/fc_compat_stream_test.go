@@ -0,0 +1,352 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// compatStreamResult 汇总解析器产出的增量
+type compatStreamResult struct {
+	content      string
+	toolName     string
+	toolID       string
+	args         string
+	finishReason string
+	headerAt     int // 输出 tool_call 头时已喂入的字节数
+}
+
+// feedCompatStream 按 size 个字符切分 text 逐段喂给解析器（上游增量总是完整的 UTF-8 字符）
+func feedCompatStream(text string, size int) compatStreamResult {
+	p := newFCCompatStreamParser(compatToolChoice{Mode: compatChoiceAuto})
+	res := compatStreamResult{headerAt: -1}
+	collect := func(deltas []*model.Message, fed int) {
+		for _, d := range deltas {
+			if s, ok := d.Content.(string); ok {
+				res.content += s
+			}
+			for _, tc := range d.ToolCalls {
+				if tc.Function.Name != "" {
+					res.toolName = tc.Function.Name
+					res.toolID = tc.ID
+					res.headerAt = fed
+				}
+				res.args += tc.Function.Arguments
+			}
+		}
+	}
+	runes := []rune(text)
+	fed := 0
+	for i := 0; i < len(runes); i += size {
+		end := i + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		part := string(runes[i:end])
+		fed += len(part)
+		collect(p.feed(part), fed)
+	}
+	deltas, finish := p.finish(p.output())
+	collect(deltas, len(text))
+	res.finishReason = finish
+	return res
+}
+
+func TestFCCompatStreamParser_ToolCall(t *testing.T) {
+	text := `{"tool_call":{"name":"get_weather","arguments":{"city":"Paris","note":"a \"b\" }"}}}`
+	for _, size := range []int{1, 3, 7, len(text)} {
+		res := feedCompatStream(text, size)
+		if res.toolName != "get_weather" || res.toolID == "" {
+			t.Errorf("size %d: unexpected tool header: %+v", size, res)
+		}
+		if res.args != `{"city":"Paris","note":"a \"b\" }"}` {
+			t.Errorf("size %d: unexpected args %q", size, res.args)
+		}
+		if res.finishReason != "tool_calls" || res.content != "" {
+			t.Errorf("size %d: unexpected result: %+v", size, res)
+		}
+	}
+
+	// 工具调用缓存到上游结束、校验之后才输出
+	res := feedCompatStream(text, 1)
+	if res.headerAt != len(text) {
+		t.Errorf("expected tool call only after the output completes, got at %d", res.headerAt)
+	}
+}
+
+func TestFCCompatStreamParser_ToolCallStringArguments(t *testing.T) {
+	res := feedCompatStream(`{"tool_call":{"name":"run","arguments":null}}`, 2)
+	if res.toolName != "run" || res.args != "{}" || res.finishReason != "tool_calls" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestFCCompatStreamParser_Final(t *testing.T) {
+	text := `{"final":"Bonjour été\nline2 😀 \"quoted\""}`
+	for _, size := range []int{1, 4, len(text)} {
+		res := feedCompatStream(text, size)
+		if res.content != "Bonjour été\nline2 😀 \"quoted\"" {
+			t.Errorf("size %d: unexpected content %q", size, res.content)
+		}
+		if res.finishReason != "stop" || res.toolName != "" {
+			t.Errorf("size %d: unexpected result: %+v", size, res)
+		}
+	}
+}
+
+func TestFCCompatStreamParser_CodeFence(t *testing.T) {
+	res := feedCompatStream("```json\n{\"final\":\"hi\"}\n```", 2)
+	if res.content != "hi" || res.finishReason != "stop" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestFCCompatStreamParser_PlainText(t *testing.T) {
+	res := feedCompatStream("  Sure, here you go.", 3)
+	if res.content != "Sure, here you go." || res.finishReason != "stop" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestFCCompatStreamParser_UnknownJSON(t *testing.T) {
+	res := feedCompatStream(`{"answer":"42"}`, 3)
+	if res.content != `{"answer":"42"}` || res.finishReason != "stop" {
+		t.Errorf("expected raw text fallback, got %+v", res)
+	}
+}
+
+func TestFCCompatStreamParser_Empty(t *testing.T) {
+	res := feedCompatStream("   ", 1)
+	if res.content != "(empty response)" {
+		t.Errorf("expected placeholder, got %+v", res)
+	}
+}
+
+func TestChatCompletions_FCCompatStreams(t *testing.T) {
+	output := `{"tool_call":{"name":"get_weather","arguments":{"city":"Paris"}}}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < len(output); i += 5 {
+			end := i + 5
+			if end > len(output) {
+				end = len(output)
+			}
+			delta := strings.ReplaceAll(output[i:end], `"`, `\"`)
+			fmt.Fprintf(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%s\"}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","stream":true,"messages":[{"role":"user","content":"weather?"}],"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := w.Body.String()
+	for _, want := range []string{`"name":"get_weather"`, `"arguments":"{\"city\":\"Paris\"}"`, `"finish_reason":"tool_calls"`, "data: [DONE]"} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("expected %s in stream, got:\n%s", want, resp)
+		}
+	}
+	if strings.Contains(resp, "tool_call\\\"") {
+		t.Errorf("raw compat JSON leaked to client:\n%s", resp)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || !logs[0].Success || !logs[0].FCCompatUsed || !logs[0].Stream {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+func TestFCCompatStreamParser_MultipleToolCalls(t *testing.T) {
+	text := `{"tool_calls":[{"name":"a","arguments":{"x":1}},{"name":"b","arguments":{"y":[1,2]}}]}`
+	p := newFCCompatStreamParser(compatToolChoice{Mode: compatChoiceAuto})
+
+	names := map[int]string{}
+	ids := map[int]string{}
+	args := map[int]string{}
+	collect := func(deltas []*model.Message) {
+		for _, d := range deltas {
+			for _, tc := range d.ToolCalls {
+				if tc.Index == nil {
+					t.Fatal("expected tool call index")
+				}
+				if tc.Function.Name != "" {
+					names[*tc.Index] = tc.Function.Name
+					ids[*tc.Index] = tc.ID
+				}
+				args[*tc.Index] += tc.Function.Arguments
+			}
+		}
+	}
+	for _, r := range text {
+		collect(p.feed(string(r)))
+	}
+	deltas, finish := p.finish(p.output())
+	collect(deltas)
+
+	if finish != "tool_calls" || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("unexpected calls: %v (finish=%s)", names, finish)
+	}
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Errorf("expected distinct ids, got %v", ids)
+	}
+	if args[0] != `{"x":1}` || args[1] != `{"y":[1,2]}` {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestFCCompatStreamParser_ToolChoiceNone(t *testing.T) {
+	p := newFCCompatStreamParser(compatToolChoice{Mode: compatChoiceNone})
+	var content string
+	for _, d := range p.feed(`{"tool_calls":[]}`) {
+		content += d.Content.(string)
+	}
+	if _, finish := p.finish(p.output()); finish != "stop" || content != `{"tool_calls":[]}` {
+		t.Errorf("expected plain passthrough with tool_choice=none, got %q (%s)", content, finish)
+	}
+}
+
+func TestChatCompletions_FCCompatStreamRepairsRequiredToolCall(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req model.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			// 首轮不遵守 tool_choice=required，直接回答
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"It is sunny.\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"c2","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"{\"tool_calls\":[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}]}"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","stream":true,"tool_choice":"required","messages":[{"role":"user","content":"weather?"}],"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object","required":["city"]}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := w.Body.String()
+	if calls != 2 {
+		t.Errorf("expected one repair request, got %d upstream calls", calls)
+	}
+	if strings.Contains(resp, "It is sunny.") {
+		t.Errorf("plain text must not be committed with tool_choice=required:\n%s", resp)
+	}
+	for _, want := range []string{`"name":"get_weather"`, `{\"city\":\"Paris\"}`, `"finish_reason":"tool_calls"`} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("expected %s in stream, got:\n%s", want, resp)
+		}
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || !logs[0].Success || logs[0].RepairCount != 1 {
+		t.Errorf("expected one repair to be logged, got %+v", logs)
+	}
+}
+
+func TestChatCompletions_FCCompatStreamFallsBackToTextOnInvalidArguments(t *testing.T) {
+	var calls int
+	invalid := `{"tool_calls":[{"name":"set_level","arguments":{"level":"high"}}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req model.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			b, _ := json.Marshal(model.StreamChunk{ID: "c1", Model: "m", Choices: []model.Choice{{Delta: &model.Message{Content: invalid}}}})
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		content := invalid
+		if req.Messages[0].Role != "system" {
+			// 兜底的纯文本请求不带兼容层提示词
+			content = "Levels are integers."
+		}
+		b, _ := json.Marshal(model.ChatCompletionResponse{
+			ID:      "c2",
+			Model:   "m",
+			Choices: []model.Choice{{Message: &model.Message{Role: "assistant", Content: content}, FinishReason: "stop"}},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","stream":true,"messages":[{"role":"user","content":"set it"}],"tools":[{"type":"function","function":{"name":"set_level","parameters":{"type":"object","properties":{"level":{"type":"integer"}},"required":["level"]}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 4 {
+		t.Errorf("expected initial stream, 2 repairs and a text-only request, got %d upstream calls", calls)
+	}
+
+	resp := w.Body.String()
+	if strings.Contains(resp, `tool_calls`) || !strings.Contains(resp, "Levels are integers.") || !strings.Contains(resp, `"finish_reason":"stop"`) {
+		t.Errorf("expected text fallback without tool calls, got:\n%s", resp)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].RepairCount != 2 {
+		t.Errorf("expected repair count 2 in log, got %+v", logs)
+	}
+}
+
+func TestChatCompletions_FCCompatSlowToolCallOutlivesFirstTokenTimeout(t *testing.T) {
+	output := `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		// 工具调用 JSON 在结束前不会输出给客户端，整体耗时超过首 token 超时
+		for i := 0; i < len(output); i += 10 {
+			end := i + 10
+			if end > len(output) {
+				end = len(output)
+			}
+			b, _ := json.Marshal(model.StreamChunk{ID: "c1", Model: "m", Choices: []model.Choice{{Delta: &model.Message{Content: output[i:end]}}}})
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+			time.Sleep(250 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+	h.cfg.Routing.Failover.FirstTokenTimeout = 1
+
+	body := `{"model":"m","stream":true,"messages":[{"role":"user","content":"weather?"}],"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"finish_reason":"tool_calls"`) {
+		t.Fatalf("expected the slow tool call to complete, got %d:\n%s", w.Code, w.Body.String())
+	}
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || !logs[0].Success {
+		t.Errorf("expected a single successful log, got %+v", logs)
+	}
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/xiaopang/fusionapi/internal/model"
@@ -212,30 +214,45 @@ func TestNormalizeCompatMessages_PlainMessages(t *testing.T) {
 
 func TestParseCompatOutput_ToolCall(t *testing.T) {
 	input := `{"tool_call":{"name":"get_weather","arguments":{"city":"NYC"}}}`
-	name, args, final, ok := parseCompatOutput(input)
+	calls, final, ok := parseCompatOutput(input)
 	if !ok {
 		t.Fatal("expected ok=true")
 	}
-	if name != "get_weather" {
-		t.Errorf("expected name=get_weather, got %s", name)
+	if len(calls) != 1 || calls[0].Name != "get_weather" {
+		t.Fatalf("expected one get_weather call, got %+v", calls)
 	}
 	if final != "" {
 		t.Errorf("expected empty final, got %s", final)
 	}
 	// args should be valid JSON
-	if !json.Valid([]byte(args)) {
-		t.Errorf("args should be valid JSON, got: %s", args)
+	if !json.Valid([]byte(calls[0].Arguments)) {
+		t.Errorf("args should be valid JSON, got: %s", calls[0].Arguments)
+	}
+}
+
+func TestParseCompatOutput_MultipleToolCalls(t *testing.T) {
+	input := `{"tool_calls":[{"name":"get_weather","arguments":{"city":"NYC"}},{"name":"get_time","arguments":"{\"tz\":\"EST\"}"}]}`
+	calls, _, ok := parseCompatOutput(input)
+	if !ok || len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %+v (ok=%v)", calls, ok)
+	}
+	if calls[0].Name != "get_weather" || calls[1].Name != "get_time" {
+		t.Errorf("unexpected call order: %+v", calls)
+	}
+	// 字符串形式的参数应取出其中的 JSON
+	if calls[1].Arguments != `{"tz":"EST"}` {
+		t.Errorf("expected string arguments to be unwrapped, got %s", calls[1].Arguments)
 	}
 }
 
 func TestParseCompatOutput_Final(t *testing.T) {
 	input := `{"final":"The weather is sunny"}`
-	name, _, final, ok := parseCompatOutput(input)
+	calls, final, ok := parseCompatOutput(input)
 	if !ok {
 		t.Fatal("expected ok=true")
 	}
-	if name != "" {
-		t.Errorf("expected empty name, got %s", name)
+	if len(calls) != 0 {
+		t.Errorf("expected no calls, got %+v", calls)
 	}
 	if final != "The weather is sunny" {
 		t.Errorf("unexpected final: %s", final)
@@ -244,24 +261,24 @@ func TestParseCompatOutput_Final(t *testing.T) {
 
 func TestParseCompatOutput_CodeFence(t *testing.T) {
 	input := "```json\n{\"tool_call\":{\"name\":\"search\",\"arguments\":{\"q\":\"test\"}}}\n```"
-	name, _, _, ok := parseCompatOutput(input)
+	calls, _, ok := parseCompatOutput(input)
 	if !ok {
 		t.Fatal("expected ok=true for code-fenced output")
 	}
-	if name != "search" {
-		t.Errorf("expected name=search, got %s", name)
+	if len(calls) != 1 || calls[0].Name != "search" {
+		t.Errorf("expected name=search, got %+v", calls)
 	}
 }
 
 func TestParseCompatOutput_Empty(t *testing.T) {
-	_, _, _, ok := parseCompatOutput("")
+	_, _, ok := parseCompatOutput("")
 	if ok {
 		t.Error("expected ok=false for empty input")
 	}
 }
 
 func TestParseCompatOutput_InvalidJSON(t *testing.T) {
-	_, _, _, ok := parseCompatOutput("not json at all")
+	_, _, ok := parseCompatOutput("not json at all")
 	if ok {
 		t.Error("expected ok=false for invalid JSON")
 	}
@@ -269,25 +286,79 @@ func TestParseCompatOutput_InvalidJSON(t *testing.T) {
 
 func TestParseCompatOutput_ToolCallEmptyName(t *testing.T) {
 	input := `{"tool_call":{"name":"","arguments":{}}}`
-	name, _, _, ok := parseCompatOutput(input)
+	calls, _, _ := parseCompatOutput(input)
 	// Empty name should not be treated as a valid tool call
-	if ok && name != "" {
+	if len(calls) != 0 {
 		t.Error("expected empty name to not be a valid tool call")
 	}
 }
 
 func TestParseCompatOutput_ToolCallNullArgs(t *testing.T) {
 	input := `{"tool_call":{"name":"do_thing","arguments":null}}`
-	name, args, _, ok := parseCompatOutput(input)
-	if !ok {
+	calls, _, ok := parseCompatOutput(input)
+	if !ok || len(calls) != 1 {
 		t.Fatal("expected ok=true")
 	}
-	if name != "do_thing" {
-		t.Errorf("expected name=do_thing, got %s", name)
+	if calls[0].Name != "do_thing" {
+		t.Errorf("expected name=do_thing, got %s", calls[0].Name)
 	}
 	// null args should default to {}
-	if args != "{}" {
-		t.Errorf("expected args={}, got %s", args)
+	if calls[0].Arguments != "{}" {
+		t.Errorf("expected args={}, got %s", calls[0].Arguments)
+	}
+}
+
+// --- parseCompatToolChoice / validateCompatOutput ---
+
+func TestParseCompatToolChoice(t *testing.T) {
+	tests := []struct {
+		req  model.ChatCompletionRequest
+		want compatToolChoice
+	}{
+		{model.ChatCompletionRequest{}, compatToolChoice{Mode: compatChoiceAuto}},
+		{model.ChatCompletionRequest{ToolChoice: "none"}, compatToolChoice{Mode: compatChoiceNone}},
+		{model.ChatCompletionRequest{ToolChoice: "required"}, compatToolChoice{Mode: compatChoiceRequired}},
+		{model.ChatCompletionRequest{ToolChoice: map[string]any{"type": "function", "function": map[string]any{"name": "f"}}}, compatToolChoice{Mode: compatChoiceFunction, Name: "f"}},
+		{model.ChatCompletionRequest{FunctionCall: map[string]any{"name": "g"}}, compatToolChoice{Mode: compatChoiceFunction, Name: "g"}},
+	}
+	for _, tt := range tests {
+		if got := parseCompatToolChoice(&tt.req); got != tt.want {
+			t.Errorf("parseCompatToolChoice(%+v) = %+v, want %+v", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCompatOutput(t *testing.T) {
+	tools := []model.Tool{
+		{Type: "function", Function: model.Function{Name: "get_weather", Parameters: map[string]any{"type": "object", "required": []any{"city"}}}},
+		{Type: "function", Function: model.Function{Name: "get_time"}},
+	}
+	auto := compatToolChoice{Mode: compatChoiceAuto}
+
+	tests := []struct {
+		name    string
+		text    string
+		choice  compatToolChoice
+		wantErr string
+	}{
+		{"valid calls", `{"tool_calls":[{"name":"get_weather","arguments":{"city":"NYC"}},{"name":"get_time","arguments":{}}]}`, auto, ""},
+		{"plain text", `Sure thing`, auto, ""},
+		{"final", `{"final":"hi"}`, auto, ""},
+		{"broken json", `{"tool_calls":[{"name":"get_weather","arguments":{"city":}}]}`, auto, "valid JSON object"},
+		{"unknown tool", `{"tool_calls":[{"name":"nope","arguments":{}}]}`, auto, "unknown tool"},
+		{"missing required", `{"tool_calls":[{"name":"get_weather","arguments":{}}]}`, auto, "missing required property"},
+		{"non-object args", `{"tool_calls":[{"name":"get_time","arguments":"now"}]}`, auto, "JSON object"},
+		{"required without call", `{"final":"hi"}`, compatToolChoice{Mode: compatChoiceRequired}, "at least one tool call"},
+		{"wrong function", `{"tool_calls":[{"name":"get_time","arguments":{}}]}`, compatToolChoice{Mode: compatChoiceFunction, Name: "get_weather"}, "only tool"},
+	}
+	for _, tt := range tests {
+		err := validateCompatOutput(tt.text, tools, tt.choice)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
 	}
 }
 
@@ -327,6 +398,24 @@ func TestBuildCompatResponse_ToolCall(t *testing.T) {
 	}
 }
 
+func TestBuildCompatResponse_MultipleToolCalls(t *testing.T) {
+	upstream := &model.ChatCompletionResponse{
+		Choices: []model.Choice{{Index: 0, Message: &model.Message{
+			Role:    "assistant",
+			Content: `{"tool_calls":[{"name":"a","arguments":{}},{"name":"b","arguments":{"x":1}}]}`,
+		}}},
+	}
+
+	resp := buildCompatResponse(upstream)
+	calls := resp.Choices[0].Message.ToolCalls
+	if len(calls) != 2 || calls[0].Function.Name != "a" || calls[1].Function.Name != "b" {
+		t.Fatalf("expected calls a and b, got %+v", calls)
+	}
+	if calls[0].ID == "" || calls[0].ID == calls[1].ID {
+		t.Errorf("expected distinct tool call IDs, got %q and %q", calls[0].ID, calls[1].ID)
+	}
+}
+
 func TestBuildCompatResponse_FinalText(t *testing.T) {
 	upstream := &model.ChatCompletionResponse{
 		ID:      "chatcmpl-456",
@@ -495,3 +584,167 @@ func containsStr(s, substr string) bool {
 	}
 	return false
 }
+
+// --- handleFCCompatRequest ---
+
+func TestChatCompletions_FCCompatRepairsInvalidArguments(t *testing.T) {
+	var calls int
+	var repairPrompt string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req model.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		content := `{"tool_calls":[{"name":"get_weather","arguments":{}}]}`
+		if calls > 1 {
+			repairPrompt, _ = req.Messages[len(req.Messages)-1].Content.(string)
+			content = `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}},{"name":"get_weather","arguments":{"city":"Rome"}}]}`
+		}
+		b, _ := json.Marshal(model.ChatCompletionResponse{
+			ID:      "c1",
+			Model:   "m",
+			Choices: []model.Choice{{Message: &model.Message{Role: "assistant", Content: content}, FinishReason: "stop"}},
+			Usage:   &model.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","messages":[{"role":"user","content":"weather?"}],"tool_choice":"required","tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object","required":["city"]}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 2 || !contains(repairPrompt, `missing required property "city"`) {
+		t.Errorf("expected one repair round with the validation error, got %d calls, prompt %q", calls, repairPrompt)
+	}
+
+	var resp model.ChatCompletionResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Choices) != 1 || len(resp.Choices[0].Message.ToolCalls) != 2 {
+		t.Fatalf("expected two repaired tool calls, got %s", w.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].TotalTokens != 30 || logs[0].RepairCount != 1 {
+		t.Errorf("expected usage of both attempts and one repair to be logged, got %+v", logs)
+	}
+}
+
+func TestChatCompletions_FCCompatFallsBackToTextAfterRepairs(t *testing.T) {
+	var calls int
+	invalid := `{"tool_calls":[{"name":"set_level","arguments":{"level":"high"}}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req model.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		content := invalid
+		if req.Messages[0].Role != "system" {
+			// 兜底的纯文本请求不带兼容层提示词
+			content = "Levels go from 1 to 5."
+		}
+		b, _ := json.Marshal(model.ChatCompletionResponse{
+			ID:      "c1",
+			Model:   "m",
+			Choices: []model.Choice{{Message: &model.Message{Role: "assistant", Content: content}, FinishReason: "stop"}},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","messages":[{"role":"user","content":"set it"}],"tools":[{"type":"function","function":{"name":"set_level","parameters":{"type":"object","properties":{"level":{"type":"integer","minimum":1,"maximum":5}},"required":["level"]}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 4 {
+		t.Errorf("expected initial attempt, 2 repairs and a text-only request, got %d upstream calls", calls)
+	}
+
+	var resp model.ChatCompletionResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Choices) != 1 || len(resp.Choices[0].Message.ToolCalls) != 0 || resp.Choices[0].FinishReason != "stop" {
+		t.Fatalf("expected text fallback without tool calls, got %s", w.Body.String())
+	}
+	if resp.Choices[0].Message.Content != "Levels go from 1 to 5." {
+		t.Errorf("expected the text-only answer instead of the invalid tool call JSON, got %v", resp.Choices[0].Message.Content)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].RepairCount != 2 {
+		t.Errorf("expected repair count 2 in log, got %+v", logs)
+	}
+}
+
+func TestChatCompletions_FCCompatFailsWhenTextFallbackStillCallsTools(t *testing.T) {
+	invalid := `{"tool_calls":[{"name":"set_level","arguments":{"level":"high"}}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(model.ChatCompletionResponse{
+			ID:      "c1",
+			Model:   "m",
+			Choices: []model.Choice{{Message: &model.Message{Role: "assistant", Content: invalid}, FinishReason: "stop"}},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","messages":[{"role":"user","content":"set it"}],"tools":[{"type":"function","function":{"name":"set_level","parameters":{"type":"object","properties":{"level":{"type":"integer"}},"required":["level"]}}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code == 200 || contains(w.Body.String(), `"choices"`) {
+		t.Fatalf("expected an error instead of the invalid tool call JSON as an answer, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChatCompletions_FCCompatToolChoiceNone(t *testing.T) {
+	var upstreamReq model.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"{\"final\":\"x\"} is literal"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","messages":[{"role":"user","content":"hi"}],"tool_choice":"none","tools":[{"type":"function","function":{"name":"f"}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+
+	if len(upstreamReq.Messages) != 1 || upstreamReq.Messages[0].Role != "user" {
+		t.Errorf("expected no compat system prompt for tool_choice=none, got %+v", upstreamReq.Messages)
+	}
+	var resp model.ChatCompletionResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != `{"final":"x"} is literal` {
+		t.Errorf("expected upstream text returned verbatim, got %s", w.Body.String())
+	}
+}
+
+func TestChatCompletions_FCCompatPropagatesUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"messages: too many images","type":"invalid_request_error"}}`))
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "nofc", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	body := `{"model":"m","messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function","function":{"name":"f"}}]}`
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if w.Code != http.StatusBadRequest || !contains(w.Body.String(), "too many images") {
+		t.Fatalf("expected upstream 400 to be passed through, got %d: %s", w.Code, w.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || !contains(logs[0].Error, "[nofc]") || !contains(logs[0].Error, "400") {
+		t.Errorf("expected the wrapped upstream error in the log, got %+v", logs)
+	}
+}
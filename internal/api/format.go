@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// ClientEncoderKey 入口协议编码器（存入 gin.Context）
+const ClientEncoderKey = "client_encoder"
+
+// clientEncoder 将内部 OpenAI 格式的结果按入口协议写回客户端
+type clientEncoder interface {
+	writeResponse(c *gin.Context, status int, resp *model.ChatCompletionResponse)
+	writeStreamFrame(c *gin.Context, data string) // data 为 OpenAI chunk JSON 或 [DONE]
+	writeError(c *gin.Context, status int, detail model.ErrorDetail)
+}
+
+// streamErrorWriter 可在已开始输出的流中报告错误的入口协议（上游流中途中断时使用）
+type streamErrorWriter interface {
+	writeStreamError(c *gin.Context, detail model.ErrorDetail)
+}
+
+// encoderFromContext 获取入口协议编码器，默认 OpenAI
+func encoderFromContext(c *gin.Context) clientEncoder {
+	if v, ok := c.Get(ClientEncoderKey); ok {
+		if enc, ok := v.(clientEncoder); ok {
+			return enc
+		}
+	}
+	return openAIEncoder{}
+}
+
+// ClientEncoderMiddleware 在认证之前按入口路由设置编码器，使认证、限流与预算错误也使用入口协议的错误格式
+// 处理函数会重新设置各自的编码器（流式编码器有状态，每个请求一个）
+func ClientEncoderMiddleware(translator *core.Translator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.FullPath() == "/v1/messages" {
+			c.Set(ClientEncoderKey, newAnthropicEncoder(translator))
+		}
+		c.Next()
+	}
+}
+
+// writeSSEHeaders 设置 SSE 响应头
+func writeSSEHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Transfer-Encoding", "chunked")
+}
+
+// openAIEncoder OpenAI 协议：原样输出
+type openAIEncoder struct{}
+
+func (openAIEncoder) writeResponse(c *gin.Context, status int, resp *model.ChatCompletionResponse) {
+	c.JSON(status, resp)
+}
+
+func (openAIEncoder) writeStreamFrame(c *gin.Context, data string) {
+	fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+}
+
+func (openAIEncoder) writeError(c *gin.Context, status int, detail model.ErrorDetail) {
+	c.JSON(status, model.ErrorResponse{Error: detail})
+}
+
+// anthropicEncoder Anthropic Messages 协议
+type anthropicEncoder struct {
+	translator *core.Translator
+	stream     *core.AnthropicStreamEncoder
+}
+
+func newAnthropicEncoder(translator *core.Translator) *anthropicEncoder {
+	return &anthropicEncoder{
+		translator: translator,
+		stream:     translator.NewAnthropicStreamEncoder(),
+	}
+}
+
+func (e *anthropicEncoder) writeResponse(c *gin.Context, status int, resp *model.ChatCompletionResponse) {
+	c.JSON(status, e.translator.ToAnthropicResponse(resp))
+}
+
+func (e *anthropicEncoder) writeStreamFrame(c *gin.Context, data string) {
+	for _, ev := range e.stream.Encode(data) {
+		fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", ev.Event, ev.Data)
+	}
+}
+
+func (e *anthropicEncoder) writeStreamError(c *gin.Context, detail model.ErrorDetail) {
+	for _, ev := range e.stream.Abort(anthropicErrorType(detail.Type), detail.Message) {
+		fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", ev.Event, ev.Data)
+	}
+}
+
+func (e *anthropicEncoder) writeError(c *gin.Context, status int, detail model.ErrorDetail) {
+	c.JSON(status, model.AnthropicErrorResponse{
+		Type: "error",
+		Error: model.AnthropicError{
+			Type:    anthropicErrorType(detail.Type),
+			Message: detail.Message,
+		},
+	})
+}
+
+// anthropicErrorType 映射错误类型到 Anthropic 错误类型
+func anthropicErrorType(t string) string {
+	switch t {
+	case "invalid_request_error", "authentication_error", "permission_error", "not_found_error", "rate_limit_error":
+		return t
+	default:
+		return "api_error"
+	}
+}
+
+// completionsEncoder OpenAI 旧版 Completions 协议
+type completionsEncoder struct {
+	translator *core.Translator
+}
+
+func (e completionsEncoder) writeResponse(c *gin.Context, status int, resp *model.ChatCompletionResponse) {
+	c.JSON(status, e.translator.ToCompletionResponse(resp))
+}
+
+func (e completionsEncoder) writeStreamFrame(c *gin.Context, data string) {
+	fmt.Fprintf(c.Writer, "data: %s\n\n", e.translator.ToCompletionChunk(data))
+}
+
+func (completionsEncoder) writeError(c *gin.Context, status int, detail model.ErrorDetail) {
+	c.JSON(status, model.ErrorResponse{Error: detail})
+}
+
+// responsesEncoder OpenAI Responses 协议
+type responsesEncoder struct {
+	translator *core.Translator
+	stream     *core.ResponsesStreamEncoder
+}
+
+func newResponsesEncoder(translator *core.Translator) *responsesEncoder {
+	return &responsesEncoder{
+		translator: translator,
+		stream:     translator.NewResponsesStreamEncoder(),
+	}
+}
+
+func (e *responsesEncoder) writeResponse(c *gin.Context, status int, resp *model.ChatCompletionResponse) {
+	c.JSON(status, e.translator.ToResponsesResponse(resp))
+}
+
+func (e *responsesEncoder) writeStreamFrame(c *gin.Context, data string) {
+	for _, ev := range e.stream.Encode(data) {
+		fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", ev.Event, ev.Data)
+	}
+}
+
+func (e *responsesEncoder) writeError(c *gin.Context, status int, detail model.ErrorDetail) {
+	c.JSON(status, model.ErrorResponse{Error: detail})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/logger"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// hedgedKey gin 上下文中标记请求已发出对冲请求
+const hedgedKey = "hedged"
+
+// hedgeLoserStatus 输掉竞争被取消的对冲尝试在日志中的状态码（同 nginx 的 499 Client Closed Request）
+const hedgeLoserStatus = 499
+
+// hedgeAttempt 对冲中的一次尝试，结果为开始向客户端输出之前的状态
+type hedgeAttempt struct {
+	src     *model.Source
+	req     *model.ChatCompletionRequest // 按该源转换后的请求
+	start   time.Time
+	release func()
+	cancel  context.CancelFunc
+	done    bool // 结果已被主流程取走
+
+	head *streamHead                   // 流式：首个内容增量之前的状态
+	resp *model.ChatCompletionResponse // 非流式：完整响应
+	err  error
+}
+
+// hedgeEnabled 判断请求在该源上是否走对冲转发（走 FC 兼容层或原生透传的请求不对冲）
+func (h *ProxyHandler) hedgeEnabled(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, clientInfo *model.ClientInfo) bool {
+	tool := ""
+	if clientInfo != nil {
+		tool = clientInfo.Tool
+	}
+	if !h.cfg.Routing.Hedge.Enabled(tool) {
+		return false
+	}
+	if nativeRequestFor(c, src) != nil {
+		return false
+	}
+	return !req.HasTools() || sourceSupportsFC(src, req.Model)
+}
+
+// forwardHedged 对冲转发：主源在 delay 内未产出首个 token（非流式为完整响应）时，
+// 向 RouteRequest 给出的下一个候选源发出同一请求，采用先成功的一方并取消另一方，两次尝试都记录日志
+// 接管主源额度的释放；返回值同 forwardToSource，hedge 为发出了对冲请求的源（未发出时为 nil）
+func (h *ProxyHandler) forwardHedged(c *gin.Context, req *model.ChatCompletionRequest, primary *model.Source, release func(), exclude []string, tokens int, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (ok bool, hedge *model.Source, err error) {
+	results := make(chan *hedgeAttempt, 2)
+	attempts := []*hedgeAttempt{h.startHedgeAttempt(c, req, primary, release, startTime, results)}
+	running := 1
+
+	timer := time.NewTimer(time.Duration(h.cfg.Routing.Hedge.Delay) * time.Millisecond)
+	defer timer.Stop()
+
+	var winner *hedgeAttempt
+	for winner == nil && running > 0 {
+		select {
+		case <-timer.C:
+			exclude = append(append([]string(nil), exclude...), primary.ID)
+			src, release := h.routeHedge(c, req, clientInfo, exclude, tokens)
+			if src == nil {
+				continue
+			}
+			hedge = src
+			c.Set(hedgedKey, true)
+			logger.Info("hedging request", "request_id", requestIDFromContext(c), "primary", primary.Name, "hedge", src.Name)
+			attempts = append(attempts, h.startHedgeAttempt(c, req, src, release, time.Now(), results))
+			running++
+		case a := <-results:
+			running--
+			a.done = true
+			if a.err == nil {
+				winner = a
+				continue
+			}
+			a.release()
+			err = a.err
+			if a.src == primary {
+				failoverFrom = primary.ID
+			}
+		}
+	}
+	if winner == nil {
+		return false, hedge, err
+	}
+
+	// 取消仍在进行的一方并记录日志；其连接与额度在后台回收
+	for _, a := range attempts {
+		if !a.done {
+			a.cancel()
+			h.logHedgeLoser(c, a, winner.src, clientInfo)
+		}
+	}
+	if running > 0 {
+		go func(n int) {
+			for i := 0; i < n; i++ {
+				a := <-results
+				if a.head != nil {
+					a.head.stream.close()
+				}
+				a.release()
+			}
+		}(running)
+	}
+
+	// 日志与延迟按请求开始时间计算，对冲源的 start 只是对冲发出的时间
+	defer winner.release()
+	defer winner.cancel()
+	if winner.head != nil {
+		ok, err = h.relayStream(c, winner.req, winner.src, startTime, winner.head, failoverFrom, clientInfo)
+		return ok, hedge, err
+	}
+	h.respondNormal(c, winner.req, winner.resp, winner.src, startTime, failoverFrom, clientInfo)
+	return true, hedge, nil
+}
+
+// startHedgeAttempt 在后台向源发出请求，到首个内容增量（非流式为完整响应）为止，结果写入 results
+func (h *ProxyHandler) startHedgeAttempt(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, release func(), start time.Time, results chan<- *hedgeAttempt) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	a := &hedgeAttempt{
+		src:     src,
+		req:     h.translator.TranslateRequest(req, src),
+		start:   start,
+		release: release,
+		cancel:  cancel,
+	}
+
+	go func() {
+		if !a.req.Stream {
+			a.resp, a.err = h.fetchResponse(ctx, a.req, src, start)
+			results <- a
+			return
+		}
+
+		head, err := h.readStreamHead(ctx, a.req, src, start)
+		switch {
+		case head == nil:
+			a.err = err
+		case err != nil:
+			// 输掉竞争被取消时不归咎于源
+			if ctx.Err() == nil {
+				h.updateSourceLatency(src, time.Since(start), err)
+			}
+			a.err = fmt.Errorf("[%s] stream: %w", src.Name, err)
+		default:
+			a.head = head
+		}
+		results <- a
+	}()
+	return a
+}
+
+// routeHedge 为对冲请求选择下一个候选源并占用其额度，没有可用源时返回 nil
+func (h *ProxyHandler) routeHedge(c *gin.Context, req *model.ChatCompletionRequest, clientInfo *model.ClientInfo, exclude []string, tokens int) (*model.Source, func()) {
+	for {
+		src, err := h.router.RouteRequest(req, clientInfo, exclude)
+		if err != nil {
+			return nil, nil
+		}
+		if !req.HasTools() || sourceSupportsFC(src, req.Model) {
+			if release, ok := h.acquireSource(c, src, tokens); ok {
+				return src, release
+			}
+		}
+		exclude = append(exclude, src.ID)
+	}
+}
+
+// logHedgeLoser 记录输掉竞争被取消的对冲尝试，并释放其在源上的 TPM 预占（Key 的预占由胜出方校正）
+// 上游已处理 prompt，按预估 prompt 用量计费；取消不是 Key 的错误，不计入自动封禁统计
+func (h *ProxyHandler) logHedgeLoser(c *gin.Context, a *hedgeAttempt, winner *model.Source, clientInfo *model.ClientInfo) {
+	h.releaseSourceTokens(reservationFrom(c), a.src)
+
+	prompt := core.EstimatePromptTokens(a.req)
+	usage := &model.Usage{PromptTokens: prompt, TotalTokens: prompt}
+	log := &model.RequestLog{
+		ID:           core.GenerateLogID(),
+		RequestID:    requestIDFromContext(c),
+		Timestamp:    a.start,
+		SourceID:     a.src.ID,
+		SourceName:   a.src.Name,
+		Model:        a.req.Model,
+		HasTools:     a.req.HasTools(),
+		HasThinking:  a.req.HasThinking(),
+		Stream:       a.req.Stream,
+		StatusCode:   hedgeLoserStatus,
+		LatencyMs:    time.Since(a.start).Milliseconds(),
+		PromptTokens: usage.PromptTokens,
+		TotalTokens:  usage.TotalTokens,
+		Cost:         a.src.CostFor(a.req.Model, usage),
+		Error:        fmt.Sprintf("hedged request cancelled: %s responded first", winner.Name),
+		Hedged:       true,
+		Endpoint:     c.GetString(endpointKey),
+	}
+	if clientInfo != nil {
+		log.ClientIP = clientInfo.IP
+		log.ClientTool = clientInfo.Tool
+		log.APIKeyID = clientInfo.KeyID
+	}
+	h.store.SaveLog(log)
+}
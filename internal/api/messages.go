@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// Messages Anthropic 兼容的 /v1/messages 入口
+// 请求转换为内部格式后走统一的路由/failover，响应按 Anthropic 格式返回
+func (h *ProxyHandler) Messages(c *gin.Context) {
+	encoder := newAnthropicEncoder(h.translator)
+	c.Set(ClientEncoderKey, encoder)
+
+	var req model.AnthropicRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		encoder.writeError(c, 400, model.ErrorDetail{
+			Message: "Invalid request: " + err.Error(),
+			Type:    "invalid_request_error",
+		})
+		return
+	}
+
+	h.proxyChatCompletion(c, h.translator.FromAnthropicRequest(&req))
+}
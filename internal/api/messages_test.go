@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestMessages_OpenAISourceReturnsAnthropicFormat(t *testing.T) {
+	var upstreamReq model.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`)
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+
+	w := performRequest(h.Messages, "/v1/messages", `{"model":"gpt-4o","max_tokens":100,"system":"Be nice.","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(upstreamReq.Messages) != 2 || upstreamReq.Messages[0].Role != "system" {
+		t.Errorf("expected system message forwarded upstream, got %+v", upstreamReq.Messages)
+	}
+
+	var resp model.AnthropicResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if resp.Type != "message" || resp.Content.Text() != "Hello!" || resp.StopReason != "end_turn" {
+		t.Errorf("unexpected anthropic response: %+v", resp)
+	}
+	if resp.Usage.InputTokens != 5 || resp.Usage.OutputTokens != 2 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestMessages_StreamEmitsAnthropicEvents(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+
+	w := performRequest(h.Messages, "/v1/messages", `{"model":"gpt-4o","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body := w.Body.String()
+	for _, ev := range []string{"event: message_start", "event: content_block_delta", "event: message_delta", "event: message_stop"} {
+		if !strings.Contains(body, ev) {
+			t.Errorf("expected %q in stream, got:\n%s", ev, body)
+		}
+	}
+	if strings.Contains(body, "[DONE]") {
+		t.Error("anthropic stream must not contain [DONE]")
+	}
+}
+
+func TestMessages_TruncatedStreamEmitsErrorEvent(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\" there\"}}]}\n\n")
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+
+	w := performRequest(h.Messages, "/v1/messages", `{"model":"gpt-4o","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	body := w.Body.String()
+	if !strings.Contains(body, "event: content_block_delta") || !strings.Contains(body, "event: error") {
+		t.Fatalf("expected content followed by an error event, got:\n%s", body)
+	}
+	if strings.Contains(body, "event: message_stop") {
+		t.Errorf("truncated stream must not end with message_stop, got:\n%s", body)
+	}
+}
+
+func TestMessages_InvalidRequestUsesAnthropicError(t *testing.T) {
+	h, _ := newTestProxy(t)
+
+	w := performRequest(h.Messages, "/v1/messages", `{not json`, nil)
+	if w.Code != 400 {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	var resp model.AnthropicErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Type != "error" || resp.Error.Type != "invalid_request_error" {
+		t.Errorf("unexpected error body: %s", w.Body.String())
+	}
+}
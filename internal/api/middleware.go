@@ -34,32 +34,39 @@ func RequestIDMiddleware() gin.HandlerFunc {
 	}
 }
 
+// abortWithError 按入口协议的错误格式返回并中止请求
+func abortWithError(c *gin.Context, status int, detail model.ErrorDetail) {
+	encoderFromContext(c).writeError(c, status, detail)
+	c.Abort()
+}
+
 // AuthMiddleware API Key 认证中间件
 // Now supports multi-key: checks api_keys table first, then fallback to server.api_key
-func AuthMiddleware(apiKey string, st *store.Store, rateLimiter *core.RateLimiter) gin.HandlerFunc {
+func AuthMiddleware(apiKey string, st *store.Store, rateLimiter core.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Tool detection (always run, even without auth)
 		tool := core.DetectTool(c.Request.Header)
 		clientIP := c.ClientIP()
+		sessionID := c.GetHeader("X-Session-ID")
 
 		// 如果未设置 API Key，跳过认证 but still set client info
 		if apiKey == "" {
-			c.Set("client_info", &model.ClientInfo{Tool: tool, IP: clientIP})
+			c.Set("client_info", &model.ClientInfo{Tool: tool, IP: clientIP, SessionID: sessionID})
 			c.Next()
 			return
 		}
 
-		// 从 Authorization header 获取 token
+		// 从 Authorization header 获取 token（Anthropic SDK 使用 x-api-key）
 		auth := c.GetHeader("Authorization")
 		if auth == "" {
-			c.JSON(401, model.ErrorResponse{
-				Error: model.ErrorDetail{
-					Message: "Missing Authorization header",
-					Type:    "authentication_error",
-					Code:    "missing_api_key",
-				},
+			auth = c.GetHeader("x-api-key")
+		}
+		if auth == "" {
+			abortWithError(c, 401, model.ErrorDetail{
+				Message: "Missing Authorization header",
+				Type:    "authentication_error",
+				Code:    "missing_api_key",
 			})
-			c.Abort()
 			return
 		}
 
@@ -76,14 +83,11 @@ func AuthMiddleware(apiKey string, st *store.Store, rateLimiter *core.RateLimite
 			if err == nil && apiKeyObj != nil {
 				// Found a managed key
 				if !apiKeyObj.Enabled {
-					c.JSON(403, model.ErrorResponse{
-						Error: model.ErrorDetail{
-							Message: "API key is disabled",
-							Type:    "authentication_error",
-							Code:    "key_disabled",
-						},
+					abortWithError(c, 403, model.ErrorDetail{
+						Message: "API key is disabled",
+						Type:    "authentication_error",
+						Code:    "key_disabled",
 					})
-					c.Abort()
 					return
 				}
 
@@ -97,43 +101,51 @@ func AuthMiddleware(apiKey string, st *store.Store, rateLimiter *core.RateLimite
 						}
 					}
 					if !toolAllowed {
-						c.JSON(403, model.ErrorResponse{
-							Error: model.ErrorDetail{
-								Message: "Tool not allowed for this API key",
-								Type:    "authentication_error",
-								Code:    "tool_not_allowed",
-							},
+						abortWithError(c, 403, model.ErrorDetail{
+							Message: "Tool not allowed for this API key",
+							Type:    "authentication_error",
+							Code:    "tool_not_allowed",
 						})
-						c.Abort()
 						return
 					}
 				}
 
+				// Check spend budgets（先于限流检查，超预算被拒绝的请求不占用并发和 RPM/配额）
+				if !checkKeyBudgets(c, st, apiKeyObj) {
+					c.Abort()
+					return
+				}
+
 				// Check rate limits
 				if rateLimiter != nil {
 					// Check auto-ban first
 					if banned, remaining := rateLimiter.IsAutoBanned(apiKeyObj.ID); banned {
-						c.JSON(403, model.ErrorResponse{
-							Error: model.ErrorDetail{
-								Message: fmt.Sprintf("API key auto-banned due to excessive errors, remaining: %v", remaining.Round(time.Second)),
-								Type:    "authentication_error",
-								Code:    "key_auto_banned",
-							},
+						abortWithError(c, 403, model.ErrorDetail{
+							Message: fmt.Sprintf("API key auto-banned due to excessive errors, remaining: %v", remaining.Round(time.Second)),
+							Type:    "authentication_error",
+							Code:    "key_auto_banned",
+						})
+						return
+					}
+
+					// 并发令牌先于 RPM/配额占用，被拒绝的请求不计入窗口
+					if ok, reason := rateLimiter.AcquireConcurrent(apiKeyObj.ID, apiKeyObj.Limits.Concurrent); !ok {
+						abortWithError(c, 429, model.ErrorDetail{
+							Message: reason,
+							Type:    "rate_limit_error",
+							Code:    "rate_limit_exceeded",
 						})
-						c.Abort()
 						return
 					}
+					defer rateLimiter.ReleaseConcurrent(apiKeyObj.ID)
 
 					allowed, reason := rateLimiter.AllowWithTool(apiKeyObj.ID, apiKeyObj.Limits, tool)
 					if !allowed {
-						c.JSON(429, model.ErrorResponse{
-							Error: model.ErrorDetail{
-								Message: reason,
-								Type:    "rate_limit_error",
-								Code:    "rate_limit_exceeded",
-							},
+						abortWithError(c, 429, model.ErrorDetail{
+							Message: reason,
+							Type:    "rate_limit_error",
+							Code:    "rate_limit_exceeded",
 						})
-						c.Abort()
 						return
 					}
 				}
@@ -142,9 +154,11 @@ func AuthMiddleware(apiKey string, st *store.Store, rateLimiter *core.RateLimite
 				go st.UpdateAPIKeyLastUsed(apiKeyObj.ID)
 
 				c.Set("client_info", &model.ClientInfo{
-					KeyID: apiKeyObj.ID,
-					Tool:  tool,
-					IP:    clientIP,
+					KeyID:     apiKeyObj.ID,
+					Tool:      tool,
+					IP:        clientIP,
+					Limits:    apiKeyObj.Limits,
+					SessionID: sessionID,
 				})
 				c.Next()
 				return
@@ -153,22 +167,61 @@ func AuthMiddleware(apiKey string, st *store.Store, rateLimiter *core.RateLimite
 
 		// Fallback to server.api_key
 		if token != apiKey {
-			c.JSON(401, model.ErrorResponse{
-				Error: model.ErrorDetail{
-					Message: "Invalid API key",
-					Type:    "authentication_error",
-					Code:    "invalid_api_key",
-				},
+			abortWithError(c, 401, model.ErrorDetail{
+				Message: "Invalid API key",
+				Type:    "authentication_error",
+				Code:    "invalid_api_key",
 			})
-			c.Abort()
 			return
 		}
 
-		c.Set("client_info", &model.ClientInfo{Tool: tool, IP: clientIP})
+		c.Set("client_info", &model.ClientInfo{Tool: tool, IP: clientIP, SessionID: sessionID})
 		c.Next()
 	}
 }
 
+// checkKeyBudgets 按 request_logs 聚合用量检查 Key 预算
+// 超过硬限制返回 429；达到软限制时通过 X-Budget-Warning 响应头提示。查询失败时放行
+func checkKeyBudgets(c *gin.Context, st *store.Store, key *model.APIKey) bool {
+	if !key.Limits.HasBudget() {
+		return true
+	}
+
+	dayStart, monthStart := core.BudgetPeriodStarts(time.Now())
+	daily, monthly, err := st.GetKeyBudgetSpend(key.ID, dayStart, monthStart)
+	if err != nil {
+		logger.Warn("budget check failed", "key_id", key.ID, "error", err)
+		return true
+	}
+
+	statuses := core.EvaluateBudgets(key.Limits, *daily, *monthly)
+	for _, b := range statuses {
+		if b.Exceeded {
+			encoderFromContext(c).writeError(c, 429, model.ErrorDetail{
+				Message: fmt.Sprintf("%s budget exceeded: used %s of %s", b.Name, formatBudgetValue(b.Name, b.Used), formatBudgetValue(b.Name, b.Limit)),
+				Type:    "rate_limit_error",
+				Code:    "budget_exceeded",
+			})
+			return false
+		}
+	}
+	for _, b := range statuses {
+		if b.Warning {
+			c.Writer.Header().Add("X-Budget-Warning", fmt.Sprintf("%s; used=%s; limit=%s; remaining=%s",
+				b.Name, formatBudgetValue(b.Name, b.Used), formatBudgetValue(b.Name, b.Limit), formatBudgetValue(b.Name, b.Remaining)))
+		}
+	}
+	return true
+}
+
+// formatBudgetValue 格式化预算数值：token 为整数，费用保留 4 位小数
+func formatBudgetValue(name string, v float64) string {
+	if strings.HasSuffix(name, "_cost") {
+		return fmt.Sprintf("%.4f", v)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
 // AdminAuthMiddleware 管理 API 认证中间件（简单单 key 检查）
 func AdminAuthMiddleware(apiKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -216,7 +269,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, x-api-key, anthropic-version, X-Session-ID")
 		c.Header("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -271,7 +324,7 @@ func LoggerMiddleware() gin.HandlerFunc {
 }
 
 // SetupRouter 设置路由
-func SetupRouter(cfg *config.Config, proxy *ProxyHandler, admin *AdminHandler, st *store.Store, rateLimiter *core.RateLimiter) *gin.Engine {
+func SetupRouter(cfg *config.Config, proxy *ProxyHandler, admin *AdminHandler, st *store.Store, rateLimiter core.Limiter) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.New()
@@ -282,9 +335,14 @@ func SetupRouter(cfg *config.Config, proxy *ProxyHandler, admin *AdminHandler, s
 
 	// 代理 API（需要认证）
 	v1 := r.Group("/v1")
+	v1.Use(ClientEncoderMiddleware(proxy.translator))
 	v1.Use(AuthMiddleware(cfg.Server.APIKey, st, rateLimiter))
 	{
 		v1.POST("/chat/completions", proxy.ChatCompletions)
+		v1.POST("/completions", proxy.Completions)
+		v1.POST("/responses", proxy.Responses)
+		v1.POST("/messages", proxy.Messages)
+		v1.POST("/embeddings", proxy.Embeddings)
 		v1.GET("/models", proxy.ListModels)
 	}
 
@@ -313,6 +371,13 @@ func SetupRouter(cfg *config.Config, proxy *ProxyHandler, admin *AdminHandler, s
 		api.GET("/config", admin.GetConfig)
 		api.PUT("/config", admin.UpdateConfig)
 
+		// 路由规则
+		api.GET("/routing/rules", admin.ListRoutingRules)
+		api.POST("/routing/rules", admin.CreateRoutingRule)
+		api.GET("/routing/rules/:id", admin.GetRoutingRule)
+		api.PUT("/routing/rules/:id", admin.UpdateRoutingRule)
+		api.DELETE("/routing/rules/:id", admin.DeleteRoutingRule)
+
 		// Key management
 		api.GET("/keys", admin.ListKeys)
 		api.POST("/keys", admin.CreateKey)
@@ -324,6 +389,10 @@ func SetupRouter(cfg *config.Config, proxy *ProxyHandler, admin *AdminHandler, s
 		api.PUT("/keys/:id/unblock", admin.UnblockKey)
 		api.GET("/keys/:id/usage", admin.GetKeyUsage)
 
+		// Rate limiter state
+		api.GET("/ratelimit", admin.GetRateLimitState)
+		api.GET("/breakers", admin.GetBreakers)
+
 		// Tool stats
 		api.GET("/tools/stats", admin.GetToolStats)
 	}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+	"github.com/xiaopang/fusionapi/internal/store"
+)
+
+// newBudgetTestStore 创建带预算 Key 的临时数据库，并写入 spentTokens 的今日用量
+func newBudgetTestStore(t *testing.T, limits model.KeyLimits, spentTokens int) *store.Store {
+	t.Helper()
+	st, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	key := &model.APIKey{ID: "key-1", Key: "sk-fa-budget", Name: "budget", Enabled: true, Limits: limits, CreatedAt: time.Now()}
+	if err := st.SaveAPIKey(key); err != nil {
+		t.Fatalf("SaveAPIKey failed: %v", err)
+	}
+	if err := st.SaveLog(&model.RequestLog{ID: "log-1", Timestamp: time.Now(), APIKeyID: "key-1", TotalTokens: spentTokens}); err != nil {
+		t.Fatalf("SaveLog failed: %v", err)
+	}
+	return st
+}
+
+// performAuthRequest 经过 AuthMiddleware 访问一个空处理器
+func performAuthRequest(st *store.Store) *httptest.ResponseRecorder {
+	return performLimitedAuthRequest(st, nil)
+}
+
+// performLimitedAuthRequest 同 performAuthRequest，并启用频率限制器
+func performLimitedAuthRequest(st *store.Store, limiter core.Limiter) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AuthMiddleware("admin-key", st, limiter))
+	r.GET("/v1/models", func(c *gin.Context) { c.JSON(200, gin.H{}) })
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer sk-fa-budget")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddleware_BudgetExceeded(t *testing.T) {
+	st := newBudgetTestStore(t, model.KeyLimits{DailyTokenBudget: 1000}, 1000)
+
+	w := performAuthRequest(st)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "budget_exceeded") {
+		t.Errorf("expected budget_exceeded code, got %s", w.Body.String())
+	}
+}
+
+func TestAuthMiddleware_BudgetExceededDoesNotConsumeRateLimit(t *testing.T) {
+	limits := model.KeyLimits{RPM: 1, DailyTokenBudget: 1000}
+	st := newBudgetTestStore(t, limits, 1000)
+	limiter := core.NewRateLimiter()
+
+	for i := 0; i < 2; i++ {
+		w := performLimitedAuthRequest(st, limiter)
+		if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "budget_exceeded") {
+			t.Fatalf("request %d: expected budget_exceeded, got %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	if ok, reason := limiter.Allow("key-1", limits); !ok {
+		t.Errorf("expected RPM window untouched by budget rejections, got %s", reason)
+	}
+}
+
+func TestAuthMiddleware_BudgetWarning(t *testing.T) {
+	st := newBudgetTestStore(t, model.KeyLimits{DailyTokenBudget: 1000, MonthlyTokenBudget: 100000}, 850)
+
+	w := performAuthRequest(st)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	warnings := w.Header().Values("X-Budget-Warning")
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "daily_tokens;") {
+		t.Errorf("expected a single daily_tokens warning, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0], "remaining=150") {
+		t.Errorf("expected remaining=150 in warning, got %q", warnings[0])
+	}
+}
+
+func TestAuthMiddleware_MessagesUsesAnthropicErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ClientEncoderMiddleware(core.NewTranslator()))
+	r.Use(AuthMiddleware("admin-key", nil, nil))
+	r.POST("/v1/messages", func(c *gin.Context) { c.JSON(200, gin.H{}) })
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader("{}"))
+	req.Header.Set("x-api-key", "wrong")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"type":"error"`) || !strings.Contains(w.Body.String(), `"authentication_error"`) {
+		t.Errorf("expected anthropic error format, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// endpointKey gin 上下文中的入口接口名（写入请求日志的 endpoint 字段，聊天补全为空）
+const endpointKey = "endpoint"
+
+// nativeRequestKey gin 上下文中的原生请求，选中的源原生支持该接口时原样透传
+const nativeRequestKey = "native_request"
+
+// nativeRequest 转换为聊天请求之前的原始请求
+type nativeRequest struct {
+	endpoint string // model.EndpointCompletions | model.EndpointResponses
+	body     []byte
+	// nativeOnly 请求无法转换为聊天请求（如 previous_response_id 依赖上游保存的会话状态），只能路由到原生支持该接口的源
+	nativeOnly bool
+}
+
+// nativeRequestFor 返回可以原样透传到该源的原生请求，不适用时返回 nil
+func nativeRequestFor(c *gin.Context, src *model.Source) *nativeRequest {
+	v, ok := c.Get(nativeRequestKey)
+	if !ok {
+		return nil
+	}
+	native, ok := v.(*nativeRequest)
+	if !ok || !src.SupportsEndpoint(native.endpoint) {
+		return nil
+	}
+	return native
+}
+
+// nativeExclusions 请求只能原样透传时，返回不支持该接口的源（路由时排除），否则返回 nil
+func (h *ProxyHandler) nativeExclusions(c *gin.Context) []string {
+	v, ok := c.Get(nativeRequestKey)
+	if !ok {
+		return nil
+	}
+	native, ok := v.(*nativeRequest)
+	if !ok || !native.nativeOnly {
+		return nil
+	}
+	var ids []string
+	for _, src := range h.manager.List() {
+		if !src.SupportsEndpoint(native.endpoint) {
+			ids = append(ids, src.ID)
+		}
+	}
+	return ids
+}
+
+// hasNativeSource 返回是否有源原生支持该接口
+func (h *ProxyHandler) hasNativeSource(endpoint string) bool {
+	for _, src := range h.manager.List() {
+		if src.SupportsEndpoint(endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardNative 将原生请求透传到源的同名接口（仅替换 model 为上游模型名），响应原样返回
+// 返回值同 forwardToSource；token 统计优先使用上游 usage，缺失时按输出文本估算
+func (h *ProxyHandler) forwardNative(c *gin.Context, req *model.ChatCompletionRequest, native *nativeRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
+	body, err := nativeBody(native.body, src.UpstreamModel(req.Model))
+	if err != nil {
+		return false, fmt.Errorf("[%s] encode request: %w", src.Name, err)
+	}
+	path := "/v1/" + native.endpoint
+
+	if req.Stream {
+		return h.relayNativeStream(c, req, native.endpoint, path, body, src, startTime, failoverFrom, clientInfo)
+	}
+
+	ctx := c.Request.Context()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", src.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("[%s] build request: %w", src.Name, err)
+	}
+	h.setHeaders(httpReq, src)
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.updateSourceLatency(src, time.Since(startTime), err)
+		}
+		return false, fmt.Errorf("[%s] %w", src.Name, err)
+	}
+	defer resp.Body.Close()
+
+	// 读取响应（限制 512KB 防止 OOM）
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 512*1024))
+	if err != nil {
+		return false, fmt.Errorf("[%s] read body: %w", src.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		statusErr := newUpstreamStatusError(resp, respBody)
+		h.updateSourceLatency(src, time.Since(startTime), statusErr)
+		return false, fmt.Errorf("[%s] %w", src.Name, statusErr)
+	}
+
+	usage, text, err := nativeResult(native.endpoint, respBody)
+	if err != nil {
+		return false, fmt.Errorf("[%s] decode response: %w", src.Name, err)
+	}
+	if usage == nil {
+		usage = core.EstimateUsage(req, text)
+	}
+
+	h.updateSourceLatency(src, time.Since(startTime), nil)
+	h.logRequest(c, req, &model.ChatCompletionResponse{Usage: usage}, src, startTime, http.StatusOK, nil, failoverFrom, clientInfo, false, 0)
+	c.Data(http.StatusOK, "application/json", respBody)
+	return true, nil
+}
+
+// nativeBody 将请求体中的 model 替换为上游模型名，其余字段原样保留
+func nativeBody(body []byte, upstreamModel string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	name, err := json.Marshal(upstreamModel)
+	if err != nil {
+		return nil, err
+	}
+	fields["model"] = name
+	return json.Marshal(fields)
+}
+
+// nativeResult 从原生非流式响应中提取 usage 与输出文本
+func nativeResult(endpoint string, body []byte) (*model.Usage, string, error) {
+	if endpoint == model.EndpointResponses {
+		var resp model.Response
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, "", err
+		}
+		var sb strings.Builder
+		for _, item := range resp.Output {
+			for _, part := range item.Content {
+				sb.WriteString(part.Text)
+			}
+			sb.WriteString(item.Arguments)
+		}
+		return resp.Usage.ToUsage(), sb.String(), nil
+	}
+
+	var resp model.CompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, "", err
+	}
+	var text string
+	if len(resp.Choices) > 0 {
+		text = resp.Choices[0].Text
+	}
+	return resp.Usage, text, nil
+}
+
+// nativeStream 原生透传的流式响应，上游 SSE 行原样转发
+// 与 streamHead 相同，首个内容增量（或正常结束）之前的行缓存在 pending 中，期间出错可以 failover
+type nativeStream struct {
+	stream     *upstreamStream
+	endpoint   string
+	pending    []string // 待写给客户端的原始 SSE 行
+	usage      *model.Usage
+	completion strings.Builder
+	finished   bool // 上游是否正常结束（[DONE] / response.completed）
+	done       bool // 上游数据已读完
+}
+
+// read 读取下一行追加到 pending，返回其中是否含有内容增量
+func (s *nativeStream) read() (bool, error) {
+	line, err := s.stream.reader.ReadString('\n')
+	if line != "" {
+		s.pending = append(s.pending, line)
+	}
+	if err == io.EOF {
+		s.done = true
+		if !s.finished {
+			return false, io.ErrUnexpectedEOF
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, s.stream.wrapErr(err)
+	}
+
+	data := strings.TrimSpace(line)
+	if !strings.HasPrefix(data, "data: ") {
+		return false, nil
+	}
+	data = strings.TrimPrefix(data, "data: ")
+	if data == "[DONE]" {
+		s.finished = true
+		s.done = true
+		return false, nil
+	}
+	if s.endpoint == model.EndpointResponses {
+		return s.readResponsesEvent(data), nil
+	}
+	return s.readCompletionChunk(data), nil
+}
+
+// readCompletionChunk 统计 completions 数据帧
+func (s *nativeStream) readCompletionChunk(data string) bool {
+	var chunk model.CompletionResponse
+	if json.Unmarshal([]byte(data), &chunk) != nil {
+		return false
+	}
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+	hasContent := false
+	for _, choice := range chunk.Choices {
+		if choice.Text != "" {
+			s.completion.WriteString(choice.Text)
+			hasContent = true
+		}
+		if choice.FinishReason != nil {
+			s.finished = true
+		}
+	}
+	return hasContent
+}
+
+// readResponsesEvent 统计 Responses 事件（response.completed / response.incomplete 携带完整 usage）
+func (s *nativeStream) readResponsesEvent(data string) bool {
+	var ev model.ResponsesStreamEvent
+	if json.Unmarshal([]byte(data), &ev) != nil {
+		return false
+	}
+	switch ev.Type {
+	case "response.output_text.delta", "response.function_call_arguments.delta":
+		s.completion.WriteString(ev.Delta)
+		return ev.Delta != ""
+	case "response.completed", "response.incomplete":
+		s.finished = true
+		if ev.Response != nil && ev.Response.Usage != nil {
+			s.usage = ev.Response.Usage.ToUsage()
+		}
+	}
+	return false
+}
+
+// finalUsage 上游未返回 usage 时使用本地估算
+func (s *nativeStream) finalUsage(req *model.ChatCompletionRequest) *model.Usage {
+	if s.usage != nil {
+		return s.usage
+	}
+	return core.EstimateUsage(req, s.completion.String())
+}
+
+// relayNativeStream 透传流式请求：首个内容增量之前失败时返回 false 以便 failover，之后原样转发剩余的流
+func (h *ProxyHandler) relayNativeStream(c *gin.Context, req *model.ChatCompletionRequest, endpoint, path string, body []byte, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
+	stream, err := h.openUpstream(c.Request.Context(), path, body, src, startTime)
+	if err != nil {
+		return false, err
+	}
+	defer stream.close()
+
+	s := &nativeStream{stream: stream, endpoint: endpoint}
+	for {
+		hasContent, err := s.read()
+		if err != nil {
+			return h.handleStreamFailure(c, req, src, startTime, s.finalUsage(req), err, false, failoverFrom, clientInfo, false)
+		}
+		if hasContent || s.finished {
+			stream.firstToken()
+			break
+		}
+	}
+
+	writeSSEHeaders(c)
+	var streamErr error
+	for {
+		if len(s.pending) > 0 {
+			for _, line := range s.pending {
+				c.Writer.WriteString(line)
+			}
+			s.pending = s.pending[:0]
+			c.Writer.Flush()
+		}
+		if s.done {
+			break
+		}
+		if _, err := s.read(); err != nil {
+			streamErr = err
+			break
+		}
+	}
+
+	usage := s.finalUsage(req)
+	if streamErr != nil {
+		return h.handleStreamFailure(c, req, src, startTime, usage, streamErr, true, failoverFrom, clientInfo, false)
+	}
+
+	h.updateSourceLatency(src, time.Since(startTime), nil)
+	h.logStreamRequest(c, req, src, startTime, usage, nil, failoverFrom, clientInfo, false, 0)
+	return true, nil
+}
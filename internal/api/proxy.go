@@ -1,18 +1,24 @@
 package api
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/xiaopang/fusionapi/internal/config"
 	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/logger"
 	"github.com/xiaopang/fusionapi/internal/model"
 	"github.com/xiaopang/fusionapi/internal/store"
 )
@@ -25,11 +31,12 @@ type ProxyHandler struct {
 	store       *store.Store
 	cfg         *config.Config
 	client      *http.Client
-	rateLimiter *core.RateLimiter
+	rateLimiter core.Limiter
+	cache       core.ResponseCache
 }
 
 // NewProxyHandler 创建代理处理器
-func NewProxyHandler(router *core.Router, manager *core.SourceManager, translator *core.Translator, store *store.Store, cfg *config.Config, rateLimiter *core.RateLimiter) *ProxyHandler {
+func NewProxyHandler(router *core.Router, manager *core.SourceManager, translator *core.Translator, store *store.Store, cfg *config.Config, rateLimiter core.Limiter) *ProxyHandler {
 	return &ProxyHandler{
 		router:     router,
 		manager:    manager,
@@ -57,20 +64,78 @@ func (h *ProxyHandler) ChatCompletions(c *gin.Context) {
 		return
 	}
 
+	h.proxyChatCompletion(c, &req)
+}
+
+// proxyChatCompletion 路由 + failover 主循环（各入口协议共用）
+func (h *ProxyHandler) proxyChatCompletion(c *gin.Context, req *model.ChatCompletionRequest) {
 	// Get client info
 	var clientInfo *model.ClientInfo
 	if ci, exists := c.Get("client_info"); exists {
 		clientInfo = ci.(*model.ClientInfo)
 	}
 
-	// Concurrent tracking
-	if clientInfo != nil && clientInfo.KeyID != "" && h.rateLimiter != nil {
-		h.rateLimiter.AcquireConcurrent(clientInfo.KeyID)
-		defer h.rateLimiter.ReleaseConcurrent(clientInfo.KeyID)
+	// 全局模型映射：别名/模式 -> 路由使用的模型名
+	req.Model = model.MapModel(h.cfg.ModelMappings, req.Model)
+
+	// 响应缓存：确定性请求命中时直接回放，不请求上游也不占用 TPM 额度
+	if policy := h.responseCachePolicy(c, req, clientInfo); policy != nil {
+		if entry, ok := h.lookupCachedResponse(req, policy); ok {
+			h.replayCachedResponse(c, req, entry, time.Now(), clientInfo)
+			return
+		}
+		c.Header("X-Cache", "MISS")
+		c.Set(cacheKeyCtx, policy.key)
+	}
+
+	// Key TPM：按预估 prompt token 准入，完成后按实际用量校正
+	estimatedTokens := core.EstimatePromptTokens(req)
+	if clientInfo != nil && clientInfo.Limits.TPM > 0 && h.rateLimiter != nil {
+		if ok, reason := h.rateLimiter.ReserveTokens(core.KeyTokenBucket(clientInfo.KeyID), reservationFrom(c).id, clientInfo.Limits.TPM, estimatedTokens); !ok {
+			encoderFromContext(c).writeError(c, 429, model.ErrorDetail{
+				Message: reason,
+				Type:    "rate_limit_error",
+				Code:    "rate_limit_exceeded",
+			})
+			return
+		}
 	}
 
 	// 记录开始时间
 	startTime := time.Now()
+	unsupported := h.nativeExclusions(c)
+	route := func(exclude []string) (*model.Source, error) {
+		return h.router.RouteRequest(req, clientInfo, append(exclude, unsupported...))
+	}
+	forward := func(src *model.Source, release func(), exclude []string, failoverFrom string) (bool, *model.Source, error) {
+		if h.hedgeEnabled(c, req, src, clientInfo) {
+			return h.forwardHedged(c, req, src, release, exclude, estimatedTokens, startTime, failoverFrom, clientInfo)
+		}
+		defer release()
+		ok, err := h.forwardToSource(c, req, src, startTime, failoverFrom, clientInfo)
+		return ok, nil, err
+	}
+
+	ok, failoverFrom, lastError := h.failover(c, estimatedTokens, startTime, route, forward)
+	if ok {
+		return
+	}
+	h.releaseTokens(c, clientInfo)
+	status, detail := failoverError(lastError)
+	h.logRequest(c, req, nil, nil, startTime, status, lastError, failoverFrom, clientInfo, false, 0)
+	encoderFromContext(c).writeError(c, status, detail)
+}
+
+// routeFunc 按排除列表（已尝试和本轮暂时跳过的源）选择源
+type routeFunc func(exclude []string) (*model.Source, error)
+
+// forwardFunc 将请求转发到已占用源级额度的源，负责调用 release 释放额度
+// 返回是否已向客户端输出响应，以及对冲时额外尝试的源
+type forwardFunc func(src *model.Source, release func(), exclude []string, failoverFrom string) (ok bool, hedge *model.Source, err error)
+
+// failover 路由 + 排队 + failover 主循环（聊天补全与 embeddings 共用）
+// 所有尝试都失败时返回最后的错误与 failoverFrom，由调用方按 failoverError 记录日志并返回错误
+func (h *ProxyHandler) failover(c *gin.Context, tokens int, startTime time.Time, route routeFunc, forward forwardFunc) (bool, string, error) {
 	var lastError error
 	var triedSources []string
 	var failoverFrom string
@@ -81,74 +146,136 @@ func (h *ProxyHandler) ChatCompletions(c *gin.Context) {
 		maxRetries = 0
 	}
 
+	// 所有源暂时饱和时排队等待，直到有源释放额度或超过排队时间
+	var queueDeadline time.Time
+	if h.cfg.Routing.QueueTimeout > 0 {
+		queueDeadline = startTime.Add(time.Duration(h.cfg.Routing.QueueTimeout) * time.Millisecond)
+	}
+	var skipped []string // 本轮因源级限制未能占用额度的源，等待后重新参与路由
+
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		// 路由选择
-		src, err := h.router.RouteRequest(&req, triedSources)
+		exclude := append(append([]string(nil), triedSources...), skipped...)
+		src, err := route(exclude)
 		if err != nil {
-			lastError = err
+			if errors.Is(err, core.ErrSourcesSaturated) || len(skipped) > 0 {
+				lastError = core.ErrSourcesSaturated
+				if waitForCapacity(c, queueDeadline) {
+					skipped = nil
+					attempt--
+					continue
+				}
+			} else {
+				lastError = err
+			}
 			break
 		}
 
+		// 源已达到 RPM/TPM/并发上限（与其他请求竞争时可能发生）：跳过该源，不计入重试次数
+		release, ok := h.acquireSource(c, src, tokens)
+		if !ok {
+			skipped = append(skipped, src.ID)
+			attempt--
+			continue
+		}
+
 		triedSources = append(triedSources, src.ID)
+		ok, hedge, err := forward(src, release, exclude, failoverFrom)
+		if hedge != nil {
+			triedSources = append(triedSources, hedge.ID)
+		}
+		if ok {
+			return true, failoverFrom, nil
+		}
+		failoverFrom = src.ID
+		if err != nil {
+			lastError = err
+		} else {
+			lastError = fmt.Errorf("source %s failed", src.Name)
+		}
+
+		// 请求本身不合法时换源也会同样失败，直接返回
+		if !classifyError(lastError).Retryable() {
+			break
+		}
+	}
+	return false, failoverFrom, lastError
+}
 
-		// 转换请求
-		translatedReq := h.translator.TranslateRequest(&req, src)
+// failoverError 所有尝试失败后返回给客户端的状态码与错误
+// 请求本身的问题（参数错误、超出上下文长度）透传上游状态码与错误信息，源均饱和返回 429，其余返回 500
+func failoverError(lastError error) (int, model.ErrorDetail) {
+	var statusErr *upstreamStatusError
+	if errors.As(lastError, &statusErr) && classifyError(lastError).ClientCaused() {
+		return statusErr.StatusCode, core.ParseUpstreamError(statusErr.Body)
+	}
 
-		// FC 兼容模式：
-		// - 源支持 FC：走原生透传
-		// - 源不支持 FC：走兼容层（模拟 tool_call 输出）
-		if req.HasTools() && !sourceSupportsFC(src, req.Model) {
-			if h.handleFCCompatRequest(c, &req, translatedReq, src, startTime, failoverFrom, clientInfo) {
-				return // 成功
-			}
-			failoverFrom = src.ID
-			lastError = fmt.Errorf("source %s fc_compat failed", src.Name)
-		} else {
-			// 转发请求
-			if req.Stream {
-				ok, err := h.handleStreamRequest(c, translatedReq, src, startTime, failoverFrom, clientInfo)
-				if ok {
-					return
-				}
-				failoverFrom = src.ID
-				if err != nil {
-					lastError = err
-				} else {
-					lastError = fmt.Errorf("source %s failed", src.Name)
-				}
-			} else {
-				ok, err := h.handleNormalRequest(c, translatedReq, src, startTime, failoverFrom, clientInfo)
-				if ok {
-					return
-				}
-				failoverFrom = src.ID
-				if err != nil {
-					lastError = err
-				} else {
-					lastError = fmt.Errorf("source %s failed", src.Name)
-				}
-			}
+	if errors.Is(lastError, core.ErrSourcesSaturated) {
+		return 429, model.ErrorDetail{
+			Message: "All sources are at their rate or concurrency limits, please retry later",
+			Type:    "rate_limit_error",
+			Code:    "sources_saturated",
 		}
 	}
 
 	// 所有尝试都失败
-	h.logRequest(requestIDFromContext(c), &req, nil, nil, startTime, 500, lastError, failoverFrom, clientInfo, false)
-	c.JSON(500, model.ErrorResponse{
-		Error: model.ErrorDetail{
-			Message: "All sources failed: " + lastError.Error(),
-			Type:    "upstream_error",
-			Code:    "all_sources_failed",
-		},
-	})
+	return 500, model.ErrorDetail{
+		Message: "All sources failed: " + lastError.Error(),
+		Type:    "upstream_error",
+		Code:    "all_sources_failed",
+	}
+}
+
+// forwardToSource 将请求转发到指定源
+// FC 兼容模式：源支持 FC 时原生透传，不支持时走兼容层（模拟 tool_call 输出）
+func (h *ProxyHandler) forwardToSource(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
+	// completions / responses 入口：源原生支持该接口时原样透传，不经过聊天格式转换
+	if native := nativeRequestFor(c, src); native != nil {
+		return h.forwardNative(c, req, native, src, startTime, failoverFrom, clientInfo)
+	}
+
+	// 转换请求
+	translatedReq := h.translator.TranslateRequest(req, src)
+
+	if req.HasTools() && !sourceSupportsFC(src, req.Model) {
+		return h.handleFCCompatRequest(c, req, translatedReq, src, startTime, failoverFrom, clientInfo)
+	}
+	if req.Stream {
+		return h.handleStreamRequest(c, translatedReq, src, startTime, failoverFrom, clientInfo)
+	}
+	return h.handleNormalRequest(c, translatedReq, src, startTime, failoverFrom, clientInfo)
 }
 
 // handleNormalRequest 处理非流式请求
 func (h *ProxyHandler) handleNormalRequest(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
+	chatResp, err := h.fetchResponse(c.Request.Context(), req, src, startTime)
+	if err != nil {
+		return false, err
+	}
+	h.respondNormal(c, req, chatResp, src, startTime, failoverFrom, clientInfo)
+	return true, nil
+}
+
+// respondNormal 记录日志、写入响应缓存并返回非流式响应
+func (h *ProxyHandler) respondNormal(c *gin.Context, req *model.ChatCompletionRequest, chatResp *model.ChatCompletionResponse, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) {
+	// 记录日志
+	h.logRequest(c, req, chatResp, src, startTime, http.StatusOK, nil, failoverFrom, clientInfo, false, 0)
+	h.saveCachedResponse(c, src, &model.CachedResponse{Response: chatResp})
+
+	// 返回响应
+	encoderFromContext(c).writeResponse(c, http.StatusOK, chatResp)
+}
+
+// fetchResponse 发送非流式请求并解析响应；上游失败时已更新源状态，ctx 被取消时不归咎于源
+func (h *ProxyHandler) fetchResponse(ctx context.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time) (*model.ChatCompletionResponse, error) {
 	// 构建请求
-	body, _ := json.Marshal(req)
-	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "POST", src.BaseURL+"/v1/chat/completions", bytes.NewReader(body))
+	path, body, err := h.translator.EncodeRequest(req, src)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] encode request: %w", src.Name, err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", src.BaseURL+path, bytes.NewReader(body))
 	if err != nil {
-		return false, fmt.Errorf("[%s] build request: %w", src.Name, err)
+		return nil, fmt.Errorf("[%s] build request: %w", src.Name, err)
 	}
 
 	h.setHeaders(httpReq, src)
@@ -156,120 +283,206 @@ func (h *ProxyHandler) handleNormalRequest(c *gin.Context, req *model.ChatComple
 	// 发送请求
 	resp, err := h.client.Do(httpReq)
 	if err != nil {
-		h.updateSourceLatency(src, time.Since(startTime), err)
-		return false, fmt.Errorf("[%s] %w", src.Name, err)
+		if ctx.Err() == nil {
+			h.updateSourceLatency(src, time.Since(startTime), err)
+		}
+		return nil, fmt.Errorf("[%s] %w", src.Name, err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应（限制 512KB 防止 OOM）
 	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 512*1024))
 	if err != nil {
-		return false, fmt.Errorf("[%s] read body: %w", src.Name, err)
+		return nil, fmt.Errorf("[%s] read body: %w", src.Name, err)
 	}
 
 	// 检查状态码 — 保留上游错误体
 	if resp.StatusCode != http.StatusOK {
-		upstreamErr := truncateBody(respBody, 4096)
-		h.updateSourceLatency(src, time.Since(startTime), fmt.Errorf("status %d", resp.StatusCode))
-		return false, fmt.Errorf("[%s] status %d: %s", src.Name, resp.StatusCode, upstreamErr)
+		statusErr := newUpstreamStatusError(resp, respBody)
+		h.updateSourceLatency(src, time.Since(startTime), statusErr)
+		return nil, fmt.Errorf("[%s] %w", src.Name, statusErr)
 	}
 
-	// 解析响应
-	var chatResp model.ChatCompletionResponse
-	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		return false, fmt.Errorf("[%s] decode response: %w", src.Name, err)
+	// 解析响应（按源类型转换为 OpenAI 格式）
+	chatResp, err := h.translator.TranslateResponse(respBody, src)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] decode response: %w", src.Name, err)
 	}
 
 	// 更新延迟
 	h.updateSourceLatency(src, time.Since(startTime), nil)
-
-	// 记录日志
-	h.logRequest(requestIDFromContext(c), req, &chatResp, src, startTime, resp.StatusCode, nil, failoverFrom, clientInfo, false)
-
-	// 返回响应
-	c.JSON(resp.StatusCode, chatResp)
-	return true, nil
+	return chatResp, nil
 }
 
 // handleStreamRequest 处理流式请求
+// 收到首个内容增量前缓冲所有数据帧：期间上游断开、报错或超过首 token 超时均返回 false 以便 failover；
+// 一旦开始向客户端输出则不能回退，之后的中断记为截断失败
 func (h *ProxyHandler) handleStreamRequest(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
-	// 构建请求
-	body, _ := json.Marshal(req)
-	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "POST", src.BaseURL+"/v1/chat/completions", bytes.NewReader(body))
+	head, err := h.readStreamHead(c.Request.Context(), req, src, startTime)
+	if head == nil {
+		return false, err
+	}
 	if err != nil {
-		return false, fmt.Errorf("[%s] build request: %w", src.Name, err)
+		return h.handleStreamFailure(c, req, src, startTime, head.finalUsage(req), err, false, failoverFrom, clientInfo, false)
 	}
+	return h.relayStream(c, req, src, startTime, head, failoverFrom, clientInfo)
+}
 
-	h.setHeaders(httpReq, src)
+// streamHead 流式响应开始向客户端输出之前的状态
+// 首个内容增量（或无内容但正常结束）到达前的数据帧缓存在 pending 中
+type streamHead struct {
+	stream           *upstreamStream
+	clientWantsUsage bool
+	pending          []string // 待写给客户端的数据帧
+	usage            *model.Usage
+	completion       strings.Builder
+	finished         bool // 上游是否正常结束（[DONE] / message_stop / finish_reason）
+	done             bool // 上游数据已读完
+}
 
-	// 发送请求
-	resp, err := h.client.Do(httpReq)
+// readStreamHead 打开上游流并读取到首个内容增量为止，期间不向客户端写任何数据
+// 打开失败时返回 nil（源状态已更新）；打开后读取失败时关闭流，并同时返回已读取的状态与错误
+func (h *ProxyHandler) readStreamHead(ctx context.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time) (*streamHead, error) {
+	stream, err := h.openStream(ctx, req, src, startTime)
 	if err != nil {
-		h.updateSourceLatency(src, time.Since(startTime), err)
-		return false, fmt.Errorf("[%s] %w", src.Name, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// 检查状态码 — 保留上游错误体
-	if resp.StatusCode != http.StatusOK {
-		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		upstreamErr := truncateBody(errBody, 4096)
-		h.updateSourceLatency(src, time.Since(startTime), fmt.Errorf("status %d", resp.StatusCode))
-		return false, fmt.Errorf("[%s] status %d: %s", src.Name, resp.StatusCode, upstreamErr)
+	head := &streamHead{
+		stream:           stream,
+		clientWantsUsage: req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
 	}
-
-	// 设置 SSE 响应头
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Transfer-Encoding", "chunked")
-
-	// 流式转发
-	reader := bufio.NewReader(resp.Body)
-	var totalTokens int
-
 	for {
-		line, err := reader.ReadString('\n')
+		hasContent, err := head.read()
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return true, nil // 已开始流式输出，不能回退
+			stream.close()
+			return head, err
 		}
+		if hasContent || head.finished {
+			stream.firstToken()
+			return head, nil
+		}
+	}
+}
 
-		// 跳过空行
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// read 读取下一批数据帧追加到 pending，返回其中是否含有内容增量
+// 按源类型转换后的 OpenAI chunk 同时用于统计 token：优先使用上游 usage，并累积输出文本用于估算
+func (s *streamHead) read() (bool, error) {
+	frames, done, err := s.stream.next()
+	if err == io.EOF {
+		s.done = true
+		if !s.finished {
+			return false, io.ErrUnexpectedEOF
 		}
+		return false, nil
+	}
 
-		// 解析 SSE 数据
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-				c.Writer.Flush()
-				break
+	hasContent := false
+	for _, frame := range frames {
+		var chunk model.StreamChunk
+		if frame != "[DONE]" && json.Unmarshal([]byte(frame), &chunk) == nil {
+			if chunk.Usage != nil {
+				s.usage = chunk.Usage
 			}
+			for _, choice := range chunk.Choices {
+				if text := core.ResponseText(choice.Delta); text != "" {
+					s.completion.WriteString(text)
+					hasContent = true
+				}
+				if choice.FinishReason != "" {
+					s.finished = true
+				}
+			}
+			// 客户端未请求 include_usage 时，不转发仅含 usage 的尾块
+			if chunk.Usage != nil && len(chunk.Choices) == 0 && !s.clientWantsUsage {
+				continue
+			}
+		}
+		s.pending = append(s.pending, frame)
+	}
 
-			// 转发数据
-			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
-			c.Writer.Flush()
+	if err != nil {
+		return hasContent, err
+	}
+	if done {
+		s.finished = true
+		s.done = true
+	}
+	return hasContent, nil
+}
+
+// finalUsage 上游未返回 usage 时使用本地估算（截断的流按已输出部分估算）
+func (s *streamHead) finalUsage(req *model.ChatCompletionRequest) *model.Usage {
+	if s.usage != nil {
+		return s.usage
+	}
+	return core.EstimateUsage(req, s.completion.String())
+}
+
+// relayStream 开始向客户端输出并转发剩余的流（按入口协议编码），此后不能再 failover
+func (h *ProxyHandler) relayStream(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, head *streamHead, failoverFrom string, clientInfo *model.ClientInfo) (bool, error) {
+	defer head.stream.close()
 
-			// 尝试解析以统计 token
-			var chunk model.StreamChunk
-			if json.Unmarshal([]byte(data), &chunk) == nil {
-				// 可以在这里统计
+	encoder := encoderFromContext(c)
+	writeSSEHeaders(c)
+
+	capture := newStreamCapture(c, h.cfg.Cache.MaxEntrySize)
+	var streamErr error
+	for {
+		if len(head.pending) > 0 {
+			capture.add(head.pending)
+			for _, frame := range head.pending {
+				encoder.writeStreamFrame(c, frame)
 			}
+			head.pending = head.pending[:0]
+			c.Writer.Flush()
 		}
+		if head.done {
+			break
+		}
+		if _, err := head.read(); err != nil {
+			streamErr = err
+			break
+		}
+	}
+
+	usage := head.finalUsage(req)
+	if streamErr != nil {
+		return h.handleStreamFailure(c, req, src, startTime, usage, streamErr, true, failoverFrom, clientInfo, false)
 	}
 
 	// 更新延迟
 	h.updateSourceLatency(src, time.Since(startTime), nil)
 
-	// 记录日志（流式请求无法获取完整 token 统计）
-	h.logStreamRequest(requestIDFromContext(c), req, src, startTime, totalTokens, failoverFrom, clientInfo, false)
+	// 记录日志
+	h.logStreamRequest(c, req, src, startTime, usage, nil, failoverFrom, clientInfo, false, 0)
+	if capture.enabled {
+		h.saveCachedResponse(c, src, &model.CachedResponse{Frames: capture.frames})
+	}
+
+	return true, nil
+}
+
+// handleStreamFailure 处理流中断
+// 未开始输出时返回 false 以便 failover；已开始输出则不能回退，记为截断失败
+func (h *ProxyHandler) handleStreamFailure(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, usage *model.Usage, streamErr error, committed bool, failoverFrom string, clientInfo *model.ClientInfo, fcCompatUsed bool) (bool, error) {
+	// 客户端主动断开：不归咎于上游，也不再 failover
+	if c.Request.Context().Err() != nil {
+		h.logStreamRequest(c, req, src, startTime, usage, fmt.Errorf("client disconnected"), failoverFrom, clientInfo, fcCompatUsed, 0)
+		return true, nil
+	}
+
+	h.updateSourceLatency(src, time.Since(startTime), streamErr)
+	if !committed {
+		return false, fmt.Errorf("[%s] stream: %w", src.Name, streamErr)
+	}
 
+	// 按入口协议在流中报告中断（如 Anthropic 的 error 事件），避免客户端把截断的回答当作完整结果
+	if w, ok := encoderFromContext(c).(streamErrorWriter); ok {
+		w.writeStreamError(c, model.ErrorDetail{Message: "Upstream stream interrupted", Type: "upstream_error"})
+		c.Writer.Flush()
+	}
+	h.logStreamRequest(c, req, src, startTime, usage, fmt.Errorf("stream truncated: %w", streamErr), failoverFrom, clientInfo, fcCompatUsed, 0)
 	return true, nil
 }
 
@@ -290,8 +503,52 @@ func (h *ProxyHandler) setHeaders(req *http.Request, src *model.Source) {
 	}
 }
 
-// updateSourceLatency 更新源延迟
+// upstreamStatusError 上游返回的非 200 响应，保留状态码、响应头与错误体
+type upstreamStatusError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func newUpstreamStatusError(resp *http.Response, body []byte) *upstreamStatusError {
+	return &upstreamStatusError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, truncateBody(e.Body, 4096))
+}
+
+// classifyError 对转发失败的错误分类：上游状态码按响应分类，其余为网络或源侧错误
+func classifyError(err error) core.ErrorClass {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return core.ClassifyStatus(statusErr.StatusCode, statusErr.Body)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return core.ErrorClassNetwork
+	}
+	return core.ErrorClassServer
+}
+
+// updateSourceLatency 更新源延迟，并将请求结果计入源的熔断器
+// 上游限流或配额耗尽时使源进入冷却（遵守 Retry-After 等重置头），请求本身的错误不影响源状态，
+// 二者都不计入熔断错误率；健康状态只由健康检查探测决定
 func (h *ProxyHandler) updateSourceLatency(src *model.Source, latency time.Duration, err error) {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		class := core.ClassifyStatus(statusErr.StatusCode, statusErr.Body)
+		if class == core.ErrorClassRateLimit {
+			cooldown := core.CooldownFromHeaders(statusErr.Header, time.Now(), core.DefaultSourceCooldown)
+			src.SetCooldown(time.Now().Add(cooldown), fmt.Sprintf("status %d", statusErr.StatusCode))
+			logger.Warn("source rate limited", "source", src.Name, "status", statusErr.StatusCode, "cooldown", cooldown.String())
+			return
+		}
+		if class.ClientCaused() {
+			return
+		}
+	}
+
 	status := src.GetStatus()
 	status.Latency = latency
 	status.LastCheck = time.Now()
@@ -300,15 +557,12 @@ func (h *ProxyHandler) updateSourceLatency(src *model.Source, latency time.Durat
 		status.ConsecutiveFail++
 		status.ErrorCount++
 		status.LastError = err.Error()
-		if status.ConsecutiveFail >= h.cfg.HealthCheck.FailureThreshold {
-			status.State = model.HealthStateUnhealthy
-		}
 	} else {
 		status.ConsecutiveFail = 0
-		status.State = model.HealthStateHealthy
 	}
 
 	src.SetStatus(status)
+	h.manager.Breakers().Record(src, err != nil)
 }
 
 // requestIDFromContext gets request id from gin context (if present).
@@ -324,8 +578,114 @@ func requestIDFromContext(c *gin.Context) string {
 	return ""
 }
 
+// queuePollInterval 排队等待时重新检查源额度的间隔
+const queuePollInterval = 100 * time.Millisecond
+
+// acquireSource 占用源的 RPM、并发与 TPM 额度，返回释放函数；未配置限制时直接放行
+// 释放函数归还并发令牌，该源未记录上游用量（转发失败后 failover、对冲落败）时同时释放其 TPM 预占
+func (h *ProxyHandler) acquireSource(c *gin.Context, src *model.Source, tokens int) (func(), bool) {
+	if h.rateLimiter == nil || src.Limits == nil {
+		return func() {}, true
+	}
+	res := reservationFrom(c)
+	if ok, _ := h.rateLimiter.AcquireSource(src.ID, res.id, src.Limits, tokens); !ok {
+		return nil, false
+	}
+	// 释放函数可能在请求结束后由后台 goroutine 调用（对冲落败），只持有预占记录而不持有 gin 上下文
+	return func() {
+		h.rateLimiter.ReleaseSource(src.ID)
+		h.releaseSourceTokens(res, src)
+	}, true
+}
+
+// releaseSourceTokens 释放源上尚未按实际用量校正的 TPM 预占（每个源只处理一次）
+func (h *ProxyHandler) releaseSourceTokens(res *tokenReservation, src *model.Source) {
+	if h.rateLimiter == nil || src.Limits == nil || src.Limits.TPM <= 0 {
+		return
+	}
+	if res.claim(src.ID) {
+		h.rateLimiter.ReconcileTokens(core.SourceTokenBucket(src.ID), res.id, 0)
+	}
+}
+
+// reservationKey gin 上下文中本次请求的 TPM 预占记录
+const reservationKey = "token_reservation"
+
+// tokenReservation 本次请求在 Key 与各源上的 TPM 预占（对冲时多个 goroutine 并发访问）
+// 预占 ID 由服务端生成：X-Request-ID 可由客户端指定，用它校正会改写其他请求的预占
+type tokenReservation struct {
+	id         string
+	mu         sync.Mutex
+	reconciled map[string]bool // 已按实际用量校正的源
+}
+
+// reservationFrom 返回本次请求的预占记录，不存在时生成新的预占 ID
+// 首次调用在路由主循环中（尚未启动对冲 goroutine），无需额外同步
+func reservationFrom(c *gin.Context) *tokenReservation {
+	if v, ok := c.Get(reservationKey); ok {
+		return v.(*tokenReservation)
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	res := &tokenReservation{id: "rsv_" + hex.EncodeToString(b), reconciled: make(map[string]bool)}
+	c.Set(reservationKey, res)
+	return res
+}
+
+// claim 标记源已校正，返回此前是否尚未校正
+func (r *tokenReservation) claim(srcID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reconciled[srcID] {
+		return false
+	}
+	r.reconciled[srcID] = true
+	return true
+}
+
+// waitForCapacity 等待一个检查间隔；超过排队截止时间或客户端已断开时返回 false
+func waitForCapacity(c *gin.Context, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+	if wait > queuePollInterval {
+		wait = queuePollInterval
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-c.Request.Context().Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// reconcileTokens 用实际用量校正 Key 与源的 TPM 预占
+func (h *ProxyHandler) reconcileTokens(c *gin.Context, src *model.Source, clientInfo *model.ClientInfo, usage *model.Usage) {
+	if h.rateLimiter == nil || usage == nil {
+		return
+	}
+	res := reservationFrom(c)
+	if clientInfo != nil && clientInfo.Limits.TPM > 0 {
+		h.rateLimiter.ReconcileTokens(core.KeyTokenBucket(clientInfo.KeyID), res.id, usage.TotalTokens)
+	}
+	if src != nil && src.Limits != nil && src.Limits.TPM > 0 {
+		res.claim(src.ID)
+		h.rateLimiter.ReconcileTokens(core.SourceTokenBucket(src.ID), res.id, usage.TotalTokens)
+	}
+}
+
+// releaseTokens 请求未产生上游用量即结束（全部失败、源饱和、请求被上游拒绝）时释放 Key 的 TPM 预占
+func (h *ProxyHandler) releaseTokens(c *gin.Context, clientInfo *model.ClientInfo) {
+	h.reconcileTokens(c, nil, clientInfo, &model.Usage{})
+}
+
 // logRequest 记录请求日志
-func (h *ProxyHandler) logRequest(requestID string, req *model.ChatCompletionRequest, resp *model.ChatCompletionResponse, src *model.Source, startTime time.Time, statusCode int, err error, failoverFrom string, clientInfo *model.ClientInfo, fcCompatUsed bool) {
+func (h *ProxyHandler) logRequest(c *gin.Context, req *model.ChatCompletionRequest, resp *model.ChatCompletionResponse, src *model.Source, startTime time.Time, statusCode int, err error, failoverFrom string, clientInfo *model.ClientInfo, fcCompatUsed bool, repairCount int) {
+	requestID := requestIDFromContext(c)
 	log := &model.RequestLog{
 		ID:           core.GenerateLogID(),
 		RequestID:    requestID,
@@ -338,7 +698,10 @@ func (h *ProxyHandler) logRequest(requestID string, req *model.ChatCompletionReq
 		StatusCode:   statusCode,
 		LatencyMs:    time.Since(startTime).Milliseconds(),
 		FailoverFrom: failoverFrom,
+		Hedged:       c.GetBool(hedgedKey),
+		Endpoint:     c.GetString(endpointKey),
 		FCCompatUsed: fcCompatUsed,
+		RepairCount:  repairCount,
 	}
 
 	if src != nil {
@@ -350,10 +713,25 @@ func (h *ProxyHandler) logRequest(requestID string, req *model.ChatCompletionReq
 		log.Error = err.Error()
 	}
 
-	if resp != nil && resp.Usage != nil {
-		log.PromptTokens = resp.Usage.PromptTokens
-		log.CompletionTokens = resp.Usage.CompletionTokens
-		log.TotalTokens = resp.Usage.TotalTokens
+	if resp != nil {
+		usage := resp.Usage
+		if usage == nil {
+			// 上游未返回 usage 时使用本地估算
+			var completion string
+			if len(resp.Choices) > 0 {
+				completion = core.ResponseText(resp.Choices[0].Message)
+			}
+			usage = core.EstimateUsage(req, completion)
+		}
+		log.PromptTokens = usage.PromptTokens
+		log.CompletionTokens = usage.CompletionTokens
+		log.TotalTokens = usage.TotalTokens
+		log.CachedTokens = usage.CachedTokens()
+		log.CacheCreationTokens = usage.CacheCreationTokens()
+		if src != nil {
+			log.Cost = src.CostFor(req.Model, usage)
+		}
+		h.reconcileTokens(c, src, clientInfo, usage)
 	}
 
 	// Add client info
@@ -376,7 +754,9 @@ func (h *ProxyHandler) logRequest(requestID string, req *model.ChatCompletionReq
 }
 
 // logStreamRequest 记录流式请求日志
-func (h *ProxyHandler) logStreamRequest(requestID string, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, totalTokens int, failoverFrom string, clientInfo *model.ClientInfo, fcCompatUsed bool) {
+// streamErr 非空表示流在开始输出后中断（截断），记为失败
+func (h *ProxyHandler) logStreamRequest(c *gin.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time, usage *model.Usage, streamErr error, failoverFrom string, clientInfo *model.ClientInfo, fcCompatUsed bool, repairCount int) {
+	requestID := requestIDFromContext(c)
 	log := &model.RequestLog{
 		ID:           core.GenerateLogID(),
 		RequestID:    requestID,
@@ -387,12 +767,28 @@ func (h *ProxyHandler) logStreamRequest(requestID string, req *model.ChatComplet
 		HasTools:     req.HasTools(),
 		HasThinking:  req.HasThinking(),
 		Stream:       true,
-		Success:      true,
+		Success:      streamErr == nil,
 		StatusCode:   200,
 		LatencyMs:    time.Since(startTime).Milliseconds(),
-		TotalTokens:  totalTokens,
 		FailoverFrom: failoverFrom,
+		Hedged:       c.GetBool(hedgedKey),
+		Endpoint:     c.GetString(endpointKey),
 		FCCompatUsed: fcCompatUsed,
+		RepairCount:  repairCount,
+	}
+
+	if streamErr != nil {
+		log.Error = streamErr.Error()
+	}
+
+	if usage != nil {
+		log.PromptTokens = usage.PromptTokens
+		log.CompletionTokens = usage.CompletionTokens
+		log.TotalTokens = usage.TotalTokens
+		log.CachedTokens = usage.CachedTokens()
+		log.CacheCreationTokens = usage.CacheCreationTokens()
+		log.Cost = src.CostFor(req.Model, usage)
+		h.reconcileTokens(c, src, clientInfo, usage)
 	}
 
 	// Add client info
@@ -402,22 +798,26 @@ func (h *ProxyHandler) logStreamRequest(requestID string, req *model.ChatComplet
 		log.APIKeyID = clientInfo.KeyID
 	}
 
-	// Record success for auto-ban
+	// Record success/error for auto-ban
 	if clientInfo != nil && clientInfo.KeyID != "" && h.rateLimiter != nil {
-		h.rateLimiter.RecordSuccess(clientInfo.KeyID)
+		if log.Success {
+			h.rateLimiter.RecordSuccess(clientInfo.KeyID)
+		} else {
+			h.rateLimiter.RecordError(clientInfo.KeyID)
+		}
 	}
 
 	h.store.SaveLog(log)
 }
 
-// ListModels 列出模型
+// ListModels 列出模型（公开别名代替上游原始模型 ID）
 func (h *ProxyHandler) ListModels(c *gin.Context) {
 	sources := h.manager.GetHealthy()
 	modelSet := make(map[string]bool)
 	var models []model.ModelInfo
 
 	for _, src := range sources {
-		for _, m := range src.Capabilities.Models {
+		for _, m := range append(src.PublicModels(h.cfg.ModelMappings), src.PublicEmbeddingModels(h.cfg.ModelMappings)...) {
 			if !modelSet[m] {
 				modelSet[m] = true
 				models = append(models, model.ModelInfo{
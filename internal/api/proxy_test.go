@@ -0,0 +1,833 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/config"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+	"github.com/xiaopang/fusionapi/internal/store"
+)
+
+// newTestProxy 创建带临时数据库的代理处理器，sources 按顺序作为优先级
+func newTestProxy(t *testing.T, sources ...*model.Source) (*ProxyHandler, *store.Store) {
+	t.Helper()
+	st, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	manager := core.NewSourceManager(st)
+	for i, src := range sources {
+		if src.Priority == 0 {
+			src.Priority = i + 1
+		}
+		src.Enabled = true
+		if err := manager.Add(src); err != nil {
+			t.Fatalf("failed to add source: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		HealthCheck: config.HealthCheckConfig{FailureThreshold: 3},
+		Routing: config.RoutingConfig{
+			Strategy: core.StrategyPriority,
+			Failover: config.FailoverConfig{Enabled: true, MaxRetries: 2},
+		},
+		FCCompat: config.FCCompatConfig{MaxRepairAttempts: 2},
+	}
+	router := core.NewRouter(manager, cfg.Routing.Strategy)
+	return NewProxyHandler(router, manager, core.NewTranslator(), st, cfg, nil), st
+}
+
+// performRequest 通过 gin 引擎执行请求
+func performRequest(h gin.HandlerFunc, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(path, h)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChatCompletions_StreamRecordsUpstreamUsage(t *testing.T) {
+	var upstreamReq model.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":11,\"completion_tokens\":7,\"total_tokens\":18}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if upstreamReq.StreamOptions == nil || !upstreamReq.StreamOptions.IncludeUsage {
+		t.Error("expected stream_options.include_usage to be requested upstream")
+	}
+	if strings.Contains(w.Body.String(), `"usage"`) {
+		t.Errorf("usage chunk must not be forwarded when client did not request it:\n%s", w.Body.String())
+	}
+
+	logs, err := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d (%v)", len(logs), err)
+	}
+	if logs[0].PromptTokens != 11 || logs[0].CompletionTokens != 7 || logs[0].TotalTokens != 18 {
+		t.Errorf("unexpected logged usage: %+v", logs[0])
+	}
+}
+
+func TestChatCompletions_StreamEstimatesMissingUsage(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello there\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "custom", Type: model.SourceTypeCustom, BaseURL: upstream.URL})
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"m","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if logs[0].CompletionTokens != core.EstimateTokens("Hello there") || logs[0].PromptTokens == 0 {
+		t.Errorf("expected estimated usage, got %+v", logs[0])
+	}
+}
+
+func TestChatCompletions_StreamFailsOverBeforeFirstToken(t *testing.T) {
+	// 首个源返回 200 后未输出任何内容即断开
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c0\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+	}))
+	defer broken.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer healthy.Close()
+
+	first := &model.Source{Name: "broken", Type: model.SourceTypeCustom, BaseURL: broken.URL}
+	h, st := newTestProxy(t, first, &model.Source{Name: "healthy", Type: model.SourceTypeCustom, BaseURL: healthy.URL})
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"ok"`) || strings.Contains(w.Body.String(), `"c0"`) {
+		t.Fatalf("expected stream from second source only, got %d:\n%s", w.Code, w.Body.String())
+	}
+	if first.GetStatus().ConsecutiveFail != 1 {
+		t.Errorf("expected broken source to be penalised, got %+v", first.GetStatus())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || !logs[0].Success || logs[0].SourceName != "healthy" || logs[0].FailoverFrom != first.ID {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+func TestChatCompletions_StreamFirstTokenTimeout(t *testing.T) {
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer stalled.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer healthy.Close()
+
+	h, _ := newTestProxy(t,
+		&model.Source{Name: "stalled", Type: model.SourceTypeCustom, BaseURL: stalled.URL},
+		&model.Source{Name: "healthy", Type: model.SourceTypeCustom, BaseURL: healthy.URL},
+	)
+	h.cfg.Routing.Failover.FirstTokenTimeout = 1
+
+	start := time.Now()
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"ok"`) {
+		t.Fatalf("expected failover to healthy source, got %d:\n%s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("first token timeout not applied, took %s", elapsed)
+	}
+}
+
+func TestChatCompletions_StreamTruncatedLoggedAsFailure(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"partial\"}}]}\n\n")
+	}))
+	defer upstream.Close()
+
+	src := &model.Source{Name: "flaky", Type: model.SourceTypeCustom, BaseURL: upstream.URL}
+	h, st := newTestProxy(t, src)
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "partial") {
+		t.Fatalf("expected partial stream to reach client, got %d:\n%s", w.Code, w.Body.String())
+	}
+	if src.GetStatus().ConsecutiveFail != 1 {
+		t.Errorf("expected truncated stream to penalise source, got %+v", src.GetStatus())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].Success || !strings.Contains(logs[0].Error, "stream truncated") {
+		t.Errorf("expected truncated stream logged as failure, got %+v", logs)
+	}
+}
+
+func TestChatCompletions_ModelMapping(t *testing.T) {
+	var upstreamModel string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		upstreamModel = req.Model
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	other := &model.Source{Name: "other", Type: model.SourceTypeOpenAI, BaseURL: "http://127.0.0.1:1",
+		Capabilities: model.Capabilities{Models: []string{"gpt-4o"}}}
+	router := &model.Source{Name: "router", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL,
+		Capabilities: model.Capabilities{Models: []string{"anthropic/claude-sonnet-4"}},
+		ModelMap:     []model.ModelMapping{{Match: "claude-sonnet-4", Target: "anthropic/claude-sonnet-4"}}}
+	h, st := newTestProxy(t, other, router)
+	h.cfg.ModelMappings = []model.ModelMapping{{Match: "claude-sonnet*", Target: "claude-sonnet-4"}}
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if upstreamModel != "anthropic/claude-sonnet-4" {
+		t.Errorf("expected outgoing model to be renamed per source, got %q", upstreamModel)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].SourceName != "router" || logs[0].Model != "claude-sonnet-4" {
+		t.Errorf("expected request routed by resolved model, got %+v", logs)
+	}
+}
+
+func TestListModels_PublicAliases(t *testing.T) {
+	src := &model.Source{Name: "router", Type: model.SourceTypeOpenAI, BaseURL: "http://127.0.0.1:1",
+		Capabilities: model.Capabilities{Models: []string{"anthropic/claude-sonnet-4", "gpt-4o"}},
+		ModelMap:     []model.ModelMapping{{Match: "claude-sonnet-4", Target: "anthropic/claude-sonnet-4"}}}
+	h, _ := newTestProxy(t, src)
+	h.cfg.ModelMappings = []model.ModelMapping{{Match: "sonnet", Target: "claude-sonnet-4"}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/v1/models", h.ListModels)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+
+	var resp model.ModelsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	var ids []string
+	for _, m := range resp.Data {
+		ids = append(ids, m.ID)
+	}
+	if strings.Join(ids, ",") != "sonnet,gpt-4o" {
+		t.Errorf("expected public aliases instead of upstream IDs, got %v", ids)
+	}
+}
+
+func TestChatCompletions_LogsCost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":2000,"completion_tokens":1000,"total_tokens":3000,"prompt_tokens_details":{"cached_tokens":1000}}}`)
+	}))
+	defer upstream.Close()
+
+	src := &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL,
+		Pricing: []model.ModelPrice{{Model: "gpt-4o", Input: 2.5, Output: 10, CacheRead: 1.25}}}
+	h, st := newTestProxy(t, src)
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	want := (1000*2.5 + 1000*1.25 + 1000*10) / 1e6
+	if len(logs) != 1 || logs[0].Cost < want-1e-9 || logs[0].Cost > want+1e-9 {
+		t.Errorf("expected logged cost %v, got %+v", want, logs)
+	}
+	if logs[0].CachedTokens != 1000 {
+		t.Errorf("expected 1000 cached tokens logged, got %d", logs[0].CachedTokens)
+	}
+}
+
+func TestChatCompletions_SkipsSourceOverTPM(t *testing.T) {
+	var hits []string
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+		}))
+	}
+	capped, other := newUpstream("capped"), newUpstream("other")
+	defer capped.Close()
+	defer other.Close()
+
+	src := &model.Source{ID: "capped", Name: "capped", Type: model.SourceTypeOpenAI, BaseURL: capped.URL,
+		Limits: &model.SourceLimits{TPM: 100}}
+	h, _ := newTestProxy(t, src, &model.Source{ID: "other", Name: "other", Type: model.SourceTypeOpenAI, BaseURL: other.URL})
+	h.cfg.Routing.Failover.MaxRetries = 0
+	h.rateLimiter = core.NewRateLimiter()
+	h.rateLimiter.ReserveTokens(core.SourceTokenBucket("capped"), "earlier", 100, 100)
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(hits) != 1 || hits[0] != "other" {
+		t.Errorf("expected only the uncapped source to be called, got %v", hits)
+	}
+}
+
+func TestChatCompletions_QueuesWhenSourcesSaturated(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	}))
+	defer upstream.Close()
+
+	limits := &model.SourceLimits{MaxConcurrent: 1}
+	h, _ := newTestProxy(t, &model.Source{ID: "busy", Name: "busy", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL, Limits: limits})
+	limiter := core.NewRateLimiter()
+	h.rateLimiter = limiter
+	h.router.SetLimiter(limiter)
+	h.cfg.Routing.QueueTimeout = 2000
+
+	// 占满并发，稍后释放：请求应排队等待而不是直接失败
+	limiter.AcquireSource("busy", "", limits, 0)
+	time.AfterFunc(200*time.Millisecond, func() { limiter.ReleaseSource("busy") })
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected queued request to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if state := limiter.State(); len(state.Sources) != 0 {
+		t.Errorf("expected concurrency to be released after the request, got %+v", state.Sources)
+	}
+}
+
+func TestChatCompletions_SourcesSaturated(t *testing.T) {
+	limits := &model.SourceLimits{RPM: 1}
+	h, _ := newTestProxy(t, &model.Source{ID: "busy", Name: "busy", Type: model.SourceTypeOpenAI, BaseURL: "http://127.0.0.1:0", Limits: limits})
+	limiter := core.NewRateLimiter()
+	h.rateLimiter = limiter
+	h.router.SetLimiter(limiter)
+	h.cfg.Routing.QueueTimeout = 150
+
+	limiter.AcquireSource("busy", "", limits, 0)
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 429 || !strings.Contains(w.Body.String(), "sources_saturated") {
+		t.Errorf("expected 429 sources_saturated, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChatCompletions_RateLimitedSourceCoolsDown(t *testing.T) {
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"rate limited"}}`)
+	}))
+	defer limited.Close()
+	var backupHits int
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backupHits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer backup.Close()
+
+	src := &model.Source{ID: "limited", Name: "limited", Type: model.SourceTypeOpenAI, BaseURL: limited.URL, Priority: 1}
+	h, _ := newTestProxy(t, src, &model.Source{ID: "backup", Name: "backup", Type: model.SourceTypeOpenAI, BaseURL: backup.URL, Priority: 2})
+	h.cfg.HealthCheck.FailureThreshold = 1
+	h.cfg.Routing.Failover.Enabled = true
+	h.cfg.Routing.Failover.MaxRetries = 1
+
+	for i := 0; i < 2; i++ {
+		w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+		if w.Code != 200 {
+			t.Fatalf("request %d: expected failover to succeed, got %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	if backupHits != 2 {
+		t.Errorf("expected both requests to be served by backup, got %d", backupHits)
+	}
+
+	limitedSrc, _ := h.manager.Get("limited")
+	if !limitedSrc.IsHealthy() {
+		t.Error("expected a rate limited source to stay healthy")
+	}
+	until, reason, ok := limitedSrc.Cooldown()
+	if !ok || reason != "status 429" || time.Until(until) < 110*time.Second {
+		t.Errorf("expected a ~120s cooldown from Retry-After, got %v %q %v", until, reason, ok)
+	}
+	if status := limitedSrc.ToResponse().Status; status.CooldownUntil == nil {
+		t.Error("expected cooldown to be exposed in the status response")
+	}
+}
+
+func TestChatCompletions_ClientErrorFailsFast(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"temperature must be <= 2","type":"invalid_request_error","param":"temperature"}}`)
+	}))
+	defer bad.Close()
+	var otherHits int
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherHits++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer other.Close()
+
+	h, _ := newTestProxy(t,
+		&model.Source{ID: "bad", Name: "bad", Type: model.SourceTypeOpenAI, BaseURL: bad.URL, Priority: 1},
+		&model.Source{ID: "other", Name: "other", Type: model.SourceTypeOpenAI, BaseURL: other.URL, Priority: 2})
+	h.cfg.HealthCheck.FailureThreshold = 1
+	h.cfg.Routing.Failover.Enabled = true
+	h.cfg.Routing.Failover.MaxRetries = 2
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","temperature":5,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 400 {
+		t.Fatalf("expected upstream 400 to be passed through, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp model.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error.Message != "temperature must be <= 2" || resp.Error.Param != "temperature" {
+		t.Errorf("expected upstream error body, got %+v", resp.Error)
+	}
+	if otherHits != 0 {
+		t.Errorf("expected no failover on a client error, got %d calls to the second source", otherHits)
+	}
+	if src, _ := h.manager.Get("bad"); !src.IsHealthy() {
+		t.Error("expected a client error not to mark the source unhealthy")
+	}
+}
+
+func TestChatCompletions_ContextLengthFailsOver(t *testing.T) {
+	small := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"This model's maximum context length is 8192 tokens","code":"context_length_exceeded"}}`)
+	}))
+	defer small.Close()
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer large.Close()
+
+	h, _ := newTestProxy(t,
+		&model.Source{ID: "small", Name: "small", Type: model.SourceTypeOpenAI, BaseURL: small.URL, Priority: 1},
+		&model.Source{ID: "large", Name: "large", Type: model.SourceTypeOpenAI, BaseURL: large.URL, Priority: 2})
+	h.cfg.Routing.Failover.Enabled = true
+	h.cfg.Routing.Failover.MaxRetries = 1
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected context length error to fail over, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChatCompletions_BreakerOpensOnLiveErrors(t *testing.T) {
+	var flakyHits int
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flakyHits++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer flaky.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer backup.Close()
+
+	h, _ := newTestProxy(t,
+		&model.Source{ID: "flaky", Name: "flaky", Type: model.SourceTypeOpenAI, BaseURL: flaky.URL, Priority: 1},
+		&model.Source{ID: "backup", Name: "backup", Type: model.SourceTypeOpenAI, BaseURL: backup.URL, Priority: 2})
+	h.manager.SetBreakers(core.NewBreakerSet(&config.CircuitBreakerConfig{MinRequests: 2}))
+	h.cfg.Routing.Failover.Enabled = true
+	h.cfg.Routing.Failover.MaxRetries = 1
+
+	for i := 0; i < 4; i++ {
+		w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+		if w.Code != 200 {
+			t.Fatalf("request %d: expected failover to succeed, got %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	if flakyHits != 2 {
+		t.Errorf("expected the breaker to stop traffic after 2 failures, got %d calls", flakyHits)
+	}
+	if state := h.manager.Breakers().State("flaky"); state != model.BreakerOpen {
+		t.Errorf("expected breaker to be open, got %s", state)
+	}
+	if src, _ := h.manager.Get("flaky"); !src.IsHealthy() {
+		t.Error("expected live errors to open the breaker without changing health state")
+	}
+}
+
+func TestChatCompletions_HedgesSlowSource(t *testing.T) {
+	// 首个源返回响应头后迟迟不出首个 token，直到请求被取消
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"fast\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer fast.Close()
+
+	h, st := newTestProxy(t,
+		&model.Source{ID: "slow", Name: "slow", Type: model.SourceTypeOpenAI, BaseURL: slow.URL, Limits: &model.SourceLimits{TPM: 100000}},
+		&model.Source{ID: "fast", Name: "fast", Type: model.SourceTypeOpenAI, BaseURL: fast.URL})
+	h.cfg.Routing.Hedge = config.HedgeConfig{Delay: 50, Tools: []string{"cursor"}}
+	limiter := core.NewRateLimiter()
+	h.rateLimiter = limiter
+
+	started := time.Now()
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set(RequestIDKey, "req_hedge")
+		c.Set("client_info", &model.ClientInfo{Tool: "cursor"})
+		h.ChatCompletions(c)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "fast") {
+		t.Fatalf("expected the hedge response, got %d: %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("expected hedging to avoid waiting on the slow source, took %s", elapsed)
+	}
+	if used := limiter.TokensUsed(core.SourceTokenBucket("slow")); used != 0 {
+		t.Errorf("expected the loser's TPM reservation to be released, %d tokens still held", used)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 2 {
+		t.Fatalf("expected both attempts to be logged, got %d", len(logs))
+	}
+	for _, l := range logs {
+		if l.RequestID != "req_hedge" || !l.Hedged {
+			t.Errorf("expected hedged log for the request, got %+v", l)
+		}
+		switch l.SourceID {
+		case "fast":
+			// 胜出方的延迟从请求开始计算，包含对冲前的等待
+			if !l.Success || l.LatencyMs < 50 {
+				t.Errorf("expected the winner to be logged as success from the request start, got %+v", l)
+			}
+		case "slow":
+			if l.Success || l.StatusCode != hedgeLoserStatus || l.PromptTokens == 0 {
+				t.Errorf("expected the loser to be logged as cancelled, got %+v", l)
+			}
+		}
+	}
+	if src, _ := h.manager.Get("slow"); src.GetStatus().ConsecutiveFail != 0 {
+		t.Error("expected the cancelled loser not to count as a source failure")
+	}
+}
+
+func TestChatCompletions_HedgeOnlyForConfiguredTools(t *testing.T) {
+	var slowHits, fastHits int
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits++
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"slow"},"finish_reason":"stop"}]}`)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits++
+	}))
+	defer fast.Close()
+
+	h, _ := newTestProxy(t,
+		&model.Source{ID: "slow", Name: "slow", Type: model.SourceTypeOpenAI, BaseURL: slow.URL},
+		&model.Source{ID: "fast", Name: "fast", Type: model.SourceTypeOpenAI, BaseURL: fast.URL})
+	h.cfg.Routing.Hedge = config.HedgeConfig{Delay: 10, Tools: []string{"cursor"}}
+
+	w := performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "slow") {
+		t.Fatalf("expected the primary response, got %d: %s", w.Code, w.Body.String())
+	}
+	if slowHits != 1 || fastHits != 0 {
+		t.Errorf("expected no hedge for other tools, got slow=%d fast=%d", slowHits, fastHits)
+	}
+}
+
+func TestChatCompletions_CachesDeterministicResponses(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"c%d","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`, hits)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{ID: "openai", Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+	h.cfg.Cache.Enabled = true
+	h.SetResponseCache(core.NewMemoryCache(time.Minute, 10))
+
+	body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	first := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	second := performRequest(h.ChatCompletions, "/v1/chat/completions", body, nil)
+	if first.Code != 200 || second.Code != 200 {
+		t.Fatalf("expected 200s, got %d and %d", first.Code, second.Code)
+	}
+	if hits != 1 {
+		t.Errorf("expected the second request to be served from cache, upstream called %d times", hits)
+	}
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("unexpected X-Cache headers: %q, %q", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("expected identical replayed response:\n%s\n%s", first.Body.String(), second.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	var cacheHits int
+	for _, l := range logs {
+		if l.CacheHit {
+			cacheHits++
+			if l.SourceID != "openai" || l.TotalTokens != 0 || l.Cost != 0 {
+				t.Errorf("expected cache hit logged without upstream usage, got %+v", l)
+			}
+		}
+	}
+	if len(logs) != 2 || cacheHits != 1 {
+		t.Errorf("expected 1 cache hit among 2 logs, got %d of %d", cacheHits, len(logs))
+	}
+
+	// 非确定性请求与 no-store 请求不使用缓存
+	performRequest(h.ChatCompletions, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	performRequest(h.ChatCompletions, "/v1/chat/completions", body, map[string]string{"Cache-Control": "no-store"})
+	if hits != 3 {
+		t.Errorf("expected uncacheable requests to reach upstream, got %d calls", hits)
+	}
+}
+
+func TestChatCompletions_ReplaysCachedStream(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+	h.SetResponseCache(core.NewMemoryCache(time.Minute, 10))
+	keyed := func(c *gin.Context) {
+		c.Set("client_info", &model.ClientInfo{KeyID: "key_ci", Limits: model.KeyLimits{ResponseCache: "on"}})
+		h.ChatCompletions(c)
+	}
+
+	body := `{"model":"gpt-4o","stream":true,"temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	first := performRequest(keyed, "/v1/chat/completions", body, nil)
+	second := performRequest(keyed, "/v1/chat/completions", body, nil)
+	if hits != 1 {
+		t.Fatalf("expected the key's cache setting to enable caching, upstream called %d times", hits)
+	}
+	if second.Header().Get("X-Cache") != "HIT" || first.Body.String() != second.Body.String() {
+		t.Errorf("expected the stream to be replayed from cache:\n%s\n%s", first.Body.String(), second.Body.String())
+	}
+
+	// no-cache 跳过缓存并刷新
+	performRequest(keyed, "/v1/chat/completions", body, map[string]string{"Cache-Control": "no-cache"})
+	if hits != 2 {
+		t.Errorf("expected no-cache to bypass the cache, upstream called %d times", hits)
+	}
+}
+
+func TestChatCompletions_ReleasesKeyTokensWhenAllSourcesFail(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"bad request","type":"invalid_request_error"}}`)
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL,
+		Capabilities: model.Capabilities{Embeddings: true}})
+	limiter := core.NewRateLimiter()
+	h.rateLimiter = limiter
+	keyed := func(handler gin.HandlerFunc, requestID string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set(RequestIDKey, requestID)
+			c.Set("client_info", &model.ClientInfo{KeyID: "key_tpm", Limits: model.KeyLimits{TPM: 100000}})
+			handler(c)
+		}
+	}
+
+	bucket := core.KeyTokenBucket("key_tpm")
+	if w := performRequest(keyed(h.ChatCompletions, "req_chat"), "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hello there"}]}`, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected upstream 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if used := limiter.TokensUsed(bucket); used != 0 {
+		t.Errorf("expected chat reservation to be released, %d tokens still held", used)
+	}
+
+	if w := performRequest(keyed(h.Embeddings, "req_embed"), "/v1/embeddings", `{"model":"m","input":"hello there"}`, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected upstream 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if used := limiter.TokensUsed(bucket); used != 0 {
+		t.Errorf("expected embeddings reservation to be released, %d tokens still held", used)
+	}
+}
+
+func TestChatCompletions_ReleasesSourceTokensOnFailover(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	}))
+	defer healthy.Close()
+
+	limits := &model.SourceLimits{TPM: 100000}
+	h, _ := newTestProxy(t,
+		&model.Source{ID: "failing", Name: "failing", Type: model.SourceTypeOpenAI, BaseURL: failing.URL, Limits: limits},
+		&model.Source{ID: "healthy", Name: "healthy", Type: model.SourceTypeOpenAI, BaseURL: healthy.URL, Limits: limits})
+	limiter := core.NewRateLimiter()
+	h.rateLimiter = limiter
+
+	handler := func(c *gin.Context) {
+		c.Set(RequestIDKey, "req_failover")
+		h.ChatCompletions(c)
+	}
+	if w := performRequest(handler, "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hello there"}]}`, nil); w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if used := limiter.TokensUsed(core.SourceTokenBucket("failing")); used != 0 {
+		t.Errorf("expected the failed source's reservation to be released, %d tokens still held", used)
+	}
+	if used := limiter.TokensUsed(core.SourceTokenBucket("healthy")); used != 6 {
+		t.Errorf("expected the serving source to be charged actual usage, got %d", used)
+	}
+}
+
+func TestChatCompletions_CacheIsScopedPerKey(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"c%d","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`, hits)
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+	h.cfg.Cache.Enabled = true
+	h.SetResponseCache(core.NewMemoryCache(time.Minute, 10))
+	withKey := func(id string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("client_info", &model.ClientInfo{KeyID: id})
+			h.ChatCompletions(c)
+		}
+	}
+
+	body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	performRequest(withKey("key_a"), "/v1/chat/completions", body, nil)
+	if w := performRequest(withKey("key_b"), "/v1/chat/completions", body, nil); w.Header().Get("X-Cache") != "MISS" || hits != 2 {
+		t.Errorf("expected another key to miss the cache, got %q with %d upstream calls", w.Header().Get("X-Cache"), hits)
+	}
+
+	// 显式开启共享后不同 Key 命中同一条目
+	h.cfg.Cache.ShareAcrossKeys = true
+	performRequest(withKey("key_a"), "/v1/chat/completions", body, nil)
+	if w := performRequest(withKey("key_b"), "/v1/chat/completions", body, nil); w.Header().Get("X-Cache") != "HIT" || hits != 3 {
+		t.Errorf("expected shared entries across keys, got %q with %d upstream calls", w.Header().Get("X-Cache"), hits)
+	}
+}
+
+func TestChatCompletions_TPMReservationIgnoresClientRequestID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	}))
+	defer upstream.Close()
+
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+	limiter := core.NewRateLimiter()
+	h.rateLimiter = limiter
+	bucket := core.KeyTokenBucket("key_tpm")
+
+	// 另一个请求以同一 X-Request-ID 占用的额度不能被本次请求校正
+	limiter.ReserveTokens(bucket, "req_dup", 100000, 5000)
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set("client_info", &model.ClientInfo{KeyID: "key_tpm", Limits: model.KeyLimits{TPM: 100000}})
+		h.ChatCompletions(c)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("X-Request-ID", "req_dup")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if used := limiter.TokensUsed(bucket); used != 5006 {
+		t.Errorf("expected the other reservation to stay intact, got %d tokens in use", used)
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// Responses OpenAI Responses API 的 /v1/responses 入口
+// 请求转换为聊天请求走统一的路由/failover，响应按 Responses 格式返回；选中的源原生支持 responses 时原样透传
+// 带 previous_response_id 的请求依赖上游保存的会话状态，只路由到原生支持 responses 的源
+func (h *ProxyHandler) Responses(c *gin.Context) {
+	encoder := newResponsesEncoder(h.translator)
+	c.Set(ClientEncoderKey, encoder)
+
+	var req model.ResponsesRequest
+	body, err := c.GetRawData()
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	var chatReq *model.ChatCompletionRequest
+	if err == nil {
+		chatReq, err = h.translator.FromResponsesRequest(&req)
+	}
+	if err == nil && req.PreviousResponseID != "" && !h.hasNativeSource(model.EndpointResponses) {
+		err = core.ErrStatefulResponse
+	}
+	if err != nil {
+		encoder.writeError(c, 400, model.ErrorDetail{
+			Message: "Invalid request: " + err.Error(),
+			Type:    "invalid_request_error",
+		})
+		return
+	}
+
+	c.Set(endpointKey, model.EndpointResponses)
+	c.Set(nativeRequestKey, &nativeRequest{endpoint: model.EndpointResponses, body: body, nativeOnly: req.PreviousResponseID != ""})
+	h.proxyChatCompletion(c, chatReq)
+}
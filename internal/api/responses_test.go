@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestResponses_StreamTranslatesChatChunks(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":1,\"total_tokens\":5}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL})
+
+	w := performRequest(h.Responses, "/v1/responses", `{"model":"gpt-4o","input":"hi","stream":true}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, ev := range []string{"event: response.created", "event: response.output_text.delta", "event: response.completed"} {
+		if !strings.Contains(body, ev) {
+			t.Errorf("expected %q in stream, got:\n%s", ev, body)
+		}
+	}
+	if strings.Contains(body, "[DONE]") {
+		t.Error("responses stream must not contain [DONE]")
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].Endpoint != model.EndpointResponses || logs[0].TotalTokens != 5 {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+func TestResponses_NativePassthrough(t *testing.T) {
+	var upstreamPath string
+	var upstreamReq map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"resp_1","object":"response","status":"completed","model":"gpt-4.1","output":[{"type":"message","id":"msg_1","status":"completed","role":"assistant","content":[{"type":"output_text","text":"Hi","annotations":[]}]}],"usage":{"input_tokens":9,"output_tokens":1,"total_tokens":10,"input_tokens_details":{"cached_tokens":4}}}`)
+	}))
+	defer upstream.Close()
+
+	h, st := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: upstream.URL,
+		Capabilities: model.Capabilities{Endpoints: []string{model.EndpointResponses}},
+		ModelMap:     []model.ModelMapping{{Match: "smart", Target: "gpt-4.1"}}})
+
+	w := performRequest(h.Responses, "/v1/responses", `{"model":"smart","input":"hi","tools":[{"type":"web_search"}],"store":false}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if upstreamPath != "/v1/responses" || upstreamReq["model"] != "gpt-4.1" || upstreamReq["store"] != false {
+		t.Errorf("expected request forwarded verbatim with upstream model, got %s %+v", upstreamPath, upstreamReq)
+	}
+	if tools, _ := upstreamReq["tools"].([]any); len(tools) != 1 {
+		t.Errorf("expected built-in tools to be passed through, got %+v", upstreamReq["tools"])
+	}
+	if !strings.Contains(w.Body.String(), `"id":"resp_1"`) {
+		t.Errorf("expected upstream response returned as-is, got %s", w.Body.String())
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].Endpoint != model.EndpointResponses || logs[0].TotalTokens != 10 || logs[0].CachedTokens != 4 {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+func TestResponses_NativeStreamFailsOverBeforeFirstToken(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: response.created\ndata: {\"type\":\"response.created\"}\n\n")
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hi\"}\n\n")
+		fmt.Fprint(w, "event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"status\":\"completed\",\"usage\":{\"input_tokens\":3,\"output_tokens\":1,\"total_tokens\":4}}}\n\n")
+	}))
+	defer healthy.Close()
+
+	native := model.Capabilities{Endpoints: []string{model.EndpointResponses}}
+	h, st := newTestProxy(t,
+		&model.Source{ID: "failing", Name: "failing", Type: model.SourceTypeOpenAI, BaseURL: failing.URL, Capabilities: native},
+		&model.Source{ID: "healthy", Name: "healthy", Type: model.SourceTypeOpenAI, BaseURL: healthy.URL, Capabilities: native})
+
+	w := performRequest(h.Responses, "/v1/responses", `{"model":"gpt-4.1","input":"hi","stream":true}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "response.created") || !strings.Contains(body, "event: response.completed") {
+		t.Errorf("expected only the healthy source's events, got:\n%s", body)
+	}
+
+	logs, _ := st.QueryLogs(&model.LogQuery{Limit: 10})
+	if len(logs) != 1 || logs[0].SourceID != "healthy" || logs[0].FailoverFrom != "failing" || logs[0].TotalTokens != 4 || !logs[0].Success {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+func TestResponses_PreviousResponseIDRoutesToNativeSource(t *testing.T) {
+	chatCalls := 0
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chatCalls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c1","model":"gpt-4.1","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer chat.Close()
+	var upstreamReq map[string]any
+	native := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"resp_2","object":"response","status":"completed","model":"gpt-4.1","output":[],"usage":{"input_tokens":3,"output_tokens":1,"total_tokens":4}}`)
+	}))
+	defer native.Close()
+
+	h, _ := newTestProxy(t,
+		&model.Source{ID: "chat", Name: "chat", Type: model.SourceTypeOpenAI, BaseURL: chat.URL, Priority: 1},
+		&model.Source{ID: "native", Name: "native", Type: model.SourceTypeOpenAI, BaseURL: native.URL, Priority: 2,
+			Capabilities: model.Capabilities{Endpoints: []string{model.EndpointResponses}}})
+
+	w := performRequest(h.Responses, "/v1/responses", `{"model":"gpt-4.1","input":"and then?","previous_response_id":"resp_1"}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if chatCalls != 0 || upstreamReq["previous_response_id"] != "resp_1" {
+		t.Errorf("expected request forwarded unchanged to the native source only, chat calls %d, got %+v", chatCalls, upstreamReq)
+	}
+}
+
+func TestResponses_PreviousResponseIDWithoutNativeSource(t *testing.T) {
+	h, _ := newTestProxy(t, &model.Source{Name: "openai", Type: model.SourceTypeOpenAI, BaseURL: "http://127.0.0.1:1"})
+
+	w := performRequest(h.Responses, "/v1/responses", `{"model":"gpt-4.1","input":"hi","previous_response_id":"resp_1"}`, nil)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "previous_response_id") {
+		t.Errorf("expected 400 for previous_response_id, got %d: %s", w.Code, w.Body.String())
+	}
+}
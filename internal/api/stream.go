@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/core"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// upstreamStream 上游流式响应
+// 负责发送请求、首 token 超时控制，并将上游 SSE 转换为 OpenAI chunk 数据帧
+type upstreamStream struct {
+	resp      *http.Response
+	reader    *bufio.Reader
+	converter *core.StreamConverter
+	cancel    context.CancelFunc
+	timer     *time.Timer
+	timeout   time.Duration
+	timedOut  atomic.Bool
+}
+
+// openStream 发起流式请求；返回错误时已更新源状态（parent 被取消时不归咎于源），调用方可直接 failover
+func (h *ProxyHandler) openStream(parent context.Context, req *model.ChatCompletionRequest, src *model.Source, startTime time.Time) (*upstreamStream, error) {
+	// 构建请求
+	path, body, err := h.translator.EncodeRequest(req, src)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] encode request: %w", src.Name, err)
+	}
+
+	s, err := h.openUpstream(parent, path, body, src, startTime)
+	if err != nil {
+		return nil, err
+	}
+	s.converter = h.translator.NewStreamConverter(src)
+	return s, nil
+}
+
+// openUpstream 向源发送已编码的流式请求并检查状态码，错误处理同 openStream
+func (h *ProxyHandler) openUpstream(parent context.Context, path string, body []byte, src *model.Source, startTime time.Time) (*upstreamStream, error) {
+	// 首 token 超时通过取消上游请求实现
+	ctx, cancel := context.WithCancel(parent)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", src.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("[%s] build request: %w", src.Name, err)
+	}
+
+	h.setHeaders(httpReq, src)
+
+	s := &upstreamStream{
+		cancel:  cancel,
+		timeout: time.Duration(h.cfg.Routing.Failover.FirstTokenTimeout) * time.Second,
+	}
+	if s.timeout > 0 {
+		s.timer = time.AfterFunc(s.timeout, func() {
+			s.timedOut.Store(true)
+			cancel()
+		})
+	}
+
+	// 发送请求
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		err = s.wrapErr(err)
+		s.close()
+		if parent.Err() == nil {
+			h.updateSourceLatency(src, time.Since(startTime), err)
+		}
+		return nil, fmt.Errorf("[%s] %w", src.Name, err)
+	}
+	s.resp = resp
+
+	// 检查状态码 — 保留上游错误体
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		s.close()
+		statusErr := newUpstreamStatusError(resp, errBody)
+		h.updateSourceLatency(src, time.Since(startTime), statusErr)
+		return nil, fmt.Errorf("[%s] %w", src.Name, statusErr)
+	}
+
+	s.reader = bufio.NewReader(resp.Body)
+	return s, nil
+}
+
+// next 读取下一条 SSE data 并转换为 OpenAI chunk 数据帧
+// 上游正常关闭连接时返回 io.EOF
+func (s *upstreamStream) next() ([]string, bool, error) {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil, false, io.EOF
+			}
+			return nil, false, s.wrapErr(err)
+		}
+
+		// 解析 SSE 数据（event: 行由 data 中的 type 字段代替）
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		return s.converter.Convert(strings.TrimPrefix(line, "data: "))
+	}
+}
+
+// firstToken 已收到首个内容增量，停止首 token 计时
+func (s *upstreamStream) firstToken() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// wrapErr 将首 token 超时导致的取消错误转换为可读错误
+func (s *upstreamStream) wrapErr(err error) error {
+	if s.timedOut.Load() {
+		return fmt.Errorf("first token timeout after %s", s.timeout)
+	}
+	return err
+}
+
+// close 释放上游连接
+func (s *upstreamStream) close() {
+	s.firstToken()
+	s.cancel()
+	if s.resp != nil {
+		s.resp.Body.Close()
+	}
+}
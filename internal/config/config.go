@@ -3,6 +3,7 @@ package config
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -18,7 +19,14 @@ type Config struct {
 	HealthCheck HealthCheckConfig `yaml:"health_check"`
 	Routing     RoutingConfig     `yaml:"routing"`
 	Logging     LoggingConfig     `yaml:"logging"`
-	Sources     []model.Source    `yaml:"sources"`
+	FCCompat    FCCompatConfig    `yaml:"fc_compat"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Cache       CacheConfig       `yaml:"response_cache"`
+
+	// 全局模型映射：客户端模型名/别名 -> 路由使用的模型名
+	ModelMappings []model.ModelMapping `yaml:"model_mappings"`
+
+	Sources []model.Source `yaml:"sources"`
 }
 
 // ServerConfig 服务器配置
@@ -44,14 +52,86 @@ type HealthCheckConfig struct {
 
 // RoutingConfig 路由配置
 type RoutingConfig struct {
-	Strategy string         `yaml:"strategy"` // priority | round-robin | weighted | least-latency
-	Failover FailoverConfig `yaml:"failover"`
+	Strategy       string               `yaml:"strategy"` // priority | round-robin | weighted | least-latency | least-cost
+	Failover       FailoverConfig       `yaml:"failover"`
+	QueueTimeout   int                  `yaml:"queue_timeout"` // 毫秒，所有源因 RPM/TPM/并发限制暂时饱和时排队等待的最长时间，负数表示不排队
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Hedge          HedgeConfig          `yaml:"hedge"`
+	Affinity       AffinityConfig       `yaml:"affinity"`
+}
+
+// AffinityConfig 粘性路由配置：亲和键相同的请求在源保持可用期间固定路由到同一个源，0 表示使用默认值
+type AffinityConfig struct {
+	Session        []string `yaml:"session"`         // 会话亲和键来源，按顺序取第一个存在的：header（X-Session-ID 请求头）| user（请求的 user 字段）| key（API Key）
+	Prefix         bool     `yaml:"prefix"`          // 按 prompt 稳定前缀（system + 前几轮）固定源，提高上游 prompt 缓存命中率
+	PrefixMessages int      `yaml:"prefix_messages"` // 前缀包含的非 system 消息数，默认 1（首条用户消息）
+	TTL            int      `yaml:"ttl"`             // 秒，亲和条目多久未使用后过期，默认 600
+	MaxEntries     int      `yaml:"max_entries"`     // 亲和表容量，默认 10000
+}
+
+// Validate 校验粘性路由配置
+func (c *AffinityConfig) Validate() error {
+	for _, source := range c.Session {
+		switch source {
+		case "header", "user", "key":
+		default:
+			return fmt.Errorf("unknown routing.affinity.session source %q", source)
+		}
+	}
+	if c.PrefixMessages < 0 || c.TTL < 0 || c.MaxEntries < 0 {
+		return fmt.Errorf("routing.affinity values must not be negative")
+	}
+	return nil
+}
+
+// HedgeConfig 对冲请求配置：首个源在 delay 内未产出首个 token 时，向下一个候选源发出同一请求，采用先响应的一方
+type HedgeConfig struct {
+	Delay int      `yaml:"delay"` // 毫秒，0 表示关闭
+	Tools []string `yaml:"tools"` // 只对这些客户端工具启用（如 cursor），留空表示所有请求
+}
+
+// Enabled 判断来自指定客户端工具的请求是否启用对冲
+func (c *HedgeConfig) Enabled(tool string) bool {
+	if c.Delay <= 0 {
+		return false
+	}
+	if len(c.Tools) == 0 {
+		return true
+	}
+	for _, t := range c.Tools {
+		if strings.EqualFold(t, tool) {
+			return true
+		}
+	}
+	return false
+}
+
+// CircuitBreakerConfig 源熔断配置（按真实请求的错误率熔断），0 表示使用默认值
+type CircuitBreakerConfig struct {
+	Window            int `yaml:"window"`              // 秒，统计错误率的滑动窗口，默认 60
+	MinRequests       int `yaml:"min_requests"`        // 窗口内请求数达到该值才判断错误率，默认 10
+	ErrorRate         int `yaml:"error_rate"`          // 百分比，窗口内错误率达到该值时熔断，默认 50
+	OpenTimeout       int `yaml:"open_timeout"`        // 秒，熔断后多久进入半开，默认 30
+	HalfOpenRatio     int `yaml:"half_open_ratio"`     // 百分比，半开且无在途试探请求时放行的请求比例，默认 10
+	HalfOpenSuccesses int `yaml:"half_open_successes"` // 半开时连续成功多少次后恢复，默认 3
+}
+
+// Validate 校验熔断配置
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.Window < 0 || c.MinRequests < 0 || c.OpenTimeout < 0 || c.HalfOpenSuccesses < 0 {
+		return fmt.Errorf("routing.circuit_breaker values must not be negative")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 100 || c.HalfOpenRatio < 0 || c.HalfOpenRatio > 100 {
+		return fmt.Errorf("routing.circuit_breaker.error_rate and half_open_ratio must be between 0 and 100")
+	}
+	return nil
 }
 
 // FailoverConfig 故障转移配置
 type FailoverConfig struct {
-	Enabled    bool `yaml:"enabled"`
-	MaxRetries int  `yaml:"max_retries"`
+	Enabled           bool `yaml:"enabled"`
+	MaxRetries        int  `yaml:"max_retries"`
+	FirstTokenTimeout int  `yaml:"first_token_timeout"` // 秒，流式请求等待首个内容增量的超时，超时后 failover
 }
 
 // LoggingConfig 日志配置
@@ -60,6 +140,36 @@ type LoggingConfig struct {
 	RetentionDays int    `yaml:"retention_days"`
 }
 
+// FCCompatConfig 函数调用兼容层配置
+type FCCompatConfig struct {
+	MaxRepairAttempts int `yaml:"max_repair_attempts"` // 工具参数校验失败时重新提示同一个源的最大次数，0 表示不修复，未配置时为 2
+}
+
+// RateLimitConfig 频率限制后端配置
+type RateLimitConfig struct {
+	Backend string      `yaml:"backend"` // memory | redis（多实例部署共享计数）
+	Redis   RedisConfig `yaml:"redis"`
+}
+
+// RedisConfig Redis 连接配置（兼容 Redis 协议的服务均可）
+type RedisConfig struct {
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
+	Timeout   int    `yaml:"timeout"` // 毫秒，单条命令超时
+}
+
+// CacheConfig 响应缓存配置（只缓存确定性请求：temperature=0）
+type CacheConfig struct {
+	Enabled         bool   `yaml:"enabled"`           // 默认是否启用，API Key 可单独开启或关闭
+	Backend         string `yaml:"backend"`           // memory（进程内 LRU）| sqlite（与日志同库，重启后保留）
+	TTL             int    `yaml:"ttl"`               // 秒，缓存条目有效期
+	MaxEntries      int    `yaml:"max_entries"`       // 最多缓存的响应数，超过时淘汰最久未命中的条目
+	MaxEntrySize    int    `yaml:"max_entry_size"`    // 字节，超过该大小的响应不缓存
+	ShareAcrossKeys bool   `yaml:"share_across_keys"` // 不同 API Key 共用缓存条目；默认关闭，每个 Key 只命中自己写入的缓存
+}
+
 var (
 	globalConfig *Config
 	configMu     sync.RWMutex
@@ -72,7 +182,8 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	cfg := &Config{}
+	// 0 是有效值（关闭修复），先以 -1 标记未配置
+	cfg := &Config{FCCompat: FCCompatConfig{MaxRepairAttempts: -1}}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, err
 	}
@@ -80,6 +191,45 @@ func Load(path string) (*Config, error) {
 	// 设置默认值
 	setDefaults(cfg)
 
+	if err := model.ValidateModelMappings(cfg.ModelMappings); err != nil {
+		return nil, err
+	}
+	if err := cfg.Routing.CircuitBreaker.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Routing.Affinity.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.RateLimit.Backend {
+	case "memory":
+	case "redis":
+		if cfg.RateLimit.Redis.Addr == "" {
+			return nil, fmt.Errorf("rate_limit.redis.addr is required for the redis backend")
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate_limit.backend %q", cfg.RateLimit.Backend)
+	}
+	switch cfg.Cache.Backend {
+	case "memory", "sqlite":
+	default:
+		return nil, fmt.Errorf("unknown response_cache.backend %q", cfg.Cache.Backend)
+	}
+	for i := range cfg.Sources {
+		src := &cfg.Sources[i]
+		if err := model.ValidateModelMappings(src.ModelMap); err != nil {
+			return nil, fmt.Errorf("source %s: %w", src.Name, err)
+		}
+		if err := model.ValidatePricing(src.Pricing); err != nil {
+			return nil, fmt.Errorf("source %s: %w", src.Name, err)
+		}
+		if err := src.Limits.Validate(); err != nil {
+			return nil, fmt.Errorf("source %s: %w", src.Name, err)
+		}
+		if err := src.Capabilities.Validate(); err != nil {
+			return nil, fmt.Errorf("source %s: %w", src.Name, err)
+		}
+	}
+
 	// 支持通过 "auto" 自动生成 API Key（首次加载后落盘）
 	if maybeGenerateKeys(cfg) {
 		if err := Save(path, cfg); err != nil {
@@ -150,12 +300,42 @@ func setDefaults(cfg *Config) {
 	if cfg.Routing.Failover.MaxRetries == 0 {
 		cfg.Routing.Failover.MaxRetries = 2
 	}
+	if cfg.Routing.Failover.FirstTokenTimeout == 0 {
+		cfg.Routing.Failover.FirstTokenTimeout = 30
+	}
+	if cfg.Routing.QueueTimeout == 0 {
+		cfg.Routing.QueueTimeout = 5000
+	}
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
 	if cfg.Logging.RetentionDays == 0 {
 		cfg.Logging.RetentionDays = 7
 	}
+	if cfg.FCCompat.MaxRepairAttempts < 0 {
+		cfg.FCCompat.MaxRepairAttempts = 2
+	}
+	if cfg.RateLimit.Backend == "" {
+		cfg.RateLimit.Backend = "memory"
+	}
+	if cfg.RateLimit.Redis.KeyPrefix == "" {
+		cfg.RateLimit.Redis.KeyPrefix = "fusionapi:"
+	}
+	if cfg.RateLimit.Redis.Timeout == 0 {
+		cfg.RateLimit.Redis.Timeout = 500
+	}
+	if cfg.Cache.Backend == "" {
+		cfg.Cache.Backend = "memory"
+	}
+	if cfg.Cache.TTL == 0 {
+		cfg.Cache.TTL = 3600
+	}
+	if cfg.Cache.MaxEntries == 0 {
+		cfg.Cache.MaxEntries = 1000
+	}
+	if cfg.Cache.MaxEntrySize == 0 {
+		cfg.Cache.MaxEntrySize = 1 << 20
+	}
 }
 
 // Save 保存配置到文件
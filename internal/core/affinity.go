@@ -0,0 +1,110 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/config"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// affinityTable 粘性路由表：亲和键 -> 源 ID，条目在 TTL 内未被使用即过期
+type affinityTable struct {
+	cfg *config.AffinityConfig
+
+	mu      sync.Mutex
+	entries map[string]affinityEntry
+	now     func() time.Time
+}
+
+type affinityEntry struct {
+	sourceID string
+	expires  time.Time
+}
+
+func newAffinityTable(cfg *config.AffinityConfig) *affinityTable {
+	return &affinityTable{
+		cfg:     cfg,
+		entries: make(map[string]affinityEntry),
+		now:     time.Now,
+	}
+}
+
+// 配置项（0 表示默认值）
+func (t *affinityTable) ttl() time.Duration {
+	return time.Duration(orDefault(t.cfg.TTL, 600)) * time.Second
+}
+
+// get 返回亲和键固定的源，并延长条目有效期
+func (t *affinityTable) get(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return "", false
+	}
+	now := t.now()
+	if !now.Before(e.expires) {
+		delete(t.entries, key)
+		return "", false
+	}
+	e.expires = now.Add(t.ttl())
+	t.entries[key] = e
+	return e.sourceID, true
+}
+
+// set 将亲和键固定到源；超过容量时先清理过期条目，仍超出则淘汰最早过期的条目
+func (t *affinityTable) set(key, sourceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.entries[key] = affinityEntry{sourceID: sourceID, expires: now.Add(t.ttl())}
+
+	max := orDefault(t.cfg.MaxEntries, 10000)
+	if len(t.entries) <= max {
+		return
+	}
+	for k, e := range t.entries {
+		if !now.Before(e.expires) {
+			delete(t.entries, k)
+		}
+	}
+	for len(t.entries) > max {
+		var oldest string
+		var oldestAt time.Time
+		for k, e := range t.entries {
+			if oldest == "" || e.expires.Before(oldestAt) {
+				oldest, oldestAt = k, e.expires
+			}
+		}
+		delete(t.entries, oldest)
+	}
+}
+
+// PromptPrefixKey 请求稳定前缀的哈希：模型、工具定义、开头的 system 消息与其后的前 n 条消息
+// 同一对话的后续请求只在末尾追加消息，前缀哈希保持不变
+func PromptPrefixKey(req *model.ChatCompletionRequest, n int) string {
+	var prefix []model.Message
+	for _, msg := range req.Messages {
+		if msg.Role != "system" && msg.Role != "developer" {
+			if n <= 0 {
+				break
+			}
+			n--
+		}
+		prefix = append(prefix, msg)
+	}
+
+	data, _ := json.Marshal(struct {
+		Model    string          `json:"model"`
+		Tools    []model.Tool    `json:"tools,omitempty"`
+		Messages []model.Message `json:"messages"`
+	}{req.Model, req.Tools, prefix})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,516 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// 入口协议为 Anthropic Messages API 时的转换：
+// 请求 Anthropic -> OpenAI（走统一路由/failover），响应 OpenAI -> Anthropic
+
+// FromAnthropicRequest 将 Anthropic Messages 请求转换为内部 ChatCompletionRequest
+func (t *Translator) FromAnthropicRequest(req *model.AnthropicRequest) *model.ChatCompletionRequest {
+	out := &model.ChatCompletionRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+		Thinking:    req.Thinking,
+	}
+	if req.Stream {
+		// Anthropic 流在 message_delta 中返回 usage，需要上游携带
+		out.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if req.MaxTokens > 0 {
+		maxTokens := req.MaxTokens
+		out.MaxTokens = &maxTokens
+	}
+	if len(req.StopSequences) > 0 {
+		out.Stop, _ = json.Marshal(req.StopSequences)
+	}
+	if req.Metadata != nil {
+		out.User = req.Metadata.UserID
+	}
+
+	if system := fromAnthropicText(req.System); system != nil && system != "" {
+		out.Messages = append(out.Messages, model.Message{Role: "system", Content: system})
+	}
+	for _, msg := range req.Messages {
+		out.Messages = append(out.Messages, fromAnthropicMessage(msg)...)
+	}
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+			CacheControl: tool.CacheControl,
+		})
+	}
+	if len(out.Tools) > 0 && req.ToolChoice != nil {
+		out.ToolChoice = fromAnthropicToolChoice(req.ToolChoice)
+	}
+
+	return out
+}
+
+// fromAnthropicMessage 将一条 Anthropic 消息拆分为 OpenAI 消息
+// user 消息中的 tool_result 块各自转换为一条 tool 消息
+func fromAnthropicMessage(msg model.AnthropicMessage) []model.Message {
+	if msg.Role == "assistant" {
+		out := model.Message{Role: "assistant"}
+		var reasoning strings.Builder
+		for _, block := range msg.Content {
+			switch block.Type {
+			case "thinking":
+				reasoning.WriteString(block.Thinking)
+				out.ThinkingBlocks = append(out.ThinkingBlocks, model.ThinkingBlock{Type: "thinking", Thinking: block.Thinking, Signature: block.Signature})
+			case "redacted_thinking":
+				out.ThinkingBlocks = append(out.ThinkingBlocks, model.ThinkingBlock{Type: "redacted_thinking", Data: block.Data})
+			case "tool_use":
+				args := string(block.Input)
+				if args == "" {
+					args = "{}"
+				}
+				out.ToolCalls = append(out.ToolCalls, model.ToolCall{
+					ID:       block.ID,
+					Type:     "function",
+					Function: model.FunctionCall{Name: block.Name, Arguments: args},
+				})
+			}
+		}
+		out.Content = fromAnthropicText(msg.Content)
+		if out.Content == nil {
+			out.Content = ""
+		}
+		out.ReasoningContent = reasoning.String()
+		return []model.Message{out}
+	}
+
+	var messages []model.Message
+	var blocks model.AnthropicContent
+	for _, block := range msg.Content {
+		if block.Type == "tool_result" {
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				ToolCallID: block.ToolUseID,
+				Content:    fromAnthropicToolResult(block),
+			})
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+
+	if content := fromAnthropicContent(blocks); content != nil {
+		messages = append(messages, model.Message{Role: "user", Content: content})
+	}
+	return messages
+}
+
+// fromAnthropicToolResult 转换 tool_result 内容；块级缓存断点放到最后一个内容部分上
+func fromAnthropicToolResult(block model.AnthropicContentBlock) any {
+	content := append(model.AnthropicContent(nil), block.Content...)
+	if block.IsError {
+		if len(content) > 0 && content[0].Type == "text" {
+			content[0].Text = "Error: " + content[0].Text
+		} else {
+			content = append(model.AnthropicContent{{Type: "text", Text: "Error"}}, content...)
+		}
+	}
+	if block.CacheControl != nil && len(content) > 0 {
+		content[len(content)-1].CacheControl = block.CacheControl
+	}
+	if parts := fromAnthropicContent(content); parts != nil {
+		return parts
+	}
+	return ""
+}
+
+// fromAnthropicText 转换文本内容块：没有缓存断点时拼接为字符串，否则保留为多模态数组；没有文本时返回 nil
+func fromAnthropicText(blocks model.AnthropicContent) any {
+	var text model.AnthropicContent
+	for _, block := range blocks {
+		if block.Type == "text" {
+			text = append(text, block)
+		}
+	}
+	return fromAnthropicContent(text)
+}
+
+// fromAnthropicContent 转换 text / image 内容块：纯文本且没有缓存断点时拼接为字符串，
+// 含图片或缓存断点时为多模态数组；没有可转换的内容时返回 nil
+func fromAnthropicContent(blocks model.AnthropicContent) any {
+	var parts []any
+	var texts []string
+	keepParts := false
+	for _, block := range blocks {
+		var part map[string]any
+		switch block.Type {
+		case "text":
+			part = map[string]any{"type": "text", "text": block.Text}
+			texts = append(texts, block.Text)
+		case "image":
+			url := anthropicImageToURL(block.Source)
+			if url == "" {
+				continue
+			}
+			part = map[string]any{"type": "image_url", "image_url": map[string]any{"url": url}}
+			keepParts = true
+		default:
+			continue
+		}
+		if block.CacheControl != nil {
+			part["cache_control"] = block.CacheControl
+			keepParts = true
+		}
+		parts = append(parts, part)
+	}
+
+	switch {
+	case len(parts) == 0:
+		return nil
+	case keepParts:
+		return parts
+	default:
+		return strings.Join(texts, "\n")
+	}
+}
+
+// anthropicImageToURL 将图片来源转换为 URL（base64 使用 data URL）
+func anthropicImageToURL(src *model.AnthropicImageSource) string {
+	if src == nil {
+		return ""
+	}
+	if src.Type == "base64" {
+		return "data:" + src.MediaType + ";base64," + src.Data
+	}
+	return src.URL
+}
+
+// fromAnthropicToolChoice 转换 tool_choice
+func fromAnthropicToolChoice(choice *model.AnthropicToolChoice) any {
+	switch choice.Type {
+	case "none":
+		return "none"
+	case "any":
+		return "required"
+	case "tool":
+		return map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": choice.Name},
+		}
+	default:
+		return "auto"
+	}
+}
+
+// ToAnthropicResponse 将 OpenAI 响应转换为 Anthropic Messages 响应
+func (t *Translator) ToAnthropicResponse(resp *model.ChatCompletionResponse) *model.AnthropicResponse {
+	out := &model.AnthropicResponse{
+		ID:      resp.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   resp.Model,
+		Content: model.AnthropicContent{},
+	}
+	if resp.Usage != nil {
+		out.Usage = toAnthropicUsage(resp.Usage)
+	}
+	if len(resp.Choices) == 0 {
+		out.StopReason = "end_turn"
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if choice.Message != nil {
+		out.Content = append(out.Content, anthropicThinkingContent(choice.Message)...)
+		if text := contentText(choice.Message.Content); text != "" {
+			out.Content = append(out.Content, model.AnthropicContentBlock{Type: "text", Text: text})
+		}
+		for _, tc := range choice.Message.ToolCalls {
+			out.Content = append(out.Content, model.AnthropicContentBlock{
+				Type:  "tool_use",
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: toolArgumentsToInput(tc.Function.Arguments),
+			})
+		}
+	}
+	out.StopReason = finishToAnthropicStopReason(choice.FinishReason)
+	return out
+}
+
+// anthropicThinkingContent 将思考过程转换为 thinking / redacted_thinking 块（位于其他内容块之前）
+// 来自 Anthropic 源的思考块带签名，客户端在后续工具调用轮次原样回传；其他源只有思考文本
+func anthropicThinkingContent(msg *model.Message) model.AnthropicContent {
+	var blocks model.AnthropicContent
+	for _, tb := range msg.ThinkingBlocks {
+		if tb.Type == "redacted_thinking" {
+			blocks = append(blocks, model.AnthropicContentBlock{Type: "redacted_thinking", Data: tb.Data})
+			continue
+		}
+		blocks = append(blocks, model.AnthropicContentBlock{Type: "thinking", Thinking: tb.Thinking, Signature: tb.Signature})
+	}
+	if len(blocks) == 0 && msg.ReasoningContent != "" {
+		blocks = append(blocks, model.AnthropicContentBlock{Type: "thinking", Thinking: msg.ReasoningContent})
+	}
+	return blocks
+}
+
+// toAnthropicUsage 映射 usage：Anthropic 的 input_tokens 不含缓存命中/写入部分
+func toAnthropicUsage(u *model.Usage) model.AnthropicUsage {
+	cached, created := u.CachedTokens(), u.CacheCreationTokens()
+	return model.AnthropicUsage{
+		InputTokens:              u.PromptTokens - cached - created,
+		OutputTokens:             u.CompletionTokens,
+		CacheReadInputTokens:     cached,
+		CacheCreationInputTokens: created,
+	}
+}
+
+// finishToAnthropicStopReason 映射 finish_reason 到 stop_reason
+func finishToAnthropicStopReason(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// AnthropicSSEEvent Anthropic 流式事件（event 名 + data）
+type AnthropicSSEEvent struct {
+	Event string
+	Data  []byte
+}
+
+// AnthropicStreamEncoder 将 OpenAI 流式块编码为 Anthropic 事件流
+type AnthropicStreamEncoder struct {
+	started    bool
+	finished   bool
+	id         string
+	model      string
+	blockIndex int            // 下一个内容块序号
+	openBlock  string         // 当前打开的块类型：thinking | text | tool_use | ""
+	toolBlocks map[int]int    // tool_calls index -> 内容块序号
+	toolIDs    map[int]string // tool_calls index -> tool call ID
+	signed     bool           // 当前 thinking 块已收到签名，之后的思考增量属于新的块
+	stopReason string
+	usage      model.AnthropicUsage
+}
+
+// NewAnthropicStreamEncoder 创建 Anthropic 流式编码器
+func (t *Translator) NewAnthropicStreamEncoder() *AnthropicStreamEncoder {
+	return &AnthropicStreamEncoder{
+		toolBlocks: make(map[int]int),
+		toolIDs:    make(map[int]string),
+	}
+}
+
+// Encode 编码一帧 OpenAI data（chunk JSON 或 [DONE]）
+func (e *AnthropicStreamEncoder) Encode(data string) []AnthropicSSEEvent {
+	if e.finished {
+		return nil
+	}
+	if data == "[DONE]" {
+		return e.Finish()
+	}
+
+	var chunk model.StreamChunk
+	if json.Unmarshal([]byte(data), &chunk) != nil {
+		return nil
+	}
+
+	if chunk.Usage != nil {
+		e.usage = toAnthropicUsage(chunk.Usage)
+	}
+
+	var events []AnthropicSSEEvent
+	if !e.started {
+		e.id = chunk.ID
+		e.model = chunk.Model
+		events = append(events, e.messageStart())
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Delta != nil {
+			events = append(events, e.encodeThinking(choice.Delta)...)
+			if text, ok := choice.Delta.Content.(string); ok && text != "" {
+				if e.openBlock != "text" {
+					events = append(events, e.closeBlock()...)
+					events = append(events, e.event("content_block_start", map[string]any{
+						"index":         e.blockIndex,
+						"content_block": map[string]any{"type": "text", "text": ""},
+					}))
+					e.openBlock = "text"
+				}
+				events = append(events, e.event("content_block_delta", map[string]any{
+					"index": e.blockIndex,
+					"delta": map[string]any{"type": "text_delta", "text": text},
+				}))
+			}
+
+			for _, tc := range choice.Delta.ToolCalls {
+				toolIdx := 0
+				if tc.Index != nil {
+					toolIdx = *tc.Index
+				}
+				if _, known := e.toolBlocks[toolIdx]; !known || (tc.ID != "" && tc.ID != e.toolIDs[toolIdx]) {
+					events = append(events, e.closeBlock()...)
+					e.toolBlocks[toolIdx] = e.blockIndex
+					e.toolIDs[toolIdx] = tc.ID
+					events = append(events, e.event("content_block_start", map[string]any{
+						"index": e.blockIndex,
+						"content_block": map[string]any{
+							"type":  "tool_use",
+							"id":    tc.ID,
+							"name":  tc.Function.Name,
+							"input": map[string]any{},
+						},
+					}))
+					e.openBlock = "tool_use"
+				}
+				if tc.Function.Arguments != "" {
+					events = append(events, e.event("content_block_delta", map[string]any{
+						"index": e.toolBlocks[toolIdx],
+						"delta": map[string]any{"type": "input_json_delta", "partial_json": tc.Function.Arguments},
+					}))
+				}
+			}
+		}
+		if choice.FinishReason != "" {
+			e.stopReason = finishToAnthropicStopReason(choice.FinishReason)
+		}
+	}
+
+	return events
+}
+
+// Finish 结束事件流（关闭打开的块并发送 message_delta/message_stop）
+func (e *AnthropicStreamEncoder) Finish() []AnthropicSSEEvent {
+	if e.finished {
+		return nil
+	}
+	var events []AnthropicSSEEvent
+	if !e.started {
+		events = append(events, e.messageStart())
+	}
+	events = append(events, e.closeBlock()...)
+	stopReason := e.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	events = append(events,
+		e.event("message_delta", map[string]any{
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": e.usage,
+		}),
+		e.event("message_stop", map[string]any{}),
+	)
+	e.finished = true
+	return events
+}
+
+// Abort 上游流中途中断时结束事件流：发送 error 事件而不是 message_stop，客户端据此知道回答不完整
+func (e *AnthropicStreamEncoder) Abort(errType, message string) []AnthropicSSEEvent {
+	if e.finished {
+		return nil
+	}
+	e.finished = true
+	return []AnthropicSSEEvent{e.event("error", map[string]any{
+		"error": map[string]any{"type": errType, "message": message},
+	})}
+}
+
+// messageStart 生成 message_start 事件
+func (e *AnthropicStreamEncoder) messageStart() AnthropicSSEEvent {
+	e.started = true
+	usage := e.usage
+	usage.OutputTokens = 0
+	return e.event("message_start", map[string]any{
+		"message": map[string]any{
+			"id":            e.id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         e.model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         usage,
+		},
+	})
+}
+
+// encodeThinking 编码思考增量：reasoning_content 为 thinking_delta，签名为 signature_delta，
+// redacted_thinking 作为一个完整的内容块输出
+func (e *AnthropicStreamEncoder) encodeThinking(delta *model.Message) []AnthropicSSEEvent {
+	var events []AnthropicSSEEvent
+	if delta.ReasoningContent != "" {
+		events = append(events, e.openThinking()...)
+		events = append(events, e.event("content_block_delta", map[string]any{
+			"index": e.blockIndex,
+			"delta": map[string]any{"type": "thinking_delta", "thinking": delta.ReasoningContent},
+		}))
+	}
+	for _, tb := range delta.ThinkingBlocks {
+		switch {
+		case tb.Type == "redacted_thinking":
+			events = append(events, e.closeBlock()...)
+			events = append(events, e.event("content_block_start", map[string]any{
+				"index":         e.blockIndex,
+				"content_block": map[string]any{"type": "redacted_thinking", "data": tb.Data},
+			}))
+			e.openBlock = "redacted_thinking"
+			events = append(events, e.closeBlock()...)
+		case tb.Signature != "":
+			events = append(events, e.openThinking()...)
+			events = append(events, e.event("content_block_delta", map[string]any{
+				"index": e.blockIndex,
+				"delta": map[string]any{"type": "signature_delta", "signature": tb.Signature},
+			}))
+			e.signed = true
+		}
+	}
+	return events
+}
+
+// openThinking 确保当前打开的是 thinking 块
+func (e *AnthropicStreamEncoder) openThinking() []AnthropicSSEEvent {
+	if e.openBlock == "thinking" && !e.signed {
+		return nil
+	}
+	events := e.closeBlock()
+	e.openBlock = "thinking"
+	e.signed = false
+	return append(events, e.event("content_block_start", map[string]any{
+		"index":         e.blockIndex,
+		"content_block": map[string]any{"type": "thinking", "thinking": "", "signature": ""},
+	}))
+}
+
+// closeBlock 关闭当前打开的内容块
+func (e *AnthropicStreamEncoder) closeBlock() []AnthropicSSEEvent {
+	if e.openBlock == "" {
+		return nil
+	}
+	ev := e.event("content_block_stop", map[string]any{"index": e.blockIndex})
+	e.openBlock = ""
+	e.blockIndex++
+	return []AnthropicSSEEvent{ev}
+}
+
+// event 构造事件，data 中自动补充 type 字段
+func (e *AnthropicStreamEncoder) event(name string, payload map[string]any) AnthropicSSEEvent {
+	payload["type"] = name
+	b, _ := json.Marshal(payload)
+	return AnthropicSSEEvent{Event: name, Data: b}
+}
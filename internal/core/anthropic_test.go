@@ -0,0 +1,267 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestFromAnthropicRequest(t *testing.T) {
+	tr := NewTranslator()
+	var req model.AnthropicRequest
+	body := `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 1024,
+		"system": "Be brief.",
+		"stop_sequences": ["END"],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "tool", "name": "get_weather"},
+		"messages": [
+			{"role": "user", "content": "weather?"},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Checking."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"},
+				{"type": "text", "text": "and this?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]}
+		]
+	}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	out := tr.FromAnthropicRequest(&req)
+
+	if out.MaxTokens == nil || *out.MaxTokens != 1024 {
+		t.Errorf("expected max_tokens 1024, got %v", out.MaxTokens)
+	}
+	if string(out.Stop) != `["END"]` {
+		t.Errorf("unexpected stop: %s", out.Stop)
+	}
+	if len(out.Messages) != 5 {
+		t.Fatalf("expected 5 messages (system, user, assistant, tool, user), got %d", len(out.Messages))
+	}
+	if out.Messages[0].Role != "system" || out.Messages[0].Content != "Be brief." {
+		t.Errorf("unexpected system message: %+v", out.Messages[0])
+	}
+	assistant := out.Messages[2]
+	if assistant.Content != "Checking." || len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].Function.Arguments != `{"city": "Paris"}` {
+		t.Errorf("unexpected assistant message: %+v", assistant)
+	}
+	if out.Messages[3].Role != "tool" || out.Messages[3].ToolCallID != "toolu_1" || out.Messages[3].Content != "sunny" {
+		t.Errorf("unexpected tool message: %+v", out.Messages[3])
+	}
+	if !out.HasVision() {
+		t.Error("expected image part to be detected as vision")
+	}
+	if !out.HasTools() {
+		t.Error("expected tools to be converted")
+	}
+	choice, ok := out.ToolChoice.(map[string]any)
+	if !ok || choice["type"] != "function" {
+		t.Errorf("unexpected tool_choice: %+v", out.ToolChoice)
+	}
+}
+
+func TestToAnthropicResponse(t *testing.T) {
+	tr := NewTranslator()
+	resp := &model.ChatCompletionResponse{
+		ID:    "chatcmpl-1",
+		Model: "gpt-4o",
+		Choices: []model.Choice{{
+			Message: &model.Message{
+				Role:    "assistant",
+				Content: "Sure.",
+				ToolCalls: []model.ToolCall{
+					{ID: "call_1", Type: "function", Function: model.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+				},
+			},
+			FinishReason: "tool_calls",
+		}},
+		Usage: &model.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10},
+	}
+
+	out := tr.ToAnthropicResponse(resp)
+	if out.Type != "message" || out.Role != "assistant" || out.StopReason != "tool_use" {
+		t.Errorf("unexpected response header: %+v", out)
+	}
+	if len(out.Content) != 2 || out.Content[0].Text != "Sure." || out.Content[1].Type != "tool_use" || string(out.Content[1].Input) != `{"city":"Paris"}` {
+		t.Errorf("unexpected content: %+v", out.Content)
+	}
+	if out.Usage.InputTokens != 7 || out.Usage.OutputTokens != 3 {
+		t.Errorf("unexpected usage: %+v", out.Usage)
+	}
+}
+
+func TestAnthropicRoundTrip_ThinkingAndCacheControl(t *testing.T) {
+	tr := NewTranslator()
+	var req model.AnthropicRequest
+	body := `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 4096,
+		"thinking": {"type": "enabled", "budget_tokens": 2048},
+		"system": [{"type": "text", "text": "Be brief.", "cache_control": {"type": "ephemeral"}}],
+		"tools": [{"name": "screenshot", "input_schema": {"type": "object"}, "cache_control": {"type": "ephemeral"}}],
+		"messages": [
+			{"role": "user", "content": "show me"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "need a screenshot", "signature": "sig"},
+				{"type": "redacted_thinking", "data": "opaque"},
+				{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": {}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "cache_control": {"type": "ephemeral"}, "content": [
+					{"type": "text", "text": "here"},
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+				]}
+			]}
+		]
+	}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	chat := tr.FromAnthropicRequest(&req)
+	assistant := chat.Messages[2]
+	if assistant.ReasoningContent != "need a screenshot" || len(assistant.ThinkingBlocks) != 2 || assistant.ThinkingBlocks[0].Signature != "sig" {
+		t.Errorf("expected thinking blocks carried on the assistant message, got %+v", assistant)
+	}
+	if !chat.HasVision() {
+		t.Error("expected the tool result image to be kept")
+	}
+
+	out := tr.toAnthropicFormat(chat)
+	if len(out.System) != 1 || out.System[0].CacheControl == nil {
+		t.Errorf("expected system cache_control to be kept, got %+v", out.System)
+	}
+	if len(out.Tools) != 1 || out.Tools[0].CacheControl == nil {
+		t.Errorf("expected tool cache_control to be kept, got %+v", out.Tools)
+	}
+	blocks := out.Messages[1].Content
+	if len(blocks) != 3 || blocks[0].Type != "thinking" || blocks[0].Signature != "sig" || blocks[1].Data != "opaque" || blocks[2].Type != "tool_use" {
+		t.Errorf("expected signed thinking blocks before tool_use, got %+v", blocks)
+	}
+	result := out.Messages[2].Content[0]
+	if result.Type != "tool_result" || result.CacheControl == nil || len(result.Content) != 2 || result.Content[1].Type != "image" {
+		t.Errorf("expected tool_result with image and cache_control, got %+v", result)
+	}
+}
+
+func TestToAnthropicResponse_Thinking(t *testing.T) {
+	resp := &model.ChatCompletionResponse{
+		ID: "msg_1",
+		Choices: []model.Choice{{
+			Message: &model.Message{
+				Role:             "assistant",
+				Content:          "Done.",
+				ReasoningContent: "hmm",
+				ThinkingBlocks:   []model.ThinkingBlock{{Type: "thinking", Thinking: "hmm", Signature: "sig"}},
+			},
+			FinishReason: "stop",
+		}},
+		Usage: &model.Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12, PromptTokensDetails: &model.PromptTokensDetails{CachedTokens: 6}},
+	}
+
+	out := NewTranslator().ToAnthropicResponse(resp)
+	if len(out.Content) != 2 || out.Content[0].Type != "thinking" || out.Content[0].Signature != "sig" || out.Content[1].Text != "Done." {
+		t.Errorf("expected thinking block before text, got %+v", out.Content)
+	}
+	if out.Usage.InputTokens != 4 || out.Usage.CacheReadInputTokens != 6 {
+		t.Errorf("expected cached tokens reported separately, got %+v", out.Usage)
+	}
+}
+
+func TestAnthropicStreamEncoder_Thinking(t *testing.T) {
+	enc := NewTranslator().NewAnthropicStreamEncoder()
+	frames := []string{
+		`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"reasoning_content":"hmm"}}]}`,
+		`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"thinking_blocks":[{"type":"thinking","signature":"sig"}]}}]}`,
+		`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"content":"Hi"}}]}`,
+		`[DONE]`,
+	}
+
+	var names []string
+	for _, f := range frames {
+		for _, ev := range enc.Encode(f) {
+			name := ev.Event
+			if name == "content_block_delta" {
+				var payload struct {
+					Delta struct {
+						Type string `json:"type"`
+					} `json:"delta"`
+				}
+				json.Unmarshal(ev.Data, &payload)
+				name = payload.Delta.Type
+			}
+			names = append(names, name)
+		}
+	}
+
+	want := []string{
+		"message_start",
+		"content_block_start", "thinking_delta", "signature_delta", "content_block_stop",
+		"content_block_start", "text_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", names, want)
+	}
+}
+
+func TestAnthropicStreamEncoder(t *testing.T) {
+	enc := NewTranslator().NewAnthropicStreamEncoder()
+	frames := []string{
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`[DONE]`,
+	}
+
+	var names []string
+	var last []byte
+	for _, f := range frames {
+		for _, ev := range enc.Encode(f) {
+			names = append(names, ev.Event)
+			last = ev.Data
+			if ev.Event == "message_delta" && !strings.Contains(string(ev.Data), `"stop_reason":"tool_use"`) {
+				t.Errorf("expected stop_reason tool_use, got %s", ev.Data)
+			}
+		}
+	}
+
+	want := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", names, want)
+	}
+	if string(last) != `{"type":"message_stop"}` {
+		t.Errorf("unexpected message_stop payload: %s", last)
+	}
+	if events := enc.Encode(`[DONE]`); len(events) != 0 {
+		t.Error("expected no events after stream finished")
+	}
+}
+
+func TestAnthropicStreamEncoder_Abort(t *testing.T) {
+	enc := NewTranslator().NewAnthropicStreamEncoder()
+	enc.Encode(`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"}}]}`)
+
+	events := enc.Abort("api_error", "upstream stream interrupted")
+	if len(events) != 1 || events[0].Event != "error" || !strings.Contains(string(events[0].Data), `"type":"api_error"`) {
+		t.Fatalf("expected a single error event, got %+v", events)
+	}
+	if enc.Finish() != nil || enc.Abort("api_error", "again") != nil {
+		t.Error("expected no events after the stream was aborted")
+	}
+}
@@ -0,0 +1,323 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/config"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// maxBreakerTransitions 保留的最近状态转换条数
+const maxBreakerTransitions = 100
+
+// BreakerSet 按源维护熔断器
+// 熔断由真实请求的错误率驱动：窗口内错误率超过阈值即熔断（open），到期后进入半开（half_open），
+// 半开时同一时刻只放行一个试探请求（按 half_open_ratio 抽样），连续成功若干次后恢复（closed），任一失败则重新熔断
+type BreakerSet struct {
+	cfg *config.CircuitBreakerConfig
+
+	mu          sync.Mutex
+	breakers    map[string]*circuitBreaker // sourceID -> breaker
+	transitions []model.BreakerTransition
+	now         func() time.Time
+	sample      func() float64
+}
+
+// circuitBreaker 单个源的熔断器
+type circuitBreaker struct {
+	name      string
+	state     string
+	outcomes  []breakerOutcome // 窗口内的请求结果，按时间排序
+	openedAt  time.Time
+	successes int       // 半开中连续成功的试探请求数
+	probeAt   time.Time // 半开中在途试探请求的放行时间，零值表示试探名额空闲
+}
+
+type breakerOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// NewBreakerSet 创建熔断器集合，cfg 为 nil 或字段为 0 时使用默认参数
+func NewBreakerSet(cfg *config.CircuitBreakerConfig) *BreakerSet {
+	if cfg == nil {
+		cfg = &config.CircuitBreakerConfig{}
+	}
+	return &BreakerSet{
+		cfg:      cfg,
+		breakers: make(map[string]*circuitBreaker),
+		now:      time.Now,
+		sample:   rand.Float64,
+	}
+}
+
+// 配置项（0 表示默认值）
+func (b *BreakerSet) window() time.Duration {
+	return time.Duration(orDefault(b.cfg.Window, 60)) * time.Second
+}
+
+func (b *BreakerSet) openTimeout() time.Duration {
+	return time.Duration(orDefault(b.cfg.OpenTimeout, 30)) * time.Second
+}
+
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// get 获取源的熔断器，不存在时创建（调用方持有锁）
+func (b *BreakerSet) get(src *model.Source) *circuitBreaker {
+	cb, ok := b.breakers[src.ID]
+	if !ok {
+		cb = &circuitBreaker{state: model.BreakerClosed}
+		b.breakers[src.ID] = cb
+	}
+	cb.name = src.Name
+	return cb
+}
+
+// transition 切换状态并记录（调用方持有锁）
+func (b *BreakerSet) transition(sourceID string, cb *circuitBreaker, to, reason string) {
+	if cb.state == to {
+		return
+	}
+	now := b.now()
+	log.Printf("[Breaker] %s: %s -> %s (%s)", cb.name, cb.state, to, reason)
+	b.transitions = append(b.transitions, model.BreakerTransition{
+		SourceID:   sourceID,
+		SourceName: cb.name,
+		From:       cb.state,
+		To:         to,
+		Reason:     reason,
+		At:         now,
+	})
+	if len(b.transitions) > maxBreakerTransitions {
+		b.transitions = b.transitions[len(b.transitions)-maxBreakerTransitions:]
+	}
+
+	cb.state = to
+	cb.successes = 0
+	cb.probeAt = time.Time{}
+	switch to {
+	case model.BreakerOpen:
+		cb.openedAt = now
+	case model.BreakerClosed:
+		cb.outcomes = nil
+	}
+}
+
+// Allow 判断是否可以把请求路由到该源，同时返回熔断器当前状态
+// 熔断到期后转为半开；半开时试探名额空闲才按 half_open_ratio 抽样放行，放行即占用名额，
+// 由 Record 记录结果时释放，未被选中的源由调用方通过 Release 归还
+func (b *BreakerSet) Allow(src *model.Source) (bool, string) {
+	if b == nil {
+		return true, model.BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb := b.get(src)
+	if cb.state == model.BreakerOpen && b.now().Sub(cb.openedAt) >= b.openTimeout() {
+		b.transition(src.ID, cb, model.BreakerHalfOpen, "open timeout elapsed")
+	}
+	switch cb.state {
+	case model.BreakerOpen:
+		return false, cb.state
+	case model.BreakerHalfOpen:
+		if b.probing(cb) || b.sample()*100 >= float64(orDefault(b.cfg.HalfOpenRatio, 10)) {
+			return false, cb.state
+		}
+		cb.probeAt = b.now()
+		return true, cb.state
+	}
+	return true, cb.state
+}
+
+// Probe 不经抽样占用半开源的试探名额，名额已被占用或源不处于半开时返回 false
+// 用于没有其他可用源时仍允许向半开源发出试探请求
+func (b *BreakerSet) Probe(src *model.Source) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb := b.get(src)
+	if cb.state != model.BreakerHalfOpen || b.probing(cb) {
+		return false
+	}
+	cb.probeAt = b.now()
+	return true
+}
+
+// Release 归还半开源的试探名额（通过 Allow 或 Probe 占用后未被选中）
+func (b *BreakerSet) Release(src *model.Source) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cb, ok := b.breakers[src.ID]; ok && cb.state == model.BreakerHalfOpen {
+		cb.probeAt = time.Time{}
+	}
+}
+
+// probing 判断试探名额是否被占用；占用超过 open_timeout 仍未记录结果的试探视为已丢失（调用方持有锁）
+func (b *BreakerSet) probing(cb *circuitBreaker) bool {
+	return !cb.probeAt.IsZero() && b.now().Sub(cb.probeAt) < b.openTimeout()
+}
+
+// Record 记录一次真实请求的结果
+func (b *BreakerSet) Record(src *model.Source, failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb := b.get(src)
+	now := b.now()
+	switch cb.state {
+	case model.BreakerOpen:
+		return // 熔断前发出的请求结果不影响状态
+	case model.BreakerHalfOpen:
+		cb.probeAt = time.Time{}
+		if failed {
+			b.transition(src.ID, cb, model.BreakerOpen, "probe request failed")
+			return
+		}
+		cb.successes++
+		if need := orDefault(b.cfg.HalfOpenSuccesses, 3); cb.successes >= need {
+			b.transition(src.ID, cb, model.BreakerClosed, fmt.Sprintf("%d probe requests succeeded", need))
+		}
+		return
+	}
+
+	cb.outcomes = append(cb.outcomes, breakerOutcome{at: now, failed: failed})
+	cb.trim(now.Add(-b.window()))
+	if !failed {
+		return
+	}
+	requests, failures := cb.counts()
+	if requests < orDefault(b.cfg.MinRequests, 10) {
+		return
+	}
+	if rate := failures * 100 / requests; rate >= orDefault(b.cfg.ErrorRate, 50) {
+		b.transition(src.ID, cb, model.BreakerOpen, fmt.Sprintf("error rate %d%% (%d/%d) in window", rate, failures, requests))
+	}
+}
+
+// ProbeSucceeded 健康检查成功：熔断中的源提前进入半开，由真实请求确认恢复
+func (b *BreakerSet) ProbeSucceeded(src *model.Source) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cb, ok := b.breakers[src.ID]; ok && cb.state == model.BreakerOpen {
+		b.transition(src.ID, cb, model.BreakerHalfOpen, "health check succeeded")
+	}
+}
+
+// Remove 删除源的熔断器
+func (b *BreakerSet) Remove(sourceID string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.breakers, sourceID)
+}
+
+// States 返回各源熔断器的实时状态（按源 ID 排序）
+func (b *BreakerSet) States() []model.BreakerState {
+	states := []model.BreakerState{}
+	if b == nil {
+		return states
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	for id, cb := range b.breakers {
+		cb.trim(now.Add(-b.window()))
+		requests, failures := cb.counts()
+		st := model.BreakerState{
+			SourceID:   id,
+			SourceName: cb.name,
+			State:      cb.state,
+			Requests:   requests,
+			Failures:   failures,
+			Successes:  cb.successes,
+		}
+		if requests > 0 {
+			st.ErrorRate = float64(failures) / float64(requests)
+		}
+		if !cb.openedAt.IsZero() {
+			openedAt := cb.openedAt
+			st.OpenedAt = &openedAt
+		}
+		if cb.state == model.BreakerOpen {
+			retryAt := cb.openedAt.Add(b.openTimeout())
+			st.RetryAt = &retryAt
+		}
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].SourceID < states[j].SourceID })
+	return states
+}
+
+// State 返回单个源熔断器的状态，未记录过请求时为 closed
+func (b *BreakerSet) State(sourceID string) string {
+	if b == nil {
+		return model.BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cb, ok := b.breakers[sourceID]; ok {
+		return cb.state
+	}
+	return model.BreakerClosed
+}
+
+// Transitions 返回最近的状态转换记录（新的在前）
+func (b *BreakerSet) Transitions() []model.BreakerTransition {
+	out := []model.BreakerTransition{}
+	if b == nil {
+		return out
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := len(b.transitions) - 1; i >= 0; i-- {
+		out = append(out, b.transitions[i])
+	}
+	return out
+}
+
+// trim 丢弃窗口外的请求结果
+func (cb *circuitBreaker) trim(windowStart time.Time) {
+	i := 0
+	for i < len(cb.outcomes) && !cb.outcomes[i].at.After(windowStart) {
+		i++
+	}
+	cb.outcomes = cb.outcomes[i:]
+}
+
+// counts 统计窗口内的请求数与失败数
+func (cb *circuitBreaker) counts() (requests, failures int) {
+	for _, o := range cb.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	return len(cb.outcomes), failures
+}
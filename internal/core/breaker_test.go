@@ -0,0 +1,125 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/config"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// newTestBreakers 创建使用可控时钟与抽样的熔断器集合
+func newTestBreakers(cfg config.CircuitBreakerConfig) (*BreakerSet, *time.Time, *float64) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sample := 0.5
+	b := NewBreakerSet(&cfg)
+	b.now = func() time.Time { return now }
+	b.sample = func() float64 { return sample }
+	return b, &now, &sample
+}
+
+func TestBreakerSet_OpensOnErrorRate(t *testing.T) {
+	b, _, _ := newTestBreakers(config.CircuitBreakerConfig{MinRequests: 4, ErrorRate: 50})
+	src := &model.Source{ID: "s1", Name: "one"}
+
+	b.Record(src, false)
+	b.Record(src, true)
+	b.Record(src, false)
+	if b.State("s1") != model.BreakerClosed {
+		t.Fatal("expected breaker to stay closed below min requests")
+	}
+	b.Record(src, true)
+	if b.State("s1") != model.BreakerOpen {
+		t.Fatalf("expected breaker to open at 50%% errors, got %s", b.State("s1"))
+	}
+	if ok, _ := b.Allow(src); ok {
+		t.Error("expected open breaker to reject requests")
+	}
+}
+
+func TestBreakerSet_HalfOpenRecovery(t *testing.T) {
+	b, now, sample := newTestBreakers(config.CircuitBreakerConfig{MinRequests: 1, OpenTimeout: 30, HalfOpenRatio: 10, HalfOpenSuccesses: 2})
+	src := &model.Source{ID: "s1", Name: "one"}
+
+	b.Record(src, true)
+	*now = now.Add(31 * time.Second)
+
+	// 半开：只放行抽样命中的请求
+	if ok, state := b.Allow(src); ok || state != model.BreakerHalfOpen {
+		t.Fatalf("expected half-open to reject an unsampled request, got %v %s", ok, state)
+	}
+	*sample = 0.05
+	if ok, _ := b.Allow(src); !ok {
+		t.Fatal("expected half-open to let a sampled request through")
+	}
+
+	b.Record(src, false)
+	if b.State("s1") != model.BreakerHalfOpen {
+		t.Fatal("expected breaker to need more probe successes")
+	}
+	b.Record(src, false)
+	if b.State("s1") != model.BreakerClosed {
+		t.Fatalf("expected breaker to close, got %s", b.State("s1"))
+	}
+
+	transitions := b.Transitions()
+	if len(transitions) != 3 || transitions[0].To != model.BreakerClosed || transitions[2].To != model.BreakerOpen {
+		t.Errorf("unexpected transitions: %+v", transitions)
+	}
+}
+
+func TestBreakerSet_HalfOpenSingleProbe(t *testing.T) {
+	b, now, sample := newTestBreakers(config.CircuitBreakerConfig{MinRequests: 1, OpenTimeout: 30, HalfOpenSuccesses: 2})
+	src := &model.Source{ID: "s1", Name: "one"}
+	*sample = 0
+
+	b.Record(src, true)
+	b.ProbeSucceeded(src)
+
+	// 同一时刻只放行一个试探请求
+	if ok, _ := b.Allow(src); !ok {
+		t.Fatal("expected the first probe to be admitted")
+	}
+	if ok, state := b.Allow(src); ok || state != model.BreakerHalfOpen {
+		t.Fatalf("expected a second concurrent probe to be rejected, got %v %s", ok, state)
+	}
+	if b.Probe(src) {
+		t.Fatal("expected Probe to fail while the slot is held")
+	}
+
+	// 记录结果后释放名额
+	b.Record(src, false)
+	if ok, _ := b.Allow(src); !ok {
+		t.Fatal("expected the slot to be free after the probe was recorded")
+	}
+
+	// 未被选中的源归还名额
+	b.Release(src)
+	if !b.Probe(src) {
+		t.Fatal("expected the slot to be free after Release")
+	}
+
+	// 长时间未记录结果的试探视为丢失
+	*now = now.Add(31 * time.Second)
+	if ok, _ := b.Allow(src); !ok {
+		t.Fatal("expected a stale probe slot to be reclaimed")
+	}
+}
+
+func TestBreakerSet_HalfOpenFailureReopens(t *testing.T) {
+	b, _, _ := newTestBreakers(config.CircuitBreakerConfig{MinRequests: 1})
+	src := &model.Source{ID: "s1", Name: "one"}
+
+	b.Record(src, true)
+	b.ProbeSucceeded(src)
+	if b.State("s1") != model.BreakerHalfOpen {
+		t.Fatal("expected a successful health check to move the breaker to half-open")
+	}
+	b.Record(src, true)
+	if b.State("s1") != model.BreakerOpen {
+		t.Fatal("expected a failed probe request to reopen the breaker")
+	}
+	if states := b.States(); len(states) != 1 || states[0].RetryAt == nil {
+		t.Errorf("expected open state to report retry time, got %+v", states)
+	}
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// DefaultSoftLimitPercent 默认软限制阈值（预算百分比）
+const DefaultSoftLimitPercent = 80
+
+// BudgetPeriodStarts 返回预算周期（当日、当月）的起始时间
+func BudgetPeriodStarts(now time.Time) (day, month time.Time) {
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+}
+
+// EvaluateBudgets 计算 Key 各项预算的使用情况，未设置的预算不返回
+func EvaluateBudgets(limits model.KeyLimits, daily, monthly model.KeySpend) []model.BudgetStatus {
+	soft := limits.SoftLimitPercent
+	if soft <= 0 || soft > 100 {
+		soft = DefaultSoftLimitPercent
+	}
+
+	var statuses []model.BudgetStatus
+	add := func(name string, limit, used float64) {
+		if limit <= 0 {
+			return
+		}
+		remaining := limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		statuses = append(statuses, model.BudgetStatus{
+			Name:      name,
+			Limit:     limit,
+			Used:      used,
+			Remaining: remaining,
+			Exceeded:  used >= limit,
+			Warning:   used >= limit*float64(soft)/100,
+		})
+	}
+
+	add("daily_tokens", float64(limits.DailyTokenBudget), float64(daily.Tokens))
+	add("monthly_tokens", float64(limits.MonthlyTokenBudget), float64(monthly.Tokens))
+	add("daily_cost", limits.DailyCostBudget, daily.Cost)
+	add("monthly_cost", limits.MonthlyCostBudget, monthly.Cost)
+	return statuses
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestBudgetPeriodStarts(t *testing.T) {
+	now := time.Date(2026, 3, 15, 13, 45, 0, 0, time.UTC)
+	day, month := BudgetPeriodStarts(now)
+	if !day.Equal(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected day start: %v", day)
+	}
+	if !month.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected month start: %v", month)
+	}
+}
+
+func TestEvaluateBudgets(t *testing.T) {
+	limits := model.KeyLimits{DailyTokenBudget: 1000, MonthlyCostBudget: 10}
+	statuses := EvaluateBudgets(limits, model.KeySpend{Tokens: 500}, model.KeySpend{Cost: 8.5})
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 budgets, got %d", len(statuses))
+	}
+
+	daily := statuses[0]
+	if daily.Name != "daily_tokens" || daily.Remaining != 500 || daily.Warning || daily.Exceeded {
+		t.Errorf("unexpected daily token budget: %+v", daily)
+	}
+	monthly := statuses[1]
+	if monthly.Name != "monthly_cost" || monthly.Remaining != 1.5 || !monthly.Warning || monthly.Exceeded {
+		t.Errorf("unexpected monthly cost budget: %+v", monthly)
+	}
+}
+
+func TestEvaluateBudgets_ExceededAndSoftLimit(t *testing.T) {
+	limits := model.KeyLimits{DailyCostBudget: 1, MonthlyTokenBudget: 100, SoftLimitPercent: 50}
+	statuses := EvaluateBudgets(limits, model.KeySpend{Cost: 1.2}, model.KeySpend{Tokens: 60})
+
+	for _, b := range statuses {
+		switch b.Name {
+		case "daily_cost":
+			if !b.Exceeded || b.Remaining != 0 {
+				t.Errorf("expected exceeded daily cost budget, got %+v", b)
+			}
+		case "monthly_tokens":
+			if b.Exceeded || !b.Warning {
+				t.Errorf("expected warning at 50%% soft limit, got %+v", b)
+			}
+		default:
+			t.Errorf("unexpected budget %q", b.Name)
+		}
+	}
+}
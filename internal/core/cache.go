@@ -0,0 +1,120 @@
+package core
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// ResponseCache 响应缓存
+// 单实例使用进程内 LRU（MemoryCache）；需要重启后保留时使用 SQLiteCache
+type ResponseCache interface {
+	// Get 读取未过期的缓存条目
+	Get(key string) (*model.CachedResponse, bool)
+	// Set 写入缓存条目，超过容量时淘汰最久未命中的条目
+	Set(key string, entry *model.CachedResponse)
+}
+
+// Cacheable 请求是否为可缓存的确定性请求（temperature=0 且只生成一个结果）
+func Cacheable(req *model.ChatCompletionRequest) bool {
+	if req.Temperature == nil || *req.Temperature != 0 {
+		return false
+	}
+	return req.N == nil || *req.N <= 1
+}
+
+// CacheKey 响应缓存键：作用域与规范化请求（去掉不影响输出的字段）一起计算 SHA-256
+// scope 通常为 API Key ID，不同作用域的缓存互不可见；流式与非流式请求的缓存分开存放，stream_options 影响回放的数据帧，也计入缓存键
+func CacheKey(scope string, req *model.ChatCompletionRequest) string {
+	norm := *req
+	norm.User = ""
+	if !norm.Stream {
+		norm.StreamOptions = nil
+	}
+	if norm.N != nil && *norm.N == 1 {
+		norm.N = nil
+	}
+	data, _ := json.Marshal(&norm)
+	h := sha256.New()
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write(data)
+	sum := h.Sum(nil)
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryCache 进程内 LRU 响应缓存
+type MemoryCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	ll    *list.List // 最近命中的在前
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *model.CachedResponse
+}
+
+// NewMemoryCache 创建进程内响应缓存
+func NewMemoryCache(ttl time.Duration, maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get 读取未过期的缓存条目
+func (m *MemoryCache) Get(key string) (*model.CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*memoryCacheItem)
+	if m.now().Sub(item.entry.CreatedAt) >= m.ttl {
+		m.ll.Remove(el)
+		delete(m.items, key)
+		return nil, false
+	}
+	m.ll.MoveToFront(el)
+	return item.entry, true
+}
+
+// Set 写入缓存条目，超过容量时淘汰最久未命中的条目
+func (m *MemoryCache) Set(key string, entry *model.CachedResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		m.ll.MoveToFront(el)
+		return
+	}
+	m.items[key] = m.ll.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for m.maxEntries > 0 && m.ll.Len() > m.maxEntries {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// Len 当前缓存的条目数（含未清理的过期条目）
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ll.Len()
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+	"github.com/xiaopang/fusionapi/internal/store"
+)
+
+// SQLiteCache 基于 SQLite 的响应缓存（与请求日志同库），重启后保留
+type SQLiteCache struct {
+	store      *store.Store
+	ttl        time.Duration
+	maxEntries int
+}
+
+// NewSQLiteCache 创建 SQLite 响应缓存
+func NewSQLiteCache(st *store.Store, ttl time.Duration, maxEntries int) *SQLiteCache {
+	return &SQLiteCache{store: st, ttl: ttl, maxEntries: maxEntries}
+}
+
+// Get 读取未过期的缓存条目
+func (s *SQLiteCache) Get(key string) (*model.CachedResponse, bool) {
+	entry, err := s.store.GetCachedResponse(key, time.Now().Add(-s.ttl))
+	if err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set 写入缓存条目，同时清理过期及超出容量的条目
+func (s *SQLiteCache) Set(key string, entry *model.CachedResponse) {
+	if err := s.store.SaveCachedResponse(key, entry, time.Now().Add(-s.ttl), s.maxEntries); err != nil {
+		log.Printf("[Cache] failed to save response: %v", err)
+	}
+}
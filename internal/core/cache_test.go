@@ -0,0 +1,97 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+	"github.com/xiaopang/fusionapi/internal/store"
+)
+
+func TestCacheKey(t *testing.T) {
+	zero, warm := 0.0, 0.7
+	base := model.ChatCompletionRequest{Model: "gpt-4o", Temperature: &zero, Messages: []model.Message{{Role: "user", Content: "hi"}}}
+
+	withUser := base
+	withUser.User = "alice"
+	if CacheKey("key_a", &base) != CacheKey("key_a", &withUser) {
+		t.Error("expected user field to be ignored")
+	}
+
+	stream := base
+	stream.Stream = true
+	if CacheKey("key_a", &base) == CacheKey("key_a", &stream) {
+		t.Error("expected streamed and non-streamed requests to use different keys")
+	}
+
+	other := base
+	other.Messages = []model.Message{{Role: "user", Content: "hello"}}
+	if CacheKey("key_a", &base) == CacheKey("key_a", &other) {
+		t.Error("expected different messages to use different keys")
+	}
+
+	if CacheKey("key_a", &base) == CacheKey("key_b", &base) {
+		t.Error("expected different scopes to use different keys")
+	}
+
+	if !Cacheable(&base) {
+		t.Error("expected temperature=0 request to be cacheable")
+	}
+	nonDeterministic := base
+	nonDeterministic.Temperature = &warm
+	if Cacheable(&nonDeterministic) || Cacheable(&model.ChatCompletionRequest{Model: "gpt-4o"}) {
+		t.Error("expected requests without temperature=0 not to be cacheable")
+	}
+}
+
+func TestMemoryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Now()
+	c := NewMemoryCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", &model.CachedResponse{SourceID: "a", CreatedAt: now})
+	c.Set("b", &model.CachedResponse{SourceID: "b", CreatedAt: now})
+	c.Get("a")
+	c.Set("c", &model.CachedResponse{SourceID: "c", CreatedAt: now})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected recently used entry to be kept")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected entry to expire after ttl")
+	}
+}
+
+func TestSQLiteCache(t *testing.T) {
+	st, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+
+	c := NewSQLiteCache(st, time.Minute, 1)
+	c.Set("old", &model.CachedResponse{Frames: []string{"[DONE]"}, CreatedAt: time.Now().Add(-2 * time.Minute)})
+	if _, ok := c.Get("old"); ok {
+		t.Error("expected expired entry to be ignored")
+	}
+
+	c.Set("a", &model.CachedResponse{Frames: []string{"a"}, CreatedAt: time.Now()})
+	time.Sleep(2 * time.Millisecond)
+	c.Set("b", &model.CachedResponse{Frames: []string{"b"}, CreatedAt: time.Now()})
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected entries beyond max_entries to be evicted")
+	}
+	entry, ok := c.Get("b")
+	if !ok || len(entry.Frames) != 1 || entry.Frames[0] != "b" {
+		t.Errorf("expected cached frames, got %+v", entry)
+	}
+}
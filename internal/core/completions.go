@@ -0,0 +1,88 @@
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// 入口协议为 OpenAI 旧版 Completions API 时的转换：
+// prompt 作为一条 user 消息走统一路由/failover，响应取助手消息文本
+
+// FromCompletionRequest 将 completions 请求转换为内部 ChatCompletionRequest
+func (t *Translator) FromCompletionRequest(req *model.CompletionRequest) (*model.ChatCompletionRequest, error) {
+	prompt, err := req.PromptText()
+	if err != nil {
+		return nil, err
+	}
+	return &model.ChatCompletionRequest{
+		Model:            req.Model,
+		Messages:         []model.Message{{Role: "user", Content: prompt}},
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		N:                req.N,
+		Stream:           req.Stream,
+		StreamOptions:    req.StreamOptions,
+		Stop:             req.Stop,
+		MaxTokens:        req.MaxTokens,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		LogitBias:        req.LogitBias,
+		User:             req.User,
+	}, nil
+}
+
+// ToCompletionResponse 将 OpenAI 聊天响应转换为 completions 响应
+func (t *Translator) ToCompletionResponse(resp *model.ChatCompletionResponse) *model.CompletionResponse {
+	out := &model.CompletionResponse{
+		ID:      resp.ID,
+		Object:  "text_completion",
+		Created: resp.Created,
+		Model:   resp.Model,
+		Choices: []model.CompletionChoice{},
+		Usage:   resp.Usage,
+	}
+	for _, choice := range resp.Choices {
+		var text string
+		if choice.Message != nil {
+			text = contentText(choice.Message.Content)
+		}
+		out.Choices = append(out.Choices, completionChoice(choice.Index, text, choice.FinishReason))
+	}
+	return out
+}
+
+// ToCompletionChunk 将一帧 OpenAI data（chunk JSON 或 [DONE]）转换为 completions 流式数据帧
+func (t *Translator) ToCompletionChunk(data string) string {
+	var chunk model.StreamChunk
+	if data == "[DONE]" || json.Unmarshal([]byte(data), &chunk) != nil {
+		return data
+	}
+
+	out := model.CompletionResponse{
+		ID:      chunk.ID,
+		Object:  "text_completion",
+		Created: chunk.Created,
+		Model:   chunk.Model,
+		Choices: []model.CompletionChoice{},
+		Usage:   chunk.Usage,
+	}
+	for _, choice := range chunk.Choices {
+		var text string
+		if choice.Delta != nil {
+			text = contentText(choice.Delta.Content)
+		}
+		out.Choices = append(out.Choices, completionChoice(choice.Index, text, choice.FinishReason))
+	}
+	b, _ := json.Marshal(out)
+	return string(b)
+}
+
+// completionChoice 构造 completions 选项，finish_reason 为空时输出 null
+func completionChoice(index int, text, finishReason string) model.CompletionChoice {
+	choice := model.CompletionChoice{Index: index, Text: text}
+	if finishReason != "" {
+		choice.FinishReason = &finishReason
+	}
+	return choice
+}
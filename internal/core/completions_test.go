@@ -0,0 +1,46 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestFromCompletionRequest(t *testing.T) {
+	tr := NewTranslator()
+	for _, prompt := range []string{`"Say hi"`, `["Say hi"]`} {
+		req := &model.CompletionRequest{Model: "m", Prompt: json.RawMessage(prompt)}
+		out, err := tr.FromCompletionRequest(req)
+		if err != nil {
+			t.Fatalf("prompt %s: %v", prompt, err)
+		}
+		if len(out.Messages) != 1 || out.Messages[0].Role != "user" || out.Messages[0].Content != "Say hi" {
+			t.Errorf("prompt %s: unexpected messages %+v", prompt, out.Messages)
+		}
+	}
+
+	for _, prompt := range []string{`["a","b"]`, `[1,2,3]`} {
+		req := &model.CompletionRequest{Model: "m", Prompt: json.RawMessage(prompt)}
+		if _, err := tr.FromCompletionRequest(req); !errors.Is(err, model.ErrUnsupportedPrompt) {
+			t.Errorf("prompt %s: expected ErrUnsupportedPrompt, got %v", prompt, err)
+		}
+	}
+}
+
+func TestToCompletionChunk(t *testing.T) {
+	tr := NewTranslator()
+	out := tr.ToCompletionChunk(`{"id":"c1","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"Hi"}}]}`)
+
+	var chunk model.CompletionResponse
+	if err := json.Unmarshal([]byte(out), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Object != "text_completion" || len(chunk.Choices) != 1 || chunk.Choices[0].Text != "Hi" || chunk.Choices[0].FinishReason != nil {
+		t.Errorf("unexpected chunk: %s", out)
+	}
+	if tr.ToCompletionChunk("[DONE]") != "[DONE]" {
+		t.Error("expected [DONE] to pass through")
+	}
+}
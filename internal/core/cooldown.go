@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 限流冷却时长
+const (
+	DefaultSourceCooldown = time.Minute    // 上游未给出重置时间时的冷却时长
+	MaxSourceCooldown     = 24 * time.Hour // 冷却时长上限，防止异常的重置头长期摘除源
+)
+
+// resetHeaders 各家上游在限流响应中给出的重置时间头及对应的剩余额度头
+// OpenAI 为时长（如 "6m0s"、"20ms"），Anthropic 为 RFC 3339 时间，其余为秒数或 Unix 时间戳
+// 剩余额度仍大于 0 的维度不是本次限流的原因，忽略其重置时间
+var resetHeaders = []struct{ reset, remaining string }{
+	{"x-ratelimit-reset-requests", "x-ratelimit-remaining-requests"},
+	{"x-ratelimit-reset-tokens", "x-ratelimit-remaining-tokens"},
+	{"anthropic-ratelimit-requests-reset", "anthropic-ratelimit-requests-remaining"},
+	{"anthropic-ratelimit-tokens-reset", "anthropic-ratelimit-tokens-remaining"},
+	{"anthropic-ratelimit-input-tokens-reset", "anthropic-ratelimit-input-tokens-remaining"},
+	{"anthropic-ratelimit-output-tokens-reset", "anthropic-ratelimit-output-tokens-remaining"},
+	{"x-ratelimit-reset", "x-ratelimit-remaining"},
+}
+
+// IsRateLimitResponse 判断上游响应是否为限流或配额耗尽（而非源故障）
+func IsRateLimitResponse(statusCode int, body []byte) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusPaymentRequired:
+		return true
+	case http.StatusForbidden:
+		lower := strings.ToLower(string(body))
+		return strings.Contains(lower, "quota") || strings.Contains(lower, "rate limit")
+	}
+	return false
+}
+
+// CooldownFromHeaders 从上游响应头解析冷却时长
+// 优先使用 Retry-After / retry-after-ms，其次取各家重置头中最晚的时间；都没有时返回 fallback
+func CooldownFromHeaders(header http.Header, now time.Time, fallback time.Duration) time.Duration {
+	d, ok := retryAfter(header, now)
+	if !ok {
+		for _, h := range resetHeaders {
+			if remaining, err := strconv.ParseFloat(header.Get(h.remaining), 64); err == nil && remaining > 0 {
+				continue
+			}
+			if reset, found := parseResetValue(header.Get(h.reset), now); found && reset > d {
+				d, ok = reset, true
+			}
+		}
+	}
+	if !ok || d <= 0 {
+		d = fallback
+	}
+	if d > MaxSourceCooldown {
+		d = MaxSourceCooldown
+	}
+	return d
+}
+
+// retryAfter 解析 retry-after-ms 与 Retry-After（秒数或 HTTP 日期）
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if v := strings.TrimSpace(header.Get("retry-after-ms")); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// parseResetValue 解析重置头：时长字符串、RFC 3339 时间、Unix 时间戳（秒/毫秒）或秒数
+func parseResetValue(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		switch {
+		case n > 1e12: // Unix 毫秒
+			return time.UnixMilli(int64(n)).Sub(now), true
+		case n > 1e9: // Unix 秒
+			return time.Unix(int64(n), 0).Sub(now), true
+		default:
+			return time.Duration(n * float64(time.Second)), true
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCooldownFromHeaders(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{"retry-after seconds", map[string]string{"Retry-After": "30"}, 30 * time.Second},
+		{"retry-after date", map[string]string{"Retry-After": now.Add(2 * time.Minute).Format(http.TimeFormat)}, 2 * time.Minute},
+		{"retry-after-ms wins", map[string]string{"retry-after-ms": "1500", "Retry-After": "2"}, 1500 * time.Millisecond},
+		{"openai exhausted dimension", map[string]string{
+			"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "20s",
+			"x-ratelimit-remaining-tokens": "5000", "x-ratelimit-reset-tokens": "6m0s",
+		}, 20 * time.Second},
+		{"anthropic timestamp", map[string]string{"anthropic-ratelimit-tokens-reset": now.Add(45 * time.Second).Format(time.RFC3339)}, 45 * time.Second},
+		{"unix reset", map[string]string{"x-ratelimit-reset": "1735733100"}, 5 * time.Minute},
+		{"capped", map[string]string{"Retry-After": "999999"}, MaxSourceCooldown},
+		{"fallback", map[string]string{"Retry-After": "soon"}, DefaultSourceCooldown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			if got := CooldownFromHeaders(h, now, DefaultSourceCooldown); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsRateLimitResponse(t *testing.T) {
+	if !IsRateLimitResponse(429, nil) {
+		t.Error("expected 429 to be a rate limit")
+	}
+	if !IsRateLimitResponse(403, []byte(`{"error":{"message":"You exceeded your current quota"}}`)) {
+		t.Error("expected quota 403 to be a rate limit")
+	}
+	if IsRateLimitResponse(403, []byte(`{"error":{"message":"invalid api key"}}`)) || IsRateLimitResponse(500, nil) {
+		t.Error("expected auth and server errors not to be rate limits")
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// ErrorClass 上游错误分类，决定换源重试还是直接返回给客户端
+type ErrorClass string
+
+const (
+	ErrorClassClient        ErrorClass = "client_error"   // 请求本身不合法（400/422 等），换源也会同样失败
+	ErrorClassAuth          ErrorClass = "auth_error"     // 源的凭证无效或无权限（401/403）
+	ErrorClassRateLimit     ErrorClass = "rate_limit"     // 限流或配额耗尽（429/402/配额类 403）
+	ErrorClassContextLength ErrorClass = "context_length" // 超出上下文长度，其他源可能有更大的上下文窗口
+	ErrorClassServer        ErrorClass = "server_error"   // 上游故障（5xx、404 等源侧问题）
+	ErrorClassNetwork       ErrorClass = "network_error"  // 连接失败、超时、连接中断
+)
+
+// contextLengthMarkers 各家上游超出上下文长度时错误体中的特征文本
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"context window",
+	"prompt is too long",
+	"too many tokens",
+	"reduce the length",
+}
+
+// Retryable 该类错误换一个源是否可能成功
+func (c ErrorClass) Retryable() bool {
+	return c != ErrorClassClient
+}
+
+// ClientCaused 错误是否由请求本身引起（不计入源的健康失败，最终原样返回给客户端）
+func (c ErrorClass) ClientCaused() bool {
+	return c == ErrorClassClient || c == ErrorClassContextLength
+}
+
+// ClassifyStatus 按状态码与错误体对上游非 200 响应分类
+func ClassifyStatus(statusCode int, body []byte) ErrorClass {
+	if IsRateLimitResponse(statusCode, body) {
+		return ErrorClassRateLimit
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorClassAuth
+	case statusCode == http.StatusRequestTimeout:
+		return ErrorClassNetwork
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusRequestEntityTooLarge:
+		lower := strings.ToLower(string(body))
+		for _, marker := range contextLengthMarkers {
+			if strings.Contains(lower, marker) {
+				return ErrorClassContextLength
+			}
+		}
+		return ErrorClassClient
+	case statusCode == http.StatusNotFound || statusCode == http.StatusConflict:
+		// 上游未提供该模型/接口或临时冲突，属于源侧问题
+		return ErrorClassServer
+	case statusCode >= 400 && statusCode < 500:
+		return ErrorClassClient
+	}
+	return ErrorClassServer
+}
+
+// ParseUpstreamError 解析上游错误体（OpenAI 与 Anthropic 格式均为 {"error": {...}}），
+// 无法解析时以错误体文本作为消息
+func ParseUpstreamError(body []byte) model.ErrorDetail {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		var detail struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Param   any    `json:"param"`
+			Code    any    `json:"code"`
+		}
+		var text string
+		switch {
+		case json.Unmarshal(parsed.Error, &detail) == nil && detail.Message != "":
+			out := model.ErrorDetail{Message: detail.Message, Type: detail.Type}
+			if detail.Param != nil {
+				out.Param = fmt.Sprint(detail.Param)
+			}
+			if detail.Code != nil {
+				out.Code = fmt.Sprint(detail.Code)
+			}
+			if out.Type == "" {
+				out.Type = "invalid_request_error"
+			}
+			return out
+		case json.Unmarshal(parsed.Error, &text) == nil && text != "":
+			return model.ErrorDetail{Message: text, Type: "invalid_request_error"}
+		case parsed.Message != "":
+			return model.ErrorDetail{Message: parsed.Message, Type: "invalid_request_error"}
+		}
+	}
+
+	message := strings.TrimSpace(string(body))
+	if len(message) > 4096 {
+		message = message[:4096] + "…"
+	}
+	return model.ErrorDetail{Message: message, Type: "invalid_request_error"}
+}
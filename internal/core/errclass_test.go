@@ -0,0 +1,53 @@
+package core
+
+import "testing"
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   ErrorClass
+	}{
+		{400, `{"error":{"message":"messages: field required"}}`, ErrorClassClient},
+		{422, ``, ErrorClassClient},
+		{400, `{"error":{"code":"context_length_exceeded","message":"This model's maximum context length is 8192 tokens"}}`, ErrorClassContextLength},
+		{400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, ErrorClassContextLength},
+		{401, ``, ErrorClassAuth},
+		{403, `{"error":{"message":"forbidden"}}`, ErrorClassAuth},
+		{403, `{"error":{"message":"insufficient quota"}}`, ErrorClassRateLimit},
+		{429, ``, ErrorClassRateLimit},
+		{404, ``, ErrorClassServer},
+		{408, ``, ErrorClassNetwork},
+		{502, ``, ErrorClassServer},
+	}
+	for _, tt := range tests {
+		if got := ClassifyStatus(tt.status, []byte(tt.body)); got != tt.want {
+			t.Errorf("status %d %q: expected %s, got %s", tt.status, tt.body, tt.want, got)
+		}
+	}
+	if ErrorClassClient.Retryable() || !ErrorClassContextLength.Retryable() {
+		t.Error("expected only client errors to fail fast")
+	}
+}
+
+func TestParseUpstreamError(t *testing.T) {
+	d := ParseUpstreamError([]byte(`{"error":{"message":"bad temperature","type":"invalid_request_error","param":"temperature","code":400}}`))
+	if d.Message != "bad temperature" || d.Param != "temperature" || d.Code != "400" {
+		t.Errorf("unexpected OpenAI error: %+v", d)
+	}
+
+	d = ParseUpstreamError([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too large"}}`))
+	if d.Message != "max_tokens: too large" || d.Type != "invalid_request_error" {
+		t.Errorf("unexpected Anthropic error: %+v", d)
+	}
+
+	d = ParseUpstreamError([]byte(`{"error":"model is required"}`))
+	if d.Message != "model is required" {
+		t.Errorf("unexpected string error: %+v", d)
+	}
+
+	d = ParseUpstreamError([]byte("Bad Request"))
+	if d.Message != "Bad Request" || d.Type != "invalid_request_error" {
+		t.Errorf("unexpected plain text error: %+v", d)
+	}
+}
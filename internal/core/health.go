@@ -154,6 +154,7 @@ func (h *HealthChecker) checkSource(src *model.Source) {
 		status.ConsecutiveFail = 0
 		status.State = model.HealthStateHealthy
 		status.LastError = ""
+		h.manager.Breakers().ProbeSucceeded(src)
 	}
 
 	src.SetStatus(status)
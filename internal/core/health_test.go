@@ -52,14 +52,12 @@ func TestHealthChecker_ProbeSource_SetsAuthHeader(t *testing.T) {
 			}))
 			defer srv.Close()
 
-			src := *tt.src
-			src.BaseURL = srv.URL
-			src.Enabled = true
+			src := &model.Source{Type: tt.src.Type, APIKey: tt.src.APIKey, BaseURL: srv.URL, Enabled: true}
 
-			mgr := &SourceManager{sources: map[string]*model.Source{"s1": &src}}
+			mgr := &SourceManager{sources: map[string]*model.Source{"s1": src}}
 			hc := NewHealthChecker(mgr, &config.HealthCheckConfig{Enabled: true, Interval: 60, Timeout: 2, FailureThreshold: 1})
 
-			err := hc.TestConnection(&src)
+			err := hc.TestConnection(src)
 			if err != nil {
 				t.Fatalf("TestConnection failed: %v", err)
 			}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// Limiter 频率限制器
+// 单实例部署使用内存实现 RateLimiter；多实例部署使用 RedisLimiter 在实例间共享计数
+type Limiter interface {
+	// AllowWithTool 检查并记录一次请求（RPM、日配额、工具配额）
+	AllowWithTool(keyID string, limits model.KeyLimits, tool string) (bool, string)
+	// AcquireConcurrent 原子地检查并占用并发令牌，limit 为 0 时只计数不限制
+	AcquireConcurrent(keyID string, limit int) (bool, string)
+	// ReleaseConcurrent 释放并发令牌
+	ReleaseConcurrent(keyID string)
+
+	// ReserveTokens 按预估 token 数检查并占用 TPM 额度；id 为服务端生成的预占 ID，不能使用客户端可控的值
+	ReserveTokens(bucket, id string, limit, tokens int) (bool, string)
+	// ReconcileTokens 用实际 token 用量替换预占的预估值
+	ReconcileTokens(bucket, id string, tokens int)
+
+	// AcquireSource 原子地检查并占用源的 RPM、并发与 TPM 额度（tokens 为预估 token 数，id 用于 TPM 校正）
+	AcquireSource(sourceID, id string, limits *model.SourceLimits, tokens int) (bool, string)
+	// ReleaseSource 释放源的并发令牌
+	ReleaseSource(sourceID string)
+	// SourceSaturated 只读检查源是否已达到任一上限，用于路由时跳过饱和的源
+	SourceSaturated(sourceID string, limits *model.SourceLimits, tokens int) bool
+
+	// RecordError 记录请求错误，达到阈值时自动封禁并返回 true
+	RecordError(keyID string) bool
+	// RecordSuccess 记录请求成功（重置错误计数）
+	RecordSuccess(keyID string)
+	// IsAutoBanned 检查是否被自动封禁及剩余时长
+	IsAutoBanned(keyID string) (bool, time.Duration)
+
+	// State 返回各 Key 与源的实时限制状态
+	State() *model.RateLimitState
+}
+
+var _ Limiter = (*RateLimiter)(nil)
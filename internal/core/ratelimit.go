@@ -2,6 +2,8 @@ package core
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,24 +15,32 @@ const (
 	AutoBanDuration  = 30 * time.Minute // 自动封禁持续时间
 )
 
-// RateLimiter 频率限制器
+// RateLimiter 内存频率限制器（单实例）
 type RateLimiter struct {
-	mu         sync.Mutex
-	windows    map[string][]time.Time // keyID -> request timestamps for RPM sliding window
-	dailyCount map[string]int         // keyID+date -> count
-	concurrent map[string]int         // keyID -> current concurrent count
-	errorCount map[string]int         // keyID -> consecutive error count
-	autoBanned map[string]time.Time   // keyID -> ban time
+	mu               sync.Mutex
+	windows          map[string][]time.Time        // keyID -> request timestamps for RPM sliding window
+	dailyCount       map[string]int                // keyID+date -> count
+	concurrent       map[string]int                // keyID -> current concurrent count
+	errorCount       map[string]int                // keyID -> consecutive error count
+	autoBanned       map[string]time.Time          // keyID -> ban time
+	tokens           map[string][]model.TokenUsage // bucket -> token usage for TPM sliding window
+	sourceWindows    map[string][]time.Time        // sourceID -> request timestamps for RPM sliding window
+	sourceConcurrent map[string]int                // sourceID -> current concurrent count
+	dailyChanged     chan struct{}                 // 日配额计数或自动封禁变化通知（缓冲 1，多次变化合并）
 }
 
 // NewRateLimiter 创建频率限制器
 func NewRateLimiter() *RateLimiter {
 	rl := &RateLimiter{
-		windows:    make(map[string][]time.Time),
-		dailyCount: make(map[string]int),
-		concurrent: make(map[string]int),
-		errorCount: make(map[string]int),
-		autoBanned: make(map[string]time.Time),
+		windows:          make(map[string][]time.Time),
+		dailyCount:       make(map[string]int),
+		concurrent:       make(map[string]int),
+		errorCount:       make(map[string]int),
+		autoBanned:       make(map[string]time.Time),
+		tokens:           make(map[string][]model.TokenUsage),
+		sourceWindows:    make(map[string][]time.Time),
+		sourceConcurrent: make(map[string]int),
+		dailyChanged:     make(chan struct{}, 1),
 	}
 	// Start cleanup goroutine
 	go rl.cleanup()
@@ -70,13 +80,6 @@ func (r *RateLimiter) Allow(keyID string, limits model.KeyLimits) (bool, string)
 		}
 	}
 
-	// Check concurrent
-	if limits.Concurrent > 0 {
-		if r.concurrent[keyID] >= limits.Concurrent {
-			return false, fmt.Sprintf("Concurrent limit exceeded (%d/%d)", r.concurrent[keyID], limits.Concurrent)
-		}
-	}
-
 	// Record the request
 	if limits.RPM > 0 {
 		r.windows[keyID] = append(r.windows[keyID], now)
@@ -84,11 +87,26 @@ func (r *RateLimiter) Allow(keyID string, limits model.KeyLimits) (bool, string)
 	if limits.DailyQuota > 0 {
 		dateKey := keyID + ":" + now.Format("2006-01-02")
 		r.dailyCount[dateKey]++
+		r.notifyDailyChange()
 	}
 
 	return true, ""
 }
 
+// DailyChanges 日配额、工具配额计数或自动封禁变化时收到通知，调用方据此及时保存快照
+// 这些状态跨越整天，进程崩溃时丢失的代价远大于分钟级窗口
+func (r *RateLimiter) DailyChanges() <-chan struct{} {
+	return r.dailyChanged
+}
+
+// notifyDailyChange 发出变化通知，已有未处理的通知时合并
+func (r *RateLimiter) notifyDailyChange() {
+	select {
+	case r.dailyChanged <- struct{}{}:
+	default:
+	}
+}
+
 // AllowWithTool 检查是否允许请求（含工具配额）
 func (r *RateLimiter) AllowWithTool(keyID string, limits model.KeyLimits, tool string) (bool, string) {
 	// 先调用 Allow 的逻辑
@@ -108,6 +126,7 @@ func (r *RateLimiter) AllowWithTool(keyID string, limits model.KeyLimits, tool s
 				return false, fmt.Sprintf("Tool quota exceeded for %s (%d/%d)", tool, current, quota)
 			}
 			r.dailyCount[toolDateKey]++
+			r.notifyDailyChange()
 			r.mu.Unlock()
 		}
 	}
@@ -115,11 +134,89 @@ func (r *RateLimiter) AllowWithTool(keyID string, limits model.KeyLimits, tool s
 	return true, ""
 }
 
-// AcquireConcurrent 获取并发令牌
-func (r *RateLimiter) AcquireConcurrent(keyID string) {
+// KeyTokenBucket Key 的 TPM 计数桶名
+func KeyTokenBucket(keyID string) string {
+	return "key:" + keyID
+}
+
+// SourceTokenBucket 源的 TPM 计数桶名
+func SourceTokenBucket(sourceID string) string {
+	return "source:" + sourceID
+}
+
+// ReserveTokens 按预估 token 数检查并占用 TPM 额度
+// 窗口为空时总是放行，避免单个超大请求永远无法通过；id 用于请求完成后 ReconcileTokens 校正
+func (r *RateLimiter) ReserveTokens(bucket, id string, limit, tokens int) (bool, string) {
+	if limit <= 0 {
+		return true, ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	used := r.tokensInWindow(bucket, now)
+	if used > 0 && used+tokens > limit {
+		return false, fmt.Sprintf("TPM limit exceeded (%d+%d/%d)", used, tokens, limit)
+	}
+	r.tokens[bucket] = append(r.tokens[bucket], model.TokenUsage{ID: id, At: now, Tokens: tokens})
+	return true, ""
+}
+
+// ReconcileTokens 用实际 token 用量替换预占的预估值
+func (r *RateLimiter) ReconcileTokens(bucket, id string, tokens int) {
+	if id == "" {
+		return
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	uses := r.tokens[bucket]
+	for i := range uses {
+		if uses[i].ID == id {
+			uses[i].Tokens = tokens
+			return
+		}
+	}
+}
+
+// TokensUsed 返回当前窗口内已占用的 token 数
+func (r *RateLimiter) TokensUsed(bucket string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokensInWindow(bucket, time.Now())
+}
+
+// tokensInWindow 清理过期记录并统计窗口内 token 数（调用方持有锁）
+func (r *RateLimiter) tokensInWindow(bucket string, now time.Time) int {
+	windowStart := now.Add(-time.Minute)
+	uses := r.tokens[bucket]
+	valid := uses[:0]
+	total := 0
+	for _, u := range uses {
+		if u.At.After(windowStart) {
+			valid = append(valid, u)
+			total += u.Tokens
+		}
+	}
+	if len(valid) == 0 {
+		delete(r.tokens, bucket)
+	} else {
+		r.tokens[bucket] = valid
+	}
+	return total
+}
+
+// AcquireConcurrent 获取并发令牌，limit 为 0 时只计数不限制
+func (r *RateLimiter) AcquireConcurrent(keyID string, limit int) (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limit > 0 && r.concurrent[keyID] >= limit {
+		return false, fmt.Sprintf("Concurrent limit exceeded (%d/%d)", r.concurrent[keyID], limit)
+	}
 	r.concurrent[keyID]++
+	return true, ""
 }
 
 // ReleaseConcurrent 释放并发令牌
@@ -131,6 +228,76 @@ func (r *RateLimiter) ReleaseConcurrent(keyID string) {
 	}
 }
 
+// AcquireSource 检查并占用源的 RPM、并发与 TPM 额度
+func (r *RateLimiter) AcquireSource(sourceID, id string, limits *model.SourceLimits, tokens int) (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if reason := r.sourceSaturated(sourceID, limits, tokens, now); reason != "" {
+		return false, reason
+	}
+
+	r.sourceConcurrent[sourceID]++
+	if limits == nil {
+		return true, ""
+	}
+	if limits.RPM > 0 {
+		r.sourceWindows[sourceID] = append(r.sourceWindows[sourceID], now)
+	}
+	if limits.TPM > 0 {
+		bucket := SourceTokenBucket(sourceID)
+		r.tokens[bucket] = append(r.tokens[bucket], model.TokenUsage{ID: id, At: now, Tokens: tokens})
+	}
+	return true, ""
+}
+
+// ReleaseSource 释放源的并发令牌
+func (r *RateLimiter) ReleaseSource(sourceID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sourceConcurrent[sourceID] > 0 {
+		r.sourceConcurrent[sourceID]--
+	}
+}
+
+// SourceSaturated 检查源是否已达到任一上限
+func (r *RateLimiter) SourceSaturated(sourceID string, limits *model.SourceLimits, tokens int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sourceSaturated(sourceID, limits, tokens, time.Now()) != ""
+}
+
+// sourceSaturated 返回源达到上限的原因，未饱和时返回空串（调用方持有锁）
+func (r *RateLimiter) sourceSaturated(sourceID string, limits *model.SourceLimits, tokens int, now time.Time) string {
+	if limits == nil {
+		return ""
+	}
+	if limits.RPM > 0 {
+		windowStart := now.Add(-time.Minute)
+		timestamps := r.sourceWindows[sourceID]
+		valid := timestamps[:0]
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				valid = append(valid, t)
+			}
+		}
+		r.sourceWindows[sourceID] = valid
+		if len(valid) >= limits.RPM {
+			return fmt.Sprintf("source RPM limit exceeded (%d/%d)", len(valid), limits.RPM)
+		}
+	}
+	if limits.MaxConcurrent > 0 && r.sourceConcurrent[sourceID] >= limits.MaxConcurrent {
+		return fmt.Sprintf("source concurrent limit exceeded (%d/%d)", r.sourceConcurrent[sourceID], limits.MaxConcurrent)
+	}
+	if limits.TPM > 0 {
+		if used := r.tokensInWindow(SourceTokenBucket(sourceID), now); used > 0 && used+tokens > limits.TPM {
+			return fmt.Sprintf("source TPM limit exceeded (%d+%d/%d)", used, tokens, limits.TPM)
+		}
+	}
+	return ""
+}
+
 // RecordError 记录请求错误
 func (r *RateLimiter) RecordError(keyID string) bool {
 	r.mu.Lock()
@@ -138,6 +305,7 @@ func (r *RateLimiter) RecordError(keyID string) bool {
 	r.errorCount[keyID]++
 	if r.errorCount[keyID] >= AutoBanThreshold {
 		r.autoBanned[keyID] = time.Now()
+		r.notifyDailyChange()
 		return true // 触发自动封禁
 	}
 	return false
@@ -167,6 +335,222 @@ func (r *RateLimiter) IsAutoBanned(keyID string) (bool, time.Duration) {
 	return true, AutoBanDuration - elapsed
 }
 
+// Snapshot 导出可持久化状态（仅包含仍在有效期内的记录）
+func (r *RateLimiter) Snapshot() *model.RateLimitSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	windowStart := now.Add(-time.Minute)
+	today := now.Format("2006-01-02")
+	snap := &model.RateLimitSnapshot{
+		SavedAt:       now,
+		Windows:       make(map[string][]time.Time),
+		SourceWindows: make(map[string][]time.Time),
+		DailyCount:    make(map[string]int),
+		ErrorCount:    make(map[string]int),
+		AutoBanned:    make(map[string]time.Time),
+		Tokens:        make(map[string][]model.TokenUsage),
+	}
+	for k, timestamps := range r.windows {
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				snap.Windows[k] = append(snap.Windows[k], t)
+			}
+		}
+	}
+	for k, timestamps := range r.sourceWindows {
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				snap.SourceWindows[k] = append(snap.SourceWindows[k], t)
+			}
+		}
+	}
+	for k, n := range r.dailyCount {
+		if strings.HasSuffix(k, ":"+today) {
+			snap.DailyCount[k] = n
+		}
+	}
+	for k, n := range r.errorCount {
+		if n > 0 {
+			snap.ErrorCount[k] = n
+		}
+	}
+	for k, banTime := range r.autoBanned {
+		if now.Sub(banTime) < AutoBanDuration {
+			snap.AutoBanned[k] = banTime
+		}
+	}
+	for k, uses := range r.tokens {
+		for _, u := range uses {
+			if u.At.After(windowStart) {
+				snap.Tokens[k] = append(snap.Tokens[k], u)
+			}
+		}
+	}
+	return snap
+}
+
+// Restore 从快照恢复状态（启动时调用）；过期的窗口、非当日计数和已到期的封禁会被丢弃
+func (r *RateLimiter) Restore(snap *model.RateLimitSnapshot) {
+	if snap == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	windowStart := now.Add(-time.Minute)
+	today := now.Format("2006-01-02")
+	for k, timestamps := range snap.Windows {
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				r.windows[k] = append(r.windows[k], t)
+			}
+		}
+	}
+	for k, timestamps := range snap.SourceWindows {
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				r.sourceWindows[k] = append(r.sourceWindows[k], t)
+			}
+		}
+	}
+	for k, n := range snap.DailyCount {
+		if strings.HasSuffix(k, ":"+today) {
+			r.dailyCount[k] += n
+		}
+	}
+	for k, n := range snap.ErrorCount {
+		r.errorCount[k] += n
+	}
+	for k, banTime := range snap.AutoBanned {
+		if now.Sub(banTime) < AutoBanDuration {
+			r.autoBanned[k] = banTime
+		}
+	}
+	for k, uses := range snap.Tokens {
+		for _, u := range uses {
+			if u.At.After(windowStart) {
+				r.tokens[k] = append(r.tokens[k], u)
+			}
+		}
+	}
+}
+
+// State 返回各 Key 与源的实时限制状态
+func (r *RateLimiter) State() *model.RateLimitState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	windowStart := now.Add(-time.Minute)
+	today := now.Format("2006-01-02")
+
+	keys := make(map[string]*model.KeyLimitState)
+	keyState := func(keyID string) *model.KeyLimitState {
+		ks, ok := keys[keyID]
+		if !ok {
+			ks = &model.KeyLimitState{KeyID: keyID}
+			keys[keyID] = ks
+		}
+		return ks
+	}
+
+	for k, timestamps := range r.windows {
+		n := 0
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				n++
+			}
+		}
+		if n > 0 {
+			keyState(k).RPMUsed = n
+		}
+	}
+	for k, n := range r.dailyCount {
+		// keyID:date 或 keyID:tool:date
+		rest, ok := strings.CutSuffix(k, ":"+today)
+		if !ok {
+			continue
+		}
+		if keyID, tool, isTool := strings.Cut(rest, ":"); isTool {
+			ks := keyState(keyID)
+			if ks.ToolDaily == nil {
+				ks.ToolDaily = make(map[string]int)
+			}
+			ks.ToolDaily[tool] = n
+		} else {
+			keyState(rest).DailyUsed = n
+		}
+	}
+	for k, n := range r.concurrent {
+		if n > 0 {
+			keyState(k).Concurrent = n
+		}
+	}
+	for k, n := range r.errorCount {
+		if n > 0 {
+			keyState(k).ErrorCount = n
+		}
+	}
+	for k, banTime := range r.autoBanned {
+		if until := banTime.Add(AutoBanDuration); until.After(now) {
+			keyState(k).BannedUntil = &until
+		}
+	}
+
+	sources := make(map[string]*model.SourceLimitState)
+	sourceState := func(sourceID string) *model.SourceLimitState {
+		ss, ok := sources[sourceID]
+		if !ok {
+			ss = &model.SourceLimitState{SourceID: sourceID}
+			sources[sourceID] = ss
+		}
+		return ss
+	}
+
+	for bucket := range r.tokens {
+		used := r.tokensInWindow(bucket, now)
+		if used == 0 {
+			continue
+		}
+		if keyID, ok := strings.CutPrefix(bucket, KeyTokenBucket("")); ok {
+			keyState(keyID).TPMUsed = used
+		} else if sourceID, ok := strings.CutPrefix(bucket, SourceTokenBucket("")); ok {
+			sourceState(sourceID).TPMUsed = used
+		}
+	}
+	for k, timestamps := range r.sourceWindows {
+		n := 0
+		for _, t := range timestamps {
+			if t.After(windowStart) {
+				n++
+			}
+		}
+		if n > 0 {
+			sourceState(k).RPMUsed = n
+		}
+	}
+	for k, n := range r.sourceConcurrent {
+		if n > 0 {
+			sourceState(k).Concurrent = n
+		}
+	}
+
+	state := &model.RateLimitState{Keys: []model.KeyLimitState{}, Sources: []model.SourceLimitState{}}
+	for _, ks := range keys {
+		state.Keys = append(state.Keys, *ks)
+	}
+	for _, ss := range sources {
+		state.Sources = append(state.Sources, *ss)
+	}
+	sort.Slice(state.Keys, func(i, j int) bool { return state.Keys[i].KeyID < state.Keys[j].KeyID })
+	sort.Slice(state.Sources, func(i, j int) bool { return state.Sources[i].SourceID < state.Sources[j].SourceID })
+	return state
+}
+
 // cleanup periodically cleans old data
 func (r *RateLimiter) cleanup() {
 	ticker := time.NewTicker(10 * time.Minute)
@@ -188,6 +572,23 @@ func (r *RateLimiter) cleanup() {
 				r.windows[k] = valid
 			}
 		}
+		for k, timestamps := range r.sourceWindows {
+			valid := timestamps[:0]
+			for _, t := range timestamps {
+				if t.After(windowStart) {
+					valid = append(valid, t)
+				}
+			}
+			if len(valid) == 0 {
+				delete(r.sourceWindows, k)
+			} else {
+				r.sourceWindows[k] = valid
+			}
+		}
+		// Clean TPM windows
+		for k := range r.tokens {
+			r.tokensInWindow(k, now)
+		}
 		// Clean old daily counts (keep only today)
 		today := now.Format("2006-01-02")
 		for k := range r.dailyCount {
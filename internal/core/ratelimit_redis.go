@@ -0,0 +1,533 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// redisConcurrentTTL 并发计数的过期时间：实例崩溃未释放的令牌在无新请求时自动清除
+const redisConcurrentTTL = 10 * time.Minute
+
+// redisScanCount 遍历限流键时每次 SCAN 的 COUNT 提示
+const redisScanCount = 500
+
+// RedisLimiter 基于 Redis 协议的频率限制器，多实例共享计数
+// 每项检查都是"先占用、超限回滚"：占用与计数在单条命令或 MULTI/EXEC 内原子完成，
+// 并发竞争时只会多拒绝、不会超额放行。Redis 不可用时放行请求并记录日志
+type RedisLimiter struct {
+	client *redisClient
+	prefix string
+}
+
+var _ Limiter = (*RedisLimiter)(nil)
+
+// NewRedisLimiter 创建 Redis 频率限制器，prefix 为所有键的前缀
+func NewRedisLimiter(addr, password string, db int, prefix string, timeout time.Duration) *RedisLimiter {
+	return &RedisLimiter{
+		client: newRedisClient(addr, password, db, timeout),
+		prefix: prefix,
+	}
+}
+
+// Ping 检查 Redis 连接
+func (l *RedisLimiter) Ping() error {
+	_, err := l.client.do("PING")
+	return err
+}
+
+// key 拼接带前缀的键名
+func (l *RedisLimiter) key(parts ...string) string {
+	return l.prefix + strings.Join(parts, ":")
+}
+
+// failOpen Redis 不可用时放行
+func (l *RedisLimiter) failOpen(err error) (bool, string) {
+	log.Printf("[RateLimit] redis unavailable, allowing request: %v", err)
+	return true, ""
+}
+
+// AllowWithTool 检查并记录一次请求（RPM、日配额、工具配额），后续检查失败时回滚已占用的额度
+func (l *RedisLimiter) AllowWithTool(keyID string, limits model.KeyLimits, tool string) (bool, string) {
+	now := time.Now()
+	var undo [][]string
+	rollback := func() {
+		for _, cmd := range undo {
+			l.client.do(cmd...)
+		}
+	}
+
+	if limits.RPM > 0 {
+		key := l.key("rpm", keyID)
+		member := strconv.FormatInt(now.UnixNano(), 10) + "-" + generateID()
+		replies, err := l.client.multi(
+			[]string{"ZREMRANGEBYSCORE", key, "-inf", redisScore(now.Add(-time.Minute))},
+			[]string{"ZADD", key, redisScore(now), member},
+			[]string{"ZCARD", key},
+			[]string{"PEXPIRE", key, strconv.FormatInt(time.Minute.Milliseconds(), 10)},
+		)
+		if err != nil {
+			return l.failOpen(err)
+		}
+		if count := redisInt(replies[2]); count > int64(limits.RPM) {
+			l.client.do("ZREM", key, member)
+			return false, fmt.Sprintf("RPM limit exceeded (%d/%d)", count-1, limits.RPM)
+		}
+		undo = append(undo, []string{"ZREM", key, member})
+	}
+
+	date := now.Format("2006-01-02")
+	if limits.DailyQuota > 0 {
+		key := l.key("daily", keyID, date)
+		ok, used, err := l.incrWithin(key, limits.DailyQuota, 48*time.Hour)
+		if err != nil {
+			return l.failOpen(err)
+		}
+		if !ok {
+			rollback()
+			return false, fmt.Sprintf("Daily quota exceeded (%d/%d)", used, limits.DailyQuota)
+		}
+		undo = append(undo, []string{"DECR", key})
+	}
+
+	if tool != "" && tool != "unknown" && len(limits.ToolQuotas) > 0 {
+		if quota, ok := limits.ToolQuotas[tool]; ok && quota > 0 {
+			ok, used, err := l.incrWithin(l.key("daily", keyID, tool, date), quota, 48*time.Hour)
+			if err != nil {
+				return l.failOpen(err)
+			}
+			if !ok {
+				rollback()
+				return false, fmt.Sprintf("Tool quota exceeded for %s (%d/%d)", tool, used, quota)
+			}
+		}
+	}
+
+	return true, ""
+}
+
+// incrWithin 计数加一并刷新过期时间，超过 limit 时回滚；返回是否放行及此前的计数
+func (l *RedisLimiter) incrWithin(key string, limit int, ttl time.Duration) (bool, int64, error) {
+	replies, err := l.client.multi(
+		[]string{"INCR", key},
+		[]string{"PEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10)},
+	)
+	if err != nil {
+		return false, 0, err
+	}
+	n := redisInt(replies[0])
+	if limit > 0 && n > int64(limit) {
+		l.client.do("DECR", key)
+		return false, n - 1, nil
+	}
+	return true, n - 1, nil
+}
+
+// AcquireConcurrent 原子地检查并占用并发令牌
+func (l *RedisLimiter) AcquireConcurrent(keyID string, limit int) (bool, string) {
+	ok, current, err := l.incrWithin(l.key("conc", keyID), limit, redisConcurrentTTL)
+	if err != nil {
+		return l.failOpen(err)
+	}
+	if !ok {
+		return false, fmt.Sprintf("Concurrent limit exceeded (%d/%d)", current, limit)
+	}
+	return true, ""
+}
+
+// ReleaseConcurrent 释放并发令牌
+func (l *RedisLimiter) ReleaseConcurrent(keyID string) {
+	key := l.key("conc", keyID)
+	n, err := l.client.do("DECR", key)
+	if err != nil {
+		log.Printf("[RateLimit] redis release failed: %v", err)
+		return
+	}
+	// 计数已过期后释放会减成负数，归零
+	if redisInt(n) < 0 {
+		l.client.do("SET", key, "0", "PX", strconv.FormatInt(redisConcurrentTTL.Milliseconds(), 10))
+	}
+}
+
+// ReserveTokens 按预估 token 数检查并占用 TPM 额度；窗口为空时总是放行
+func (l *RedisLimiter) ReserveTokens(bucket, id string, limit, tokens int) (bool, string) {
+	if limit <= 0 {
+		return true, ""
+	}
+
+	now := time.Now()
+	key := l.key("tpm", bucket)
+	member := tokenMember(tokens, id)
+	replies, err := l.client.multi(
+		[]string{"ZREMRANGEBYSCORE", key, "-inf", redisScore(now.Add(-time.Minute))},
+		[]string{"ZADD", key, redisScore(now), member},
+		[]string{"ZRANGE", key, "0", "-1"},
+		[]string{"PEXPIRE", key, strconv.FormatInt(time.Minute.Milliseconds(), 10)},
+	)
+	if err != nil {
+		return l.failOpen(err)
+	}
+
+	members, _ := replies[2].([]any)
+	total := 0
+	for _, m := range members {
+		s, _ := m.(string)
+		total += parseTokenMember(s)
+	}
+	if others := total - tokens; others > 0 && total > limit {
+		l.client.do("ZREM", key, member)
+		return false, fmt.Sprintf("TPM limit exceeded (%d+%d/%d)", others, tokens, limit)
+	}
+	return true, ""
+}
+
+// ReconcileTokens 用实际 token 用量替换预占的预估值
+func (l *RedisLimiter) ReconcileTokens(bucket, id string, tokens int) {
+	if id == "" {
+		return
+	}
+
+	key := l.key("tpm", bucket)
+	reply, err := l.client.do("ZRANGE", key, "0", "-1", "WITHSCORES")
+	if err != nil {
+		log.Printf("[RateLimit] redis reconcile failed: %v", err)
+		return
+	}
+	items, _ := reply.([]any)
+	for i := 0; i+1 < len(items); i += 2 {
+		member, _ := items[i].(string)
+		score, _ := items[i+1].(string)
+		if tokenMemberID(member) != id {
+			continue
+		}
+		if _, err := l.client.multi(
+			[]string{"ZREM", key, member},
+			[]string{"ZADD", key, score, tokenMember(tokens, id)},
+		); err != nil {
+			log.Printf("[RateLimit] redis reconcile failed: %v", err)
+		}
+		return
+	}
+}
+
+// AcquireSource 依次占用源的 RPM、并发与 TPM 额度，任一超限时回滚已占用的部分
+func (l *RedisLimiter) AcquireSource(sourceID, id string, limits *model.SourceLimits, tokens int) (bool, string) {
+	if limits == nil {
+		return true, ""
+	}
+
+	now := time.Now()
+	var undo [][]string
+	rollback := func() {
+		for _, cmd := range undo {
+			l.client.do(cmd...)
+		}
+	}
+
+	if limits.RPM > 0 {
+		key := l.key("srpm", sourceID)
+		member := strconv.FormatInt(now.UnixNano(), 10) + "-" + generateID()
+		replies, err := l.client.multi(
+			[]string{"ZREMRANGEBYSCORE", key, "-inf", redisScore(now.Add(-time.Minute))},
+			[]string{"ZADD", key, redisScore(now), member},
+			[]string{"ZCARD", key},
+			[]string{"PEXPIRE", key, strconv.FormatInt(time.Minute.Milliseconds(), 10)},
+		)
+		if err != nil {
+			return l.failOpen(err)
+		}
+		if count := redisInt(replies[2]); count > int64(limits.RPM) {
+			l.client.do("ZREM", key, member)
+			return false, fmt.Sprintf("source RPM limit exceeded (%d/%d)", count-1, limits.RPM)
+		}
+		undo = append(undo, []string{"ZREM", key, member})
+	}
+
+	key := l.key("sconc", sourceID)
+	ok, current, err := l.incrWithin(key, limits.MaxConcurrent, redisConcurrentTTL)
+	if err != nil {
+		return l.failOpen(err)
+	}
+	if !ok {
+		rollback()
+		return false, fmt.Sprintf("source concurrent limit exceeded (%d/%d)", current, limits.MaxConcurrent)
+	}
+
+	if ok, reason := l.ReserveTokens(SourceTokenBucket(sourceID), id, limits.TPM, tokens); !ok {
+		rollback()
+		l.ReleaseSource(sourceID)
+		return false, "source " + reason
+	}
+	return true, ""
+}
+
+// ReleaseSource 释放源的并发令牌
+func (l *RedisLimiter) ReleaseSource(sourceID string) {
+	key := l.key("sconc", sourceID)
+	n, err := l.client.do("DECR", key)
+	if err != nil {
+		log.Printf("[RateLimit] redis release failed: %v", err)
+		return
+	}
+	if redisInt(n) < 0 {
+		l.client.do("SET", key, "0", "PX", strconv.FormatInt(redisConcurrentTTL.Milliseconds(), 10))
+	}
+}
+
+// SourceSaturated 只读检查源是否已达到任一上限；Redis 不可用时视为未饱和
+func (l *RedisLimiter) SourceSaturated(sourceID string, limits *model.SourceLimits, tokens int) bool {
+	if limits == nil {
+		return false
+	}
+
+	windowStart := time.Now().Add(-time.Minute)
+	if limits.RPM > 0 {
+		reply, err := l.client.do("ZCOUNT", l.key("srpm", sourceID), "("+redisScore(windowStart), "+inf")
+		if err == nil && redisInt(reply) >= int64(limits.RPM) {
+			return true
+		}
+	}
+	if limits.MaxConcurrent > 0 {
+		reply, err := l.client.do("GET", l.key("sconc", sourceID))
+		if err == nil && redisInt(reply) >= int64(limits.MaxConcurrent) {
+			return true
+		}
+	}
+	if limits.TPM > 0 {
+		used := l.tokensInWindow(l.key("tpm", SourceTokenBucket(sourceID)), windowStart)
+		if used > 0 && used+tokens > limits.TPM {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordError 记录请求错误，达到阈值时自动封禁
+func (l *RedisLimiter) RecordError(keyID string) bool {
+	key := l.key("err", keyID)
+	replies, err := l.client.multi(
+		[]string{"INCR", key},
+		[]string{"PEXPIRE", key, strconv.FormatInt((24 * time.Hour).Milliseconds(), 10)},
+	)
+	if err != nil {
+		log.Printf("[RateLimit] redis record error failed: %v", err)
+		return false
+	}
+	if redisInt(replies[0]) < AutoBanThreshold {
+		return false
+	}
+
+	// 错误计数与封禁同时到期，解封后重新累计
+	ttl := strconv.FormatInt(AutoBanDuration.Milliseconds(), 10)
+	if _, err := l.client.multi(
+		[]string{"SET", l.key("ban", keyID), strconv.FormatInt(time.Now().Unix(), 10), "PX", ttl},
+		[]string{"PEXPIRE", key, ttl},
+	); err != nil {
+		log.Printf("[RateLimit] redis auto-ban failed: %v", err)
+		return false
+	}
+	return true
+}
+
+// RecordSuccess 记录请求成功（重置错误计数）
+func (l *RedisLimiter) RecordSuccess(keyID string) {
+	if _, err := l.client.do("DEL", l.key("err", keyID)); err != nil {
+		log.Printf("[RateLimit] redis record success failed: %v", err)
+	}
+}
+
+// IsAutoBanned 检查是否被自动封禁
+func (l *RedisLimiter) IsAutoBanned(keyID string) (bool, time.Duration) {
+	reply, err := l.client.do("PTTL", l.key("ban", keyID))
+	if err != nil {
+		log.Printf("[RateLimit] redis ban check failed: %v", err)
+		return false, 0
+	}
+	if ms := redisInt(reply); ms > 0 {
+		return true, time.Duration(ms) * time.Millisecond
+	}
+	return false, 0
+}
+
+// State 扫描限流键汇总各 Key 与源的实时状态
+func (l *RedisLimiter) State() *model.RateLimitState {
+	state := &model.RateLimitState{Keys: []model.KeyLimitState{}, Sources: []model.SourceLimitState{}}
+
+	names, err := l.scanKeys(l.prefix + "*")
+	if err != nil {
+		log.Printf("[RateLimit] redis state failed: %v", err)
+		return state
+	}
+
+	now := time.Now()
+	windowStart := now.Add(-time.Minute)
+	today := now.Format("2006-01-02")
+
+	keys := make(map[string]*model.KeyLimitState)
+	keyState := func(keyID string) *model.KeyLimitState {
+		ks, ok := keys[keyID]
+		if !ok {
+			ks = &model.KeyLimitState{KeyID: keyID}
+			keys[keyID] = ks
+		}
+		return ks
+	}
+	sources := make(map[string]*model.SourceLimitState)
+	sourceState := func(sourceID string) *model.SourceLimitState {
+		ss, ok := sources[sourceID]
+		if !ok {
+			ss = &model.SourceLimitState{SourceID: sourceID}
+			sources[sourceID] = ss
+		}
+		return ss
+	}
+	get := func(name string) int {
+		v, err := l.client.do("GET", name)
+		if err != nil {
+			return 0
+		}
+		return int(redisInt(v))
+	}
+
+	for _, name := range names {
+		kind, rest, _ := strings.Cut(strings.TrimPrefix(name, l.prefix), ":")
+		switch kind {
+		case "rpm":
+			if v, err := l.client.do("ZCOUNT", name, "("+redisScore(windowStart), "+inf"); err == nil && redisInt(v) > 0 {
+				keyState(rest).RPMUsed = int(redisInt(v))
+			}
+		case "srpm":
+			if v, err := l.client.do("ZCOUNT", name, "("+redisScore(windowStart), "+inf"); err == nil && redisInt(v) > 0 {
+				sourceState(rest).RPMUsed = int(redisInt(v))
+			}
+		case "sconc":
+			if v := get(name); v > 0 {
+				sourceState(rest).Concurrent = v
+			}
+		case "daily":
+			// keyID:date 或 keyID:tool:date
+			head, ok := strings.CutSuffix(rest, ":"+today)
+			if !ok {
+				continue
+			}
+			if keyID, tool, isTool := strings.Cut(head, ":"); isTool {
+				ks := keyState(keyID)
+				if ks.ToolDaily == nil {
+					ks.ToolDaily = make(map[string]int)
+				}
+				ks.ToolDaily[tool] = get(name)
+			} else {
+				keyState(head).DailyUsed = get(name)
+			}
+		case "conc":
+			if v := get(name); v > 0 {
+				keyState(rest).Concurrent = v
+			}
+		case "err":
+			if v := get(name); v > 0 {
+				keyState(rest).ErrorCount = v
+			}
+		case "ban":
+			if v, err := l.client.do("PTTL", name); err == nil && redisInt(v) > 0 {
+				until := now.Add(time.Duration(redisInt(v)) * time.Millisecond)
+				keyState(rest).BannedUntil = &until
+			}
+		case "tpm":
+			used := l.tokensInWindow(name, windowStart)
+			if used == 0 {
+				continue
+			}
+			if keyID, ok := strings.CutPrefix(rest, KeyTokenBucket("")); ok {
+				keyState(keyID).TPMUsed = used
+			} else if sourceID, ok := strings.CutPrefix(rest, SourceTokenBucket("")); ok {
+				sourceState(sourceID).TPMUsed = used
+			}
+		}
+	}
+
+	for _, ks := range keys {
+		state.Keys = append(state.Keys, *ks)
+	}
+	for _, ss := range sources {
+		state.Sources = append(state.Sources, *ss)
+	}
+	sort.Slice(state.Keys, func(i, j int) bool { return state.Keys[i].KeyID < state.Keys[j].KeyID })
+	sort.Slice(state.Sources, func(i, j int) bool { return state.Sources[i].SourceID < state.Sources[j].SourceID })
+	return state
+}
+
+// scanKeys 以 SCAN 游标分批遍历匹配 pattern 的键（不使用阻塞 Redis 的 KEYS），结果已去重
+func (l *RedisLimiter) scanKeys(pattern string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := l.client.do("SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return nil, err
+		}
+		page, _ := reply.([]any)
+		if len(page) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply")
+		}
+		cursor, _ = page[0].(string)
+		batch, _ := page[1].([]any)
+		for _, k := range batch {
+			if name, ok := k.(string); ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return names, nil
+		}
+	}
+}
+
+// tokensInWindow 统计 TPM 键中窗口内的 token 数
+func (l *RedisLimiter) tokensInWindow(name string, windowStart time.Time) int {
+	reply, err := l.client.do("ZRANGE", name, "0", "-1", "WITHSCORES")
+	if err != nil {
+		return 0
+	}
+	items, _ := reply.([]any)
+	cutoff := windowStart.UnixMilli()
+	total := 0
+	for i := 0; i+1 < len(items); i += 2 {
+		member, _ := items[i].(string)
+		score, _ := items[i+1].(string)
+		if ms, err := strconv.ParseFloat(score, 64); err == nil && int64(ms) > cutoff {
+			total += parseTokenMember(member)
+		}
+	}
+	return total
+}
+
+// redisScore 时间转换为有序集合分数（毫秒）
+func redisScore(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// tokenMember TPM 有序集合成员：tokens|id|nonce（nonce 保证成员唯一）
+func tokenMember(tokens int, id string) string {
+	return strconv.Itoa(tokens) + "|" + id + "|" + generateID()
+}
+
+// parseTokenMember 解析成员中的 token 数
+func parseTokenMember(member string) int {
+	tokens, _, _ := strings.Cut(member, "|")
+	n, _ := strconv.Atoi(tokens)
+	return n
+}
+
+// tokenMemberID 解析成员中的预占 ID
+func tokenMemberID(member string) string {
+	_, rest, _ := strings.Cut(member, "|")
+	id, _, _ := strings.Cut(rest, "|")
+	return id
+}
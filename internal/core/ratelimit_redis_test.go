@@ -0,0 +1,443 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// fakeScanPage fakeRedis 的 SCAN 每页返回的键数
+const fakeScanPage = 2
+
+// fakeRedis 测试用的 Redis 协议服务，实现限流器用到的命令子集
+type fakeRedis struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	strs    map[string]string
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{
+		ln:      ln,
+		strs:    make(map[string]string),
+		zsets:   make(map[string]map[string]float64),
+		expires: make(map[string]time.Time),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var queued [][]string
+	inMulti := false
+	for {
+		reply, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, it := range items {
+			args[i], _ = it.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		var out string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "MULTI":
+			inMulti, queued = true, nil
+			out = "+OK\r\n"
+		case cmd == "EXEC":
+			f.mu.Lock()
+			var b strings.Builder
+			fmt.Fprintf(&b, "*%d\r\n", len(queued))
+			for _, q := range queued {
+				b.WriteString(f.exec(q))
+			}
+			f.mu.Unlock()
+			inMulti = false
+			out = b.String()
+		case inMulti:
+			queued = append(queued, args)
+			out = "+QUEUED\r\n"
+		default:
+			f.mu.Lock()
+			out = f.exec(args)
+			f.mu.Unlock()
+		}
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+// expire 惰性删除过期键（调用方持有锁）
+func (f *fakeRedis) expire(key string) {
+	if at, ok := f.expires[key]; ok && !time.Now().Before(at) {
+		delete(f.strs, key)
+		delete(f.zsets, key)
+		delete(f.expires, key)
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	cmd := strings.ToUpper(args[0])
+	if len(args) > 1 {
+		f.expire(args[1])
+	}
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.strs[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "SET":
+		f.strs[args[1]] = args[2]
+		delete(f.expires, args[1])
+		if len(args) == 5 && strings.EqualFold(args[3], "PX") {
+			ms, _ := strconv.Atoi(args[4])
+			f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "INCR", "DECR":
+		n, _ := strconv.ParseInt(f.strs[args[1]], 10, 64)
+		if cmd == "INCR" {
+			n++
+		} else {
+			n--
+		}
+		f.strs[args[1]] = strconv.FormatInt(n, 10)
+		return fmt.Sprintf(":%d\r\n", n)
+	case "DEL":
+		_, s := f.strs[args[1]]
+		_, z := f.zsets[args[1]]
+		delete(f.strs, args[1])
+		delete(f.zsets, args[1])
+		delete(f.expires, args[1])
+		if s || z {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "PEXPIRE":
+		ms, _ := strconv.Atoi(args[2])
+		f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case "PTTL":
+		_, s := f.strs[args[1]]
+		_, z := f.zsets[args[1]]
+		if !s && !z {
+			return ":-2\r\n"
+		}
+		at, ok := f.expires[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(at).Milliseconds())
+	case "ZADD":
+		score, _ := strconv.ParseFloat(args[2], 64)
+		z := f.zsets[args[1]]
+		if z == nil {
+			z = make(map[string]float64)
+			f.zsets[args[1]] = z
+		}
+		z[args[3]] = score
+		return ":1\r\n"
+	case "ZREM":
+		delete(f.zsets[args[1]], args[2])
+		return ":1\r\n"
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(f.zsets[args[1]]))
+	case "ZREMRANGEBYSCORE", "ZCOUNT":
+		min, minExcl := parseScoreBound(args[2])
+		max, maxExcl := parseScoreBound(args[3])
+		n := 0
+		for m, s := range f.zsets[args[1]] {
+			if (s > min || (!minExcl && s == min)) && (s < max || (!maxExcl && s == max)) {
+				n++
+				if cmd == "ZREMRANGEBYSCORE" {
+					delete(f.zsets[args[1]], m)
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "ZRANGE":
+		z := f.zsets[args[1]]
+		members := make([]string, 0, len(z))
+		for m := range z {
+			members = append(members, m)
+		}
+		sort.Slice(members, func(i, j int) bool { return z[members[i]] < z[members[j]] })
+		withScores := len(args) > 4 && strings.EqualFold(args[4], "WITHSCORES")
+		var b strings.Builder
+		if withScores {
+			fmt.Fprintf(&b, "*%d\r\n", len(members)*2)
+		} else {
+			fmt.Fprintf(&b, "*%d\r\n", len(members))
+		}
+		for _, m := range members {
+			b.WriteString(bulk(m))
+			if withScores {
+				b.WriteString(bulk(strconv.FormatFloat(z[m], 'f', -1, 64)))
+			}
+		}
+		return b.String()
+	case "SCAN":
+		// SCAN cursor MATCH pattern COUNT n：游标为已排序键的偏移量，每页最多 fakeScanPage 个，便于覆盖多页遍历
+		var names []string
+		for k := range f.strs {
+			names = append(names, k)
+		}
+		for k := range f.zsets {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var matched []string
+		for _, k := range names {
+			f.expire(k)
+			_, s := f.strs[k]
+			_, z := f.zsets[k]
+			if ok, _ := path.Match(args[3], k); ok && (s || z) {
+				matched = append(matched, k)
+			}
+		}
+		start, _ := strconv.Atoi(args[1])
+		if start > len(matched) {
+			start = len(matched)
+		}
+		end, next := start+fakeScanPage, "0"
+		if end < len(matched) {
+			next = strconv.Itoa(end)
+		} else {
+			end = len(matched)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "*2\r\n%s*%d\r\n", bulk(next), end-start)
+		for _, k := range matched[start:end] {
+			b.WriteString(bulk(k))
+		}
+		return b.String()
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func parseScoreBound(s string) (float64, bool) {
+	switch s {
+	case "-inf":
+		return -1e308, false
+	case "+inf":
+		return 1e308, false
+	}
+	excl := strings.HasPrefix(s, "(")
+	v, _ := strconv.ParseFloat(strings.TrimPrefix(s, "("), 64)
+	return v, excl
+}
+
+func newTestRedisLimiter(t *testing.T) (*RedisLimiter, *RedisLimiter) {
+	t.Helper()
+	f := newFakeRedis(t)
+	// 两个实例共享同一个 Redis，模拟多副本部署
+	a := NewRedisLimiter(f.addr(), "", 0, "test:", time.Second)
+	b := NewRedisLimiter(f.addr(), "", 0, "test:", time.Second)
+	return a, b
+}
+
+func TestRedisLimiter_SharedRPM(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+	limits := model.KeyLimits{RPM: 3}
+
+	for i, l := range []*RedisLimiter{a, b, a} {
+		if ok, reason := l.AllowWithTool("key-1", limits, ""); !ok {
+			t.Fatalf("request %d rejected: %s", i, reason)
+		}
+	}
+	if ok, _ := b.AllowWithTool("key-1", limits, ""); ok {
+		t.Error("expected RPM to be shared across instances")
+	}
+	if state := a.State(); len(state.Keys) != 1 || state.Keys[0].RPMUsed != 3 {
+		t.Errorf("unexpected state: %+v", state.Keys)
+	}
+}
+
+func TestRedisLimiter_DailyQuotaRollsBackRPM(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+	limits := model.KeyLimits{RPM: 10, DailyQuota: 1}
+
+	if ok, _ := a.AllowWithTool("key-1", limits, ""); !ok {
+		t.Fatal("expected first request to pass")
+	}
+	if ok, reason := b.AllowWithTool("key-1", limits, ""); ok || !strings.Contains(reason, "Daily quota") {
+		t.Fatalf("expected daily quota rejection, got %v %q", ok, reason)
+	}
+	state := a.State()
+	if len(state.Keys) != 1 || state.Keys[0].RPMUsed != 1 || state.Keys[0].DailyUsed != 1 {
+		t.Errorf("expected rejected request to be rolled back, got %+v", state.Keys)
+	}
+}
+
+func TestRedisLimiter_Concurrent(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+
+	if ok, _ := a.AcquireConcurrent("key-1", 2); !ok {
+		t.Fatal("expected first acquire to pass")
+	}
+	if ok, _ := b.AcquireConcurrent("key-1", 2); !ok {
+		t.Fatal("expected second acquire to pass")
+	}
+	if ok, _ := a.AcquireConcurrent("key-1", 2); ok {
+		t.Error("expected concurrency to be shared across instances")
+	}
+	b.ReleaseConcurrent("key-1")
+	if ok, _ := a.AcquireConcurrent("key-1", 2); !ok {
+		t.Error("expected acquire to pass after release")
+	}
+}
+
+func TestRedisLimiter_ConcurrentAtomic(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 20; i++ {
+		l := a
+		if i%2 == 1 {
+			l = b
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := l.AcquireConcurrent("key-1", 5); ok {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 5 {
+		t.Errorf("expected exactly 5 concurrent grants, got %d", granted)
+	}
+}
+
+func TestRedisLimiter_TokensAndReconcile(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+	bucket := SourceTokenBucket("src-1")
+
+	if ok, _ := a.ReserveTokens(bucket, "req-1", 1000, 900); !ok {
+		t.Fatal("expected first reservation to pass")
+	}
+	if ok, _ := b.ReserveTokens(bucket, "req-2", 1000, 500); ok {
+		t.Fatal("expected reservation over the shared TPM limit to be rejected")
+	}
+	a.ReconcileTokens(bucket, "req-1", 200)
+	if ok, reason := b.ReserveTokens(bucket, "req-3", 1000, 500); !ok {
+		t.Fatalf("expected reservation to pass after reconcile: %s", reason)
+	}
+	if state := b.State(); len(state.Sources) != 1 || state.Sources[0].TPMUsed != 700 {
+		t.Errorf("unexpected source state: %+v", state.Sources)
+	}
+}
+
+func TestRedisLimiter_AcquireSource(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+	limits := &model.SourceLimits{RPM: 10, MaxConcurrent: 1, TPM: 1000}
+
+	if ok, reason := a.AcquireSource("src-1", "req-1", limits, 100); !ok {
+		t.Fatalf("expected first acquire to pass: %s", reason)
+	}
+	if !b.SourceSaturated("src-1", limits, 100) {
+		t.Error("expected saturation to be shared across instances")
+	}
+	if ok, reason := b.AcquireSource("src-1", "req-2", limits, 100); ok || !strings.Contains(reason, "concurrent") {
+		t.Fatalf("expected concurrency rejection, got %v %q", ok, reason)
+	}
+
+	// 被拒绝的请求不应占用 RPM
+	state := a.State()
+	if len(state.Sources) != 1 || state.Sources[0].RPMUsed != 1 || state.Sources[0].Concurrent != 1 || state.Sources[0].TPMUsed != 100 {
+		t.Errorf("unexpected source state: %+v", state.Sources)
+	}
+
+	a.ReleaseSource("src-1")
+	if ok, reason := b.AcquireSource("src-1", "req-3", limits, 950); ok || !strings.Contains(reason, "TPM") {
+		t.Fatalf("expected TPM rejection, got %v %q", ok, reason)
+	}
+	if ok, reason := b.AcquireSource("src-1", "req-4", limits, 100); !ok {
+		t.Errorf("expected TPM rejection to release concurrency and RPM: %s", reason)
+	}
+}
+
+func TestRedisLimiter_AutoBan(t *testing.T) {
+	a, b := newTestRedisLimiter(t)
+
+	for i := 0; i < AutoBanThreshold-1; i++ {
+		if a.RecordError("key-1") {
+			t.Fatalf("unexpected ban after %d errors", i+1)
+		}
+	}
+	if !b.RecordError("key-1") {
+		t.Fatal("expected ban at threshold")
+	}
+	if banned, remaining := a.IsAutoBanned("key-1"); !banned || remaining <= 0 {
+		t.Errorf("expected key to be banned on other instance, got %v %v", banned, remaining)
+	}
+}
+
+func TestRedisLimiter_FailOpen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l := NewRedisLimiter(addr, "", 0, "test:", 100*time.Millisecond)
+	if ok, _ := l.AllowWithTool("key-1", model.KeyLimits{RPM: 1}, ""); !ok {
+		t.Error("expected requests to be allowed when redis is unreachable")
+	}
+	if err := l.Ping(); err == nil {
+		t.Error("expected ping to fail")
+	}
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestRateLimiter_RPM(t *testing.T) {
+	rl := NewRateLimiter()
+	limits := model.KeyLimits{RPM: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, reason := rl.Allow("key-1", limits); !ok {
+			t.Fatalf("request %d rejected: %s", i, reason)
+		}
+	}
+	if ok, _ := rl.Allow("key-1", limits); ok {
+		t.Error("expected third request to exceed RPM")
+	}
+	if ok, _ := rl.Allow("key-2", limits); !ok {
+		t.Error("expected other key to be unaffected")
+	}
+}
+
+func TestRateLimiter_ReserveTokens(t *testing.T) {
+	rl := NewRateLimiter()
+	bucket := KeyTokenBucket("key-1")
+
+	if ok, _ := rl.ReserveTokens(bucket, "req-1", 1000, 600); !ok {
+		t.Fatal("expected first reservation to pass")
+	}
+	if ok, _ := rl.ReserveTokens(bucket, "req-2", 1000, 500); ok {
+		t.Error("expected reservation over the TPM limit to be rejected")
+	}
+	if ok, _ := rl.ReserveTokens(bucket, "req-3", 1000, 400); !ok {
+		t.Error("expected reservation within the TPM limit to pass")
+	}
+	if used := rl.TokensUsed(bucket); used != 1000 {
+		t.Errorf("expected 1000 tokens used, got %d", used)
+	}
+}
+
+func TestRateLimiter_ReconcileTokens(t *testing.T) {
+	rl := NewRateLimiter()
+	bucket := SourceTokenBucket("src-1")
+
+	rl.ReserveTokens(bucket, "req-1", 1000, 900)
+	rl.ReconcileTokens(bucket, "req-1", 200)
+	if used := rl.TokensUsed(bucket); used != 200 {
+		t.Fatalf("expected 200 tokens after reconcile, got %d", used)
+	}
+	if ok, _ := rl.ReserveTokens(bucket, "req-2", 1000, 700); !ok {
+		t.Error("expected reservation to pass after reconcile freed capacity")
+	}
+}
+
+func TestRateLimiter_ReserveTokensEmptyWindow(t *testing.T) {
+	rl := NewRateLimiter()
+	if ok, _ := rl.ReserveTokens(KeyTokenBucket("key-1"), "req-1", 100, 5000); !ok {
+		t.Error("expected oversized request to pass into an empty window")
+	}
+	if ok, _ := rl.ReserveTokens(KeyTokenBucket("key-1"), "req-2", 100, 1); ok {
+		t.Error("expected window to be full after oversized request")
+	}
+}
+
+func TestRateLimiter_AcquireSource(t *testing.T) {
+	rl := NewRateLimiter()
+	limits := &model.SourceLimits{RPM: 3, MaxConcurrent: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, reason := rl.AcquireSource("src-1", "", limits, 10); !ok {
+			t.Fatalf("acquire %d rejected: %s", i, reason)
+		}
+	}
+	if !rl.SourceSaturated("src-1", limits, 10) {
+		t.Error("expected source to be saturated at max concurrency")
+	}
+	if ok, _ := rl.AcquireSource("src-1", "", limits, 10); ok {
+		t.Error("expected acquire over max concurrency to be rejected")
+	}
+
+	rl.ReleaseSource("src-1")
+	if ok, reason := rl.AcquireSource("src-1", "", limits, 10); !ok {
+		t.Fatalf("expected acquire to pass after release: %s", reason)
+	}
+	rl.ReleaseSource("src-1")
+	if ok, reason := rl.AcquireSource("src-1", "", limits, 10); ok || reason == "" {
+		t.Error("expected fourth request in the minute to exceed source RPM")
+	}
+
+	state := rl.State()
+	if len(state.Sources) != 1 || state.Sources[0].RPMUsed != 3 || state.Sources[0].Concurrent != 1 {
+		t.Errorf("unexpected source state: %+v", state.Sources)
+	}
+}
+
+func TestRateLimiter_SnapshotRestore(t *testing.T) {
+	rl := NewRateLimiter()
+	limits := model.KeyLimits{RPM: 10, DailyQuota: 5, ToolQuotas: map[string]int{"cursor": 3}}
+	rl.AllowWithTool("key-1", limits, "cursor")
+	rl.AllowWithTool("key-1", limits, "cursor")
+	rl.ReserveTokens(KeyTokenBucket("key-1"), "req-1", 1000, 300)
+	for i := 0; i < AutoBanThreshold; i++ {
+		rl.RecordError("key-2")
+	}
+
+	restored := NewRateLimiter()
+	restored.Restore(rl.Snapshot())
+
+	state := restored.State()
+	if len(state.Keys) != 2 {
+		t.Fatalf("expected 2 keys in state, got %+v", state.Keys)
+	}
+	k1 := state.Keys[0]
+	if k1.KeyID != "key-1" || k1.RPMUsed != 2 || k1.DailyUsed != 2 || k1.ToolDaily["cursor"] != 2 || k1.TPMUsed != 300 {
+		t.Errorf("unexpected key-1 state: %+v", k1)
+	}
+	k2 := state.Keys[1]
+	if k2.KeyID != "key-2" || k2.BannedUntil == nil || k2.ErrorCount != AutoBanThreshold {
+		t.Errorf("unexpected key-2 state: %+v", k2)
+	}
+	if banned, _ := restored.IsAutoBanned("key-2"); !banned {
+		t.Error("expected auto-ban to survive restore")
+	}
+
+	// 日配额在恢复后继续累计
+	restored.Allow("key-1", limits)
+	restored.Allow("key-1", limits)
+	restored.Allow("key-1", limits)
+	if ok, _ := restored.Allow("key-1", limits); ok {
+		t.Error("expected daily quota to be exhausted after restore")
+	}
+}
+
+func TestRateLimiter_RestoreDropsExpired(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	snap := &model.RateLimitSnapshot{
+		Windows:    map[string][]time.Time{"key-1": {old}},
+		DailyCount: map[string]int{"key-1:" + old.Format("2006-01-02"): 100},
+		AutoBanned: map[string]time.Time{"key-1": old},
+		Tokens:     map[string][]model.TokenUsage{KeyTokenBucket("key-1"): {{ID: "req-1", At: old, Tokens: 500}}},
+	}
+
+	rl := NewRateLimiter()
+	rl.Restore(snap)
+	if state := rl.State(); len(state.Keys) != 0 {
+		t.Errorf("expected expired state to be dropped, got %+v", state.Keys)
+	}
+}
+
+func TestRateLimiter_DailyChanges(t *testing.T) {
+	rl := NewRateLimiter()
+
+	// 只有分钟级窗口的请求不触发通知
+	rl.Allow("key-1", model.KeyLimits{RPM: 10})
+	select {
+	case <-rl.DailyChanges():
+		t.Fatal("expected no notification for RPM-only limits")
+	default:
+	}
+
+	// 多次日配额变化合并为一个通知
+	rl.Allow("key-1", model.KeyLimits{DailyQuota: 10})
+	rl.Allow("key-1", model.KeyLimits{DailyQuota: 10})
+	select {
+	case <-rl.DailyChanges():
+	default:
+		t.Fatal("expected a notification after the daily count changed")
+	}
+	select {
+	case <-rl.DailyChanges():
+		t.Fatal("expected pending notifications to be merged")
+	default:
+	}
+}
@@ -0,0 +1,240 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisMaxIdleConns 连接池保留的空闲连接数
+const redisMaxIdleConns = 8
+
+// redisError Redis 返回的错误回复
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisClient 最小化的 RESP2 客户端，只实现限流所需的命令调用与 MULTI/EXEC
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+// redisConn 单条 Redis 连接
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient 创建 Redis 客户端（连接按需建立）
+func newRedisClient(addr, password string, db int, timeout time.Duration) *redisClient {
+	return &redisClient{
+		addr:     addr,
+		password: password,
+		db:       db,
+		timeout:  timeout,
+		idle:     make(chan *redisConn, redisMaxIdleConns),
+	}
+}
+
+// do 执行单条命令
+func (c *redisClient) do(args ...string) (any, error) {
+	replies, err := c.pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := replies[0].(redisError); ok {
+		return nil, e
+	}
+	return replies[0], nil
+}
+
+// multi 在 MULTI/EXEC 事务中原子执行多条命令，返回各命令的回复
+func (c *redisClient) multi(cmds ...[]string) ([]any, error) {
+	batch := make([][]string, 0, len(cmds)+2)
+	batch = append(batch, []string{"MULTI"})
+	batch = append(batch, cmds...)
+	batch = append(batch, []string{"EXEC"})
+
+	replies, err := c.pipeline(batch)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range replies[:len(replies)-1] {
+		if e, ok := r.(redisError); ok {
+			return nil, e
+		}
+	}
+	results, ok := replies[len(replies)-1].([]any)
+	if !ok {
+		if e, isErr := replies[len(replies)-1].(redisError); isErr {
+			return nil, e
+		}
+		return nil, errors.New("redis: transaction aborted")
+	}
+	return results, nil
+}
+
+// pipeline 在同一连接上依次发送命令并读取全部回复；错误回复作为 redisError 值返回
+func (c *redisClient) pipeline(cmds [][]string) ([]any, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.conn.SetDeadline(time.Now().Add(c.timeout))
+	var buf strings.Builder
+	for _, args := range cmds {
+		writeRESPCommand(&buf, args)
+	}
+	if _, err := conn.conn.Write([]byte(buf.String())); err != nil {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	replies := make([]any, 0, len(cmds))
+	for range cmds {
+		reply, err := readRESP(conn.r)
+		if err != nil {
+			conn.conn.Close()
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	c.put(conn)
+	return replies, nil
+}
+
+// get 取出空闲连接或新建连接（新连接完成 AUTH / SELECT）
+func (c *redisClient) get() (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: nc, r: bufio.NewReader(nc)}
+
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) > 0 {
+		nc.SetDeadline(time.Now().Add(c.timeout))
+		var buf strings.Builder
+		for _, args := range setup {
+			writeRESPCommand(&buf, args)
+		}
+		if _, err := nc.Write([]byte(buf.String())); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		for range setup {
+			reply, err := readRESP(conn.r)
+			if err == nil {
+				if e, ok := reply.(redisError); ok {
+					err = e
+				}
+			}
+			if err != nil {
+				nc.Close()
+				return nil, fmt.Errorf("redis setup: %w", err)
+			}
+		}
+	}
+	return conn, nil
+}
+
+// put 归还连接，池满时关闭
+func (c *redisClient) put(conn *redisConn) {
+	select {
+	case c.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// writeRESPCommand 以 RESP 数组格式编码命令
+func writeRESPCommand(b *strings.Builder, args []string) {
+	fmt.Fprintf(b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(b, "$%d\r\n%s\r\n", len(a), a)
+	}
+}
+
+// readRESP 读取一条 RESP2 回复：string、int64、nil、[]any 或 redisError
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// redisInt 将回复转换为整数（兼容字符串形式的数字）
+func redisInt(v any) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
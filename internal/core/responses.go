@@ -0,0 +1,469 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// 入口协议为 OpenAI Responses API 时的转换：
+// 请求 Responses -> OpenAI Chat（走统一路由/failover），响应 OpenAI Chat -> Responses
+
+// ErrStatefulResponse 引用上一次响应的请求需要上游保存的会话状态，无法转换为无状态的聊天请求，
+// 只能原样透传到原生支持 responses 的源
+var ErrStatefulResponse = errors.New("previous_response_id requires a source with native responses support; send the full conversation in input")
+
+// FromResponsesRequest 将 Responses 请求转换为内部 ChatCompletionRequest
+// 内置工具（web_search 等）与 reasoning 条目没有对应的聊天请求字段，转换时忽略；
+// previous_response_id 由调用方限定只路由到原生透传的源，转换结果不会被发送
+func (t *Translator) FromResponsesRequest(req *model.ResponsesRequest) (*model.ChatCompletionRequest, error) {
+	out := &model.ChatCompletionRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxOutputTokens,
+		Stream:      req.Stream,
+		User:        req.User,
+	}
+	if req.Stream {
+		// response.completed 事件携带 usage，需要上游返回
+		out.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+
+	if req.Instructions != "" {
+		out.Messages = append(out.Messages, model.Message{Role: "system", Content: req.Instructions})
+	}
+	for _, item := range req.Input {
+		out.Messages = appendResponsesItem(out.Messages, item)
+	}
+
+	for _, tool := range req.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+		out.Tools = append(out.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if len(out.Tools) > 0 && len(req.ToolChoice) > 0 {
+		out.ToolChoice = fromResponsesToolChoice(req.ToolChoice)
+	}
+
+	if req.Text != nil && req.Text.Format != nil {
+		switch f := req.Text.Format; f.Type {
+		case "json_object":
+			out.ResponseFormat = &model.ResponseFormat{Type: "json_object"}
+		case "json_schema":
+			schema := map[string]any{"name": f.Name, "schema": f.Schema}
+			if f.Description != "" {
+				schema["description"] = f.Description
+			}
+			if f.Strict != nil {
+				schema["strict"] = *f.Strict
+			}
+			out.ResponseFormat = &model.ResponseFormat{Type: "json_schema", JSONSchema: schema}
+		}
+	}
+
+	return out, nil
+}
+
+// appendResponsesItem 将一个输入条目追加为聊天消息
+// 连续的 function_call 合并到同一条 assistant 消息的 tool_calls 中
+func appendResponsesItem(messages []model.Message, item model.ResponsesItem) []model.Message {
+	switch item.Type {
+	case "", "message":
+		role := item.Role
+		if role == "" {
+			role = "user"
+		}
+		return append(messages, model.Message{Role: role, Content: fromResponsesContent(item.Content)})
+	case "function_call":
+		args := item.Arguments
+		if args == "" {
+			args = "{}"
+		}
+		call := model.ToolCall{
+			ID:       item.CallID,
+			Type:     "function",
+			Function: model.FunctionCall{Name: item.Name, Arguments: args},
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == "assistant" {
+			messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+			return messages
+		}
+		return append(messages, model.Message{Role: "assistant", ToolCalls: []model.ToolCall{call}})
+	case "function_call_output":
+		return append(messages, model.Message{
+			Role:       "tool",
+			ToolCallID: item.CallID,
+			Content:    responsesOutputText(item.Output),
+		})
+	default:
+		return messages
+	}
+}
+
+// fromResponsesContent 转换消息内容：纯文本时为字符串，含图片时为多模态数组
+func fromResponsesContent(content model.ResponsesContent) any {
+	hasImage := false
+	for _, p := range content {
+		if p.Type == "input_image" && p.ImageURL != "" {
+			hasImage = true
+		}
+	}
+	if !hasImage {
+		return content.Text()
+	}
+
+	var parts []any
+	for _, p := range content {
+		switch p.Type {
+		case "input_text", "output_text", "text":
+			parts = append(parts, map[string]any{"type": "text", "text": p.Text})
+		case "input_image":
+			if p.ImageURL == "" {
+				continue
+			}
+			image := map[string]any{"url": p.ImageURL}
+			if p.Detail != "" {
+				image["detail"] = p.Detail
+			}
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": image})
+		}
+	}
+	return parts
+}
+
+// responsesOutputText 提取 function_call_output 的输出文本（字符串或内容数组）
+func responsesOutputText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var content model.ResponsesContent
+	if json.Unmarshal(raw, &content) == nil {
+		return content.Text()
+	}
+	return string(raw)
+}
+
+// fromResponsesToolChoice 转换 tool_choice：字符串原样保留，{"type":"function","name":...} 转为聊天格式
+func fromResponsesToolChoice(raw json.RawMessage) any {
+	var mode string
+	if json.Unmarshal(raw, &mode) == nil {
+		return mode
+	}
+	var choice struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if json.Unmarshal(raw, &choice) == nil && choice.Type == "function" && choice.Name != "" {
+		return map[string]any{"type": "function", "function": map[string]any{"name": choice.Name}}
+	}
+	return "auto"
+}
+
+// ToResponsesResponse 将 OpenAI 聊天响应转换为 Responses 响应
+func (t *Translator) ToResponsesResponse(resp *model.ChatCompletionResponse) *model.Response {
+	out := &model.Response{
+		ID:        responsesID("resp_", resp.ID),
+		Object:    "response",
+		CreatedAt: resp.Created,
+		Status:    "completed",
+		Model:     resp.Model,
+		Output:    []model.ResponsesOutputItem{},
+		Usage:     toResponsesUsage(resp.Usage),
+	}
+	if out.CreatedAt == 0 {
+		out.CreatedAt = time.Now().Unix()
+	}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if choice.Message != nil {
+		if text := contentText(choice.Message.Content); text != "" {
+			out.Output = append(out.Output, model.ResponsesOutputItem{
+				Type:    "message",
+				ID:      responsesID("msg_", resp.ID),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []model.ResponsesOutputContent{{Type: "output_text", Text: text, Annotations: []any{}}},
+			})
+		}
+		for _, tc := range choice.Message.ToolCalls {
+			out.Output = append(out.Output, model.ResponsesOutputItem{
+				Type:      "function_call",
+				ID:        responsesID("fc_", tc.ID),
+				Status:    "completed",
+				CallID:    tc.ID,
+				Name:      tc.Function.Name,
+				Arguments: tc.Function.Arguments,
+			})
+		}
+	}
+	out.Status, out.IncompleteDetails = responsesStatus(choice.FinishReason)
+	return out
+}
+
+// responsesStatus 映射 finish_reason 到响应状态
+func responsesStatus(reason string) (string, *model.ResponsesIncompleteDetails) {
+	switch reason {
+	case "length":
+		return "incomplete", &model.ResponsesIncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		return "incomplete", &model.ResponsesIncompleteDetails{Reason: "content_filter"}
+	default:
+		return "completed", nil
+	}
+}
+
+// toResponsesUsage 转换 usage
+func toResponsesUsage(u *model.Usage) *model.ResponsesUsage {
+	if u == nil {
+		return nil
+	}
+	return &model.ResponsesUsage{
+		InputTokens:        u.PromptTokens,
+		InputTokensDetails: model.ResponsesInputTokensDetails{CachedTokens: u.CachedTokens()},
+		OutputTokens:       u.CompletionTokens,
+		TotalTokens:        u.TotalTokens,
+	}
+}
+
+// responsesID 为上游 ID 加上 Responses API 的类型前缀
+func responsesID(prefix, id string) string {
+	if strings.HasPrefix(id, prefix) {
+		return id
+	}
+	return prefix + id
+}
+
+// ResponsesSSEEvent Responses 流式事件（event 名 + data）
+type ResponsesSSEEvent struct {
+	Event string
+	Data  []byte
+}
+
+// ResponsesStreamEncoder 将 OpenAI 流式块编码为 Responses 事件流
+type ResponsesStreamEncoder struct {
+	started  bool
+	finished bool
+	seq      int
+	resp     model.Response
+	text     int         // 当前打开的 message 条目在 output 中的序号，-1 表示没有
+	tools    map[int]int // tool_calls index -> output 序号
+	toolIDs  map[int]string
+	open     []int // 尚未结束的 function_call 条目
+	reason   string
+}
+
+// NewResponsesStreamEncoder 创建 Responses 流式编码器
+func (t *Translator) NewResponsesStreamEncoder() *ResponsesStreamEncoder {
+	return &ResponsesStreamEncoder{
+		text:    -1,
+		tools:   make(map[int]int),
+		toolIDs: make(map[int]string),
+	}
+}
+
+// Encode 编码一帧 OpenAI data（chunk JSON 或 [DONE]）
+func (e *ResponsesStreamEncoder) Encode(data string) []ResponsesSSEEvent {
+	if e.finished {
+		return nil
+	}
+	if data == "[DONE]" {
+		return e.Finish()
+	}
+
+	var chunk model.StreamChunk
+	if json.Unmarshal([]byte(data), &chunk) != nil {
+		return nil
+	}
+
+	var events []ResponsesSSEEvent
+	if !e.started {
+		e.resp.ID = responsesID("resp_", chunk.ID)
+		e.resp.Model = chunk.Model
+		e.resp.CreatedAt = chunk.Created
+		events = append(events, e.start()...)
+	}
+	if chunk.Usage != nil {
+		e.resp.Usage = toResponsesUsage(chunk.Usage)
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta != nil {
+			if text, ok := choice.Delta.Content.(string); ok && text != "" {
+				events = append(events, e.appendText(text)...)
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				events = append(events, e.appendToolCall(tc)...)
+			}
+		}
+		if choice.FinishReason != "" {
+			e.reason = choice.FinishReason
+		}
+	}
+	return events
+}
+
+// Finish 结束事件流（结束打开的条目并发送 response.completed / response.incomplete）
+func (e *ResponsesStreamEncoder) Finish() []ResponsesSSEEvent {
+	if e.finished {
+		return nil
+	}
+	var events []ResponsesSSEEvent
+	if !e.started {
+		events = append(events, e.start()...)
+	}
+	events = append(events, e.closeText()...)
+	for _, idx := range e.open {
+		item := e.resp.Output[idx]
+		if item.Arguments == "" {
+			item.Arguments = "{}"
+		}
+		item.Status = "completed"
+		e.resp.Output[idx] = item
+		events = append(events,
+			e.event("response.function_call_arguments.done", map[string]any{
+				"item_id": item.ID, "output_index": idx, "arguments": item.Arguments,
+			}),
+			e.event("response.output_item.done", map[string]any{"output_index": idx, "item": item}),
+		)
+	}
+	e.open = nil
+
+	e.resp.Status, e.resp.IncompleteDetails = responsesStatus(e.reason)
+	name := "response.completed"
+	if e.resp.Status == "incomplete" {
+		name = "response.incomplete"
+	}
+	events = append(events, e.event(name, map[string]any{"response": e.resp}))
+	e.finished = true
+	return events
+}
+
+// start 生成 response.created 与 response.in_progress 事件
+func (e *ResponsesStreamEncoder) start() []ResponsesSSEEvent {
+	e.started = true
+	e.resp.Object = "response"
+	e.resp.Status = "in_progress"
+	e.resp.Output = []model.ResponsesOutputItem{}
+	if e.resp.CreatedAt == 0 {
+		e.resp.CreatedAt = time.Now().Unix()
+	}
+	return []ResponsesSSEEvent{
+		e.event("response.created", map[string]any{"response": e.resp}),
+		e.event("response.in_progress", map[string]any{"response": e.resp}),
+	}
+}
+
+// appendText 追加文本增量，必要时先打开 message 条目
+func (e *ResponsesStreamEncoder) appendText(text string) []ResponsesSSEEvent {
+	var events []ResponsesSSEEvent
+	if e.text < 0 {
+		e.text = len(e.resp.Output)
+		item := model.ResponsesOutputItem{
+			Type:   "message",
+			ID:     responsesID("msg_", e.resp.ID+"_"+strconv.Itoa(e.text)),
+			Status: "in_progress",
+			Role:   "assistant",
+		}
+		e.resp.Output = append(e.resp.Output, item)
+		events = append(events,
+			e.event("response.output_item.added", map[string]any{"output_index": e.text, "item": item}),
+			e.event("response.content_part.added", map[string]any{
+				"item_id": item.ID, "output_index": e.text, "content_index": 0,
+				"part": model.ResponsesOutputContent{Type: "output_text", Annotations: []any{}},
+			}),
+		)
+		e.resp.Output[e.text].Content = []model.ResponsesOutputContent{{Type: "output_text", Annotations: []any{}}}
+	}
+
+	item := &e.resp.Output[e.text]
+	item.Content[0].Text += text
+	return append(events, e.event("response.output_text.delta", map[string]any{
+		"item_id": item.ID, "output_index": e.text, "content_index": 0, "delta": text,
+	}))
+}
+
+// closeText 结束当前打开的 message 条目
+func (e *ResponsesStreamEncoder) closeText() []ResponsesSSEEvent {
+	if e.text < 0 {
+		return nil
+	}
+	idx := e.text
+	e.text = -1
+	item := &e.resp.Output[idx]
+	item.Status = "completed"
+	part := item.Content[0]
+	return []ResponsesSSEEvent{
+		e.event("response.output_text.done", map[string]any{
+			"item_id": item.ID, "output_index": idx, "content_index": 0, "text": part.Text,
+		}),
+		e.event("response.content_part.done", map[string]any{
+			"item_id": item.ID, "output_index": idx, "content_index": 0, "part": part,
+		}),
+		e.event("response.output_item.done", map[string]any{"output_index": idx, "item": *item}),
+	}
+}
+
+// appendToolCall 追加工具调用增量，新的调用打开一个 function_call 条目
+func (e *ResponsesStreamEncoder) appendToolCall(tc model.ToolCall) []ResponsesSSEEvent {
+	toolIdx := 0
+	if tc.Index != nil {
+		toolIdx = *tc.Index
+	}
+
+	var events []ResponsesSSEEvent
+	if _, known := e.tools[toolIdx]; !known || (tc.ID != "" && tc.ID != e.toolIDs[toolIdx]) {
+		events = append(events, e.closeText()...)
+		idx := len(e.resp.Output)
+		e.tools[toolIdx] = idx
+		e.toolIDs[toolIdx] = tc.ID
+		e.open = append(e.open, idx)
+		item := model.ResponsesOutputItem{
+			Type:   "function_call",
+			ID:     responsesID("fc_", tc.ID),
+			Status: "in_progress",
+			CallID: tc.ID,
+			Name:   tc.Function.Name,
+		}
+		e.resp.Output = append(e.resp.Output, item)
+		events = append(events, e.event("response.output_item.added", map[string]any{"output_index": idx, "item": item}))
+	}
+
+	idx := e.tools[toolIdx]
+	if tc.Function.Arguments != "" {
+		item := &e.resp.Output[idx]
+		item.Arguments += tc.Function.Arguments
+		events = append(events, e.event("response.function_call_arguments.delta", map[string]any{
+			"item_id": item.ID, "output_index": idx, "delta": tc.Function.Arguments,
+		}))
+	}
+	return events
+}
+
+// event 构造事件，data 中自动补充 type 与 sequence_number 字段
+func (e *ResponsesStreamEncoder) event(name string, payload map[string]any) ResponsesSSEEvent {
+	payload["type"] = name
+	payload["sequence_number"] = e.seq
+	e.seq++
+	b, _ := json.Marshal(payload)
+	return ResponsesSSEEvent{Event: name, Data: b}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestFromResponsesRequest(t *testing.T) {
+	tr := NewTranslator()
+	var req model.ResponsesRequest
+	body := `{
+		"model": "gpt-4o",
+		"instructions": "Be brief.",
+		"max_output_tokens": 256,
+		"input": [
+			{"role": "user", "content": [{"type": "input_text", "text": "look"}, {"type": "input_image", "image_url": "https://example.com/a.png"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+			{"type": "reasoning", "id": "rs_1"}
+		],
+		"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object"}}, {"type": "web_search"}],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"text": {"format": {"type": "json_schema", "name": "weather", "schema": {"type": "object"}, "strict": true}}
+	}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := tr.FromResponsesRequest(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.MaxTokens == nil || *out.MaxTokens != 256 {
+		t.Errorf("expected max_output_tokens mapped to max_tokens, got %v", out.MaxTokens)
+	}
+	if len(out.Messages) != 4 {
+		t.Fatalf("expected system, user, assistant and tool messages, got %+v", out.Messages)
+	}
+	if out.Messages[0].Role != "system" || out.Messages[0].Content != "Be brief." {
+		t.Errorf("unexpected system message: %+v", out.Messages[0])
+	}
+	if parts, ok := out.Messages[1].Content.([]any); !ok || len(parts) != 2 {
+		t.Errorf("expected multimodal user content, got %+v", out.Messages[1].Content)
+	}
+	if tc := out.Messages[2].ToolCalls; out.Messages[2].Role != "assistant" || len(tc) != 1 || tc[0].ID != "call_1" || tc[0].Function.Name != "get_weather" {
+		t.Errorf("unexpected assistant tool call: %+v", out.Messages[2])
+	}
+	if out.Messages[3].Role != "tool" || out.Messages[3].ToolCallID != "call_1" || out.Messages[3].Content != "sunny" {
+		t.Errorf("unexpected tool result: %+v", out.Messages[3])
+	}
+	if len(out.Tools) != 1 || out.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("expected only function tools, got %+v", out.Tools)
+	}
+	if choice, _ := json.Marshal(out.ToolChoice); string(choice) != `{"function":{"name":"get_weather"},"type":"function"}` {
+		t.Errorf("unexpected tool_choice: %s", choice)
+	}
+	if out.ResponseFormat == nil || out.ResponseFormat.Type != "json_schema" || out.ResponseFormat.JSONSchema["name"] != "weather" {
+		t.Errorf("unexpected response_format: %+v", out.ResponseFormat)
+	}
+}
+
+func TestFromResponsesRequest_StringInputAndStateful(t *testing.T) {
+	tr := NewTranslator()
+	var req model.ResponsesRequest
+	json.Unmarshal([]byte(`{"model":"m","input":"hi","stream":true}`), &req)
+	out, err := tr.FromResponsesRequest(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 || out.Messages[0].Role != "user" || out.Messages[0].Content != "hi" {
+		t.Errorf("unexpected messages: %+v", out.Messages)
+	}
+	if out.StreamOptions == nil || !out.StreamOptions.IncludeUsage {
+		t.Error("expected stream usage to be requested")
+	}
+
+}
+
+func TestToResponsesResponse(t *testing.T) {
+	resp := &model.ChatCompletionResponse{
+		ID:    "abc",
+		Model: "gpt-4o",
+		Choices: []model.Choice{{
+			Message: &model.Message{
+				Role:      "assistant",
+				Content:   "Hello",
+				ToolCalls: []model.ToolCall{{ID: "call_1", Type: "function", Function: model.FunctionCall{Name: "f", Arguments: "{}"}}},
+			},
+			FinishReason: "length",
+		}},
+		Usage: &model.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10},
+	}
+
+	out := NewTranslator().ToResponsesResponse(resp)
+	if out.ID != "resp_abc" || out.Object != "response" || out.Status != "incomplete" || out.IncompleteDetails.Reason != "max_output_tokens" {
+		t.Errorf("unexpected response: %+v", out)
+	}
+	if len(out.Output) != 2 || out.Output[0].Content[0].Text != "Hello" || out.Output[1].CallID != "call_1" || out.Output[1].ID != "fc_call_1" {
+		t.Errorf("unexpected output: %+v", out.Output)
+	}
+	if out.Usage.InputTokens != 7 || out.Usage.OutputTokens != 3 {
+		t.Errorf("unexpected usage: %+v", out.Usage)
+	}
+}
+
+func TestResponsesStreamEncoder(t *testing.T) {
+	enc := NewTranslator().NewResponsesStreamEncoder()
+	frames := []string{
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]}}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"c1","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+		`[DONE]`,
+	}
+
+	var names []string
+	var last []byte
+	for _, f := range frames {
+		for _, ev := range enc.Encode(f) {
+			names = append(names, ev.Event)
+			last = ev.Data
+		}
+	}
+
+	want := []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.content_part.added", "response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta",
+		"response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", names, want)
+	}
+
+	var completed struct {
+		SequenceNumber int            `json:"sequence_number"`
+		Response       model.Response `json:"response"`
+	}
+	if err := json.Unmarshal(last, &completed); err != nil {
+		t.Fatal(err)
+	}
+	if completed.SequenceNumber != len(want)-1 || completed.Response.Status != "completed" || len(completed.Response.Output) != 2 {
+		t.Errorf("unexpected response.completed payload: %s", last)
+	}
+	if u := completed.Response.Usage; u == nil || u.InputTokens != 5 || u.OutputTokens != 2 {
+		t.Errorf("expected usage in response.completed, got %s", last)
+	}
+	if events := enc.Encode(`[DONE]`); len(events) != 0 {
+		t.Error("expected no events after stream finished")
+	}
+}
@@ -3,8 +3,10 @@ package core
 import (
 	"errors"
 	"sort"
+	"sync"
 	"sync/atomic"
 
+	"github.com/xiaopang/fusionapi/internal/config"
 	"github.com/xiaopang/fusionapi/internal/model"
 )
 
@@ -21,13 +23,19 @@ const (
 var (
 	ErrNoAvailableSource = errors.New("no available source")
 	ErrSourceNotFound    = errors.New("source not found")
+	ErrSourcesSaturated  = errors.New("all sources are saturated")
 )
 
 // Router 路由器
 type Router struct {
 	manager  *SourceManager
 	strategy string
-	rrIndex  uint64 // round-robin 索引
+	rrIndex  uint64         // round-robin 索引
+	limiter  Limiter        // 源级 RPM/TPM/并发限制，nil 时不检查
+	affinity *affinityTable // 粘性路由表，nil 时不启用
+
+	rulesMu sync.RWMutex
+	rules   []*model.RoutingRule // 按 Priority 排序
 }
 
 // NewRouter 创建路由器
@@ -39,84 +47,282 @@ func NewRouter(manager *SourceManager, strategy string) *Router {
 }
 
 // RouteRequest 为请求选择源
-func (r *Router) RouteRequest(req *model.ChatCompletionRequest, exclude []string) (*model.Source, error) {
+// 先匹配路由规则（可覆盖策略、限定有序源列表或排除源），未命中时使用全局策略
+func (r *Router) RouteRequest(req *model.ChatCompletionRequest, client *model.ClientInfo, exclude []string) (selected *model.Source, err error) {
 	needFC := req.HasTools()
 	needThinking := req.HasThinking()
 	needVision := req.HasVision()
 
+	rule := r.MatchRule(req, client)
+
 	// 获取符合条件的源
-	candidates := r.manager.GetByCapability(
-		needFC,
-		needThinking,
-		needVision,
-		req.Model,
-	)
+	candidates := r.candidates(needFC, needThinking, needVision, req.Model, exclude, rule)
 
 	// 如果请求包含工具但没有 FC 候选源，允许降级到非 FC 源
 	if len(candidates) == 0 && needFC {
-		candidates = r.manager.GetByCapability(
-			false,
-			needThinking,
-			needVision,
-			req.Model,
-		)
-	}
-
-	// 排除已尝试的源
-	if len(exclude) > 0 {
-		excludeMap := make(map[string]bool)
-		for _, id := range exclude {
-			excludeMap[id] = true
-		}
-		var filtered []*model.Source
-		for _, src := range candidates {
-			if !excludeMap[src.ID] {
-				filtered = append(filtered, src)
-			}
+		candidates = r.candidates(false, needThinking, needVision, req.Model, exclude, rule)
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrNoAvailableSource
+	}
+
+	// 跳过熔断中的源
+	candidates, probes := r.breakerAllowed(candidates)
+	defer func() { r.releaseProbes(probes, selected) }()
+	if len(candidates) == 0 {
+		return nil, ErrNoAvailableSource
+	}
+
+	// 跳过限流冷却中或已达到源级限制的源；全部饱和时由调用方排队等待
+	candidates = r.unsaturated(candidates, func() int { return EstimatePromptTokens(req) })
+	if len(candidates) == 0 {
+		return nil, ErrSourcesSaturated
+	}
+
+	strategy := r.strategy
+	if rule != nil {
+		if rule.Strategy != "" {
+			strategy = rule.Strategy
+		} else if len(rule.Sources) > 0 {
+			return candidates[0], nil // 有序源列表：按规则顺序选择
 		}
-		candidates = filtered
 	}
 
-	// 过滤重试后如果 FC 候选为空，再次尝试降级到非 FC 源
-	if len(candidates) == 0 && needFC {
-		fallback := r.manager.GetByCapability(
-			false,
-			needThinking,
-			needVision,
-			req.Model,
-		)
-		if len(exclude) > 0 {
-			excludeMap := make(map[string]bool)
-			for _, id := range exclude {
-				excludeMap[id] = true
-			}
-			for _, src := range fallback {
-				if !excludeMap[src.ID] {
-					candidates = append(candidates, src)
+	// 粘性路由：亲和键已固定的源仍可用时继续使用，否则按策略重新选择并固定
+	key := r.affinityKey(req, client)
+	if key != "" {
+		if id, ok := r.affinity.get(key); ok {
+			for _, src := range candidates {
+				if src.ID == id {
+					return src, nil
 				}
 			}
-		} else {
-			candidates = fallback
 		}
 	}
 
+	src := r.selectByStrategy(strategy, candidates, req.Model, func() *model.Usage { return expectedUsage(req) })
+	if key != "" {
+		r.affinity.set(key, src.ID)
+	}
+	return src, nil
+}
+
+// RouteEmbeddingRequest 为 embeddings 请求选择源：仅在声明了 embeddings 能力且支持该模型的源中选择
+// 路由规则按模型、客户端工具与 API Key 匹配；熔断、限流冷却、源级限制与路由策略同 RouteRequest，不使用粘性路由
+func (r *Router) RouteEmbeddingRequest(req *model.EmbeddingRequest, tokens int, client *model.ClientInfo, exclude []string) (selected *model.Source, err error) {
+	rule := r.MatchRule(&model.ChatCompletionRequest{Model: req.Model}, client)
+
+	candidates := r.filter(r.manager.GetEmbeddingSources(req.Model), exclude, rule)
 	if len(candidates) == 0 {
 		return nil, ErrNoAvailableSource
 	}
 
-	// 根据策略选择
-	switch r.strategy {
+	candidates, probes := r.breakerAllowed(candidates)
+	defer func() { r.releaseProbes(probes, selected) }()
+	if len(candidates) == 0 {
+		return nil, ErrNoAvailableSource
+	}
+
+	candidates = r.unsaturated(candidates, func() int { return tokens })
+	if len(candidates) == 0 {
+		return nil, ErrSourcesSaturated
+	}
+
+	strategy := r.strategy
+	if rule != nil {
+		if rule.Strategy != "" {
+			strategy = rule.Strategy
+		} else if len(rule.Sources) > 0 {
+			return candidates[0], nil
+		}
+	}
+
+	// embeddings 没有输出 token，按输入 token 比较费用
+	usage := func() *model.Usage { return &model.Usage{PromptTokens: tokens, TotalTokens: tokens} }
+	return r.selectByStrategy(strategy, candidates, req.Model, usage), nil
+}
+
+// selectByStrategy 根据策略选择；usage 为 least-cost 比较时假设的用量
+func (r *Router) selectByStrategy(strategy string, candidates []*model.Source, modelName string, usage func() *model.Usage) *model.Source {
+	switch strategy {
 	case StrategyRoundRobin:
-		return r.roundRobin(candidates), nil
+		return r.roundRobin(candidates)
 	case StrategyWeighted:
-		return r.weighted(candidates), nil
+		return r.weighted(candidates)
 	case StrategyLeastLatency:
-		return r.leastLatency(candidates), nil
+		return r.leastLatency(candidates)
 	case StrategyLeastCost:
-		return r.leastCost(candidates), nil
+		return r.leastCost(candidates, modelName, usage())
 	default: // priority
-		return r.priority(candidates), nil
+		return r.priority(candidates)
+	}
+}
+
+// affinityKey 请求的亲和键，未启用粘性路由时为空
+// 会话键按配置顺序取第一个存在的来源，优先于 prompt 前缀
+func (r *Router) affinityKey(req *model.ChatCompletionRequest, client *model.ClientInfo) string {
+	if r.affinity == nil {
+		return ""
+	}
+	cfg := r.affinity.cfg
+	for _, source := range cfg.Session {
+		switch {
+		case source == "header" && client != nil && client.SessionID != "":
+			return "session:" + client.SessionID
+		case source == "user" && req.User != "":
+			return "user:" + req.User
+		case source == "key" && client != nil && client.KeyID != "":
+			return "key:" + client.KeyID
+		}
+	}
+	if cfg.Prefix {
+		return "prefix:" + PromptPrefixKey(req, orDefault(cfg.PrefixMessages, 1))
+	}
+	return ""
+}
+
+// candidates 按能力筛选源，并排除已尝试的源和规则排除的源
+func (r *Router) candidates(needFC, needThinking, needVision bool, modelName string, exclude []string, rule *model.RoutingRule) []*model.Source {
+	return r.filter(r.manager.GetByCapability(needFC, needThinking, needVision, modelName), exclude, rule)
+}
+
+// filter 排除已尝试的源和规则排除的源；规则指定了有序源列表时按列表顺序只保留其中的源
+func (r *Router) filter(sources []*model.Source, exclude []string, rule *model.RoutingRule) []*model.Source {
+	excludeMap := make(map[string]bool)
+	for _, id := range exclude {
+		excludeMap[id] = true
+	}
+
+	var filtered []*model.Source
+	for _, src := range sources {
+		if excludeMap[src.ID] || (rule != nil && sourceListed(rule.Exclude, src)) {
+			continue
+		}
+		filtered = append(filtered, src)
+	}
+
+	if rule == nil || len(rule.Sources) == 0 {
+		return filtered
+	}
+
+	var ordered []*model.Source
+	for _, ref := range rule.Sources {
+		for _, src := range filtered {
+			if src.RefersTo(ref) {
+				ordered = append(ordered, src)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// breakerAllowed 过滤掉熔断中的源；半开的源在试探名额空闲时按比例抽样放行，
+// 没有其他可用源时半开的源只要名额空闲即放行，避免单源部署在半开期间长时间拒绝请求。
+// probes 为本次占用了试探名额的半开源，路由结束后由 releaseProbes 归还未被选中者的名额
+func (r *Router) breakerAllowed(candidates []*model.Source) (allowed, probes []*model.Source) {
+	breakers := r.manager.Breakers()
+	if breakers == nil {
+		return candidates, nil
+	}
+
+	var halfOpen []*model.Source
+	for _, src := range candidates {
+		ok, state := breakers.Allow(src)
+		switch {
+		case ok:
+			allowed = append(allowed, src)
+			if state == model.BreakerHalfOpen {
+				probes = append(probes, src)
+			}
+		case state == model.BreakerHalfOpen:
+			halfOpen = append(halfOpen, src)
+		}
+	}
+	if len(allowed) == 0 {
+		for _, src := range halfOpen {
+			if breakers.Probe(src) {
+				allowed = append(allowed, src)
+				probes = append(probes, src)
+			}
+		}
+	}
+	return allowed, probes
+}
+
+// releaseProbes 归还未被选中的半开源占用的试探名额；被选中的源由请求结果经 Record 释放
+func (r *Router) releaseProbes(probes []*model.Source, selected *model.Source) {
+	for _, src := range probes {
+		if selected == nil || src.ID != selected.ID {
+			r.manager.Breakers().Release(src)
+		}
+	}
+}
+
+// unsaturated 过滤掉处于限流冷却中或已达到 RPM、TPM、并发上限的源；estimate 返回请求的预估 token 数，仅在需要时调用
+func (r *Router) unsaturated(candidates []*model.Source, estimate func() int) []*model.Source {
+	tokens := -1
+	var available []*model.Source
+	for _, src := range candidates {
+		if src.InCooldown() {
+			continue
+		}
+		if r.limiter != nil && src.Limits != nil {
+			if tokens < 0 {
+				tokens = estimate()
+			}
+			if r.limiter.SourceSaturated(src.ID, src.Limits, tokens) {
+				continue
+			}
+		}
+		available = append(available, src)
+	}
+	return available
+}
+
+// sourceListed 判断源是否在引用列表（ID 或名称）中
+func sourceListed(refs []string, src *model.Source) bool {
+	for _, ref := range refs {
+		if src.RefersTo(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRule 返回请求命中的第一条路由规则，未命中返回 nil
+func (r *Router) MatchRule(req *model.ChatCompletionRequest, client *model.ClientInfo) *model.RoutingRule {
+	r.rulesMu.RLock()
+	defer r.rulesMu.RUnlock()
+
+	for _, rule := range r.rules {
+		if rule.Matches(req, client) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// SetRules 替换路由规则
+func (r *Router) SetRules(rules []*model.RoutingRule) {
+	sorted := append([]*model.RoutingRule{}, rules...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	r.rulesMu.Lock()
+	r.rules = sorted
+	r.rulesMu.Unlock()
+}
+
+// IsValidStrategy 判断路由策略是否受支持
+func IsValidStrategy(strategy string) bool {
+	switch strategy {
+	case StrategyPriority, StrategyRoundRobin, StrategyWeighted, StrategyLeastLatency, StrategyLeastCost:
+		return true
 	}
+	return false
 }
 
 // priority 按优先级选择
@@ -207,21 +413,62 @@ func (r *Router) leastLatency(candidates []*model.Source) *model.Source {
 	return candidates[0]
 }
 
-// leastCost 选择余额最多的源
-func (r *Router) leastCost(candidates []*model.Source) *model.Source {
+// expectedCompletionTokens least-cost 比较时假设的输出 token 数（max_tokens 更小时取 max_tokens）
+const expectedCompletionTokens = 500
+
+// expectedUsage least-cost 比较时假设的聊天请求用量：估算输入 token + 预期输出 token
+func expectedUsage(req *model.ChatCompletionRequest) *model.Usage {
+	completion := expectedCompletionTokens
+	if req.MaxTokens != nil && *req.MaxTokens > 0 && *req.MaxTokens < completion {
+		completion = *req.MaxTokens
+	}
+	prompt := EstimatePromptTokens(req)
+	return &model.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+// leastCost 选择按预期用量计算费用最低的源
+// 未配置该模型价格的源排在已定价源之后，彼此间按余额从高到低
+func (r *Router) leastCost(candidates []*model.Source, modelName string, usage *model.Usage) *model.Source {
 	if len(candidates) == 0 {
 		return nil
 	}
 
-	sort.Slice(candidates, func(i, j int) bool {
-		si := candidates[i].GetStatus()
-		sj := candidates[j].GetStatus()
-		return si.Balance > sj.Balance
+	costs := make(map[string]float64, len(candidates))
+	priced := make(map[string]bool, len(candidates))
+	for _, src := range candidates {
+		if price := src.PriceFor(modelName); price != nil {
+			priced[src.ID] = true
+			costs[src.ID] = price.Cost(usage)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		si, sj := candidates[i], candidates[j]
+		if priced[si.ID] != priced[sj.ID] {
+			return priced[si.ID]
+		}
+		if priced[si.ID] {
+			if costs[si.ID] != costs[sj.ID] {
+				return costs[si.ID] < costs[sj.ID]
+			}
+			return si.Priority < sj.Priority
+		}
+		return si.GetStatus().Balance > sj.GetStatus().Balance
 	})
 
 	return candidates[0]
 }
 
+// SetLimiter 设置用于检查源级限制的频率限制器
+func (r *Router) SetLimiter(limiter Limiter) {
+	r.limiter = limiter
+}
+
+// SetAffinity 设置粘性路由配置（配置在运行时修改后即时生效）
+func (r *Router) SetAffinity(cfg *config.AffinityConfig) {
+	r.affinity = newAffinityTable(cfg)
+}
+
 // SetStrategy 设置路由策略
 func (r *Router) SetStrategy(strategy string) {
 	r.strategy = strategy
@@ -0,0 +1,324 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/config"
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// newTestRouter 创建不落盘的路由器，源按顺序作为优先级
+func newTestRouter(t *testing.T, names ...string) *Router {
+	t.Helper()
+	sources := make([]model.Source, len(names))
+	for i, name := range names {
+		sources[i] = model.Source{ID: name, Name: name, Type: model.SourceTypeOpenAI, Priority: i + 1, Enabled: true,
+			Capabilities: model.Capabilities{FunctionCalling: true}}
+	}
+	manager := NewSourceManager(nil)
+	if err := manager.LoadFromConfig(sources); err != nil {
+		t.Fatalf("failed to load sources: %v", err)
+	}
+	return NewRouter(manager, StrategyPriority)
+}
+
+func routeNames(t *testing.T, r *Router, req *model.ChatCompletionRequest, client *model.ClientInfo) []string {
+	t.Helper()
+	var tried []string
+	for {
+		src, err := r.RouteRequest(req, client, tried)
+		if err != nil {
+			return tried
+		}
+		tried = append(tried, src.ID)
+	}
+}
+
+func TestRouter_NoRulesUsesDefaultStrategy(t *testing.T) {
+	r := newTestRouter(t, "a", "b", "c")
+	got := routeNames(t, r, &model.ChatCompletionRequest{Model: "gpt-4o"}, nil)
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("expected priority order a,b,c, got %v", got)
+	}
+}
+
+func TestRouter_RuleOrderedSourcesAndExclude(t *testing.T) {
+	r := newTestRouter(t, "a", "b", "c")
+	r.SetRules([]*model.RoutingRule{
+		{ID: "pin", Priority: 10, Enabled: true, Match: model.RuleMatch{Models: []string{"claude-*"}}, Sources: []string{"c", "a"}},
+		{ID: "skip", Priority: 20, Enabled: true, Match: model.RuleMatch{ClientTools: []string{"cursor"}}, Exclude: []string{"a"}},
+	})
+
+	got := routeNames(t, r, &model.ChatCompletionRequest{Model: "claude-sonnet-4"}, nil)
+	if len(got) != 2 || got[0] != "c" || got[1] != "a" {
+		t.Errorf("expected pinned order c,a, got %v", got)
+	}
+
+	got = routeNames(t, r, &model.ChatCompletionRequest{Model: "gpt-4o"}, &model.ClientInfo{Tool: "cursor"})
+	if len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("expected a excluded for cursor, got %v", got)
+	}
+
+	got = routeNames(t, r, &model.ChatCompletionRequest{Model: "gpt-4o"}, &model.ClientInfo{Tool: "cline"})
+	if len(got) != 3 {
+		t.Errorf("expected no rule to apply, got %v", got)
+	}
+}
+
+func TestRouter_RuleMatchesKeyAndFeatures(t *testing.T) {
+	r := newTestRouter(t, "a", "b")
+	hasTools := true
+	r.SetRules([]*model.RoutingRule{
+		{ID: "disabled", Priority: 1, Enabled: false, Sources: []string{"b"}},
+		{ID: "tools", Priority: 5, Enabled: true, Match: model.RuleMatch{APIKeys: []string{"key_1"}, HasTools: &hasTools}, Strategy: StrategyRoundRobin, Exclude: []string{"a"}},
+	})
+
+	withTools := &model.ChatCompletionRequest{Model: "m", Tools: []model.Tool{{Type: "function", Function: model.Function{Name: "f"}}}}
+	if rule := r.MatchRule(withTools, &model.ClientInfo{KeyID: "key_1"}); rule == nil || rule.ID != "tools" {
+		t.Errorf("expected tools rule to match, got %+v", rule)
+	}
+	if rule := r.MatchRule(&model.ChatCompletionRequest{Model: "m"}, &model.ClientInfo{KeyID: "key_1"}); rule != nil {
+		t.Errorf("expected no match without tools, got %+v", rule)
+	}
+	if rule := r.MatchRule(withTools, &model.ClientInfo{KeyID: "key_2"}); rule != nil {
+		t.Errorf("expected no match for other key, got %+v", rule)
+	}
+
+	src, err := r.RouteRequest(withTools, &model.ClientInfo{KeyID: "key_1"}, nil)
+	if err != nil || src.ID != "b" {
+		t.Errorf("expected b, got %v (%v)", src, err)
+	}
+}
+
+func TestRouter_LeastCostUsesPricing(t *testing.T) {
+	r := newTestRouter(t, "unpriced", "expensive", "cheap-output", "cheap-input")
+	r.SetStrategy(StrategyLeastCost)
+	for _, src := range r.manager.List() {
+		switch src.ID {
+		case "expensive":
+			src.Pricing = []model.ModelPrice{{Model: "m", Input: 10, Output: 30}}
+		case "cheap-output":
+			src.Pricing = []model.ModelPrice{{Model: "m", Input: 5, Output: 1}}
+		case "cheap-input":
+			src.Pricing = []model.ModelPrice{{Model: "m*", Input: 1, Output: 5}}
+		}
+	}
+
+	// 短输入：输出价格主导
+	got := routeNames(t, r, &model.ChatCompletionRequest{Model: "m", Messages: []model.Message{{Role: "user", Content: "hi"}}}, nil)
+	if len(got) != 4 || got[0] != "cheap-output" || got[1] != "cheap-input" || got[2] != "expensive" || got[3] != "unpriced" {
+		t.Errorf("unexpected least-cost order: %v", got)
+	}
+
+	// 长输入：输入价格主导
+	long := strings.Repeat("word ", 20000)
+	got = routeNames(t, r, &model.ChatCompletionRequest{Model: "m", Messages: []model.Message{{Role: "user", Content: long}}}, nil)
+	if len(got) != 4 || got[0] != "cheap-input" {
+		t.Errorf("expected cheap-input first for long prompt, got %v", got)
+	}
+}
+
+func TestRouter_SkipsSaturatedSources(t *testing.T) {
+	r := newTestRouter(t, "a", "b")
+	limiter := NewRateLimiter()
+	r.SetLimiter(limiter)
+
+	limits := &model.SourceLimits{MaxConcurrent: 1}
+	for _, id := range []string{"a", "b"} {
+		src, _ := r.manager.Get(id)
+		src.Limits = limits
+	}
+
+	req := &model.ChatCompletionRequest{Model: "gpt-4o"}
+	limiter.AcquireSource("a", "", limits, 0)
+	if src, err := r.RouteRequest(req, nil, nil); err != nil || src.ID != "b" {
+		t.Fatalf("expected saturated a to be skipped, got %v %v", src, err)
+	}
+
+	limiter.AcquireSource("b", "", limits, 0)
+	if _, err := r.RouteRequest(req, nil, nil); err != ErrSourcesSaturated {
+		t.Errorf("expected ErrSourcesSaturated, got %v", err)
+	}
+}
+
+func TestRouter_SkipsOpenBreakers(t *testing.T) {
+	r := newTestRouter(t, "a", "b")
+	breakers := NewBreakerSet(&config.CircuitBreakerConfig{MinRequests: 1})
+	r.manager.SetBreakers(breakers)
+
+	a, _ := r.manager.Get("a")
+	breakers.Record(a, true)
+
+	req := &model.ChatCompletionRequest{Model: "gpt-4o"}
+	if src, err := r.RouteRequest(req, nil, nil); err != nil || src.ID != "b" {
+		t.Fatalf("expected open source a to be skipped, got %v %v", src, err)
+	}
+
+	// 半开且没有其他可用源时放行
+	breakers.ProbeSucceeded(a)
+	breakers.sample = func() float64 { return 0.99 }
+	if src, err := r.RouteRequest(req, nil, []string{"b"}); err != nil || src.ID != "a" {
+		t.Fatalf("expected half-open a to be used as the only source, got %v %v", src, err)
+	}
+
+	// 试探请求尚未记录结果时不再放行第二个
+	if _, err := r.RouteRequest(req, nil, []string{"b"}); err != ErrNoAvailableSource {
+		t.Fatalf("expected the in-flight probe to block half-open a, got %v", err)
+	}
+	breakers.Record(a, false)
+	if src, err := r.RouteRequest(req, nil, []string{"b"}); err != nil || src.ID != "a" {
+		t.Errorf("expected the recorded probe to free the slot, got %v %v", src, err)
+	}
+}
+
+func TestRouter_ReleasesUnselectedProbes(t *testing.T) {
+	r := newTestRouter(t, "a", "b")
+	breakers := NewBreakerSet(&config.CircuitBreakerConfig{MinRequests: 1})
+	breakers.sample = func() float64 { return 0 }
+	r.manager.SetBreakers(breakers)
+
+	a, _ := r.manager.Get("a")
+	b, _ := r.manager.Get("b")
+	breakers.Record(a, true)
+	breakers.ProbeSucceeded(a)
+	breakers.Record(b, true)
+	breakers.ProbeSucceeded(b)
+
+	req := &model.ChatCompletionRequest{Model: "gpt-4o"}
+	src, err := r.RouteRequest(req, nil, nil)
+	if err != nil {
+		t.Fatalf("RouteRequest failed: %v", err)
+	}
+	other := a
+	if src.ID == "a" {
+		other = b
+	}
+	if !breakers.Probe(other) {
+		t.Errorf("expected the unselected half-open source %s to get its probe slot back", other.ID)
+	}
+	if breakers.Probe(src) {
+		t.Errorf("expected the selected source %s to keep its probe slot", src.ID)
+	}
+}
+
+func TestRouter_PrefixAffinity(t *testing.T) {
+	r := newTestRouter(t, "a", "b", "c")
+	r.SetStrategy(StrategyRoundRobin)
+	r.SetAffinity(&config.AffinityConfig{Prefix: true})
+
+	conversation := func(turns ...string) *model.ChatCompletionRequest {
+		req := &model.ChatCompletionRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "system", Content: "You are helpful."}}}
+		for i, turn := range turns {
+			role := "user"
+			if i%2 == 1 {
+				role = "assistant"
+			}
+			req.Messages = append(req.Messages, model.Message{Role: role, Content: turn})
+		}
+		return req
+	}
+
+	first, err := r.RouteRequest(conversation("hi"), nil, nil)
+	if err != nil {
+		t.Fatalf("route failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		src, _ := r.RouteRequest(conversation("hi", "hello", "more"), nil, nil)
+		if src.ID != first.ID {
+			t.Fatalf("expected later turns to stay on %s, got %s", first.ID, src.ID)
+		}
+	}
+
+	// 固定的源不可用时重新选择并固定到新的源
+	first.SetCooldown(time.Now().Add(time.Minute), "status 429")
+	moved, _ := r.RouteRequest(conversation("hi", "hello", "more"), nil, nil)
+	if moved.ID == first.ID {
+		t.Fatal("expected an unavailable pinned source to be skipped")
+	}
+	first.SetCooldown(time.Now(), "")
+	if src, _ := r.RouteRequest(conversation("hi"), nil, nil); src.ID != moved.ID {
+		t.Errorf("expected the conversation to stay on the new source %s, got %s", moved.ID, src.ID)
+	}
+}
+
+func TestPromptPrefixKey(t *testing.T) {
+	base := &model.ChatCompletionRequest{Model: "m", Messages: []model.Message{
+		{Role: "system", Content: "sys"}, {Role: "user", Content: "q1"}}}
+	longer := &model.ChatCompletionRequest{Model: "m", Messages: append(append([]model.Message(nil), base.Messages...),
+		model.Message{Role: "assistant", Content: "a1"}, model.Message{Role: "user", Content: "q2"})}
+	if PromptPrefixKey(base, 1) != PromptPrefixKey(longer, 1) {
+		t.Error("expected appended turns to keep the prefix key")
+	}
+	if PromptPrefixKey(base, 1) == PromptPrefixKey(longer, 2) {
+		t.Error("expected prefix_messages to extend the prefix")
+	}
+	other := &model.ChatCompletionRequest{Model: "m", Messages: []model.Message{
+		{Role: "system", Content: "other"}, {Role: "user", Content: "q1"}}}
+	if PromptPrefixKey(base, 1) == PromptPrefixKey(other, 1) {
+		t.Error("expected different system prompts to use different keys")
+	}
+}
+
+func TestRouter_SessionAffinity(t *testing.T) {
+	r := newTestRouter(t, "a", "b", "c")
+	r.SetStrategy(StrategyRoundRobin)
+	r.SetAffinity(&config.AffinityConfig{Session: []string{"header", "user", "key"}})
+
+	req := func(user string) *model.ChatCompletionRequest {
+		return &model.ChatCompletionRequest{Model: "gpt-4o", User: user, Messages: []model.Message{{Role: "user", Content: "hi"}}}
+	}
+	pinned := func(r1 *model.ChatCompletionRequest, client *model.ClientInfo) string {
+		t.Helper()
+		first, err := r.RouteRequest(r1, client, nil)
+		if err != nil {
+			t.Fatalf("route failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if src, _ := r.RouteRequest(r1, client, nil); src.ID != first.ID {
+				t.Fatalf("expected requests to stay on %s, got %s", first.ID, src.ID)
+			}
+		}
+		return first.ID
+	}
+
+	pinned(req("alice"), &model.ClientInfo{SessionID: "s1"})
+	pinned(req("alice"), nil)
+	id := pinned(req(""), &model.ClientInfo{KeyID: "k1"})
+
+	// 固定的源变为不健康时按策略重新选择，恢复后会话仍留在新的源上
+	src, _ := r.manager.Get(id)
+	src.SetStatus(&model.SourceStatus{State: model.HealthStateUnhealthy})
+	moved, _ := r.RouteRequest(req(""), &model.ClientInfo{KeyID: "k1"}, nil)
+	if moved.ID == id {
+		t.Fatal("expected an unhealthy pinned source to be skipped")
+	}
+	src.SetStatus(&model.SourceStatus{State: model.HealthStateHealthy})
+	if again, _ := r.RouteRequest(req(""), &model.ClientInfo{KeyID: "k1"}, nil); again.ID != moved.ID {
+		t.Errorf("expected the key to stay on the new source %s, got %s", moved.ID, again.ID)
+	}
+}
+
+func TestAffinityTable_Expires(t *testing.T) {
+	now := time.Now()
+	table := newAffinityTable(&config.AffinityConfig{TTL: 10, MaxEntries: 2})
+	table.now = func() time.Time { return now }
+
+	table.set("a", "src-a")
+	table.set("b", "src-b")
+	now = now.Add(5 * time.Second)
+	table.get("a") // 使用会延长有效期
+	table.set("c", "src-c")
+	if _, ok := table.get("b"); ok {
+		t.Error("expected the earliest-expiring entry to be evicted over capacity")
+	}
+	if id, ok := table.get("a"); !ok || id != "src-a" {
+		t.Errorf("expected a recently used entry to survive, got %q %v", id, ok)
+	}
+
+	now = now.Add(11 * time.Second)
+	if _, ok := table.get("c"); ok {
+		t.Error("expected an entry unused for longer than the TTL to expire")
+	}
+}
@@ -0,0 +1,358 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSONSchema 按 JSON Schema 校验已解码的 JSON 值
+// 支持工具参数常用的关键字：type、properties、required、additionalProperties、items、
+// enum、const、数值/长度/数量范围、pattern、anyOf/oneOf/allOf、nullable；未知关键字忽略
+func ValidateJSONSchema(value any, schema map[string]any) error {
+	return validateSchema("$", value, schema)
+}
+
+// ValidateJSONArguments 解析 JSON 文本并按 schema 校验，顶层必须为对象
+func ValidateJSONArguments(args string, schema map[string]any) error {
+	var value any
+	if err := json.Unmarshal([]byte(args), &value); err != nil {
+		return fmt.Errorf("arguments are not valid JSON: %v", err)
+	}
+	if _, ok := value.(map[string]any); !ok {
+		return fmt.Errorf("arguments must be a JSON object")
+	}
+	return ValidateJSONSchema(value, schema)
+}
+
+func validateSchema(path string, value any, schema map[string]any) error {
+	if len(schema) == 0 {
+		return nil
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(value, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			b, _ := json.Marshal(enum)
+			return fmt.Errorf("%s: must be one of %s", path, b)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(value, c) {
+		b, _ := json.Marshal(c)
+		return fmt.Errorf("%s: must be %s", path, b)
+	}
+
+	if err := validateComposition(path, value, schema); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateObject(path, v, schema)
+	case []any:
+		return validateArray(path, v, schema)
+	case string:
+		return validateString(path, v, schema)
+	case float64:
+		return validateNumber(path, v, schema)
+	}
+	return nil
+}
+
+// validateComposition 校验 allOf / anyOf / oneOf
+func validateComposition(path string, value any, schema map[string]any) error {
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := validateSchema(path, value, sub); err != nil {
+			return err
+		}
+	}
+
+	if subs := schemaList(schema["anyOf"]); len(subs) > 0 {
+		var firstErr error
+		for _, sub := range subs {
+			err := validateSchema(path, value, sub)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: does not match any allowed schema (%v)", path, firstErr)
+		}
+	}
+
+	if subs := schemaList(schema["oneOf"]); len(subs) > 0 {
+		matches := 0
+		for _, sub := range subs {
+			if validateSchema(path, value, sub) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: must match exactly one allowed schema, matched %d", path, matches)
+		}
+	}
+	return nil
+}
+
+func validateObject(path string, obj map[string]any, schema map[string]any) error {
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+
+	// 按键名排序，保证错误信息稳定
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "." + k
+		if sub, ok := props[k].(map[string]any); ok {
+			if err := validateSchema(childPath, obj[k], sub); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, declared := props[k]; declared {
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Errorf("%s: unexpected property %q", path, k)
+			}
+		case map[string]any:
+			if err := validateSchema(childPath, obj[k], extra); err != nil {
+				return err
+			}
+		}
+	}
+
+	if n, ok := schemaNumber(schema["minProperties"]); ok && float64(len(obj)) < n {
+		return fmt.Errorf("%s: must have at least %v properties", path, n)
+	}
+	if n, ok := schemaNumber(schema["maxProperties"]); ok && float64(len(obj)) > n {
+		return fmt.Errorf("%s: must have at most %v properties", path, n)
+	}
+	return nil
+}
+
+func validateArray(path string, arr []any, schema map[string]any) error {
+	if n, ok := schemaNumber(schema["minItems"]); ok && float64(len(arr)) < n {
+		return fmt.Errorf("%s: must have at least %v items", path, n)
+	}
+	if n, ok := schemaNumber(schema["maxItems"]); ok && float64(len(arr)) > n {
+		return fmt.Errorf("%s: must have at most %v items", path, n)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					return fmt.Errorf("%s: items must be unique", path)
+				}
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		for i, item := range arr {
+			if err := validateSchema(fmt.Sprintf("%s[%d]", path, i), item, items); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateString(path, s string, schema map[string]any) error {
+	length := float64(utf8.RuneCountInString(s))
+	if n, ok := schemaNumber(schema["minLength"]); ok && length < n {
+		return fmt.Errorf("%s: must be at least %v characters", path, n)
+	}
+	if n, ok := schemaNumber(schema["maxLength"]); ok && length > n {
+		return fmt.Errorf("%s: must be at most %v characters", path, n)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		// 无法编译的 pattern 视为未声明
+		if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(s) {
+			return fmt.Errorf("%s: must match pattern %q", path, pattern)
+		}
+	}
+	return nil
+}
+
+func validateNumber(path string, n float64, schema map[string]any) error {
+	if min, ok := schemaNumber(schema["minimum"]); ok && n < min {
+		return fmt.Errorf("%s: must be >= %v", path, min)
+	}
+	if max, ok := schemaNumber(schema["maximum"]); ok && n > max {
+		return fmt.Errorf("%s: must be <= %v", path, max)
+	}
+	if min, ok := schemaNumber(schema["exclusiveMinimum"]); ok && n <= min {
+		return fmt.Errorf("%s: must be > %v", path, min)
+	}
+	if max, ok := schemaNumber(schema["exclusiveMaximum"]); ok && n >= max {
+		return fmt.Errorf("%s: must be < %v", path, max)
+	}
+	if m, ok := schemaNumber(schema["multipleOf"]); ok && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			return fmt.Errorf("%s: must be a multiple of %v", path, m)
+		}
+	}
+	return nil
+}
+
+// schemaTypes 解析 type（string 或 []string）
+func schemaTypes(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	default:
+		return stringList(v)
+	}
+}
+
+// jsonTypeMatches 判断值是否符合 JSON Schema 类型
+func jsonTypeMatches(value any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true // 未知类型不限制
+}
+
+// jsonTypeName 返回值的 JSON 类型名
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual 比较两个 JSON 值（schema 中的常量可能来自 Go 字面量，统一按 JSON 编码比较）
+func jsonEqual(a, b any) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	ab, err1 := json.Marshal(a)
+	bb, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(ab) == string(bb)
+}
+
+// schemaNumber 读取数值关键字（兼容 Go 代码中构造的 int）
+func schemaNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// stringList 读取字符串数组关键字（[]any 或 []string）
+func stringList(v any) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// schemaList 读取子 schema 数组关键字
+func schemaList(v any) []map[string]any {
+	list, ok := v.([]any)
+	if !ok {
+		if typed, ok := v.([]map[string]any); ok {
+			return typed
+		}
+		return nil
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if m, ok := item.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONArguments(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"city":  map[string]any{"type": "string", "minLength": float64(1)},
+			"unit":  map[string]any{"type": "string", "enum": []any{"c", "f"}},
+			"days":  map[string]any{"type": "integer", "minimum": float64(1), "maximum": float64(7)},
+			"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": float64(2)},
+			"note":  map[string]any{"type": []any{"string", "null"}},
+			"where": map[string]any{"anyOf": []any{map[string]any{"type": "string"}, map[string]any{"type": "object", "required": []any{"lat"}}}},
+		},
+		"required":             []any{"city"},
+		"additionalProperties": false,
+	}
+
+	tests := []struct {
+		name    string
+		args    string
+		wantErr string
+	}{
+		{"valid", `{"city":"Paris","unit":"c","days":3,"tags":["a"],"note":null,"where":{"lat":1}}`, ""},
+		{"not json", `{"city":`, "not valid JSON"},
+		{"not object", `["Paris"]`, "must be a JSON object"},
+		{"missing required", `{"unit":"c"}`, `$: missing required property "city"`},
+		{"wrong type", `{"city":1}`, "$.city: expected string, got integer"},
+		{"enum", `{"city":"Paris","unit":"k"}`, "$.unit: must be one of"},
+		{"integer", `{"city":"Paris","days":1.5}`, "$.days: expected integer"},
+		{"maximum", `{"city":"Paris","days":9}`, "$.days: must be <= 7"},
+		{"items", `{"city":"Paris","tags":["a",2]}`, "$.tags[1]: expected string"},
+		{"maxItems", `{"city":"Paris","tags":["a","b","c"]}`, "$.tags: must have at most 2 items"},
+		{"minLength", `{"city":""}`, "$.city: must be at least 1 characters"},
+		{"additional", `{"city":"Paris","extra":true}`, `unexpected property "extra"`},
+		{"anyOf", `{"city":"Paris","where":{"lng":1}}`, "$.where: does not match any allowed schema"},
+	}
+	for _, tt := range tests {
+		err := ValidateJSONArguments(tt.args, schema)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidateJSONSchema_GoLiteralSchema(t *testing.T) {
+	// 在 Go 代码中构造的 schema（[]string / int）同样生效
+	schema := map[string]any{
+		"type":     "object",
+		"required": []string{"q"},
+		"properties": map[string]any{
+			"n": map[string]any{"type": "integer", "minimum": 2},
+		},
+	}
+	if err := ValidateJSONArguments(`{"n":3}`, schema); err == nil {
+		t.Error("expected missing required property error")
+	}
+	if err := ValidateJSONArguments(`{"q":"x","n":1}`, schema); err == nil {
+		t.Error("expected minimum error")
+	}
+	if err := ValidateJSONArguments(`{"q":"x","n":2}`, nil); err != nil {
+		t.Errorf("empty schema should accept any object, got %v", err)
+	}
+}
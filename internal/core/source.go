@@ -9,9 +9,10 @@ import (
 
 // SourceManager 源管理器
 type SourceManager struct {
-	sources map[string]*model.Source
-	store   *store.Store
-	mu      sync.RWMutex
+	sources  map[string]*model.Source
+	store    *store.Store
+	breakers *BreakerSet // 按真实请求错误率熔断，nil 时不熔断
+	mu       sync.RWMutex
 }
 
 // NewSourceManager 创建源管理器
@@ -22,6 +23,16 @@ func NewSourceManager(s *store.Store) *SourceManager {
 	}
 }
 
+// SetBreakers 设置源熔断器集合
+func (m *SourceManager) SetBreakers(b *BreakerSet) {
+	m.breakers = b
+}
+
+// Breakers 返回源熔断器集合（未设置时为 nil，其方法均可安全调用）
+func (m *SourceManager) Breakers() *BreakerSet {
+	return m.breakers
+}
+
 // Load 从存储加载所有源
 func (m *SourceManager) Load() error {
 	sources, err := m.store.ListSources()
@@ -125,6 +136,7 @@ func (m *SourceManager) Delete(id string) error {
 	}
 
 	delete(m.sources, id)
+	m.breakers.Remove(id)
 	return nil
 }
 
@@ -205,6 +217,20 @@ func (m *SourceManager) GetByCapability(needFC, needThinking, needVision bool, m
 	return sources
 }
 
+// GetEmbeddingSources 获取声明了 embeddings 能力且支持该模型的源
+func (m *SourceManager) GetEmbeddingSources(modelName string) []*model.Source {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sources []*model.Source
+	for _, src := range m.sources {
+		if src.Enabled && src.IsHealthy() && src.SupportsEmbeddingModel(modelName) {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
 // UpdateStatus 更新源状态
 func (m *SourceManager) UpdateStatus(id string, status *model.SourceStatus) {
 	m.mu.RLock()
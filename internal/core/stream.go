@@ -0,0 +1,168 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// StreamConverter 流式响应转换器
+// 将上游 SSE data 行转换为 OpenAI chat.completion.chunk 数据帧，跨事件维护状态
+type StreamConverter struct {
+	src *model.Source
+
+	// Anthropic 流状态
+	id         string
+	model      string
+	created    int64
+	toolIndex  map[int]int // content block index -> tool_calls index
+	nextTool   int
+	stopReason string
+	usage      model.AnthropicUsage
+}
+
+// NewStreamConverter 为指定源创建流式转换器
+func (t *Translator) NewStreamConverter(src *model.Source) *StreamConverter {
+	return &StreamConverter{
+		src:       src,
+		created:   time.Now().Unix(),
+		toolIndex: make(map[int]int),
+	}
+}
+
+// Convert 转换一行 SSE data（不含 "data: " 前缀）
+// 返回需要写给客户端的数据帧、流是否结束，以及上游在流中返回的错误
+func (sc *StreamConverter) Convert(data string) ([]string, bool, error) {
+	if sc.src.Type != model.SourceTypeAnthropic {
+		if data == "[DONE]" {
+			return []string{data}, true, nil
+		}
+		return []string{data}, false, nil
+	}
+
+	var event model.AnthropicStreamEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return nil, false, nil // 无法解析的事件直接忽略
+	}
+
+	chunks, done, err := sc.convertAnthropicEvent(&event)
+	frames := make([]string, 0, len(chunks)+1)
+	for _, chunk := range chunks {
+		b, _ := json.Marshal(chunk)
+		frames = append(frames, string(b))
+	}
+	if done && err == nil {
+		frames = append(frames, "[DONE]")
+	}
+	return frames, done, err
+}
+
+// convertAnthropicEvent 将单个 Anthropic 事件转换为 OpenAI 流式块
+func (sc *StreamConverter) convertAnthropicEvent(event *model.AnthropicStreamEvent) ([]*model.StreamChunk, bool, error) {
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			sc.id = event.Message.ID
+			sc.model = event.Message.Model
+			sc.usage = event.Message.Usage
+		}
+		return []*model.StreamChunk{sc.chunk(&model.Message{Role: "assistant", Content: ""}, "")}, false, nil
+
+	case "content_block_start":
+		if event.ContentBlock != nil && event.ContentBlock.Type == "redacted_thinking" {
+			return []*model.StreamChunk{sc.chunk(&model.Message{
+				ThinkingBlocks: []model.ThinkingBlock{{Type: "redacted_thinking", Data: event.ContentBlock.Data}},
+			}, "")}, false, nil
+		}
+		if event.ContentBlock == nil || event.ContentBlock.Type != "tool_use" {
+			return nil, false, nil
+		}
+		idx := sc.nextTool
+		sc.nextTool++
+		sc.toolIndex[event.Index] = idx
+		return []*model.StreamChunk{sc.chunk(&model.Message{
+			ToolCalls: []model.ToolCall{{
+				Index: &idx,
+				ID:    event.ContentBlock.ID,
+				Type:  "function",
+				Function: model.FunctionCall{
+					Name:      event.ContentBlock.Name,
+					Arguments: "",
+				},
+			}},
+		}, "")}, false, nil
+
+	case "content_block_delta":
+		if event.Delta == nil {
+			return nil, false, nil
+		}
+		switch event.Delta.Type {
+		case "text_delta":
+			return []*model.StreamChunk{sc.chunk(&model.Message{Content: event.Delta.Text}, "")}, false, nil
+		case "thinking_delta":
+			return []*model.StreamChunk{sc.chunk(&model.Message{ReasoningContent: event.Delta.Thinking}, "")}, false, nil
+		case "signature_delta":
+			return []*model.StreamChunk{sc.chunk(&model.Message{
+				ThinkingBlocks: []model.ThinkingBlock{{Type: "thinking", Signature: event.Delta.Signature}},
+			}, "")}, false, nil
+		case "input_json_delta":
+			idx, ok := sc.toolIndex[event.Index]
+			if !ok || event.Delta.PartialJSON == "" {
+				return nil, false, nil
+			}
+			return []*model.StreamChunk{sc.chunk(&model.Message{
+				ToolCalls: []model.ToolCall{{
+					Index:    &idx,
+					Function: model.FunctionCall{Arguments: event.Delta.PartialJSON},
+				}},
+			}, "")}, false, nil
+		}
+		return nil, false, nil
+
+	case "message_delta":
+		if event.Usage != nil {
+			sc.usage.OutputTokens = event.Usage.OutputTokens
+		}
+		if event.Delta != nil && event.Delta.StopReason != "" {
+			sc.stopReason = event.Delta.StopReason
+			return []*model.StreamChunk{sc.chunk(&model.Message{}, anthropicStopReasonToFinish(sc.stopReason))}, false, nil
+		}
+		return nil, false, nil
+
+	case "message_stop":
+		// 与 OpenAI stream_options.include_usage 一致：最后一个 chunk 不含 choices，仅携带 usage
+		usageChunk := sc.chunk(nil, "")
+		usageChunk.Choices = []model.Choice{}
+		usageChunk.Usage = anthropicUsageToUsage(sc.usage)
+		return []*model.StreamChunk{usageChunk}, true, nil
+
+	case "error":
+		msg := "upstream stream error"
+		if event.Error != nil {
+			msg = fmt.Sprintf("%s: %s", event.Error.Type, event.Error.Message)
+		}
+		return nil, true, fmt.Errorf("%s", msg)
+
+	default: // ping, content_block_stop
+		return nil, false, nil
+	}
+}
+
+// chunk 构造 OpenAI 流式块
+func (sc *StreamConverter) chunk(delta *model.Message, finishReason string) *model.StreamChunk {
+	return &model.StreamChunk{
+		ID:      sc.id,
+		Object:  "chat.completion.chunk",
+		Created: sc.created,
+		Model:   sc.model,
+		Choices: []model.Choice{
+			{
+				Index:        0,
+				Delta:        delta,
+				FinishReason: finishReason,
+			},
+		},
+	}
+}
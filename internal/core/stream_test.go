@@ -0,0 +1,131 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestStreamConverter_OpenAIPassthrough(t *testing.T) {
+	sc := NewTranslator().NewStreamConverter(&model.Source{Type: model.SourceTypeOpenAI})
+
+	frames, done, err := sc.Convert(`{"id":"x","choices":[]}`)
+	if err != nil || done || len(frames) != 1 || frames[0] != `{"id":"x","choices":[]}` {
+		t.Fatalf("unexpected passthrough result: %v %v %v", frames, done, err)
+	}
+
+	frames, done, _ = sc.Convert("[DONE]")
+	if !done || len(frames) != 1 || frames[0] != "[DONE]" {
+		t.Fatalf("expected [DONE] to end stream, got %v %v", frames, done)
+	}
+}
+
+func TestStreamConverter_AnthropicEvents(t *testing.T) {
+	sc := NewTranslator().NewStreamConverter(&model.Source{Type: model.SourceTypeAnthropic})
+
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"ping"}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	var chunks []model.StreamChunk
+	var finished bool
+	for _, e := range events {
+		frames, done, err := sc.Convert(e)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, f := range frames {
+			if f == "[DONE]" {
+				finished = true
+				continue
+			}
+			var chunk model.StreamChunk
+			if err := json.Unmarshal([]byte(f), &chunk); err != nil {
+				t.Fatalf("invalid chunk %s: %v", f, err)
+			}
+			chunks = append(chunks, chunk)
+		}
+		if done {
+			break
+		}
+	}
+
+	if !finished {
+		t.Fatal("expected [DONE] frame after message_stop")
+	}
+
+	var text, reasoning, args strings.Builder
+	var toolID, toolName, finish, signature string
+	var usage *model.Usage
+	for _, chunk := range chunks {
+		if chunk.ID != "msg_1" || chunk.Object != "chat.completion.chunk" {
+			t.Errorf("unexpected chunk header: %+v", chunk)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		d := chunk.Choices[0].Delta
+		if s, ok := d.Content.(string); ok {
+			text.WriteString(s)
+		}
+		reasoning.WriteString(d.ReasoningContent)
+		for _, tb := range d.ThinkingBlocks {
+			signature += tb.Signature
+		}
+		for _, tc := range d.ToolCalls {
+			if tc.Index == nil || *tc.Index != 0 {
+				t.Errorf("expected tool call index 0, got %v", tc.Index)
+			}
+			if tc.ID != "" {
+				toolID = tc.ID
+				toolName = tc.Function.Name
+			}
+			args.WriteString(tc.Function.Arguments)
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finish = chunk.Choices[0].FinishReason
+		}
+	}
+
+	if text.String() != "Hello" {
+		t.Errorf("expected text 'Hello', got %q", text.String())
+	}
+	if reasoning.String() != "hmm" || signature != "sig" {
+		t.Errorf("expected reasoning 'hmm' signed 'sig', got %q %q", reasoning.String(), signature)
+	}
+	if toolID != "toolu_1" || toolName != "get_weather" || args.String() != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool call: id=%s name=%s args=%s", toolID, toolName, args.String())
+	}
+	if finish != "tool_calls" {
+		t.Errorf("expected finish_reason tool_calls, got %s", finish)
+	}
+	if usage == nil || usage.PromptTokens != 10 || usage.CompletionTokens != 20 || usage.TotalTokens != 30 {
+		t.Errorf("expected final usage chunk 10/20/30, got %+v", usage)
+	}
+}
+
+func TestStreamConverter_AnthropicError(t *testing.T) {
+	sc := NewTranslator().NewStreamConverter(&model.Source{Type: model.SourceTypeAnthropic})
+
+	_, done, err := sc.Convert(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	if !done || err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Fatalf("expected overloaded error, got done=%v err=%v", done, err)
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"encoding/json"
+	"unicode"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// 本地 token 估算（上游未返回 usage 时使用）
+// 采用与 cl100k 系列分词器相近的经验规则：
+// - CJK 字符约 1 token/字
+// - 其余连续字母数字按约 4 字符/token 计
+// - 标点和符号各计 1 token
+const (
+	tokensPerMessage = 3 // 每条消息的格式开销
+	tokensPerReply   = 3 // assistant 回复的起始开销
+	charsPerToken    = 4
+)
+
+// EstimateTokens 估算文本的 token 数
+func EstimateTokens(text string) int {
+	tokens := 0
+	wordLen := 0
+	flush := func() {
+		if wordLen > 0 {
+			tokens += (wordLen + charsPerToken - 1) / charsPerToken
+			wordLen = 0
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			wordLen++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isCJK 判断是否为中日韩字符
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// EstimatePromptTokens 估算请求的 prompt token 数（消息 + 工具定义）
+func EstimatePromptTokens(req *model.ChatCompletionRequest) int {
+	tokens := tokensPerReply
+	for _, msg := range req.Messages {
+		tokens += tokensPerMessage
+		tokens += EstimateTokens(msg.Role)
+		tokens += EstimateTokens(contentText(msg.Content))
+		if msg.Name != "" {
+			tokens += EstimateTokens(msg.Name)
+		}
+		for _, tc := range msg.ToolCalls {
+			tokens += EstimateTokens(tc.Function.Name) + EstimateTokens(tc.Function.Arguments)
+		}
+		if msg.FunctionCall != nil {
+			tokens += EstimateTokens(msg.FunctionCall.Name) + EstimateTokens(msg.FunctionCall.Arguments)
+		}
+	}
+	if tools := collectTools(req); len(tools) > 0 {
+		b, _ := json.Marshal(tools)
+		tokens += EstimateTokens(string(b))
+	}
+	return tokens
+}
+
+// EstimateUsage 根据请求和补全文本估算完整 usage
+func EstimateUsage(req *model.ChatCompletionRequest, completion string) *model.Usage {
+	prompt := EstimatePromptTokens(req)
+	completionTokens := EstimateTokens(completion)
+	return &model.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completionTokens,
+		TotalTokens:      prompt + completionTokens,
+	}
+}
+
+// EstimateEmbeddingTokens 估算 embeddings 批次的输入 token 总数：已分词的输入按 token 数计，文本按估算
+func EstimateEmbeddingTokens(inputs []model.EmbeddingInput) int {
+	tokens := 0
+	for _, in := range inputs {
+		if in.Tokens != nil {
+			tokens += len(in.Tokens)
+		} else {
+			tokens += EstimateTokens(in.Text)
+		}
+	}
+	return tokens
+}
+
+// ResponseText 提取响应中可计费的输出文本（内容、思考过程、工具调用参数）
+func ResponseText(msg *model.Message) string {
+	if msg == nil {
+		return ""
+	}
+	text := contentText(msg.Content) + msg.ReasoningContent
+	for _, tc := range msg.ToolCalls {
+		text += tc.Function.Name + tc.Function.Arguments
+	}
+	if msg.FunctionCall != nil {
+		text += msg.FunctionCall.Name + msg.FunctionCall.Arguments
+	}
+	return text
+}
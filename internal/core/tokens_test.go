@@ -0,0 +1,69 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 2},
+		{"hello world!", 5},
+		{"你好世界", 4},
+		{"a, b", 3},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateUsage(t *testing.T) {
+	req := &model.ChatCompletionRequest{
+		Messages: []model.Message{{Role: "user", Content: "hello world"}},
+		Tools:    []model.Tool{{Type: "function", Function: model.Function{Name: "f"}}},
+	}
+	noTools := &model.ChatCompletionRequest{Messages: req.Messages}
+
+	usage := EstimateUsage(req, "hi there")
+	if usage.PromptTokens <= EstimatePromptTokens(noTools) {
+		t.Errorf("expected tool definitions to add prompt tokens, got %d", usage.PromptTokens)
+	}
+	if usage.CompletionTokens != 3 || usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestEstimateEmbeddingTokens(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{`"hello world"`, 4},
+		{`["hello", "你好"]`, 4},
+		{`[1, 2, 3]`, 3},
+		{`[[1, 2], [3]]`, 3},
+	}
+	for _, tt := range tests {
+		req := &model.EmbeddingRequest{Input: []byte(tt.input)}
+		inputs, err := req.Inputs()
+		if err != nil {
+			t.Fatalf("Inputs(%s) failed: %v", tt.input, err)
+		}
+		if got := EstimateEmbeddingTokens(inputs); got != tt.want {
+			t.Errorf("EstimateEmbeddingTokens(%s) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{`[]`, `{}`, `[1, "a"]`} {
+		if _, err := (&model.EmbeddingRequest{Input: []byte(bad)}).Inputs(); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
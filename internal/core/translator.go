@@ -1,9 +1,24 @@
 package core
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/xiaopang/fusionapi/internal/model"
 )
 
+// Anthropic 默认参数
+const (
+	defaultAnthropicMaxTokens  = 4096
+	minAnthropicThinkingBudget = 1024
+	anthropicLeadingUserText   = "(continue)" // 对话以 assistant 开头时补在最前的 user 消息
+	anthropicMessagesPath      = "/v1/messages"
+	openAIChatCompletionsPath  = "/v1/chat/completions"
+	openAIEmbeddingsPath       = "/v1/embeddings"
+)
+
 // Translator 请求/响应转换器
 type Translator struct{}
 
@@ -39,15 +54,98 @@ func (t *Translator) TranslateRequest(req *model.ChatCompletionRequest, src *mod
 		translated.Thinking = nil
 	}
 
-	// 根据源类型做特定转换
+	return &translated
+}
+
+// EncodeRequest 按源类型生成上游路径和请求体
+// Anthropic 源走原生 /v1/messages，其余源走 OpenAI 兼容的 /v1/chat/completions
+func (t *Translator) EncodeRequest(req *model.ChatCompletionRequest, src *model.Source) (string, []byte, error) {
+	// 源级模型映射只改写发出的 model 字段，路由与日志仍使用原模型名
+	if upstream := src.UpstreamModel(req.Model); upstream != req.Model {
+		renamed := *req
+		renamed.Model = upstream
+		req = &renamed
+	}
+
 	switch src.Type {
 	case model.SourceTypeAnthropic:
-		return t.toAnthropicFormat(&translated)
+		body, err := json.Marshal(t.toAnthropicFormat(req))
+		return anthropicMessagesPath, body, err
 	default:
-		return &translated
+		// 流式请求要求上游在最后一个 chunk 返回 usage，用于准确计量
+		if req.Stream && (req.StreamOptions == nil || !req.StreamOptions.IncludeUsage) {
+			withUsage := *req
+			withUsage.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+			req = &withUsage
+		}
+		body, err := json.Marshal(forOpenAISource(req))
+		return openAIChatCompletionsPath, body, err
+	}
+}
+
+// forOpenAISource 去掉只有 Anthropic 源能识别的字段（思考签名、cache_control），
+// 并将 tool 消息中的多模态内容降级为文本（OpenAI 的 tool 消息只接受文本）；原请求保持不变以便 failover 到其他源
+func forOpenAISource(req *model.ChatCompletionRequest) *model.ChatCompletionRequest {
+	out := *req
+	out.Messages = make([]model.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		msg.ThinkingBlocks = nil
+		if msg.Role == "tool" {
+			if _, isText := msg.Content.(string); !isText && msg.Content != nil {
+				msg.Content = contentText(msg.Content)
+			}
+		}
+		msg.Content = withoutCacheControl(msg.Content)
+		out.Messages[i] = msg
+	}
+	if len(req.Tools) > 0 {
+		out.Tools = make([]model.Tool, len(req.Tools))
+		for i, tool := range req.Tools {
+			tool.CacheControl = nil
+			out.Tools[i] = tool
+		}
+	}
+	return &out
+}
+
+// withoutCacheControl 返回去掉 cache_control 的内容副本
+func withoutCacheControl(content any) any {
+	switch v := content.(type) {
+	case []model.ContentPart:
+		parts := make([]model.ContentPart, len(v))
+		for i, p := range v {
+			p.CacheControl = nil
+			parts[i] = p
+		}
+		return parts
+	case []any:
+		parts := make([]any, len(v))
+		for i, raw := range v {
+			if p, ok := raw.(map[string]any); ok && p["cache_control"] != nil {
+				copied := make(map[string]any, len(p))
+				for k, val := range p {
+					if k != "cache_control" {
+						copied[k] = val
+					}
+				}
+				raw = copied
+			}
+			parts[i] = raw
+		}
+		return parts
+	default:
+		return content
 	}
 }
 
+// EncodeEmbeddingRequest 生成 embeddings 上游路径和请求体（OpenAI 兼容格式，应用源级模型映射）
+func (t *Translator) EncodeEmbeddingRequest(req *model.EmbeddingRequest, src *model.Source) (string, []byte, error) {
+	renamed := *req
+	renamed.Model = src.UpstreamModel(req.Model)
+	body, err := json.Marshal(&renamed)
+	return openAIEmbeddingsPath, body, err
+}
+
 // degradeFCToPrompt FC 降级为 prompt
 func (t *Translator) degradeFCToPrompt(req *model.ChatCompletionRequest) model.ChatCompletionRequest {
 	// 简单降级：将工具定义添加到 system prompt
@@ -63,25 +161,477 @@ func (t *Translator) degradeFCToPrompt(req *model.ChatCompletionRequest) model.C
 	return degraded
 }
 
-// toAnthropicFormat 转换为 Anthropic 格式
-func (t *Translator) toAnthropicFormat(req *model.ChatCompletionRequest) *model.ChatCompletionRequest {
-	// OpenAI 和 Anthropic 的消息格式兼容性处理
-	// 大部分情况下，使用 OpenAI 兼容端点的 Anthropic 服务（如通过反代）不需要特殊处理
-	// 这里预留扩展点
+// anthropicOutputLimits Anthropic 各模型系列的最大输出 token 数，按模型名前缀匹配（更具体的前缀在前）
+var anthropicOutputLimits = []struct {
+	prefix string
+	limit  int
+}{
+	{"claude-opus-4-5", 64000},
+	{"claude-opus-4", 32000},
+	{"claude-sonnet-4", 64000},
+	{"claude-haiku-4", 64000},
+	{"claude-3-7-sonnet", 64000},
+	{"claude-3-5-", 8192},
+	{"claude-3-", 4096},
+}
+
+// anthropicMaxOutputTokens 返回模型的最大输出 token 数，未知模型返回 0（不限制）
+func anthropicMaxOutputTokens(modelName string) int {
+	for _, l := range anthropicOutputLimits {
+		if strings.HasPrefix(modelName, l.prefix) {
+			return l.limit
+		}
+	}
+	return 0
+}
+
+// toAnthropicFormat 转换为 Anthropic Messages API 格式
+func (t *Translator) toAnthropicFormat(req *model.ChatCompletionRequest) *model.AnthropicRequest {
+	out := &model.AnthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   defaultAnthropicMaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+	}
+	if req.MaxTokens != nil && *req.MaxTokens > 0 {
+		out.MaxTokens = *req.MaxTokens
+	}
+	if req.User != "" {
+		out.Metadata = &model.AnthropicMetadata{UserID: req.User}
+	}
+	out.StopSequences = parseStopSequences(req.Stop)
+
+	// system/developer 消息提升到顶层 system 字段
+	legacyIDs := make(map[string]string) // 函数名 -> 最近一次 legacy function_call 的工具调用 ID
+	for i, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			for _, block := range toAnthropicBlocks(msg.Content) {
+				if block.Type == "text" {
+					out.System = append(out.System, block)
+				}
+			}
+		default:
+			// legacy function_call 没有 ID：调用按消息序号生成，随后的 function 结果沿用同名调用的 ID
+			var legacyID string
+			switch {
+			case msg.FunctionCall != nil:
+				legacyID = legacyFunctionCallID(msg.FunctionCall.Name, i)
+				legacyIDs[msg.FunctionCall.Name] = legacyID
+			case (msg.Role == "function" || msg.Role == "tool") && msg.ToolCallID == "":
+				if legacyID = legacyIDs[msg.Name]; legacyID == "" {
+					legacyID = legacyFunctionCallID(msg.Name, i)
+				}
+			}
+			role, blocks := toAnthropicMessage(msg, legacyID)
+			if len(blocks) == 0 {
+				continue
+			}
+			// Anthropic 要求 user/assistant 交替出现，相邻同角色消息合并
+			if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+				out.Messages[n-1].Content = append(out.Messages[n-1].Content, blocks...)
+				continue
+			}
+			out.Messages = append(out.Messages, model.AnthropicMessage{Role: role, Content: blocks})
+		}
+	}
+	// Anthropic 要求第一条消息为 user：以 assistant 开头的对话（如只有 system 与预填充回答）补一条占位消息
+	if len(out.Messages) > 0 && out.Messages[0].Role == "assistant" {
+		lead := model.AnthropicMessage{Role: "user", Content: model.AnthropicContent{{Type: "text", Text: anthropicLeadingUserText}}}
+		out.Messages = append([]model.AnthropicMessage{lead}, out.Messages...)
+	}
+
+	// 工具定义
+	for _, tool := range collectTools(req) {
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		out.Tools = append(out.Tools, model.AnthropicTool{
+			Name:         tool.Function.Name,
+			Description:  tool.Function.Description,
+			InputSchema:  schema,
+			CacheControl: tool.CacheControl,
+		})
+	}
+	if len(out.Tools) > 0 {
+		choice := req.ToolChoice
+		if choice == nil {
+			choice = req.FunctionCall
+		}
+		out.ToolChoice = toAnthropicToolChoice(choice)
+	}
+
+	// Thinking：budget 需 >= 1024 且小于 max_tokens，且不能与 temperature/top_p 同时设置
+	// 开启 thinking 时 tool_choice 只能是 auto/none：要求强制调用工具的请求以工具调用为准，不开启 thinking
+	forcedTool := out.ToolChoice != nil && (out.ToolChoice.Type == "any" || out.ToolChoice.Type == "tool")
+	if req.HasThinking() && !forcedTool {
+		budget := req.Thinking.BudgetTokens
+		if budget < minAnthropicThinkingBudget {
+			budget = minAnthropicThinkingBudget
+		}
+		if out.MaxTokens <= budget {
+			out.MaxTokens = budget + defaultAnthropicMaxTokens
+		}
+		// max_tokens 不能超过模型的输出上限：超出时压到上限，budget 相应缩小为回答留出空间
+		if limit := anthropicMaxOutputTokens(out.Model); limit > 0 && out.MaxTokens > limit {
+			out.MaxTokens = limit
+			if budget >= limit {
+				budget = limit - defaultAnthropicMaxTokens
+				if budget < minAnthropicThinkingBudget {
+					budget = minAnthropicThinkingBudget
+				}
+			}
+		}
+		out.Thinking = &model.ThinkingConfig{Type: "enabled", BudgetTokens: budget}
+		out.Temperature = nil
+		out.TopP = nil
+	}
+
+	return out
+}
 
-	return req
+// toAnthropicMessage 将单条 OpenAI 消息转换为 Anthropic 角色和内容块
+// legacyID 为 legacy function_call 及其结果使用的工具调用 ID
+func toAnthropicMessage(msg model.Message, legacyID string) (string, model.AnthropicContent) {
+	switch msg.Role {
+	case "tool", "function":
+		toolUseID := msg.ToolCallID
+		if toolUseID == "" {
+			toolUseID = legacyID
+		}
+		result := model.AnthropicContentBlock{
+			Type:      "tool_result",
+			ToolUseID: toolUseID,
+			Content:   toAnthropicBlocks(msg.Content),
+		}
+		// 缓存断点标在 tool_result 块本身
+		for i := range result.Content {
+			if cc := result.Content[i].CacheControl; cc != nil {
+				result.CacheControl = cc
+				result.Content[i].CacheControl = nil
+			}
+		}
+		return "user", model.AnthropicContent{result}
+	case "assistant":
+		// 开启 thinking 时 Anthropic 要求工具调用轮次原样回传带签名的思考块，且位于最前
+		blocks := toAnthropicThinkingBlocks(msg.ThinkingBlocks)
+		blocks = append(blocks, toAnthropicBlocks(msg.Content)...)
+		for _, tc := range msg.ToolCalls {
+			blocks = append(blocks, model.AnthropicContentBlock{
+				Type:  "tool_use",
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: toolArgumentsToInput(tc.Function.Arguments),
+			})
+		}
+		if msg.FunctionCall != nil {
+			blocks = append(blocks, model.AnthropicContentBlock{
+				Type:  "tool_use",
+				ID:    legacyID,
+				Name:  msg.FunctionCall.Name,
+				Input: toolArgumentsToInput(msg.FunctionCall.Arguments),
+			})
+		}
+		return "assistant", blocks
+	default:
+		return "user", toAnthropicBlocks(msg.Content)
+	}
 }
 
-// TranslateResponse 转换响应
-func (t *Translator) TranslateResponse(resp *model.ChatCompletionResponse, src *model.Source) *model.ChatCompletionResponse {
-	// 目前直接返回，预留扩展点
-	return resp
+// toAnthropicThinkingBlocks 转换思考块；没有签名的思考文本无法通过 Anthropic 校验，不回传
+func toAnthropicThinkingBlocks(thinking []model.ThinkingBlock) model.AnthropicContent {
+	var blocks model.AnthropicContent
+	for _, tb := range thinking {
+		switch {
+		case tb.Type == "redacted_thinking" && tb.Data != "":
+			blocks = append(blocks, model.AnthropicContentBlock{Type: "redacted_thinking", Data: tb.Data})
+		case tb.Type == "thinking" && tb.Signature != "":
+			blocks = append(blocks, model.AnthropicContentBlock{Type: "thinking", Thinking: tb.Thinking, Signature: tb.Signature})
+		}
+	}
+	return blocks
 }
 
-// TranslateStreamChunk 转换流式响应块
-func (t *Translator) TranslateStreamChunk(chunk *model.StreamChunk, src *model.Source) *model.StreamChunk {
-	// 目前直接返回，预留扩展点
-	return chunk
+// toAnthropicBlocks 将 OpenAI content（string 或多模态数组）转换为内容块
+func toAnthropicBlocks(content any) model.AnthropicContent {
+	switch v := content.(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+		return model.AnthropicContent{{Type: "text", Text: v}}
+	case []model.ContentPart:
+		var blocks model.AnthropicContent
+		for _, part := range v {
+			if block, ok := contentPartToBlock(part); ok {
+				blocks = append(blocks, block)
+			}
+		}
+		return blocks
+	case []any:
+		var blocks model.AnthropicContent
+		for _, raw := range v {
+			b, _ := json.Marshal(raw)
+			var part model.ContentPart
+			if json.Unmarshal(b, &part) != nil {
+				continue
+			}
+			if block, ok := contentPartToBlock(part); ok {
+				blocks = append(blocks, block)
+			}
+		}
+		return blocks
+	default:
+		if text := contentText(v); text != "" {
+			return model.AnthropicContent{{Type: "text", Text: text}}
+		}
+		return nil
+	}
+}
+
+// contentPartToBlock 转换单个多模态内容部分
+func contentPartToBlock(part model.ContentPart) (model.AnthropicContentBlock, bool) {
+	switch part.Type {
+	case "text":
+		if part.Text == "" {
+			return model.AnthropicContentBlock{}, false
+		}
+		return model.AnthropicContentBlock{Type: "text", Text: part.Text, CacheControl: part.CacheControl}, true
+	case "image_url":
+		if part.ImageURL == nil || part.ImageURL.URL == "" {
+			return model.AnthropicContentBlock{}, false
+		}
+		return model.AnthropicContentBlock{Type: "image", Source: imageURLToSource(part.ImageURL.URL), CacheControl: part.CacheControl}, true
+	default:
+		return model.AnthropicContentBlock{}, false
+	}
+}
+
+// imageURLToSource 解析 data URL（base64）或普通 URL
+func imageURLToSource(url string) *model.AnthropicImageSource {
+	if strings.HasPrefix(url, "data:") {
+		meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+		if ok && strings.HasSuffix(meta, ";base64") {
+			return &model.AnthropicImageSource{
+				Type:      "base64",
+				MediaType: strings.TrimSuffix(meta, ";base64"),
+				Data:      data,
+			}
+		}
+	}
+	return &model.AnthropicImageSource{Type: "url", URL: url}
+}
+
+// toolArgumentsToInput 将 OpenAI 字符串参数转为 Anthropic input 对象
+func toolArgumentsToInput(args string) json.RawMessage {
+	args = strings.TrimSpace(args)
+	if args == "" || args == "null" {
+		return json.RawMessage("{}")
+	}
+	var obj map[string]any
+	if json.Unmarshal([]byte(args), &obj) == nil {
+		return json.RawMessage(args)
+	}
+	b, _ := json.Marshal(map[string]string{"input": args})
+	return b
+}
+
+// toAnthropicToolChoice 转换 tool_choice / function_call
+func toAnthropicToolChoice(choice any) *model.AnthropicToolChoice {
+	switch v := choice.(type) {
+	case string:
+		switch v {
+		case "none":
+			return &model.AnthropicToolChoice{Type: "none"}
+		case "required", "any":
+			return &model.AnthropicToolChoice{Type: "any"}
+		case "auto":
+			return &model.AnthropicToolChoice{Type: "auto"}
+		}
+	case map[string]any:
+		// {"type":"function","function":{"name":"x"}} 或 legacy {"name":"x"}
+		if fn, ok := v["function"].(map[string]any); ok {
+			if name, _ := fn["name"].(string); name != "" {
+				return &model.AnthropicToolChoice{Type: "tool", Name: name}
+			}
+		}
+		if name, _ := v["name"].(string); name != "" {
+			return &model.AnthropicToolChoice{Type: "tool", Name: name}
+		}
+	}
+	return nil
+}
+
+// parseStopSequences 解析 stop（string 或 []string）
+func parseStopSequences(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		if single == "" {
+			return nil
+		}
+		return []string{single}
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	return nil
+}
+
+// collectTools 合并 tools 与 legacy functions
+func collectTools(req *model.ChatCompletionRequest) []model.Tool {
+	if len(req.Tools) > 0 {
+		return req.Tools
+	}
+	tools := make([]model.Tool, 0, len(req.Functions))
+	for _, fn := range req.Functions {
+		tools = append(tools, model.Tool{Type: "function", Function: fn})
+	}
+	return tools
+}
+
+// legacyFunctionCallID 为 legacy function_call 生成工具调用 ID（同名函数多次调用时按消息序号区分）
+func legacyFunctionCallID(name string, index int) string {
+	return "call_" + name + "_" + strconv.Itoa(index)
+}
+
+// contentText 提取消息中的纯文本
+func contentText(content any) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return strings.Join(textParts(v), "\n")
+	}
+}
+
+// textParts 提取多模态数组中的 text 部分
+func textParts(content any) []string {
+	var texts []string
+	switch v := content.(type) {
+	case []model.ContentPart:
+		for _, p := range v {
+			if p.Type == "text" && p.Text != "" {
+				texts = append(texts, p.Text)
+			}
+		}
+	case []any:
+		for _, raw := range v {
+			if p, ok := raw.(map[string]any); ok && p["type"] == "text" {
+				if text, _ := p["text"].(string); text != "" {
+					texts = append(texts, text)
+				}
+			}
+		}
+	}
+	return texts
+}
+
+// TranslateResponse 将上游响应体转换为 OpenAI 格式
+func (t *Translator) TranslateResponse(body []byte, src *model.Source) (*model.ChatCompletionResponse, error) {
+	switch src.Type {
+	case model.SourceTypeAnthropic:
+		var anthropicResp model.AnthropicResponse
+		if err := json.Unmarshal(body, &anthropicResp); err != nil {
+			return nil, err
+		}
+		return t.fromAnthropicResponse(&anthropicResp), nil
+	default:
+		var chatResp model.ChatCompletionResponse
+		if err := json.Unmarshal(body, &chatResp); err != nil {
+			return nil, err
+		}
+		return &chatResp, nil
+	}
+}
+
+// fromAnthropicResponse Anthropic 响应转换为 OpenAI 格式
+func (t *Translator) fromAnthropicResponse(resp *model.AnthropicResponse) *model.ChatCompletionResponse {
+	msg := &model.Message{Role: "assistant"}
+	var text, reasoning strings.Builder
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			reasoning.WriteString(block.Thinking)
+			msg.ThinkingBlocks = append(msg.ThinkingBlocks, model.ThinkingBlock{Type: "thinking", Thinking: block.Thinking, Signature: block.Signature})
+		case "redacted_thinking":
+			msg.ThinkingBlocks = append(msg.ThinkingBlocks, model.ThinkingBlock{Type: "redacted_thinking", Data: block.Data})
+		case "tool_use":
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, model.ToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: model.FunctionCall{
+					Name:      block.Name,
+					Arguments: args,
+				},
+			})
+		}
+	}
+	msg.Content = text.String()
+	msg.ReasoningContent = reasoning.String()
+
+	return &model.ChatCompletionResponse{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   resp.Model,
+		Choices: []model.Choice{
+			{
+				Index:        0,
+				Message:      msg,
+				FinishReason: anthropicStopReasonToFinish(resp.StopReason),
+			},
+		},
+		Usage: anthropicUsageToUsage(resp.Usage),
+	}
+}
+
+// anthropicStopReasonToFinish 映射 stop_reason 到 finish_reason
+func anthropicStopReasonToFinish(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	case "":
+		return ""
+	default: // end_turn, stop_sequence, pause_turn
+		return "stop"
+	}
+}
+
+// anthropicUsageToUsage 映射 usage，缓存命中/写入计入 prompt tokens
+func anthropicUsageToUsage(u model.AnthropicUsage) *model.Usage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	usage := &model.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+	}
+	if u.CacheReadInputTokens > 0 || u.CacheCreationInputTokens > 0 {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:        u.CacheReadInputTokens,
+			CacheCreationTokens: u.CacheCreationInputTokens,
+		}
+	}
+	return usage
 }
 
 // TranslateError 转换错误响应
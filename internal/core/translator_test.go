@@ -0,0 +1,316 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+func TestToAnthropicFormat_HoistsSystemAndMergesToolResults(t *testing.T) {
+	tr := NewTranslator()
+	maxTokens := 512
+	req := &model.ChatCompletionRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: &maxTokens,
+		Stop:      json.RawMessage(`"END"`),
+		Messages: []model.Message{
+			{Role: "system", Content: "You are helpful."},
+			{Role: "user", Content: "weather in Paris and Rome?"},
+			{Role: "assistant", ToolCalls: []model.ToolCall{
+				{ID: "call_1", Type: "function", Function: model.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+				{ID: "call_2", Type: "function", Function: model.FunctionCall{Name: "get_weather", Arguments: `{"city":"Rome"}`}},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Content: "sunny"},
+			{Role: "tool", ToolCallID: "call_2", Content: "rainy"},
+		},
+		Tools: []model.Tool{
+			{Type: "function", Function: model.Function{Name: "get_weather", Parameters: map[string]any{"type": "object"}}},
+		},
+		ToolChoice: "required",
+	}
+
+	out := tr.toAnthropicFormat(req)
+
+	if len(out.System) != 1 || out.System[0].Text != "You are helpful." {
+		t.Fatalf("expected system prompt hoisted, got %+v", out.System)
+	}
+	if out.MaxTokens != 512 {
+		t.Errorf("expected max_tokens=512, got %d", out.MaxTokens)
+	}
+	if len(out.StopSequences) != 1 || out.StopSequences[0] != "END" {
+		t.Errorf("expected stop_sequences [END], got %v", out.StopSequences)
+	}
+	if len(out.Messages) != 3 {
+		t.Fatalf("expected 3 messages (user, assistant, user), got %d", len(out.Messages))
+	}
+
+	assistant := out.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 2 || assistant.Content[0].Type != "tool_use" {
+		t.Fatalf("unexpected assistant message: %+v", assistant)
+	}
+	if string(assistant.Content[0].Input) != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool_use input: %s", assistant.Content[0].Input)
+	}
+
+	results := out.Messages[2]
+	if results.Role != "user" || len(results.Content) != 2 {
+		t.Fatalf("expected tool results merged into one user message, got %+v", results)
+	}
+	if results.Content[1].Type != "tool_result" || results.Content[1].ToolUseID != "call_2" || results.Content[1].Content.Text() != "rainy" {
+		t.Errorf("unexpected tool_result block: %+v", results.Content[1])
+	}
+
+	if out.ToolChoice == nil || out.ToolChoice.Type != "any" {
+		t.Errorf("expected tool_choice any, got %+v", out.ToolChoice)
+	}
+	if len(out.Tools) != 1 || out.Tools[0].Name != "get_weather" {
+		t.Errorf("unexpected tools: %+v", out.Tools)
+	}
+}
+
+func TestToAnthropicFormat_ImageBlocks(t *testing.T) {
+	tr := NewTranslator()
+	req := &model.ChatCompletionRequest{
+		Model: "claude-sonnet-4",
+		Messages: []model.Message{
+			{Role: "user", Content: []any{
+				map[string]any{"type": "text", "text": "what is this?"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AAAA"}},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/cat.jpg"}},
+			}},
+		},
+	}
+
+	out := tr.toAnthropicFormat(req)
+	blocks := out.Messages[0].Content
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(blocks))
+	}
+	if blocks[1].Type != "image" || blocks[1].Source.Type != "base64" || blocks[1].Source.MediaType != "image/png" || blocks[1].Source.Data != "AAAA" {
+		t.Errorf("unexpected base64 image block: %+v", blocks[1].Source)
+	}
+	if blocks[2].Source.Type != "url" || blocks[2].Source.URL != "https://example.com/cat.jpg" {
+		t.Errorf("unexpected url image block: %+v", blocks[2].Source)
+	}
+}
+
+func TestToAnthropicFormat_ThinkingBudget(t *testing.T) {
+	tr := NewTranslator()
+	temp := 0.5
+	maxTokens := 1000
+	req := &model.ChatCompletionRequest{
+		Model:       "claude-sonnet-4",
+		MaxTokens:   &maxTokens,
+		Temperature: &temp,
+		Messages:    []model.Message{{Role: "user", Content: "think hard"}},
+		Thinking:    &model.ThinkingConfig{Type: "enabled", BudgetTokens: 2000},
+	}
+
+	out := tr.toAnthropicFormat(req)
+	if out.Thinking == nil || out.Thinking.BudgetTokens != 2000 {
+		t.Fatalf("expected thinking budget 2000, got %+v", out.Thinking)
+	}
+	if out.MaxTokens <= 2000 {
+		t.Errorf("expected max_tokens raised above budget, got %d", out.MaxTokens)
+	}
+	if out.Temperature != nil {
+		t.Error("expected temperature removed when thinking is enabled")
+	}
+}
+
+func TestTranslateResponse_Anthropic(t *testing.T) {
+	tr := NewTranslator()
+	src := &model.Source{Type: model.SourceTypeAnthropic}
+	body := []byte(`{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4",
+		"content": [
+			{"type": "thinking", "thinking": "let me check", "signature": "sig"},
+			{"type": "text", "text": "Checking."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5, "cache_read_input_tokens": 3}
+	}`)
+
+	resp, err := tr.TranslateResponse(body, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := resp.Choices[0].Message
+	if msg.Content != "Checking." {
+		t.Errorf("expected content 'Checking.', got %v", msg.Content)
+	}
+	if msg.ReasoningContent != "let me check" {
+		t.Errorf("expected reasoning content, got %q", msg.ReasoningContent)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID != "toolu_1" || msg.ToolCalls[0].Function.Arguments != `{"city": "Paris"}` {
+		t.Errorf("unexpected tool calls: %+v", msg.ToolCalls)
+	}
+	if resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("expected finish_reason tool_calls, got %s", resp.Choices[0].FinishReason)
+	}
+	if resp.Usage.PromptTokens != 13 || resp.Usage.CompletionTokens != 5 || resp.Usage.TotalTokens != 18 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+	if len(msg.ThinkingBlocks) != 1 || msg.ThinkingBlocks[0].Signature != "sig" {
+		t.Errorf("expected signed thinking block, got %+v", msg.ThinkingBlocks)
+	}
+}
+
+func TestToAnthropicFormat_LegacyFunctionCallIDs(t *testing.T) {
+	tr := NewTranslator()
+	req := &model.ChatCompletionRequest{
+		Model: "claude-sonnet-4",
+		Messages: []model.Message{
+			{Role: "user", Content: "weather in Paris and Rome?"},
+			{Role: "assistant", FunctionCall: &model.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+			{Role: "function", Name: "get_weather", Content: "sunny"},
+			{Role: "assistant", FunctionCall: &model.FunctionCall{Name: "get_weather", Arguments: `{"city":"Rome"}`}},
+			{Role: "function", Name: "get_weather", Content: "rainy"},
+		},
+	}
+
+	out := tr.toAnthropicFormat(req)
+	if len(out.Messages) != 5 {
+		t.Fatalf("expected 5 messages, got %+v", out.Messages)
+	}
+	first, second := out.Messages[1].Content[0].ID, out.Messages[3].Content[0].ID
+	if first == "" || first == second {
+		t.Errorf("expected distinct tool_use ids, got %q and %q", first, second)
+	}
+	if out.Messages[2].Content[0].ToolUseID != first || out.Messages[4].Content[0].ToolUseID != second {
+		t.Errorf("expected results to reference their calls, got %+v", out.Messages)
+	}
+}
+
+func TestToAnthropicFormat_ThinkingBlocksBeforeToolUse(t *testing.T) {
+	tr := NewTranslator()
+	req := &model.ChatCompletionRequest{
+		Model: "claude-sonnet-4",
+		Messages: []model.Message{
+			{Role: "user", Content: "weather?"},
+			{
+				Role:             "assistant",
+				ReasoningContent: "let me check",
+				ThinkingBlocks: []model.ThinkingBlock{
+					{Type: "thinking", Thinking: "let me check", Signature: "sig"},
+					{Type: "redacted_thinking", Data: "opaque"},
+				},
+				ToolCalls: []model.ToolCall{{ID: "toolu_1", Type: "function", Function: model.FunctionCall{Name: "get_weather", Arguments: "{}"}}},
+			},
+			{Role: "tool", ToolCallID: "toolu_1", Content: "sunny"},
+		},
+		Thinking: &model.ThinkingConfig{Type: "enabled", BudgetTokens: 2000},
+	}
+
+	blocks := tr.toAnthropicFormat(req).Messages[1].Content
+	if len(blocks) != 3 || blocks[0].Type != "thinking" || blocks[0].Signature != "sig" || blocks[1].Type != "redacted_thinking" || blocks[2].Type != "tool_use" {
+		t.Errorf("expected signed thinking blocks before tool_use, got %+v", blocks)
+	}
+
+	_, body, _ := tr.EncodeRequest(req, &model.Source{Type: model.SourceTypeOpenAI})
+	if strings.Contains(string(body), "thinking_blocks") {
+		t.Errorf("expected thinking blocks stripped for openai sources, got %s", body)
+	}
+}
+
+func TestEncodeRequest_PathBySourceType(t *testing.T) {
+	tr := NewTranslator()
+	req := &model.ChatCompletionRequest{Model: "m", Messages: []model.Message{{Role: "user", Content: "hi"}}}
+
+	path, _, err := tr.EncodeRequest(req, &model.Source{Type: model.SourceTypeOpenAI})
+	if err != nil || path != "/v1/chat/completions" {
+		t.Errorf("openai: got path=%s err=%v", path, err)
+	}
+
+	path, body, err := tr.EncodeRequest(req, &model.Source{Type: model.SourceTypeAnthropic})
+	if err != nil || path != "/v1/messages" {
+		t.Fatalf("anthropic: got path=%s err=%v", path, err)
+	}
+	var decoded map[string]any
+	json.Unmarshal(body, &decoded)
+	if decoded["max_tokens"] == nil {
+		t.Error("expected max_tokens in anthropic body")
+	}
+}
+
+func TestToAnthropicFormat_LeadingAssistantMessage(t *testing.T) {
+	tr := NewTranslator()
+	req := &model.ChatCompletionRequest{
+		Model: "claude-sonnet-4",
+		Messages: []model.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "assistant", Content: "Hello! How can I help?"},
+			{Role: "user", Content: "hi"},
+		},
+	}
+
+	out := tr.toAnthropicFormat(req)
+	if len(out.Messages) != 3 || out.Messages[0].Role != "user" || out.Messages[1].Role != "assistant" {
+		t.Fatalf("expected a placeholder user message before the assistant turn, got %+v", out.Messages)
+	}
+	if out.Messages[0].Content[0].Text == "" {
+		t.Error("expected placeholder user message to carry text")
+	}
+}
+
+func TestToAnthropicFormat_ForcedToolChoiceDisablesThinking(t *testing.T) {
+	tr := NewTranslator()
+	for _, choice := range []any{"required", map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}} {
+		req := &model.ChatCompletionRequest{
+			Model:      "claude-sonnet-4",
+			Messages:   []model.Message{{Role: "user", Content: "weather?"}},
+			Tools:      []model.Tool{{Type: "function", Function: model.Function{Name: "get_weather"}}},
+			ToolChoice: choice,
+			Thinking:   &model.ThinkingConfig{Type: "enabled", BudgetTokens: 2000},
+		}
+
+		out := tr.toAnthropicFormat(req)
+		if out.ToolChoice == nil || (out.ToolChoice.Type != "any" && out.ToolChoice.Type != "tool") {
+			t.Fatalf("expected forced tool_choice to be kept, got %+v", out.ToolChoice)
+		}
+		if out.Thinking != nil {
+			t.Errorf("expected thinking dropped with tool_choice %v, got %+v", choice, out.Thinking)
+		}
+	}
+
+	req := &model.ChatCompletionRequest{
+		Model:      "claude-sonnet-4",
+		Messages:   []model.Message{{Role: "user", Content: "weather?"}},
+		Tools:      []model.Tool{{Type: "function", Function: model.Function{Name: "get_weather"}}},
+		ToolChoice: "auto",
+		Thinking:   &model.ThinkingConfig{Type: "enabled", BudgetTokens: 2000},
+	}
+	if out := tr.toAnthropicFormat(req); out.Thinking == nil {
+		t.Error("expected thinking kept with tool_choice auto")
+	}
+}
+
+func TestToAnthropicFormat_ThinkingMaxTokensCappedAtModelLimit(t *testing.T) {
+	tr := NewTranslator()
+	req := &model.ChatCompletionRequest{
+		Model:    "claude-opus-4-1-20250805",
+		Messages: []model.Message{{Role: "user", Content: "think hard"}},
+		Thinking: &model.ThinkingConfig{Type: "enabled", BudgetTokens: 40000},
+	}
+
+	out := tr.toAnthropicFormat(req)
+	if out.MaxTokens != 32000 {
+		t.Errorf("expected max_tokens capped at the model limit 32000, got %d", out.MaxTokens)
+	}
+	if out.Thinking == nil || out.Thinking.BudgetTokens >= out.MaxTokens {
+		t.Errorf("expected budget below max_tokens, got %+v", out.Thinking)
+	}
+
+	// 未知模型不限制
+	req.Model = "claude-next"
+	if out := tr.toAnthropicFormat(req); out.MaxTokens != 40000+4096 {
+		t.Errorf("expected max_tokens raised above the budget for unknown models, got %d", out.MaxTokens)
+	}
+}
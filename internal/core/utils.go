@@ -28,9 +28,23 @@ func GenerateKeyID() string {
 	return "key_" + hex.EncodeToString(b)
 }
 
+// GenerateRuleID 生成路由规则 ID
+func GenerateRuleID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "rule_" + hex.EncodeToString(b)
+}
+
 // GenerateAPIKey 生成 API Key 值
 func GenerateAPIKey() string {
 	b := make([]byte, 24)
 	rand.Read(b)
 	return "sk-fa-" + hex.EncodeToString(b)
 }
+
+// GenerateToolCallID 生成工具调用 ID
+func GenerateToolCallID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
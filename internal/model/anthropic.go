@@ -0,0 +1,179 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// AnthropicRequest Anthropic Messages API 请求
+type AnthropicRequest struct {
+	Model         string               `json:"model"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	System        AnthropicContent     `json:"system,omitempty"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	TopK          *int                 `json:"top_k,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Thinking      *ThinkingConfig      `json:"thinking,omitempty"`
+	Metadata      *AnthropicMetadata   `json:"metadata,omitempty"`
+}
+
+// AnthropicMessage Anthropic 消息
+type AnthropicMessage struct {
+	Role    string           `json:"role"` // "user" or "assistant"
+	Content AnthropicContent `json:"content"`
+}
+
+// AnthropicContent 内容块列表，反序列化时兼容纯字符串写法
+type AnthropicContent []AnthropicContentBlock
+
+// UnmarshalJSON 支持 "content": "text" 与 "content": [{...}] 两种格式
+func (c *AnthropicContent) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		*c = nil
+		return nil
+	}
+	if strings.HasPrefix(trimmed, "\"") {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*c = AnthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// Text 拼接所有 text 块
+func (c AnthropicContent) Text() string {
+	var sb strings.Builder
+	for _, b := range c {
+		if b.Type == "text" {
+			sb.WriteString(b.Text)
+		}
+	}
+	return sb.String()
+}
+
+// AnthropicContentBlock 内容块
+type AnthropicContentBlock struct {
+	Type string `json:"type"` // text | image | tool_use | tool_result | thinking | redacted_thinking
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image
+	Source *AnthropicImageSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   AnthropicContent `json:"content,omitempty"`
+	IsError   bool             `json:"is_error,omitempty"`
+
+	// thinking / redacted_thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
+
+	// 提示缓存断点（text / image / tool_result）
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// AnthropicImageSource 图片来源
+type AnthropicImageSource struct {
+	Type      string `json:"type"` // "base64" or "url"
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// AnthropicTool 工具定义
+type AnthropicTool struct {
+	Name         string         `json:"name"`
+	Description  string         `json:"description,omitempty"`
+	InputSchema  map[string]any `json:"input_schema"`
+	CacheControl *CacheControl  `json:"cache_control,omitempty"`
+}
+
+// AnthropicToolChoice 工具选择
+type AnthropicToolChoice struct {
+	Type string `json:"type"` // auto | any | tool | none
+	Name string `json:"name,omitempty"`
+}
+
+// AnthropicMetadata 请求元数据
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// AnthropicResponse Anthropic Messages API 响应
+type AnthropicResponse struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"` // "message"
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      AnthropicContent `json:"content"`
+	StopReason   string           `json:"stop_reason,omitempty"`
+	StopSequence string           `json:"stop_sequence,omitempty"`
+	Usage        AnthropicUsage   `json:"usage"`
+}
+
+// AnthropicUsage Token 使用量
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// AnthropicErrorResponse Anthropic 错误响应
+type AnthropicErrorResponse struct {
+	Type  string         `json:"type"` // "error"
+	Error AnthropicError `json:"error"`
+}
+
+// AnthropicError 错误详情
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// AnthropicStreamEvent Anthropic 流式事件
+// type: message_start | content_block_start | content_block_delta | content_block_stop | message_delta | message_stop | ping | error
+type AnthropicStreamEvent struct {
+	Type         string                 `json:"type"`
+	Message      *AnthropicResponse     `json:"message,omitempty"`
+	Index        int                    `json:"index"`
+	ContentBlock *AnthropicContentBlock `json:"content_block,omitempty"`
+	Delta        *AnthropicStreamDelta  `json:"delta,omitempty"`
+	Usage        *AnthropicUsage        `json:"usage,omitempty"`
+	Error        *AnthropicError        `json:"error,omitempty"`
+}
+
+// AnthropicStreamDelta 流式增量
+// content_block_delta: text_delta | input_json_delta | thinking_delta | signature_delta
+// message_delta: stop_reason / stop_sequence
+type AnthropicStreamDelta struct {
+	Type         string `json:"type,omitempty"`
+	Text         string `json:"text,omitempty"`
+	PartialJSON  string `json:"partial_json,omitempty"`
+	Thinking     string `json:"thinking,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+	StopReason   string `json:"stop_reason,omitempty"`
+	StopSequence string `json:"stop_sequence,omitempty"`
+}
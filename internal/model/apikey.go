@@ -19,21 +19,57 @@ type KeyLimits struct {
 	RPM        int            `json:"rpm"`                    // 每分钟请求数，0=无限
 	DailyQuota int            `json:"daily_quota"`            // 每日配额，0=无限
 	Concurrent int            `json:"concurrent"`             // 并发数，0=无限
+	TPM        int            `json:"tpm,omitempty"`          // 每分钟 token 数（按预估 prompt token 准入，完成后按实际用量校正），0=无限
 	ToolQuotas map[string]int `json:"tool_quotas,omitempty"`  // 工具名 -> 每日配额
+
+	// 预算（0=无限）：用尽后拒绝请求（429），超过软限制阈值时通过响应头预警
+	DailyTokenBudget   int64   `json:"daily_token_budget,omitempty"`   // 每日 token 预算
+	MonthlyTokenBudget int64   `json:"monthly_token_budget,omitempty"` // 每月 token 预算
+	DailyCostBudget    float64 `json:"daily_cost_budget,omitempty"`    // 每日费用预算（美元）
+	MonthlyCostBudget  float64 `json:"monthly_cost_budget,omitempty"`  // 每月费用预算（美元）
+	SoftLimitPercent   int     `json:"soft_limit_percent,omitempty"`   // 软限制阈值（预算百分比），0 表示默认 80
+
+	// 响应缓存：空=跟随全局配置，on=启用，off=关闭
+	ResponseCache string `json:"response_cache,omitempty"`
+}
+
+// HasBudget 是否设置了任一预算
+func (l KeyLimits) HasBudget() bool {
+	return l.DailyTokenBudget > 0 || l.MonthlyTokenBudget > 0 || l.DailyCostBudget > 0 || l.MonthlyCostBudget > 0
+}
+
+// KeySpend Key 在某个周期内的用量
+type KeySpend struct {
+	Tokens int64   `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// BudgetStatus 单项预算的使用情况
+type BudgetStatus struct {
+	Name      string  `json:"name"` // daily_tokens | monthly_tokens | daily_cost | monthly_cost
+	Limit     float64 `json:"limit"`
+	Used      float64 `json:"used"`
+	Remaining float64 `json:"remaining"`
+	Exceeded  bool    `json:"exceeded"` // 已用尽（硬限制）
+	Warning   bool    `json:"warning"`  // 超过软限制阈值
 }
 
 // ClientInfo 客户端信息（存入 gin.Context）
 type ClientInfo struct {
-	KeyID string // 关联的 API Key ID
-	Tool  string // 识别出的工具名
-	IP    string // 客户端 IP
+	KeyID  string    // 关联的 API Key ID
+	Tool   string    // 识别出的工具名
+	IP     string    // 客户端 IP
+	Limits KeyLimits // 关联 Key 的限制
+
+	SessionID string // X-Session-ID 请求头，用于会话粘性路由
 }
 
 // ToolStats 工具使用统计
 type ToolStats struct {
-	Tool         string `json:"tool"`
-	RequestCount int    `json:"request_count"`
-	LastUsedAt   string `json:"last_used_at"`
+	Tool         string  `json:"tool"`
+	RequestCount int     `json:"request_count"`
+	TotalCost    float64 `json:"total_cost"`
+	LastUsedAt   string  `json:"last_used_at"`
 }
 
 // KeyDailyUsage Key 每日使用量
@@ -43,5 +79,6 @@ type KeyDailyUsage struct {
 	SuccessCount int     `json:"success_count"`
 	FailCount    int     `json:"fail_count"`
 	TotalTokens  int64   `json:"total_tokens"`
+	TotalCost    float64 `json:"total_cost"`
 	AvgLatency   float64 `json:"avg_latency_ms"`
 }
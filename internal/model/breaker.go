@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常放行
+	BreakerOpen     = "open"      // 熔断，不路由到该源
+	BreakerHalfOpen = "half_open" // 半开，放行少量真实请求试探恢复
+)
+
+// BreakerState 源熔断器的实时状态（管理接口）
+type BreakerState struct {
+	SourceID   string     `json:"source_id"`
+	SourceName string     `json:"source_name"`
+	State      string     `json:"state"`
+	Requests   int        `json:"requests"`            // 统计窗口内的请求数
+	Failures   int        `json:"failures"`            // 统计窗口内的失败数
+	ErrorRate  float64    `json:"error_rate"`          // 统计窗口内的错误率（0-1）
+	OpenedAt   *time.Time `json:"opened_at,omitempty"` // 最近一次熔断时间
+	RetryAt    *time.Time `json:"retry_at,omitempty"`  // 熔断中：进入半开的时间
+	Successes  int        `json:"successes,omitempty"` // 半开中：已连续成功的试探请求数
+}
+
+// BreakerTransition 熔断器状态转换记录
+type BreakerTransition struct {
+	SourceID   string    `json:"source_id"`
+	SourceName string    `json:"source_name"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Reason     string    `json:"reason"`
+	At         time.Time `json:"at"`
+}
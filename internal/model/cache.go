@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// CachedResponse 响应缓存条目
+// 非流式请求缓存完整响应，流式请求缓存转换后的 OpenAI chunk 数据帧，回放时按入口协议重新编码
+type CachedResponse struct {
+	Response   *ChatCompletionResponse `json:"response,omitempty"`
+	Frames     []string                `json:"frames,omitempty"`
+	SourceID   string                  `json:"source_id"`
+	SourceName string                  `json:"source_name"`
+	CreatedAt  time.Time               `json:"created_at"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// CompletionRequest OpenAI 旧版 /v1/completions 请求（仅包含转换为聊天请求所需的字段，原生透传时转发完整请求体）
+type CompletionRequest struct {
+	Model            string          `json:"model"`
+	Prompt           json.RawMessage `json:"prompt"` // 字符串或只含一个字符串的数组
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	N                *int            `json:"n,omitempty"`
+	Stream           bool            `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
+	Stop             json.RawMessage `json:"stop,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	LogitBias        map[string]int  `json:"logit_bias,omitempty"`
+	User             string          `json:"user,omitempty"`
+}
+
+// ErrUnsupportedPrompt prompt 不是单个字符串
+var ErrUnsupportedPrompt = errors.New("prompt must be a string or an array with a single string")
+
+// PromptText 返回 prompt 文本；批量 prompt 与 token 数组无法转换为一条聊天消息
+func (r *CompletionRequest) PromptText() (string, error) {
+	var text string
+	if err := json.Unmarshal(r.Prompt, &text); err == nil {
+		return text, nil
+	}
+	var texts []string
+	if err := json.Unmarshal(r.Prompt, &texts); err == nil && len(texts) == 1 {
+		return texts[0], nil
+	}
+	return "", ErrUnsupportedPrompt
+}
+
+// CompletionResponse OpenAI 旧版 completions 响应，流式时每个 chunk 也使用该结构
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"` // text_completion
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+// CompletionChoice completions 选项
+type CompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"` // 流式中间块为 null
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// EmbeddingRequest OpenAI 兼容的 embeddings 请求
+type EmbeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"` // 字符串、字符串数组、token 数组或 token 数组的数组，原样转发
+	EncodingFormat string          `json:"encoding_format,omitempty"`
+	Dimensions     *int            `json:"dimensions,omitempty"`
+	User           string          `json:"user,omitempty"`
+}
+
+// EmbeddingInput 批次中的一条输入：文本或已分词的 token 数组
+type EmbeddingInput struct {
+	Text   string
+	Tokens []int
+}
+
+// ErrInvalidEmbeddingInput input 不是受支持的格式或为空
+var ErrInvalidEmbeddingInput = errors.New("input must be a non-empty string, array of strings, array of tokens, or array of token arrays")
+
+// Inputs 将 input 解析为批次内的各条输入
+func (r *EmbeddingRequest) Inputs() ([]EmbeddingInput, error) {
+	var text string
+	if err := json.Unmarshal(r.Input, &text); err == nil {
+		return []EmbeddingInput{{Text: text}}, nil
+	}
+	var texts []string
+	if err := json.Unmarshal(r.Input, &texts); err == nil && len(texts) > 0 {
+		inputs := make([]EmbeddingInput, len(texts))
+		for i, t := range texts {
+			inputs[i] = EmbeddingInput{Text: t}
+		}
+		return inputs, nil
+	}
+	var tokens []int
+	if err := json.Unmarshal(r.Input, &tokens); err == nil && len(tokens) > 0 {
+		return []EmbeddingInput{{Tokens: tokens}}, nil
+	}
+	var batches [][]int
+	if err := json.Unmarshal(r.Input, &batches); err == nil && len(batches) > 0 {
+		inputs := make([]EmbeddingInput, len(batches))
+		for i, t := range batches {
+			inputs[i] = EmbeddingInput{Tokens: t}
+		}
+		return inputs, nil
+	}
+	return nil, ErrInvalidEmbeddingInput
+}
+
+// EmbeddingResponse OpenAI 兼容的 embeddings 响应
+type EmbeddingResponse struct {
+	Object string          `json:"object"`
+	Data   []EmbeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  *Usage          `json:"usage,omitempty"`
+}
+
+// EmbeddingData 单条输入的向量
+type EmbeddingData struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"` // 浮点数组或 base64 字符串（encoding_format=base64）
+}
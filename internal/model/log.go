@@ -10,6 +10,7 @@ type RequestLog struct {
 	SourceID  string    `json:"source_id"`
 	SourceName string   `json:"source_name"`
 	Model     string    `json:"model"`
+	Endpoint  string    `json:"endpoint,omitempty"` // 非聊天接口（如 embeddings），聊天补全为空
 
 	// 请求信息
 	HasTools    bool `json:"has_tools"`
@@ -26,11 +27,22 @@ type RequestLog struct {
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
 
+	// 上游 prompt 缓存（均包含在 PromptTokens 内）
+	CachedTokens        int `json:"cached_tokens,omitempty"`         // 命中缓存的输入 token
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // 写入缓存的输入 token（Anthropic）
+
+	// 费用（美元，按源价格表计算）
+	Cost float64 `json:"cost"`
+
 	// 错误信息
 	Error string `json:"error,omitempty"`
 
 	// Failover 记录
 	FailoverFrom string `json:"failover_from,omitempty"`
+	Hedged       bool   `json:"hedged,omitempty"` // 请求发出过对冲请求（输掉竞争被取消的一方也会单独记录）
+
+	// 响应缓存
+	CacheHit bool `json:"cache_hit,omitempty"` // 由响应缓存直接返回，未请求上游
 
 	// 客户端信息
 	ClientIP   string `json:"client_ip,omitempty"`
@@ -39,6 +51,7 @@ type RequestLog struct {
 
 	// FC 兼容层
 	FCCompatUsed bool `json:"fc_compat_used,omitempty"`
+	RepairCount  int  `json:"repair_count,omitempty"` // 工具参数校验失败后的修复次数
 }
 
 // UsageStats 用量统计
@@ -61,6 +74,7 @@ type DailyStats struct {
 	TotalRequests int    `json:"total_requests"`
 	SuccessRate   float64 `json:"success_rate"`
 	TotalTokens   int64   `json:"total_tokens"`
+	TotalCost     float64 `json:"total_cost"`
 	AvgLatency    float64 `json:"avg_latency_ms"`
 }
 
@@ -72,6 +86,7 @@ type SourceStats struct {
 	SuccessRate  float64 `json:"success_rate"`
 	AvgLatency   float64 `json:"avg_latency_ms"`
 	TotalTokens  int64   `json:"total_tokens"`
+	TotalCost    float64 `json:"total_cost"`
 }
 
 // LogQuery 日志查询参数
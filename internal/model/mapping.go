@@ -0,0 +1,138 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ModelRegexPrefix 正则映射规则前缀
+const ModelRegexPrefix = "regex:"
+
+// ModelMapping 模型映射规则
+// Match 支持三种写法：
+//   - 精确名称：gpt-4o
+//   - 通配符：claude-sonnet*（* 匹配任意字符，? 匹配单个字符）
+//   - 正则：regex:^gpt-4o-(\d+)$（整体匹配，Target 可用 $1 引用捕获组）
+type ModelMapping struct {
+	Match  string `json:"match" yaml:"match"`
+	Target string `json:"target" yaml:"target"`
+}
+
+// compiledPatterns 已编译的通配符/正则规则缓存
+var compiledPatterns sync.Map // pattern -> *regexp.Regexp
+
+// IsPattern 是否为通配符或正则规则（模式规则无法反查，不作为公开别名列出）
+func (m ModelMapping) IsPattern() bool {
+	return strings.HasPrefix(m.Match, ModelRegexPrefix) || strings.ContainsAny(m.Match, "*?")
+}
+
+// Apply 规则命中时返回映射后的模型名
+func (m ModelMapping) Apply(name string) (string, bool) {
+	if !m.IsPattern() {
+		if m.Match != name {
+			return "", false
+		}
+		return m.Target, true
+	}
+	re, err := m.compile()
+	if err != nil {
+		return "", false
+	}
+	match := re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return "", false
+	}
+	return string(re.ExpandString(nil, m.Target, name, match)), true
+}
+
+// Validate 校验规则是否可用
+func (m ModelMapping) Validate() error {
+	if strings.TrimSpace(m.Match) == "" || strings.TrimSpace(m.Target) == "" {
+		return fmt.Errorf("model mapping requires both match and target")
+	}
+	if _, err := m.compile(); err != nil {
+		return fmt.Errorf("invalid model mapping %q: %v", m.Match, err)
+	}
+	return nil
+}
+
+// compile 将规则编译为整体匹配的正则
+func (m ModelMapping) compile() (*regexp.Regexp, error) {
+	if cached, ok := compiledPatterns.Load(m.Match); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	var expr string
+	if strings.HasPrefix(m.Match, ModelRegexPrefix) {
+		expr = "^(?:" + strings.TrimPrefix(m.Match, ModelRegexPrefix) + ")$"
+	} else {
+		var b strings.Builder
+		b.WriteString("^")
+		for _, r := range m.Match {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		b.WriteString("$")
+		expr = b.String()
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(m.Match, re)
+	return re, nil
+}
+
+// MatchModelPattern 判断模型名是否命中规则（精确名称、通配符或 regex: 正则）
+func MatchModelPattern(pattern, name string) bool {
+	_, ok := ModelMapping{Match: pattern}.Apply(name)
+	return ok
+}
+
+// MapModel 应用映射表：精确规则优先，其次按顺序匹配模式规则；未命中时返回原名称
+func MapModel(mappings []ModelMapping, name string) string {
+	for _, m := range mappings {
+		if !m.IsPattern() && m.Match == name {
+			return m.Target
+		}
+	}
+	for _, m := range mappings {
+		if !m.IsPattern() {
+			continue
+		}
+		if target, ok := m.Apply(name); ok {
+			return target
+		}
+	}
+	return name
+}
+
+// ModelAliases 反查映射到 target 的精确别名
+func ModelAliases(mappings []ModelMapping, target string) []string {
+	var aliases []string
+	for _, m := range mappings {
+		if !m.IsPattern() && m.Target == target {
+			aliases = append(aliases, m.Match)
+		}
+	}
+	return aliases
+}
+
+// ValidateModelMappings 校验映射表
+func ValidateModelMappings(mappings []ModelMapping) error {
+	for _, m := range mappings {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
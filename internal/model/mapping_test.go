@@ -0,0 +1,68 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapModel(t *testing.T) {
+	mappings := []ModelMapping{
+		{Match: "claude-sonnet*", Target: "claude-sonnet-4-20250514"},
+		{Match: `regex:gpt-4o-(\d{4})`, Target: "gpt-4o-$1-08-06"},
+		{Match: "claude-sonnet-legacy", Target: "claude-3-5-sonnet-20241022"},
+		{Match: "gpt-4o", Target: "gpt-4o-2024-08-06"},
+	}
+
+	cases := map[string]string{
+		"gpt-4o":               "gpt-4o-2024-08-06",
+		"claude-sonnet":        "claude-sonnet-4-20250514",
+		"claude-sonnet-latest": "claude-sonnet-4-20250514",
+		"claude-sonnet-legacy": "claude-3-5-sonnet-20241022", // 精确规则优先于模式规则
+		"gpt-4o-2024":          "gpt-4o-2024-08-06",
+		"gpt-4o-mini":          "gpt-4o-mini", // 正则整体匹配
+		"unknown":              "unknown",
+	}
+	for in, want := range cases {
+		if got := MapModel(mappings, in); got != want {
+			t.Errorf("MapModel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestValidateModelMappings(t *testing.T) {
+	if err := ValidateModelMappings([]ModelMapping{{Match: "regex:(", Target: "x"}}); err == nil {
+		t.Error("expected invalid regex to be rejected")
+	}
+	if err := ValidateModelMappings([]ModelMapping{{Match: "a", Target: ""}}); err == nil {
+		t.Error("expected empty target to be rejected")
+	}
+	if err := ValidateModelMappings([]ModelMapping{{Match: "a.b*", Target: "c"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSource_ModelMapping(t *testing.T) {
+	src := &Source{
+		Capabilities: Capabilities{Models: []string{"anthropic/claude-sonnet-4", "openai/gpt-4o"}},
+		ModelMap: []ModelMapping{
+			{Match: "claude-sonnet-4", Target: "anthropic/claude-sonnet-4"},
+			{Match: "regex:(gpt-.*)", Target: "openai/$1"},
+		},
+	}
+
+	if got := src.UpstreamModel("claude-sonnet-4"); got != "anthropic/claude-sonnet-4" {
+		t.Errorf("unexpected upstream model: %s", got)
+	}
+	if !src.SupportsModel("claude-sonnet-4") || !src.SupportsModel("gpt-4o") {
+		t.Error("expected mapped models to be supported")
+	}
+	if src.SupportsModel("gpt-4o-mini") {
+		t.Error("expected model missing upstream to be unsupported")
+	}
+
+	global := []ModelMapping{{Match: "claude-sonnet", Target: "claude-sonnet-4"}}
+	want := []string{"claude-sonnet", "openai/gpt-4o"}
+	if got := src.PublicModels(global); !reflect.DeepEqual(got, want) {
+		t.Errorf("PublicModels = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package model
+
+import "fmt"
+
+// ModelPrice 模型价格（美元 / 百万 token）
+type ModelPrice struct {
+	Model      string  `json:"model" yaml:"model"`                                 // 模型名，支持通配符和 regex: 前缀
+	Input      float64 `json:"input" yaml:"input"`                                 // 输入价格
+	Output     float64 `json:"output" yaml:"output"`                               // 输出价格
+	CacheRead  float64 `json:"cache_read,omitempty" yaml:"cache_read,omitempty"`   // 缓存命中输入价格，0 表示按输入价格计
+	CacheWrite float64 `json:"cache_write,omitempty" yaml:"cache_write,omitempty"` // 缓存写入输入价格，0 表示按输入价格计
+}
+
+// Cost 按用量计算费用（美元）
+func (p *ModelPrice) Cost(u *Usage) float64 {
+	if p == nil || u == nil {
+		return 0
+	}
+
+	cached := u.CachedTokens()
+	written := u.CacheCreationTokens()
+	input := u.PromptTokens - cached - written
+	if input < 0 {
+		input = 0
+	}
+
+	cacheRead := p.CacheRead
+	if cacheRead == 0 {
+		cacheRead = p.Input
+	}
+	cacheWrite := p.CacheWrite
+	if cacheWrite == 0 {
+		cacheWrite = p.Input
+	}
+
+	cost := float64(input)*p.Input +
+		float64(cached)*cacheRead +
+		float64(written)*cacheWrite +
+		float64(u.CompletionTokens)*p.Output
+	return cost / 1e6
+}
+
+// PriceFor 查找模型价格：先按路由模型名、再按上游模型 ID 匹配，精确规则优先于模式规则
+func (s *Source) PriceFor(modelName string) *ModelPrice {
+	if len(s.Pricing) == 0 {
+		return nil
+	}
+
+	names := []string{modelName}
+	if upstream := s.UpstreamModel(modelName); upstream != modelName {
+		names = append(names, upstream)
+	}
+
+	for _, name := range names {
+		for i := range s.Pricing {
+			p := &s.Pricing[i]
+			if !(ModelMapping{Match: p.Model}).IsPattern() && p.Model == name {
+				return p
+			}
+		}
+	}
+	for _, name := range names {
+		for i := range s.Pricing {
+			p := &s.Pricing[i]
+			if (ModelMapping{Match: p.Model}).IsPattern() && MatchModelPattern(p.Model, name) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// CostFor 计算该源上指定模型请求的费用，未配置价格时为 0
+func (s *Source) CostFor(modelName string, u *Usage) float64 {
+	return s.PriceFor(modelName).Cost(u)
+}
+
+// ValidatePricing 校验价格表
+func ValidatePricing(pricing []ModelPrice) error {
+	for _, p := range pricing {
+		if err := (ModelMapping{Match: p.Model, Target: p.Model}).Validate(); err != nil {
+			return err
+		}
+		if p.Input < 0 || p.Output < 0 || p.CacheRead < 0 || p.CacheWrite < 0 {
+			return fmt.Errorf("invalid price for %q: prices must not be negative", p.Model)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestModelPrice_Cost(t *testing.T) {
+	price := &ModelPrice{Input: 3, Output: 15, CacheRead: 0.3}
+	usage := &Usage{
+		PromptTokens:        1_000_000,
+		CompletionTokens:    100_000,
+		PromptTokensDetails: &PromptTokensDetails{CachedTokens: 500_000, CacheCreationTokens: 100_000},
+	}
+
+	// 400k 输入 × 3 + 500k 缓存命中 × 0.3 + 100k 缓存写入 × 3（未配置按输入价）+ 100k 输出 × 15
+	want := (400_000*3.0 + 500_000*0.3 + 100_000*3.0 + 100_000*15.0) / 1e6
+	if got := price.Cost(usage); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Cost = %v, want %v", got, want)
+	}
+
+	var none *ModelPrice
+	if none.Cost(usage) != 0 {
+		t.Error("expected zero cost without price")
+	}
+}
+
+func TestSource_PriceFor(t *testing.T) {
+	src := &Source{
+		ModelMap: []ModelMapping{{Match: "sonnet", Target: "anthropic/claude-sonnet-4"}},
+		Pricing: []ModelPrice{
+			{Model: "gpt-4o*", Input: 2.5, Output: 10},
+			{Model: "gpt-4o-mini", Input: 0.15, Output: 0.6},
+			{Model: "anthropic/claude-sonnet-4", Input: 3, Output: 15},
+		},
+	}
+
+	if p := src.PriceFor("gpt-4o-mini"); p == nil || p.Input != 0.15 {
+		t.Errorf("expected exact price to win over pattern, got %+v", p)
+	}
+	if p := src.PriceFor("gpt-4o-2024-08-06"); p == nil || p.Input != 2.5 {
+		t.Errorf("expected wildcard price, got %+v", p)
+	}
+	if p := src.PriceFor("sonnet"); p == nil || p.Input != 3 {
+		t.Errorf("expected price by upstream model ID, got %+v", p)
+	}
+	if p := src.PriceFor("unknown"); p != nil {
+		t.Errorf("expected no price, got %+v", p)
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+// RateLimitSnapshot 频率限制器的可持久化状态（并发计数随进程结束失效，不保存）
+type RateLimitSnapshot struct {
+	SavedAt       time.Time               `json:"saved_at"`
+	Windows       map[string][]time.Time  `json:"windows,omitempty"`        // keyID -> RPM 窗口内的请求时间
+	SourceWindows map[string][]time.Time  `json:"source_windows,omitempty"` // sourceID -> RPM 窗口内的请求时间
+	DailyCount    map[string]int          `json:"daily_count,omitempty"`    // keyID[:tool]:date -> 当日请求数
+	ErrorCount    map[string]int          `json:"error_count,omitempty"`    // keyID -> 连续错误数
+	AutoBanned    map[string]time.Time    `json:"auto_banned,omitempty"`    // keyID -> 封禁时间
+	Tokens        map[string][]TokenUsage `json:"tokens,omitempty"`         // bucket -> TPM 窗口内的 token 占用
+}
+
+// TokenUsage TPM 窗口内的一次 token 占用
+type TokenUsage struct {
+	ID     string    `json:"id,omitempty"`
+	At     time.Time `json:"at"`
+	Tokens int       `json:"tokens"`
+}
+
+// RateLimitState 频率限制器实时状态（管理接口）
+type RateLimitState struct {
+	Keys    []KeyLimitState    `json:"keys"`
+	Sources []SourceLimitState `json:"sources"`
+}
+
+// KeyLimitState Key 的实时限制状态
+type KeyLimitState struct {
+	KeyID       string         `json:"key_id"`
+	RPMUsed     int            `json:"rpm_used"`
+	TPMUsed     int            `json:"tpm_used"`
+	DailyUsed   int            `json:"daily_used"`
+	ToolDaily   map[string]int `json:"tool_daily,omitempty"`
+	Concurrent  int            `json:"concurrent"`
+	ErrorCount  int            `json:"error_count"`
+	BannedUntil *time.Time     `json:"banned_until,omitempty"`
+}
+
+// SourceLimitState 源的实时限制状态
+type SourceLimitState struct {
+	SourceID   string `json:"source_id"`
+	RPMUsed    int    `json:"rpm_used"`
+	TPMUsed    int    `json:"tpm_used"`
+	Concurrent int    `json:"concurrent"`
+}
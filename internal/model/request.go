@@ -10,6 +10,7 @@ type ChatCompletionRequest struct {
 	TopP             *float64        `json:"top_p,omitempty"`
 	N                *int            `json:"n,omitempty"`
 	Stream           bool            `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
 	Stop             json.RawMessage `json:"stop,omitempty"`
 	MaxTokens        *int            `json:"max_tokens,omitempty"`
 	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
@@ -42,13 +43,33 @@ type Message struct {
 
 	// Legacy function calling
 	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+
+	// 思考过程（Extended Thinking 输出）
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	// Anthropic 思考块（含签名），后续工具调用轮次需原样回传
+	ThinkingBlocks []ThinkingBlock `json:"thinking_blocks,omitempty"`
+}
+
+// ThinkingBlock Anthropic 思考块；流式增量中只含 signature 的 thinking 块表示当前思考块的签名
+type ThinkingBlock struct {
+	Type      string `json:"type"` // thinking | redacted_thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"` // redacted_thinking 的加密内容
 }
 
 // ContentPart 多模态内容部分
 type ContentPart struct {
-	Type     string    `json:"type"` // "text" or "image_url"
-	Text     string    `json:"text,omitempty"`
-	ImageURL *ImageURL `json:"image_url,omitempty"`
+	Type         string        `json:"type"` // "text" or "image_url"
+	Text         string        `json:"text,omitempty"`
+	ImageURL     *ImageURL     `json:"image_url,omitempty"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"` // Anthropic 入口的提示缓存断点，仅转发给 Anthropic 源
+}
+
+// CacheControl Anthropic 提示缓存断点
+type CacheControl struct {
+	Type string `json:"type"` // "ephemeral"
+	TTL  string `json:"ttl,omitempty"`
 }
 
 // ImageURL 图片URL
@@ -59,8 +80,9 @@ type ImageURL struct {
 
 // Tool 工具定义
 type Tool struct {
-	Type     string   `json:"type"` // "function"
-	Function Function `json:"function"`
+	Type         string        `json:"type"` // "function"
+	Function     Function      `json:"function"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"` // 同 ContentPart.CacheControl
 }
 
 // Function 函数定义
@@ -72,17 +94,23 @@ type Function struct {
 
 // ToolCall 工具调用
 type ToolCall struct {
-	ID       string       `json:"id"`
-	Type     string       `json:"type"` // "function"
+	Index    *int         `json:"index,omitempty"` // 仅流式增量使用
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"` // "function"
 	Function FunctionCall `json:"function"`
 }
 
 // FunctionCall 函数调用
 type FunctionCall struct {
-	Name      string `json:"name"`
+	Name      string `json:"name,omitempty"`
 	Arguments string `json:"arguments"`
 }
 
+// StreamOptions 流式选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"` // 最后一个 chunk 携带 usage
+}
+
 // ThinkingConfig Extended Thinking 配置
 type ThinkingConfig struct {
 	Type         string `json:"type,omitempty"`          // "enabled"
@@ -91,7 +119,8 @@ type ThinkingConfig struct {
 
 // ResponseFormat 响应格式
 type ResponseFormat struct {
-	Type string `json:"type"` // "text" or "json_object"
+	Type       string         `json:"type"`                  // "text", "json_object" or "json_schema"
+	JSONSchema map[string]any `json:"json_schema,omitempty"` // type 为 json_schema 时的 name、schema、strict
 }
 
 // ChatCompletionResponse OpenAI 兼容的聊天补全响应
@@ -115,9 +144,32 @@ type Choice struct {
 
 // Usage Token 使用量
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails 输入 token 明细（均包含在 PromptTokens 内）
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`                   // 命中缓存的输入 token
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // 写入缓存的输入 token（Anthropic）
+}
+
+// CachedTokens 命中缓存的输入 token 数
+func (u *Usage) CachedTokens() int {
+	if u == nil || u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+// CacheCreationTokens 写入缓存的输入 token 数
+func (u *Usage) CacheCreationTokens() int {
+	if u == nil || u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CacheCreationTokens
 }
 
 // StreamChunk SSE 流式响应块
@@ -127,6 +179,7 @@ type StreamChunk struct {
 	Created           int64    `json:"created"`
 	Model             string   `json:"model"`
 	Choices           []Choice `json:"choices"`
+	Usage             *Usage   `json:"usage,omitempty"` // stream_options.include_usage 时最后一个 chunk 携带
 	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
 }
 
@@ -0,0 +1,205 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ResponsesRequest OpenAI Responses API 请求（仅包含转换为聊天请求所需的字段，原生透传时转发完整请求体）
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              ResponsesInput  `json:"input"`
+	Instructions       string          `json:"instructions,omitempty"`
+	Tools              []ResponsesTool `json:"tools,omitempty"`
+	ToolChoice         json.RawMessage `json:"tool_choice,omitempty"`
+	Temperature        *float64        `json:"temperature,omitempty"`
+	TopP               *float64        `json:"top_p,omitempty"`
+	MaxOutputTokens    *int            `json:"max_output_tokens,omitempty"`
+	Stream             bool            `json:"stream,omitempty"`
+	User               string          `json:"user,omitempty"`
+	Text               *ResponsesText  `json:"text,omitempty"`
+	PreviousResponseID string          `json:"previous_response_id,omitempty"`
+}
+
+// ResponsesInput 输入条目列表，反序列化时兼容纯字符串写法
+type ResponsesInput []ResponsesItem
+
+// UnmarshalJSON 支持 "input": "text" 与 "input": [{...}] 两种格式
+func (in *ResponsesInput) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "\"") {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*in = ResponsesInput{{Type: "message", Role: "user", Content: ResponsesContent{{Type: "input_text", Text: text}}}}
+		return nil
+	}
+	var items []ResponsesItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*in = items
+	return nil
+}
+
+// ResponsesItem 输入条目：消息、函数调用或函数调用结果
+type ResponsesItem struct {
+	Type      string           `json:"type,omitempty"` // message（可省略）| function_call | function_call_output | reasoning ...
+	ID        string           `json:"id,omitempty"`
+	Role      string           `json:"role,omitempty"` // user | assistant | system | developer
+	Content   ResponsesContent `json:"content,omitempty"`
+	CallID    string           `json:"call_id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Arguments string           `json:"arguments,omitempty"`
+	Output    json.RawMessage  `json:"output,omitempty"` // function_call_output：字符串或内容数组
+}
+
+// ResponsesContent 消息内容，反序列化时兼容纯字符串写法
+type ResponsesContent []ResponsesContentPart
+
+// UnmarshalJSON 支持 "content": "text" 与 "content": [{...}] 两种格式
+func (c *ResponsesContent) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		*c = nil
+		return nil
+	}
+	if strings.HasPrefix(trimmed, "\"") {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*c = ResponsesContent{{Type: "input_text", Text: text}}
+		return nil
+	}
+	var parts []ResponsesContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	*c = parts
+	return nil
+}
+
+// Text 拼接所有文本内容
+func (c ResponsesContent) Text() string {
+	var sb strings.Builder
+	for _, p := range c {
+		switch p.Type {
+		case "input_text", "output_text", "text":
+			sb.WriteString(p.Text)
+		case "refusal":
+			sb.WriteString(p.Refusal)
+		}
+	}
+	return sb.String()
+}
+
+// ResponsesContentPart 消息内容部分
+type ResponsesContentPart struct {
+	Type     string `json:"type"` // input_text | output_text | input_image | refusal
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Refusal  string `json:"refusal,omitempty"`
+}
+
+// ResponsesTool 工具定义（函数工具的字段直接位于顶层）
+type ResponsesTool struct {
+	Type        string         `json:"type"` // function | web_search | ...
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// ResponsesText 文本输出配置
+type ResponsesText struct {
+	Format *ResponsesTextFormat `json:"format,omitempty"`
+}
+
+// ResponsesTextFormat 文本输出格式
+type ResponsesTextFormat struct {
+	Type        string         `json:"type"` // text | json_object | json_schema
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// Response OpenAI Responses API 响应
+type Response struct {
+	ID                string                      `json:"id"`
+	Object            string                      `json:"object"` // response
+	CreatedAt         int64                       `json:"created_at"`
+	Status            string                      `json:"status"` // in_progress | completed | incomplete
+	Model             string                      `json:"model"`
+	Output            []ResponsesOutputItem       `json:"output"`
+	Usage             *ResponsesUsage             `json:"usage,omitempty"`
+	IncompleteDetails *ResponsesIncompleteDetails `json:"incomplete_details,omitempty"`
+}
+
+// ResponsesOutputItem 输出条目：消息或函数调用
+type ResponsesOutputItem struct {
+	Type      string                   `json:"type"` // message | function_call
+	ID        string                   `json:"id"`
+	Status    string                   `json:"status"`
+	Role      string                   `json:"role,omitempty"`
+	Content   []ResponsesOutputContent `json:"content,omitempty"`
+	CallID    string                   `json:"call_id,omitempty"`
+	Name      string                   `json:"name,omitempty"`
+	Arguments string                   `json:"arguments,omitempty"`
+}
+
+// ResponsesOutputContent 输出消息内容
+type ResponsesOutputContent struct {
+	Type        string `json:"type"` // output_text
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponsesIncompleteDetails 响应未完成的原因
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"` // max_output_tokens | content_filter
+}
+
+// ResponsesUsage Responses API 的 token 使用量
+type ResponsesUsage struct {
+	InputTokens         int                          `json:"input_tokens"`
+	InputTokensDetails  ResponsesInputTokensDetails  `json:"input_tokens_details"`
+	OutputTokens        int                          `json:"output_tokens"`
+	OutputTokensDetails ResponsesOutputTokensDetails `json:"output_tokens_details"`
+	TotalTokens         int                          `json:"total_tokens"`
+}
+
+// ResponsesInputTokensDetails 输入 token 明细
+type ResponsesInputTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// ResponsesOutputTokensDetails 输出 token 明细
+type ResponsesOutputTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ToUsage 转换为内部 Usage
+func (u *ResponsesUsage) ToUsage() *Usage {
+	if u == nil {
+		return nil
+	}
+	usage := &Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if u.InputTokensDetails.CachedTokens > 0 {
+		usage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: u.InputTokensDetails.CachedTokens}
+	}
+	return usage
+}
+
+// ResponsesStreamEvent Responses API 流式事件（仅解析用量统计需要的字段）
+type ResponsesStreamEvent struct {
+	Type     string    `json:"type"`
+	Delta    string    `json:"delta,omitempty"`
+	Response *Response `json:"response,omitempty"`
+}
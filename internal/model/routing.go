@@ -0,0 +1,100 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// RoutingRule 路由规则
+// 规则按 Priority 从小到大匹配，第一条命中的启用规则生效；未命中时使用全局路由策略
+type RoutingRule struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Priority int       `json:"priority"` // 数字越小越先匹配
+	Enabled  bool      `json:"enabled"`
+	Match    RuleMatch `json:"match"`
+
+	// 动作（可组合）
+	Strategy string   `json:"strategy,omitempty"` // 覆盖全局路由策略
+	Sources  []string `json:"sources,omitempty"`  // 有序源列表（ID 或名称），仅在其中选择；未指定策略时按列表顺序
+	Exclude  []string `json:"exclude,omitempty"`  // 排除的源（ID 或名称）
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RuleMatch 规则匹配条件
+// 各字段为空表示不限制，所有非空条件同时满足才算命中；列表内任一项匹配即可
+type RuleMatch struct {
+	Models      []string `json:"models,omitempty"`       // 模型名，支持通配符和 regex: 前缀
+	ClientTools []string `json:"client_tools,omitempty"` // 识别出的客户端工具（ClientInfo.Tool）
+	APIKeys     []string `json:"api_keys,omitempty"`     // API Key ID
+	HasTools    *bool    `json:"has_tools,omitempty"`
+	HasThinking *bool    `json:"has_thinking,omitempty"`
+	HasVision   *bool    `json:"has_vision,omitempty"`
+}
+
+// Matches 判断请求是否命中规则
+func (r *RoutingRule) Matches(req *ChatCompletionRequest, client *ClientInfo) bool {
+	if !r.Enabled {
+		return false
+	}
+	m := r.Match
+
+	if len(m.Models) > 0 {
+		matched := false
+		for _, p := range m.Models {
+			if MatchModelPattern(p, req.Model) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	var tool, keyID string
+	if client != nil {
+		tool, keyID = client.Tool, client.KeyID
+	}
+	if len(m.ClientTools) > 0 && !containsString(m.ClientTools, tool) {
+		return false
+	}
+	if len(m.APIKeys) > 0 && !containsString(m.APIKeys, keyID) {
+		return false
+	}
+
+	if m.HasTools != nil && *m.HasTools != req.HasTools() {
+		return false
+	}
+	if m.HasThinking != nil && *m.HasThinking != req.HasThinking() {
+		return false
+	}
+	if m.HasVision != nil && *m.HasVision != req.HasVision() {
+		return false
+	}
+	return true
+}
+
+// Validate 校验规则
+func (r *RoutingRule) Validate() error {
+	if r.Strategy == "" && len(r.Sources) == 0 && len(r.Exclude) == 0 {
+		return fmt.Errorf("rule must set strategy, sources or exclude")
+	}
+	for _, p := range r.Match.Models {
+		if err := (ModelMapping{Match: p, Target: p}).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
@@ -42,9 +43,22 @@ type Source struct {
 	// CPA 特有配置
 	CPA *CPAConfig `json:"cpa,omitempty" yaml:"cpa,omitempty"`
 
+	// 模型映射：将路由后的模型名改写为该源的上游模型 ID
+	ModelMap []ModelMapping `json:"model_map,omitempty" yaml:"model_map,omitempty"`
+
+	// 模型价格表，用于 least-cost 路由和请求费用计算
+	Pricing []ModelPrice `json:"pricing,omitempty" yaml:"pricing,omitempty"`
+
+	// 上游限制，避免超出上游的速率上限
+	Limits *SourceLimits `json:"limits,omitempty" yaml:"limits,omitempty"`
+
 	// 运行时状态（不持久化到配置）
 	Status *SourceStatus `json:"-" yaml:"-"`
 	mu     sync.RWMutex  `json:"-" yaml:"-"`
+
+	// 限流冷却：上游返回 429/配额耗尽后暂停路由到该源，与健康状态相互独立
+	cooldownUntil  time.Time
+	cooldownReason string
 }
 
 // Capabilities 源能力声明
@@ -53,6 +67,49 @@ type Capabilities struct {
 	ExtendedThinking bool     `json:"extended_thinking" yaml:"extended_thinking"`
 	Vision           bool     `json:"vision" yaml:"vision"`
 	Models           []string `json:"models" yaml:"models"`
+
+	// Embeddings 支持 OpenAI /v1/embeddings；EmbeddingModels 为空表示支持所有 embedding 模型
+	Embeddings      bool     `json:"embeddings,omitempty" yaml:"embeddings,omitempty"`
+	EmbeddingModels []string `json:"embedding_models,omitempty" yaml:"embedding_models,omitempty"`
+
+	// Endpoints 上游原生支持的其他 OpenAI 接口（completions | responses），入口为这些接口时请求原样透传
+	Endpoints []string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+}
+
+// OpenAI 接口名，写入请求日志的 endpoint 字段（聊天补全为空）
+// 其中 completions / responses 可在 capabilities.endpoints 中声明为原样透传
+const (
+	EndpointCompletions = "completions"
+	EndpointResponses   = "responses"
+	EndpointEmbeddings  = "embeddings"
+)
+
+// Validate 校验能力声明
+func (c *Capabilities) Validate() error {
+	for _, endpoint := range c.Endpoints {
+		if endpoint != EndpointCompletions && endpoint != EndpointResponses {
+			return fmt.Errorf("unknown endpoint %q in capabilities.endpoints", endpoint)
+		}
+	}
+	return nil
+}
+
+// SourceLimits 源级限制（对应上游账号的速率与并发上限），达到上限的源在路由时被跳过
+type SourceLimits struct {
+	RPM           int `json:"rpm,omitempty" yaml:"rpm,omitempty"`                       // 每分钟请求数，0=无限
+	TPM           int `json:"tpm,omitempty" yaml:"tpm,omitempty"`                       // 每分钟 token 数，0=无限
+	MaxConcurrent int `json:"max_concurrent,omitempty" yaml:"max_concurrent,omitempty"` // 最大并发请求数，0=无限
+}
+
+// Validate 校验源级限制
+func (l *SourceLimits) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.RPM < 0 || l.TPM < 0 || l.MaxConcurrent < 0 {
+		return fmt.Errorf("invalid limits: rpm, tpm and max_concurrent must not be negative")
+	}
+	return nil
 }
 
 // CPAConfig CPA 特有配置
@@ -93,12 +150,15 @@ type SourceStatus struct {
 
 // SourceStatusResponse 源状态响应（延迟使用毫秒）
 type SourceStatusResponse struct {
-	State      HealthState `json:"state"`
-	Latency    int64       `json:"latency"`
-	Balance    float64     `json:"balance"`
-	LastCheck  time.Time   `json:"last_check"`
-	ErrorCount int         `json:"error_count"`
-	LastError  string      `json:"last_error"`
+	State          HealthState `json:"state"`
+	Latency        int64       `json:"latency"`
+	Balance        float64     `json:"balance"`
+	LastCheck      time.Time   `json:"last_check"`
+	ErrorCount     int         `json:"error_count"`
+	LastError      string      `json:"last_error"`
+	CooldownUntil  *time.Time  `json:"cooldown_until,omitempty"`  // 限流冷却结束时间，未冷却时省略
+	CooldownReason string      `json:"cooldown_reason,omitempty"` // 触发冷却的上游响应
+	Breaker        string      `json:"breaker,omitempty"`         // 熔断器状态：closed | open | half_open
 }
 
 // GetStatus 获取状态（线程安全）
@@ -120,6 +180,32 @@ func (s *Source) SetStatus(status *SourceStatus) {
 	s.Status = status
 }
 
+// SetCooldown 使源进入限流冷却直到 until；已有更晚的冷却时保留原冷却
+func (s *Source) SetCooldown(until time.Time, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until.After(s.cooldownUntil) {
+		s.cooldownUntil = until
+		s.cooldownReason = reason
+	}
+}
+
+// Cooldown 返回冷却结束时间及原因，未处于冷却时 ok 为 false
+func (s *Source) Cooldown() (until time.Time, reason string, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !time.Now().Before(s.cooldownUntil) {
+		return time.Time{}, "", false
+	}
+	return s.cooldownUntil, s.cooldownReason, true
+}
+
+// InCooldown 检查源是否处于限流冷却中
+func (s *Source) InCooldown() bool {
+	_, _, ok := s.Cooldown()
+	return ok
+}
+
 // IsHealthy 检查源是否健康
 func (s *Source) IsHealthy() bool {
 	s.mu.RLock()
@@ -130,11 +216,60 @@ func (s *Source) IsHealthy() bool {
 	return s.Status.State == HealthStateHealthy
 }
 
-// SupportsModel 检查源是否支持指定模型
+// UpstreamModel 返回发送给该源的模型 ID（应用源级模型映射）
+func (s *Source) UpstreamModel(model string) string {
+	return MapModel(s.ModelMap, model)
+}
+
+// PublicModels 返回源对客户端公开的模型名
+// 上游模型 ID 依次按源级映射、全局别名反查，存在别名时以别名代替原始 ID
+func (s *Source) PublicModels(global []ModelMapping) []string {
+	if len(s.Capabilities.Models) == 0 {
+		// 未声明模型列表：支持所有模型，仅列出全局别名
+		var names []string
+		for _, m := range global {
+			if !m.IsPattern() {
+				names = append(names, m.Match)
+			}
+		}
+		return names
+	}
+	return s.publicNames(s.Capabilities.Models, global)
+}
+
+// PublicEmbeddingModels 返回源对客户端公开的 embedding 模型名，未声明 embedding 模型列表时为空
+func (s *Source) PublicEmbeddingModels(global []ModelMapping) []string {
+	if !s.Capabilities.Embeddings {
+		return nil
+	}
+	return s.publicNames(s.Capabilities.EmbeddingModels, global)
+}
+
+// publicNames 将上游模型 ID 依次按源级映射、全局别名反查为公开名称
+func (s *Source) publicNames(ids []string, global []ModelMapping) []string {
+	var names []string
+	for _, id := range ids {
+		local := ModelAliases(s.ModelMap, id)
+		if len(local) == 0 {
+			local = []string{id}
+		}
+		for _, name := range local {
+			if aliases := ModelAliases(global, name); len(aliases) > 0 {
+				names = append(names, aliases...)
+			} else {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// SupportsModel 检查源是否支持指定模型（按源级映射后的上游模型 ID 判断）
 func (s *Source) SupportsModel(model string) bool {
 	if len(s.Capabilities.Models) == 0 {
 		return true // 未声明模型列表则认为支持所有
 	}
+	model = s.UpstreamModel(model)
 	for _, m := range s.Capabilities.Models {
 		if m == model {
 			return true
@@ -143,6 +278,42 @@ func (s *Source) SupportsModel(model string) bool {
 	return false
 }
 
+// SupportsEmbeddingModel 检查源是否可处理指定模型的 embeddings 请求（按源级映射后的上游模型 ID 判断）
+// Anthropic 没有 embeddings 接口，即使声明了能力也不参与
+func (s *Source) SupportsEmbeddingModel(model string) bool {
+	if !s.Capabilities.Embeddings || s.Type == SourceTypeAnthropic {
+		return false
+	}
+	if len(s.Capabilities.EmbeddingModels) == 0 {
+		return true
+	}
+	model = s.UpstreamModel(model)
+	for _, m := range s.Capabilities.EmbeddingModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsEndpoint 检查源是否原生支持指定接口（Anthropic 源不支持 OpenAI 接口）
+func (s *Source) SupportsEndpoint(endpoint string) bool {
+	if s.Type == SourceTypeAnthropic {
+		return false
+	}
+	for _, e := range s.Capabilities.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// RefersTo 判断源引用（ID 或名称）是否指向该源
+func (s *Source) RefersTo(ref string) bool {
+	return ref != "" && (ref == s.ID || ref == s.Name)
+}
+
 // SourceResponse 源列表响应（用于API）
 type SourceResponse struct {
 	ID           string                `json:"id"`
@@ -154,6 +325,9 @@ type SourceResponse struct {
 	Enabled      bool                  `json:"enabled"`
 	Capabilities Capabilities          `json:"capabilities"`
 	CPA          *CPAConfig            `json:"cpa,omitempty"`
+	ModelMap     []ModelMapping        `json:"model_map,omitempty"`
+	Pricing      []ModelPrice          `json:"pricing,omitempty"`
+	Limits       *SourceLimits         `json:"limits,omitempty"`
 	Status       *SourceStatusResponse `json:"status,omitempty"`
 }
 
@@ -170,6 +344,10 @@ func (s *Source) ToResponse() SourceResponse {
 			ErrorCount: status.ErrorCount,
 			LastError:  status.LastError,
 		}
+		if until, reason, ok := s.Cooldown(); ok {
+			statusResp.CooldownUntil = &until
+			statusResp.CooldownReason = reason
+		}
 	}
 
 	return SourceResponse{
@@ -182,6 +360,9 @@ func (s *Source) ToResponse() SourceResponse {
 		Enabled:      s.Enabled,
 		Capabilities: s.Capabilities,
 		CPA:          s.CPA,
+		ModelMap:     s.ModelMap,
+		Pricing:      s.Pricing,
+		Limits:       s.Limits,
 		Status:       statusResp,
 	}
 }
@@ -189,7 +370,7 @@ func (s *Source) ToResponse() SourceResponse {
 // GetProviderForModel 获取 CPA 源中模型对应的 provider
 func (s *Source) GetProviderForModel(modelName string) string {
 	if s.Status != nil && s.Status.ModelProviders != nil {
-		if provider, ok := s.Status.ModelProviders[modelName]; ok {
+		if provider, ok := s.Status.ModelProviders[s.UpstreamModel(modelName)]; ok {
 			return provider
 		}
 	}
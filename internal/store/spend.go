@@ -0,0 +1,77 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xiaopang/fusionapi/internal/model"
+)
+
+// keySpendTTL Key 预算用量缓存的有效期
+// 预算检查在每个请求上执行：有效期内只按新写入的日志增量累加，过期后重新聚合 request_logs
+const keySpendTTL = 30 * time.Second
+
+// keySpendEntry 一个 Key 缓存的当日与当月用量
+type keySpendEntry struct {
+	dayStart   time.Time
+	monthStart time.Time
+	daily      model.KeySpend
+	monthly    model.KeySpend
+	loadedAt   time.Time
+}
+
+// spendCache 按 Key 缓存预算用量
+type spendCache struct {
+	mu      sync.Mutex
+	entries map[string]*keySpendEntry
+}
+
+func newSpendCache() *spendCache {
+	return &spendCache{entries: make(map[string]*keySpendEntry)}
+}
+
+// get 返回同一周期内未过期的缓存用量
+func (c *spendCache) get(keyID string, dayStart, monthStart, now time.Time) (daily, monthly model.KeySpend, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[keyID]
+	if !ok || !e.dayStart.Equal(dayStart) || !e.monthStart.Equal(monthStart) || now.Sub(e.loadedAt) > keySpendTTL {
+		return model.KeySpend{}, model.KeySpend{}, false
+	}
+	return e.daily, e.monthly, true
+}
+
+// set 写入从 request_logs 聚合的用量
+func (c *spendCache) set(keyID string, e *keySpendEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[keyID] = e
+}
+
+// add 将新写入的日志计入该 Key 的缓存用量（未缓存的 Key 在下次查询时聚合）
+func (c *spendCache) add(log *model.RequestLog) {
+	if log.APIKeyID == "" || (log.TotalTokens == 0 && log.Cost == 0) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[log.APIKeyID]
+	if !ok || log.Timestamp.Before(e.monthStart) {
+		return
+	}
+	e.monthly.Tokens += int64(log.TotalTokens)
+	e.monthly.Cost += log.Cost
+	if !log.Timestamp.Before(e.dayStart) {
+		e.daily.Tokens += int64(log.TotalTokens)
+		e.daily.Cost += log.Cost
+	}
+}
+
+// utcTimestamp 规范化为 UTC 的 SQLite 时间字符串
+// 日志时间按驱动格式带时区偏移存储，与 julianday() 解析后的结果比较，不依赖字符串格式或本地时区一致
+func utcTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
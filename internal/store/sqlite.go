@@ -14,7 +14,8 @@ import (
 
 // Store 数据存储
 type Store struct {
-	db *sql.DB
+	db    *sql.DB
+	spend *spendCache // Key 预算用量缓存
 }
 
 // New 创建存储实例
@@ -30,7 +31,7 @@ func New(dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
 
-	store := &Store{db: db}
+	store := &Store{db: db, spend: newSpendCache()}
 	if err := store.migrate(); err != nil {
 		return nil, fmt.Errorf("migrate: %w", err)
 	}
@@ -87,6 +88,9 @@ func (s *Store) migrate() error {
 
 	// 增量迁移：为旧数据库添加 cpa_config 列
 	s.db.Exec("ALTER TABLE sources ADD COLUMN cpa_config TEXT")
+	s.db.Exec("ALTER TABLE sources ADD COLUMN model_map TEXT")
+	s.db.Exec("ALTER TABLE sources ADD COLUMN pricing TEXT")
+	s.db.Exec("ALTER TABLE sources ADD COLUMN limits TEXT")
 
 	// API Keys table
 	s.db.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
@@ -106,6 +110,44 @@ func (s *Store) migrate() error {
 	s.db.Exec("ALTER TABLE request_logs ADD COLUMN client_tool TEXT DEFAULT ''")
 	s.db.Exec("ALTER TABLE request_logs ADD COLUMN api_key_id TEXT DEFAULT ''")
 	s.db.Exec("ALTER TABLE request_logs ADD COLUMN fc_compat_used INTEGER DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN repair_count INTEGER DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN cost REAL DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN hedged INTEGER DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN cache_hit INTEGER DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN cached_tokens INTEGER DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN cache_creation_tokens INTEGER DEFAULT 0")
+	s.db.Exec("ALTER TABLE request_logs ADD COLUMN endpoint TEXT DEFAULT ''")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_logs_api_key ON request_logs(api_key_id, timestamp)")
+
+	// 路由规则
+	s.db.Exec(`CREATE TABLE IF NOT EXISTS routing_rules (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		priority INTEGER DEFAULT 100,
+		enabled INTEGER DEFAULT 1,
+		conditions TEXT,
+		strategy TEXT DEFAULT '',
+		sources TEXT,
+		exclude TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	// 频率限制器状态（单行快照）
+	s.db.Exec(`CREATE TABLE IF NOT EXISTS rate_limit_state (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		data TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	// 响应缓存（时间为 Unix 毫秒）
+	s.db.Exec(`CREATE TABLE IF NOT EXISTS response_cache (
+		key TEXT PRIMARY KEY,
+		data TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_hit_at INTEGER NOT NULL
+	)`)
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_response_cache_hit ON response_cache(last_hit_at)")
 
 	return nil
 }
@@ -125,9 +167,24 @@ func (s *Store) SaveSource(src *model.Source) error {
 		b, _ := json.Marshal(src.CPA)
 		cpaJSON = string(b)
 	}
+	modelMapJSON := ""
+	if len(src.ModelMap) > 0 {
+		b, _ := json.Marshal(src.ModelMap)
+		modelMapJSON = string(b)
+	}
+	pricingJSON := ""
+	if len(src.Pricing) > 0 {
+		b, _ := json.Marshal(src.Pricing)
+		pricingJSON = string(b)
+	}
+	limitsJSON := ""
+	if src.Limits != nil {
+		b, _ := json.Marshal(src.Limits)
+		limitsJSON = string(b)
+	}
 	_, err := s.db.Exec(`
-		INSERT INTO sources (id, name, type, base_url, api_key, priority, weight, enabled, capabilities, cpa_config, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO sources (id, name, type, base_url, api_key, priority, weight, enabled, capabilities, cpa_config, model_map, pricing, limits, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(id) DO UPDATE SET
 			name = excluded.name,
 			type = excluded.type,
@@ -138,22 +195,25 @@ func (s *Store) SaveSource(src *model.Source) error {
 			enabled = excluded.enabled,
 			capabilities = excluded.capabilities,
 			cpa_config = excluded.cpa_config,
+			model_map = excluded.model_map,
+			pricing = excluded.pricing,
+			limits = excluded.limits,
 			updated_at = CURRENT_TIMESTAMP
-	`, src.ID, src.Name, src.Type, src.BaseURL, src.APIKey, src.Priority, src.Weight, src.Enabled, string(caps), cpaJSON)
+	`, src.ID, src.Name, src.Type, src.BaseURL, src.APIKey, src.Priority, src.Weight, src.Enabled, string(caps), cpaJSON, modelMapJSON, pricingJSON, limitsJSON)
 	return err
 }
 
 // GetSource 获取源
 func (s *Store) GetSource(id string) (*model.Source, error) {
 	row := s.db.QueryRow(`
-		SELECT id, name, type, base_url, api_key, priority, weight, enabled, capabilities, COALESCE(cpa_config, '')
+		SELECT id, name, type, base_url, api_key, priority, weight, enabled, capabilities, COALESCE(cpa_config, ''), COALESCE(model_map, ''), COALESCE(pricing, ''), COALESCE(limits, '')
 		FROM sources WHERE id = ?
 	`, id)
 
 	var src model.Source
-	var capsJSON, cpaJSON string
+	var capsJSON, cpaJSON, modelMapJSON, pricingJSON, limitsJSON string
 	err := row.Scan(&src.ID, &src.Name, &src.Type, &src.BaseURL, &src.APIKey,
-		&src.Priority, &src.Weight, &src.Enabled, &capsJSON, &cpaJSON)
+		&src.Priority, &src.Weight, &src.Enabled, &capsJSON, &cpaJSON, &modelMapJSON, &pricingJSON, &limitsJSON)
 	if err != nil {
 		return nil, err
 	}
@@ -162,13 +222,23 @@ func (s *Store) GetSource(id string) (*model.Source, error) {
 		src.CPA = &model.CPAConfig{}
 		json.Unmarshal([]byte(cpaJSON), src.CPA)
 	}
+	if modelMapJSON != "" {
+		json.Unmarshal([]byte(modelMapJSON), &src.ModelMap)
+	}
+	if pricingJSON != "" {
+		json.Unmarshal([]byte(pricingJSON), &src.Pricing)
+	}
+	if limitsJSON != "" {
+		src.Limits = &model.SourceLimits{}
+		json.Unmarshal([]byte(limitsJSON), src.Limits)
+	}
 	return &src, nil
 }
 
 // ListSources 列出所有源
 func (s *Store) ListSources() ([]*model.Source, error) {
 	rows, err := s.db.Query(`
-		SELECT id, name, type, base_url, api_key, priority, weight, enabled, capabilities, COALESCE(cpa_config, '')
+		SELECT id, name, type, base_url, api_key, priority, weight, enabled, capabilities, COALESCE(cpa_config, ''), COALESCE(model_map, ''), COALESCE(pricing, ''), COALESCE(limits, '')
 		FROM sources ORDER BY priority, name
 	`)
 	if err != nil {
@@ -179,9 +249,9 @@ func (s *Store) ListSources() ([]*model.Source, error) {
 	var sources []*model.Source
 	for rows.Next() {
 		var src model.Source
-		var capsJSON, cpaJSON string
+		var capsJSON, cpaJSON, modelMapJSON, pricingJSON, limitsJSON string
 		if err := rows.Scan(&src.ID, &src.Name, &src.Type, &src.BaseURL, &src.APIKey,
-			&src.Priority, &src.Weight, &src.Enabled, &capsJSON, &cpaJSON); err != nil {
+			&src.Priority, &src.Weight, &src.Enabled, &capsJSON, &cpaJSON, &modelMapJSON, &pricingJSON, &limitsJSON); err != nil {
 			return nil, err
 		}
 		json.Unmarshal([]byte(capsJSON), &src.Capabilities)
@@ -189,6 +259,16 @@ func (s *Store) ListSources() ([]*model.Source, error) {
 			src.CPA = &model.CPAConfig{}
 			json.Unmarshal([]byte(cpaJSON), src.CPA)
 		}
+		if modelMapJSON != "" {
+			json.Unmarshal([]byte(modelMapJSON), &src.ModelMap)
+		}
+		if pricingJSON != "" {
+			json.Unmarshal([]byte(pricingJSON), &src.Pricing)
+		}
+		if limitsJSON != "" {
+			src.Limits = &model.SourceLimits{}
+			json.Unmarshal([]byte(limitsJSON), src.Limits)
+		}
 		sources = append(sources, &src)
 	}
 	return sources, nil
@@ -208,18 +288,24 @@ func (s *Store) SaveLog(log *model.RequestLog) error {
 		INSERT INTO request_logs (id, request_id, timestamp, source_id, source_name, model,
 			has_tools, has_thinking, stream, success, status_code, latency_ms,
 			prompt_tokens, completion_tokens, total_tokens, error, failover_from,
-			client_ip, client_tool, api_key_id, fc_compat_used)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			client_ip, client_tool, api_key_id, fc_compat_used, repair_count, cost, hedged, cache_hit,
+			cached_tokens, cache_creation_tokens, endpoint)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, log.ID, log.RequestID, log.Timestamp, log.SourceID, log.SourceName, log.Model,
 		log.HasTools, log.HasThinking, log.Stream, log.Success, log.StatusCode, log.LatencyMs,
 		log.PromptTokens, log.CompletionTokens, log.TotalTokens, log.Error, log.FailoverFrom,
-		log.ClientIP, log.ClientTool, log.APIKeyID, log.FCCompatUsed)
-	return err
+		log.ClientIP, log.ClientTool, log.APIKeyID, log.FCCompatUsed, log.RepairCount, log.Cost, log.Hedged, log.CacheHit,
+		log.CachedTokens, log.CacheCreationTokens, log.Endpoint)
+	if err != nil {
+		return err
+	}
+	s.spend.add(log)
+	return nil
 }
 
 // QueryLogs 查询日志
 func (s *Store) QueryLogs(query *model.LogQuery) ([]*model.RequestLog, error) {
-	sql := "SELECT id, COALESCE(request_id, ''), timestamp, source_id, source_name, model, has_tools, has_thinking, stream, success, status_code, latency_ms, prompt_tokens, completion_tokens, total_tokens, error, failover_from, COALESCE(client_ip, ''), COALESCE(client_tool, ''), COALESCE(api_key_id, ''), COALESCE(fc_compat_used, 0) FROM request_logs WHERE 1=1"
+	sql := "SELECT id, COALESCE(request_id, ''), timestamp, source_id, source_name, model, has_tools, has_thinking, stream, success, status_code, latency_ms, prompt_tokens, completion_tokens, total_tokens, error, failover_from, COALESCE(client_ip, ''), COALESCE(client_tool, ''), COALESCE(api_key_id, ''), COALESCE(fc_compat_used, 0), COALESCE(repair_count, 0), COALESCE(cost, 0), COALESCE(hedged, 0), COALESCE(cache_hit, 0), COALESCE(cached_tokens, 0), COALESCE(cache_creation_tokens, 0), COALESCE(endpoint, '') FROM request_logs WHERE 1=1"
 	args := []any{}
 
 	if query.SourceID != "" {
@@ -278,7 +364,8 @@ func (s *Store) QueryLogs(query *model.LogQuery) ([]*model.RequestLog, error) {
 		if err := rows.Scan(&log.ID, &log.RequestID, &log.Timestamp, &log.SourceID, &log.SourceName, &log.Model,
 			&log.HasTools, &log.HasThinking, &log.Stream, &log.Success, &log.StatusCode, &log.LatencyMs,
 			&log.PromptTokens, &log.CompletionTokens, &log.TotalTokens, &log.Error, &log.FailoverFrom,
-			&log.ClientIP, &log.ClientTool, &log.APIKeyID, &log.FCCompatUsed); err != nil {
+			&log.ClientIP, &log.ClientTool, &log.APIKeyID, &log.FCCompatUsed, &log.RepairCount, &log.Cost, &log.Hedged, &log.CacheHit,
+			&log.CachedTokens, &log.CacheCreationTokens, &log.Endpoint); err != nil {
 			return nil, err
 		}
 		logs = append(logs, &log)
@@ -294,6 +381,7 @@ func (s *Store) GetDailyStats(days int) ([]*model.DailyStats, error) {
 			COUNT(*) as total_requests,
 			ROUND(SUM(CASE WHEN success = 1 THEN 1 ELSE 0 END) * 100.0 / COUNT(*), 2) as success_rate,
 			SUM(total_tokens) as total_tokens,
+			COALESCE(SUM(cost), 0) as total_cost,
 			ROUND(AVG(latency_ms), 2) as avg_latency
 		FROM request_logs
 		WHERE timestamp >= date('now', ?)
@@ -308,7 +396,7 @@ func (s *Store) GetDailyStats(days int) ([]*model.DailyStats, error) {
 	var stats []*model.DailyStats
 	for rows.Next() {
 		var s model.DailyStats
-		if err := rows.Scan(&s.Date, &s.TotalRequests, &s.SuccessRate, &s.TotalTokens, &s.AvgLatency); err != nil {
+		if err := rows.Scan(&s.Date, &s.TotalRequests, &s.SuccessRate, &s.TotalTokens, &s.TotalCost, &s.AvgLatency); err != nil {
 			return nil, err
 		}
 		stats = append(stats, &s)
@@ -325,7 +413,8 @@ func (s *Store) GetSourceStats(days int) ([]*model.SourceStats, error) {
 			COUNT(*) as request_count,
 			ROUND(SUM(CASE WHEN success = 1 THEN 1 ELSE 0 END) * 100.0 / COUNT(*), 2) as success_rate,
 			ROUND(AVG(latency_ms), 2) as avg_latency,
-			SUM(total_tokens) as total_tokens
+			SUM(total_tokens) as total_tokens,
+			COALESCE(SUM(cost), 0) as total_cost
 		FROM request_logs
 		WHERE timestamp >= date('now', ?)
 		GROUP BY source_id
@@ -339,7 +428,7 @@ func (s *Store) GetSourceStats(days int) ([]*model.SourceStats, error) {
 	var stats []*model.SourceStats
 	for rows.Next() {
 		var s model.SourceStats
-		if err := rows.Scan(&s.SourceID, &s.SourceName, &s.RequestCount, &s.SuccessRate, &s.AvgLatency, &s.TotalTokens); err != nil {
+		if err := rows.Scan(&s.SourceID, &s.SourceName, &s.RequestCount, &s.SuccessRate, &s.AvgLatency, &s.TotalTokens, &s.TotalCost); err != nil {
 			return nil, err
 		}
 		stats = append(stats, &s)
@@ -493,10 +582,36 @@ func (s *Store) GetKeyDailyUsage(keyID string) (int, error) {
 	return count, err
 }
 
+// GetKeyBudgetSpend 获取 Key 当日与当月的 token 与费用用量（一次查询，当日部分按条件聚合）
+// 结果按 Key 缓存 keySpendTTL，期间由 SaveLog 增量累加；时间按 UTC 规范化后比较
+func (s *Store) GetKeyBudgetSpend(keyID string, dayStart, monthStart time.Time) (daily, monthly *model.KeySpend, err error) {
+	now := time.Now()
+	if d, m, ok := s.spend.get(keyID, dayStart, monthStart, now); ok {
+		return &d, &m, nil
+	}
+
+	daily, monthly = &model.KeySpend{}, &model.KeySpend{}
+	day, month := utcTimestamp(dayStart), utcTimestamp(monthStart)
+	err = s.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN julianday(timestamp) >= julianday(?) THEN total_tokens END), 0),
+			COALESCE(SUM(CASE WHEN julianday(timestamp) >= julianday(?) THEN cost END), 0),
+			COALESCE(SUM(total_tokens), 0),
+			COALESCE(SUM(cost), 0)
+		FROM request_logs
+		WHERE api_key_id = ? AND julianday(timestamp) >= julianday(?)
+	`, day, day, keyID, month).Scan(&daily.Tokens, &daily.Cost, &monthly.Tokens, &monthly.Cost)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.spend.set(keyID, &keySpendEntry{dayStart: dayStart, monthStart: monthStart, daily: *daily, monthly: *monthly, loadedAt: now})
+	return daily, monthly, nil
+}
+
 // GetToolStats 获取工具使用统计
 func (s *Store) GetToolStats(days int) ([]*model.ToolStats, error) {
 	rows, err := s.db.Query(`
-		SELECT client_tool, COUNT(*) as request_count, MAX(timestamp) as last_used
+		SELECT client_tool, COUNT(*) as request_count, COALESCE(SUM(cost), 0) as total_cost, MAX(timestamp) as last_used
 		FROM request_logs
 		WHERE client_tool != '' AND timestamp >= date('now', ?)
 		GROUP BY client_tool
@@ -510,7 +625,7 @@ func (s *Store) GetToolStats(days int) ([]*model.ToolStats, error) {
 	var stats []*model.ToolStats
 	for rows.Next() {
 		var ts model.ToolStats
-		if err := rows.Scan(&ts.Tool, &ts.RequestCount, &ts.LastUsedAt); err != nil {
+		if err := rows.Scan(&ts.Tool, &ts.RequestCount, &ts.TotalCost, &ts.LastUsedAt); err != nil {
 			return nil, err
 		}
 		stats = append(stats, &ts)
@@ -527,6 +642,7 @@ func (s *Store) GetKeyUsageTrend(keyID string, days int) ([]*model.KeyDailyUsage
 			SUM(CASE WHEN success = 1 THEN 1 ELSE 0 END) as success_count,
 			SUM(CASE WHEN success = 0 THEN 1 ELSE 0 END) as fail_count,
 			COALESCE(SUM(total_tokens), 0) as total_tokens,
+			COALESCE(SUM(cost), 0) as total_cost,
 			COALESCE(ROUND(AVG(latency_ms), 2), 0) as avg_latency
 		FROM request_logs
 		WHERE api_key_id = ? AND timestamp >= date('now', ?)
@@ -541,10 +657,162 @@ func (s *Store) GetKeyUsageTrend(keyID string, days int) ([]*model.KeyDailyUsage
 	var usages []*model.KeyDailyUsage
 	for rows.Next() {
 		var u model.KeyDailyUsage
-		if err := rows.Scan(&u.Date, &u.RequestCount, &u.SuccessCount, &u.FailCount, &u.TotalTokens, &u.AvgLatency); err != nil {
+		if err := rows.Scan(&u.Date, &u.RequestCount, &u.SuccessCount, &u.FailCount, &u.TotalTokens, &u.TotalCost, &u.AvgLatency); err != nil {
 			return nil, err
 		}
 		usages = append(usages, &u)
 	}
 	return usages, nil
 }
+
+// === Routing Rules CRUD ===
+
+// SaveRoutingRule 保存路由规则
+func (s *Store) SaveRoutingRule(rule *model.RoutingRule) error {
+	matchJSON, _ := json.Marshal(rule.Match)
+	sourcesJSON, _ := json.Marshal(rule.Sources)
+	excludeJSON, _ := json.Marshal(rule.Exclude)
+	_, err := s.db.Exec(`
+		INSERT INTO routing_rules (id, name, priority, enabled, conditions, strategy, sources, exclude, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			priority = excluded.priority,
+			enabled = excluded.enabled,
+			conditions = excluded.conditions,
+			strategy = excluded.strategy,
+			sources = excluded.sources,
+			exclude = excluded.exclude,
+			updated_at = excluded.updated_at
+	`, rule.ID, rule.Name, rule.Priority, rule.Enabled, string(matchJSON), rule.Strategy,
+		string(sourcesJSON), string(excludeJSON), rule.CreatedAt, rule.UpdatedAt)
+	return err
+}
+
+// GetRoutingRule 获取路由规则
+func (s *Store) GetRoutingRule(id string) (*model.RoutingRule, error) {
+	rows, err := s.db.Query(routingRuleSelect+" WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	return scanRoutingRule(rows)
+}
+
+// ListRoutingRules 按优先级列出路由规则
+func (s *Store) ListRoutingRules() ([]*model.RoutingRule, error) {
+	rows, err := s.db.Query(routingRuleSelect + " ORDER BY priority, created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*model.RoutingRule
+	for rows.Next() {
+		rule, err := scanRoutingRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteRoutingRule 删除路由规则
+func (s *Store) DeleteRoutingRule(id string) error {
+	_, err := s.db.Exec("DELETE FROM routing_rules WHERE id = ?", id)
+	return err
+}
+
+// SaveRateLimitSnapshot 保存频率限制器快照（覆盖上一次）
+func (s *Store) SaveRateLimitSnapshot(snap *model.RateLimitSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO rate_limit_state (id, data, updated_at) VALUES (1, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data, updated_at = CURRENT_TIMESTAMP
+	`, string(data))
+	return err
+}
+
+// LoadRateLimitSnapshot 读取频率限制器快照，无记录时返回 sql.ErrNoRows
+func (s *Store) LoadRateLimitSnapshot() (*model.RateLimitSnapshot, error) {
+	var data string
+	if err := s.db.QueryRow("SELECT data FROM rate_limit_state WHERE id = 1").Scan(&data); err != nil {
+		return nil, err
+	}
+	var snap model.RateLimitSnapshot
+	if err := json.Unmarshal([]byte(data), &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// GetCachedResponse 读取响应缓存条目并刷新命中时间，不存在或创建时间早于 notBefore 时返回 sql.ErrNoRows
+func (s *Store) GetCachedResponse(key string, notBefore time.Time) (*model.CachedResponse, error) {
+	var data string
+	err := s.db.QueryRow("SELECT data FROM response_cache WHERE key = ? AND created_at >= ?", key, notBefore.UnixMilli()).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	var entry model.CachedResponse
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, err
+	}
+	s.db.Exec("UPDATE response_cache SET last_hit_at = ? WHERE key = ?", time.Now().UnixMilli(), key)
+	return &entry, nil
+}
+
+// SaveCachedResponse 保存响应缓存条目，同时删除创建时间早于 notBefore 的条目，
+// 并在超过 maxEntries 时淘汰最久未命中的条目
+func (s *Store) SaveCachedResponse(key string, entry *model.CachedResponse, notBefore time.Time, maxEntries int) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	if _, err := s.db.Exec(`
+		INSERT INTO response_cache (key, data, created_at, last_hit_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET data = excluded.data, created_at = excluded.created_at, last_hit_at = excluded.last_hit_at
+	`, key, string(data), entry.CreatedAt.UnixMilli(), now); err != nil {
+		return err
+	}
+
+	s.db.Exec("DELETE FROM response_cache WHERE created_at < ?", notBefore.UnixMilli())
+	if maxEntries > 0 {
+		s.db.Exec(`DELETE FROM response_cache WHERE key NOT IN (
+			SELECT key FROM response_cache ORDER BY last_hit_at DESC LIMIT ?)`, maxEntries)
+	}
+	return nil
+}
+
+const routingRuleSelect = `
+	SELECT id, name, priority, enabled, COALESCE(conditions, '{}'), COALESCE(strategy, ''),
+		COALESCE(sources, '[]'), COALESCE(exclude, '[]'), created_at, updated_at
+	FROM routing_rules`
+
+// scanRoutingRule 扫描单行路由规则
+func scanRoutingRule(rows *sql.Rows) (*model.RoutingRule, error) {
+	var rule model.RoutingRule
+	var matchJSON, sourcesJSON, excludeJSON string
+	var createdRaw, updatedRaw any
+	if err := rows.Scan(&rule.ID, &rule.Name, &rule.Priority, &rule.Enabled, &matchJSON, &rule.Strategy,
+		&sourcesJSON, &excludeJSON, &createdRaw, &updatedRaw); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(matchJSON), &rule.Match)
+	json.Unmarshal([]byte(sourcesJSON), &rule.Sources)
+	json.Unmarshal([]byte(excludeJSON), &rule.Exclude)
+	rule.CreatedAt = parseSQLiteTime(createdRaw)
+	rule.UpdatedAt = parseSQLiteTime(updatedRaw)
+	return &rule, nil
+}
@@ -43,7 +43,7 @@ func TestMigrate_TablesExist(t *testing.T) {
 	s, cleanup := tempDB(t)
 	defer cleanup()
 
-	tables := []string{"sources", "request_logs", "api_keys"}
+	tables := []string{"sources", "request_logs", "api_keys", "routing_rules", "rate_limit_state"}
 	for _, table := range tables {
 		var count int
 		err := s.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
@@ -66,6 +66,30 @@ func TestMigrate_ColumnsExist(t *testing.T) {
 		t.Errorf("expected fc_compat_used column: %v", err)
 	}
 
+	// Check request_logs has repair_count column
+	_, err = s.db.Exec("SELECT repair_count FROM request_logs LIMIT 0")
+	if err != nil {
+		t.Errorf("expected repair_count column: %v", err)
+	}
+
+	// Check request_logs has hedged column
+	_, err = s.db.Exec("SELECT hedged FROM request_logs LIMIT 0")
+	if err != nil {
+		t.Errorf("expected hedged column: %v", err)
+	}
+
+	// Check request_logs has cached_tokens column
+	_, err = s.db.Exec("SELECT cached_tokens, cache_creation_tokens FROM request_logs LIMIT 0")
+	if err != nil {
+		t.Errorf("expected cached token columns: %v", err)
+	}
+
+	// Check request_logs has endpoint column
+	_, err = s.db.Exec("SELECT endpoint FROM request_logs LIMIT 0")
+	if err != nil {
+		t.Errorf("expected endpoint column: %v", err)
+	}
+
 	// Check request_logs has client_ip column
 	_, err = s.db.Exec("SELECT client_ip FROM request_logs LIMIT 0")
 	if err != nil {
@@ -78,11 +102,23 @@ func TestMigrate_ColumnsExist(t *testing.T) {
 		t.Errorf("expected client_tool column: %v", err)
 	}
 
+	// Check request_logs has cost column
+	_, err = s.db.Exec("SELECT cost FROM request_logs LIMIT 0")
+	if err != nil {
+		t.Errorf("expected cost column: %v", err)
+	}
+
 	// Check sources has cpa_config column
 	_, err = s.db.Exec("SELECT cpa_config FROM sources LIMIT 0")
 	if err != nil {
 		t.Errorf("expected cpa_config column: %v", err)
 	}
+
+	// Check sources has model_map and pricing columns
+	_, err = s.db.Exec("SELECT model_map, pricing FROM sources LIMIT 0")
+	if err != nil {
+		t.Errorf("expected model_map and pricing columns: %v", err)
+	}
 }
 
 func TestMigrate_Idempotent(t *testing.T) {
@@ -194,6 +230,34 @@ func TestSaveSource_WithCPA(t *testing.T) {
 	}
 }
 
+func TestSaveSource_WithModelMapAndPricing(t *testing.T) {
+	s, cleanup := tempDB(t)
+	defer cleanup()
+
+	src := &model.Source{
+		ID:       "src-map",
+		Name:     "Router",
+		Type:     model.SourceTypeCustom,
+		BaseURL:  "https://openrouter.ai/api",
+		ModelMap: []model.ModelMapping{{Match: "claude-sonnet-4", Target: "anthropic/claude-sonnet-4"}},
+		Pricing:  []model.ModelPrice{{Model: "claude-*", Input: 3, Output: 15, CacheRead: 0.3}},
+	}
+	if err := s.SaveSource(src); err != nil {
+		t.Fatalf("SaveSource failed: %v", err)
+	}
+
+	got, err := s.GetSource("src-map")
+	if err != nil {
+		t.Fatalf("GetSource failed: %v", err)
+	}
+	if len(got.ModelMap) != 1 || got.ModelMap[0].Target != "anthropic/claude-sonnet-4" {
+		t.Errorf("expected model map to round-trip, got %+v", got.ModelMap)
+	}
+	if len(got.Pricing) != 1 || got.Pricing[0].Output != 15 || got.Pricing[0].CacheRead != 0.3 {
+		t.Errorf("expected pricing to round-trip, got %+v", got.Pricing)
+	}
+}
+
 func TestListSources(t *testing.T) {
 	s, cleanup := tempDB(t)
 	defer cleanup()
@@ -256,6 +320,7 @@ func TestSaveAndQueryLog(t *testing.T) {
 		ClientTool:       "cursor",
 		APIKeyID:         "key-1",
 		FCCompatUsed:     true,
+		Cost:             0.0125,
 	}
 
 	if err := s.SaveLog(log); err != nil {
@@ -289,6 +354,135 @@ func TestSaveAndQueryLog(t *testing.T) {
 	if got.ClientIP != "127.0.0.1" {
 		t.Errorf("expected ClientIP='127.0.0.1', got '%s'", got.ClientIP)
 	}
+	if got.Cost != 0.0125 {
+		t.Errorf("expected Cost=0.0125, got %v", got.Cost)
+	}
+}
+
+func TestGetKeyBudgetSpend(t *testing.T) {
+	s, cleanup := tempDB(t)
+	defer cleanup()
+
+	now := time.Now()
+	logs := []*model.RequestLog{
+		{ID: "log-1", Timestamp: now, APIKeyID: "key-1", TotalTokens: 100, Cost: 0.5},
+		{ID: "log-2", Timestamp: now, APIKeyID: "key-1", TotalTokens: 50, Cost: 0.25},
+		{ID: "log-3", Timestamp: now.Add(-48 * time.Hour), APIKeyID: "key-1", TotalTokens: 1000, Cost: 5},
+		{ID: "log-4", Timestamp: now, APIKeyID: "key-2", TotalTokens: 999, Cost: 9},
+		{ID: "log-5", Timestamp: now.Add(-90 * 24 * time.Hour), APIKeyID: "key-1", TotalTokens: 7777, Cost: 7},
+	}
+	for _, l := range logs {
+		if err := s.SaveLog(l); err != nil {
+			t.Fatalf("SaveLog failed: %v", err)
+		}
+	}
+
+	daily, monthly, err := s.GetKeyBudgetSpend("key-1", now.Add(-time.Hour), now.Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetKeyBudgetSpend failed: %v", err)
+	}
+	if daily.Tokens != 150 || daily.Cost != 0.75 {
+		t.Errorf("expected daily 150 tokens / 0.75, got %d / %v", daily.Tokens, daily.Cost)
+	}
+	if monthly.Tokens != 1150 || monthly.Cost != 5.75 {
+		t.Errorf("expected monthly 1150 tokens / 5.75, got %d / %v", monthly.Tokens, monthly.Cost)
+	}
+
+	daily, monthly, err = s.GetKeyBudgetSpend("key-3", now.Add(-time.Hour), now.Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetKeyBudgetSpend failed: %v", err)
+	}
+	if *daily != (model.KeySpend{}) || *monthly != (model.KeySpend{}) {
+		t.Errorf("expected zero spend, got %+v / %+v", daily, monthly)
+	}
+}
+
+func TestGetKeyBudgetSpend_ComparesAcrossTimeZones(t *testing.T) {
+	s, cleanup := tempDB(t)
+	defer cleanup()
+
+	dayStart := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	east, west := time.FixedZone("UTC+8", 8*3600), time.FixedZone("UTC-8", -8*3600)
+	logs := []*model.RequestLog{
+		// 2026-10-15 23:00 UTC：本地时间字符串晚于 dayStart，但不属于当日
+		{ID: "log-east", Timestamp: time.Date(2026, 10, 16, 7, 0, 0, 0, east), APIKeyID: "key-1", TotalTokens: 100},
+		// 2026-10-16 04:00 UTC：本地时间字符串早于 dayStart，但属于当日
+		{ID: "log-west", Timestamp: time.Date(2026, 10, 15, 20, 0, 0, 0, west), APIKeyID: "key-1", TotalTokens: 10},
+	}
+	for _, l := range logs {
+		if err := s.SaveLog(l); err != nil {
+			t.Fatalf("SaveLog failed: %v", err)
+		}
+	}
+
+	daily, monthly, err := s.GetKeyBudgetSpend("key-1", dayStart, dayStart.AddDate(0, 0, -15))
+	if err != nil {
+		t.Fatalf("GetKeyBudgetSpend failed: %v", err)
+	}
+	if daily.Tokens != 10 || monthly.Tokens != 110 {
+		t.Errorf("expected daily 10 / monthly 110 tokens, got %d / %d", daily.Tokens, monthly.Tokens)
+	}
+}
+
+func TestGetKeyBudgetSpend_CachedAndUpdatedBySaveLog(t *testing.T) {
+	s, cleanup := tempDB(t)
+	defer cleanup()
+
+	now := time.Now()
+	dayStart, monthStart := now.Add(-time.Hour), now.Add(-30*24*time.Hour)
+	if err := s.SaveLog(&model.RequestLog{ID: "log-1", Timestamp: now, APIKeyID: "key-1", TotalTokens: 100, Cost: 0.5}); err != nil {
+		t.Fatalf("SaveLog failed: %v", err)
+	}
+	if _, _, err := s.GetKeyBudgetSpend("key-1", dayStart, monthStart); err != nil {
+		t.Fatalf("GetKeyBudgetSpend failed: %v", err)
+	}
+
+	// 缓存期间绕过 SaveLog 的写入不可见，经 SaveLog 写入的日志增量累加
+	if _, err := s.db.Exec("DELETE FROM request_logs"); err != nil {
+		t.Fatalf("delete logs: %v", err)
+	}
+	if err := s.SaveLog(&model.RequestLog{ID: "log-2", Timestamp: now, APIKeyID: "key-1", TotalTokens: 50, Cost: 0.25}); err != nil {
+		t.Fatalf("SaveLog failed: %v", err)
+	}
+	daily, monthly, err := s.GetKeyBudgetSpend("key-1", dayStart, monthStart)
+	if err != nil {
+		t.Fatalf("GetKeyBudgetSpend failed: %v", err)
+	}
+	if daily.Tokens != 150 || monthly.Tokens != 150 || daily.Cost != 0.75 {
+		t.Errorf("expected cached spend plus the new log, got %+v / %+v", daily, monthly)
+	}
+}
+
+func TestSaveAndLoadRateLimitSnapshot(t *testing.T) {
+	s, cleanup := tempDB(t)
+	defer cleanup()
+
+	if _, err := s.LoadRateLimitSnapshot(); err == nil {
+		t.Fatal("expected error when no snapshot saved")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, count := range []int{3, 7} {
+		snap := &model.RateLimitSnapshot{
+			SavedAt:    now,
+			DailyCount: map[string]int{"key-1:" + now.Format("2006-01-02"): count},
+			AutoBanned: map[string]time.Time{"key-2": now},
+		}
+		if err := s.SaveRateLimitSnapshot(snap); err != nil {
+			t.Fatalf("SaveRateLimitSnapshot failed: %v", err)
+		}
+	}
+
+	got, err := s.LoadRateLimitSnapshot()
+	if err != nil {
+		t.Fatalf("LoadRateLimitSnapshot failed: %v", err)
+	}
+	if got.DailyCount["key-1:"+now.Format("2006-01-02")] != 7 {
+		t.Errorf("expected latest snapshot, got %+v", got.DailyCount)
+	}
+	if !got.AutoBanned["key-2"].Equal(now) {
+		t.Errorf("expected ban time %v, got %v", now, got.AutoBanned["key-2"])
+	}
 }
 
 func TestQueryLogs_FilterBySource(t *testing.T) {
@@ -384,7 +578,7 @@ func TestQueryLogs_FilterByFCCompat(t *testing.T) {
 	s, cleanup := tempDB(t)
 	defer cleanup()
 
-	s.SaveLog(&model.RequestLog{ID: "l1", Timestamp: time.Now(), Model: "gpt-4", FCCompatUsed: true})
+	s.SaveLog(&model.RequestLog{ID: "l1", Timestamp: time.Now(), Model: "gpt-4", FCCompatUsed: true, RepairCount: 2})
 	s.SaveLog(&model.RequestLog{ID: "l2", Timestamp: time.Now(), Model: "gpt-4", FCCompatUsed: false})
 
 	fc := true
@@ -395,6 +589,9 @@ func TestQueryLogs_FilterByFCCompat(t *testing.T) {
 	if len(logs) == 1 && !logs[0].FCCompatUsed {
 		t.Errorf("expected FCCompatUsed=true")
 	}
+	if len(logs) == 1 && logs[0].RepairCount != 2 {
+		t.Errorf("expected RepairCount=2, got %d", logs[0].RepairCount)
+	}
 }
 
 // === CleanOldLogs ===
@@ -568,3 +765,48 @@ func TestGetSourceStats(t *testing.T) {
 		t.Errorf("expected 2 requests, got %d", stats[0].RequestCount)
 	}
 }
+
+// === Routing Rules ===
+
+func TestSaveAndListRoutingRules(t *testing.T) {
+	s, cleanup := tempDB(t)
+	defer cleanup()
+
+	hasTools := true
+	now := time.Now()
+	s.SaveRoutingRule(&model.RoutingRule{ID: "r2", Name: "fallback", Priority: 20, Enabled: true, Strategy: "round-robin", CreatedAt: now, UpdatedAt: now})
+	err := s.SaveRoutingRule(&model.RoutingRule{
+		ID: "r1", Name: "claude tools", Priority: 10, Enabled: true,
+		Match:     model.RuleMatch{Models: []string{"claude-*"}, ClientTools: []string{"claude-code"}, HasTools: &hasTools},
+		Sources:   []string{"s1", "s2"},
+		Exclude:   []string{"s3"},
+		CreatedAt: now, UpdatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("SaveRoutingRule failed: %v", err)
+	}
+
+	rules, err := s.ListRoutingRules()
+	if err != nil {
+		t.Fatalf("ListRoutingRules failed: %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != "r1" || rules[1].ID != "r2" {
+		t.Fatalf("expected rules ordered by priority, got %+v", rules)
+	}
+	r := rules[0]
+	if len(r.Match.Models) != 1 || r.Match.HasTools == nil || !*r.Match.HasTools || len(r.Sources) != 2 || r.Exclude[0] != "s3" {
+		t.Errorf("rule not round-tripped: %+v", r)
+	}
+
+	r.Enabled = false
+	s.SaveRoutingRule(r)
+	got, err := s.GetRoutingRule("r1")
+	if err != nil || got.Enabled {
+		t.Errorf("expected updated rule, got %+v (%v)", got, err)
+	}
+
+	s.DeleteRoutingRule("r1")
+	if _, err := s.GetRoutingRule("r1"); err == nil {
+		t.Error("expected deleted rule to be gone")
+	}
+}
@@ -24,6 +24,19 @@ function setStoredAdminKey(key: string) {
   }
 }
 
+export interface ModelMapping {
+  match: string
+  target: string
+}
+
+export interface ModelPrice {
+  model: string
+  input: number
+  output: number
+  cache_read?: number
+  cache_write?: number
+}
+
 export interface Source {
   id: string
   name: string
@@ -38,21 +51,36 @@ export interface Source {
     extended_thinking: boolean
     vision: boolean
     models: string[]
+    embeddings?: boolean
+    embedding_models?: string[]
+    endpoints?: string[]
   }
   cpa?: {
     providers: string[]
     account_mode: string
     auto_detect: boolean
   }
+  model_map?: ModelMapping[]
+  pricing?: ModelPrice[]
+  limits?: SourceLimits
   status?: {
     state: 'healthy' | 'unhealthy' | 'removed'
     latency: number
     balance: number
     last_error: string
+    cooldown_until?: string
+    cooldown_reason?: string
+    breaker?: 'closed' | 'open' | 'half_open'
     model_providers?: Record<string, string>
   }
 }
 
+export interface SourceLimits {
+  rpm?: number
+  tpm?: number
+  max_concurrent?: number
+}
+
 export interface RequestLog {
   id: string
   timestamp: string
@@ -68,12 +96,19 @@ export interface RequestLog {
   prompt_tokens: number
   completion_tokens: number
   total_tokens: number
+  cached_tokens?: number
+  cache_creation_tokens?: number
+  cost?: number
   error: string
   failover_from: string
+  hedged?: boolean
+  cache_hit?: boolean
+  endpoint?: string
   client_ip?: string
   client_tool?: string
   api_key_id?: string
   fc_compat_used?: boolean
+  repair_count?: number
 }
 
 export interface KeyDailyUsage {
@@ -82,6 +117,7 @@ export interface KeyDailyUsage {
   success_count: number
   fail_count: number
   total_tokens: number
+  total_cost?: number
   avg_latency_ms: number
 }
 
@@ -101,12 +137,52 @@ export interface KeyLimits {
   rpm: number
   daily_quota: number
   concurrent: number
+  tpm?: number
   tool_quotas?: Record<string, number>
+  daily_token_budget?: number
+  monthly_token_budget?: number
+  daily_cost_budget?: number
+  monthly_cost_budget?: number
+  soft_limit_percent?: number
+  response_cache?: '' | 'on' | 'off'
+}
+
+export interface BudgetStatus {
+  name: string
+  limit: number
+  used: number
+  remaining: number
+  exceeded: boolean
+  warning: boolean
+}
+
+export interface KeyLimitState {
+  key_id: string
+  rpm_used: number
+  tpm_used: number
+  daily_used: number
+  tool_daily?: Record<string, number>
+  concurrent: number
+  error_count: number
+  banned_until?: string
+}
+
+export interface SourceLimitState {
+  source_id: string
+  rpm_used: number
+  tpm_used: number
+  concurrent: number
+}
+
+export interface RateLimitState {
+  keys: KeyLimitState[]
+  sources: SourceLimitState[]
 }
 
 export interface ToolStats {
   tool: string
   request_count: number
+  total_cost?: number
   last_used_at: string
 }
 
@@ -116,6 +192,7 @@ export interface Stats {
     total_requests: number
     success_rate: number
     total_tokens: number
+    total_cost?: number
     avg_latency_ms: number
   }[]
   sources: {
@@ -125,6 +202,7 @@ export interface Stats {
     success_rate: number
     avg_latency_ms: number
     total_tokens: number
+    total_cost?: number
   }[]
 }
 
@@ -133,6 +211,7 @@ export interface SystemStatus {
   healthy_sources: number
   unhealthy_sources: number
   disabled_sources: number
+  cooling_down: number
   routing_strategy: string
   failover_enabled: boolean
 }
@@ -269,7 +348,13 @@ export const keysApi = {
     request<{ data: APIKey }>(`/keys/${id}/unblock`, { method: 'PUT' }).then(r => r.data),
 
   getUsage: (id: string, days?: number) =>
-    request<{ data: KeyDailyUsage[] }>(`/keys/${id}/usage?days=${days || 7}`).then(r => r.data || []),
+    request<{ data: KeyDailyUsage[]; budget: BudgetStatus[] }>(`/keys/${id}/usage?days=${days || 7}`)
+      .then(r => ({ data: r.data || [], budget: r.budget || [] })),
+}
+
+// Rate limiter API
+export const rateLimitApi = {
+  state: () => request<{ data: RateLimitState }>('/ratelimit').then(r => r.data)
 }
 
 // Tools API